@@ -0,0 +1,36 @@
+package main
+
+import "net/http"
+
+const adminPathPrefix = "/admin/"
+
+func (fs *TextWebDAVFileSystem) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminPathPrefix+"export", fs.handleExport)
+	mux.HandleFunc(adminPathPrefix+"files", fs.handleFiles)
+	mux.HandleFunc(adminPathPrefix+"reload", fs.handleReload)
+	mux.HandleFunc(adminPathPrefix, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != adminPathPrefix {
+			http.NotFound(w, r)
+			return
+		}
+		fs.handleStatus(w, r)
+	})
+	return mux
+}
+
+// adminPortHandler 是 -admin-port 上的服务: 根路径是状态页, 另外提供管理和统计接口
+func (fs *TextWebDAVFileSystem) adminPortHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(adminPathPrefix, fs.adminHandler())
+	mux.Handle(apiPathPrefix, fs.apiHandler())
+	mux.HandleFunc("/metrics", fs.handleMetrics)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fs.handleStatus(w, r)
+	})
+	return fs.adminAuth(mux)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errEntryConflict = errors.New("与已有目录或文件冲突")
+
+// adminAuth 保护 /admin/ 接口. 设置了 -admin-token 时只接受 Bearer 令牌,
+// 与 WebDAV 的账号分开; 否则沿用 WebDAV 的基本认证
+func (fs *TextWebDAVFileSystem) adminAuth(next http.Handler) http.Handler {
+	if fs.adminToken == "" {
+		return fs.authMiddleware(next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(fs.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "需要管理令牌", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminFileRequest struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	DisplayName string `json:"display_name"`
+	URL         string `json:"url"`
+	Version     *int64 `json:"version,omitempty"`
+}
+
+// handleFiles: POST 新增或更新一个文件条目, DELETE ?path=...[&prune=1] 删除条目,
+// prune 时顺带删除因此变空的上级目录
+func (fs *TextWebDAVFileSystem) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if (r.Method == http.MethodPost || r.Method == http.MethodDelete) && fs.rejectReadOnly(w) {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var req adminFileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求体不是合法的 JSON", http.StatusBadRequest)
+			return
+		}
+		name := fs.normPath(cleanAdminPath(req.Path))
+		// 与列表一样, -1 表示大小未知, 第一次使用时向上游查询
+		if name == "/" || req.Size < unknownSize {
+			http.Error(w, "路径或大小无效", http.StatusBadRequest)
+			return
+		}
+
+		if !fs.filter.Allow(name, false) {
+			http.Error(w, "条目被过滤规则排除", http.StatusForbidden)
+			return
+		}
+
+		var created bool
+		var err error
+		fs.mu.Lock()
+		if req.Version != nil {
+			err = fs.checkPropVersionLocked(name, strconv.FormatInt(*req.Version, 10))
+		}
+		if err == nil {
+			created, err = fs.putLocked(name, req.Size, req.DisplayName, req.URL)
+		}
+		if err == nil {
+			fs.recordMutation(JournalRecord{Op: JournalPut, Path: name, Size: req.Size, Name: req.DisplayName, URL: req.URL})
+		}
+		fs.mu.Unlock()
+
+		if err == errEntryConflict {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err == errVersionMismatch || os.IsNotExist(err) {
+			http.Error(w, errVersionMismatch.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, map[string]interface{}{"path": name, "created": created})
+
+	case http.MethodDelete:
+		name := fs.normPath(cleanAdminPath(r.URL.Query().Get("path")))
+		if name == "/" {
+			http.Error(w, "路径无效", http.StatusBadRequest)
+			return
+		}
+		prune := r.URL.Query().Get("prune") == "1"
+
+		fs.mu.Lock()
+		err := fs.removeAllLocked(name)
+		var removed []string
+		if err == nil {
+			removed = append(removed, name)
+			if prune {
+				removed = append(removed, fs.pruneEmptyParentsLocked(name)...)
+			}
+			for _, p := range removed {
+				fs.recordMutation(JournalRecord{Op: JournalDelete, Path: p})
+			}
+		}
+		fs.mu.Unlock()
+
+		switch {
+		case os.IsNotExist(err):
+			http.Error(w, "条目不存在", http.StatusNotFound)
+			return
+		case os.IsPermission(err):
+			http.Error(w, "不能删除根目录或挂载点", http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"removed": removed})
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func cleanAdminPath(p string) string {
+	return path.Clean("/" + strings.TrimSpace(p))
+}
+
+// putLocked 新增或更新一个文件条目. 同名目录已存在, 或某一级上级路径是文件时返回 errEntryConflict
+func (fs *TextWebDAVFileSystem) putLocked(name string, size int64, displayName, url string) (bool, error) {
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if meta, ok := fs.Files[dir]; ok && !meta.IsDir {
+			return false, errEntryConflict
+		}
+	}
+	if displayName == "" {
+		displayName = path.Base(name)
+	}
+
+	meta, ok := fs.Files[name]
+	if ok && meta.IsDir {
+		return false, errEntryConflict
+	}
+	if !ok {
+		meta = &FileMeta{Path: name}
+		fs.setEntryLocked(name, meta)
+		fs.ensureParentsLocked(name)
+	}
+	meta.Size = size
+	meta.SizeVerified = false
+	meta.DisplayName = displayName
+	meta.URL = url
+	meta.Content = nil
+	meta.ETag = ""
+	meta.MD5, meta.SHA1 = "", ""
+	meta.ModTime = time.Now()
+	meta.PropVersion++
+	return !ok, nil
+}
+
+// pruneEmptyParentsLocked 自下而上删除已经没有子项的上级目录, 返回被删除的目录
+func (fs *TextWebDAVFileSystem) pruneEmptyParentsLocked(name string) []string {
+	var removed []string
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if len(fs.childPathsLocked(dir)) > 0 {
+			return removed
+		}
+		fs.deleteEntryLocked(dir)
+		removed = append(removed, dir)
+	}
+	return removed
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func adminFiles(fs *TextWebDAVFileSystem, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	fs.handleFiles(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestAdminFilesPostUnknownSize(t *testing.T) {
+	fs := newTestFS(t, "")
+	w := adminFiles(fs, http.MethodPost, "/admin/files", `{"path":"/movies/a.mkv","size":-1,"url":"http://example.com/a.mkv"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if meta := fs.Files["/movies/a.mkv"]; meta == nil || meta.Size != unknownSize || !fs.Files["/movies"].IsDir {
+		t.Fatalf("entry not created with an unknown size: %+v", meta)
+	}
+
+	w = adminFiles(fs, http.MethodPost, "/admin/files", `{"path":"/movies/b.mkv","size":-2}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("size -2: status %d", w.Code)
+	}
+}
+
+func TestAdminFilesDeleteStatus(t *testing.T) {
+	fs := newTestFS(t, "/movies/a.mkv#10#a.mkv\n/mnt/b.mkv#10#b.mkv\n")
+	fs.sources = []treeSource{mountSource("/mnt")}
+
+	if w := adminFiles(fs, http.MethodDelete, "/admin/files?path=/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing entry: status %d", w.Code)
+	}
+	if w := adminFiles(fs, http.MethodDelete, "/admin/files?path=/mnt", ""); w.Code != http.StatusForbidden {
+		t.Errorf("mount point: status %d", w.Code)
+	}
+	if fs.Files["/mnt/b.mkv"] == nil {
+		t.Error("mount point was removed")
+	}
+
+	w := adminFiles(fs, http.MethodDelete, "/admin/files?path=/movies/a.mkv&prune=1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct{ Removed []string }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(resp.Removed, ",") != "/movies/a.mkv,/movies" {
+		t.Errorf("removed %v", resp.Removed)
+	}
+}
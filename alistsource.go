@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlistSource 通过 Alist (小雅) 的 /api/fs/list 接口递归抓取目录树,
+// 挂载到虚拟树的 mount 下, 文件内容地址指向 Alist 的 /d/ 下载链接
+type AlistSource struct {
+	base    *url.URL
+	token   string
+	root    string
+	mount   string
+	workers int
+	perPage int
+	client  *http.Client
+	limiter <-chan time.Time
+	// lazy 不为 nil 时按需抓取, Crawl 只列出挂载点
+	lazy *LazyDirs
+}
+
+type alistListResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Content []struct {
+			Name     string `json:"name"`
+			Size     int64  `json:"size"`
+			IsDir    bool   `json:"is_dir"`
+			Modified string `json:"modified"`
+			Sign     string `json:"sign"`
+		} `json:"content"`
+		Total int `json:"total"`
+	} `json:"data"`
+}
+
+// NewAlistSource 创建 Alist 源, rate 是每秒最多发出的列表请求数, 0 表示不限制
+func NewAlistSource(rawURL, token, root, mount string, workers int, rate float64) (*AlistSource, error) {
+	base, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("Alist 地址无效: %q", redactURL(rawURL))
+	}
+	if mount == "" {
+		mount = "/"
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	s := &AlistSource{
+		base:    base,
+		token:   token,
+		root:    path.Clean("/" + root),
+		mount:   path.Clean("/" + mount),
+		workers: workers,
+		perPage: 1000,
+		client:  &http.Client{Timeout: time.Minute},
+	}
+	if rate > 0 {
+		s.limiter = time.NewTicker(time.Duration(float64(time.Second) / rate)).C
+	}
+	return s, nil
+}
+
+// Crawl 抓取整棵 Alist 目录树. 单个目录抓取失败时保留该目录原有内容, 不影响其它目录
+func (s *AlistSource) Crawl(ctx context.Context, fs *TextWebDAVFileSystem) error {
+	if s.lazy != nil {
+		return s.lazy.crawlRoot(ctx, fs)
+	}
+	start := time.Now()
+
+	fs.mu.Lock()
+	fs.ensureMountLocked(s.mount)
+	fs.mu.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		pending []string
+		failed  int
+	)
+	sem := make(chan struct{}, s.workers)
+	visit := func(rel string) {
+		defer wg.Done()
+		defer func() { <-sem }()
+
+		subdirs, err := s.listDir(ctx, fs, rel)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			fmt.Printf("抓取 Alist 目录 %s 失败: %v\n", path.Join(s.root, rel), err)
+			failed++
+			return
+		}
+		pending = append(pending, subdirs...)
+	}
+
+	queue, err := s.listDir(ctx, fs, "")
+	if err != nil {
+		return err
+	}
+	for len(queue) > 0 {
+		for _, rel := range queue {
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go visit(rel)
+		}
+		wg.Wait()
+		queue, pending = pending, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Alist 源 %s 抓取完成, 用时 %v, 失败目录 %d 个\n", redactURL(s.base.String()), time.Since(start), failed)
+	return nil
+}
+
+// listDir 分页抓取一个目录的全部子项并合并进虚拟树, 返回其中的子目录
+func (s *AlistSource) listDir(ctx context.Context, fs *TextWebDAVFileSystem, rel string) ([]string, error) {
+	remote := path.Join(s.root, rel)
+
+	var entries []sourceEntry
+	for page := 1; ; page++ {
+		resp, err := s.list(ctx, remote, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range resp.Data.Content {
+			e := sourceEntry{
+				path:    path.Join(s.mount, rel, c.Name),
+				size:    c.Size,
+				modTime: time.Now(),
+				isDir:   c.IsDir,
+			}
+			if t, err := time.Parse(time.RFC3339, c.Modified); err == nil {
+				e.modTime = t
+			}
+			if !c.IsDir {
+				e.url = s.downloadURL(path.Join(remote, c.Name), c.Sign)
+			}
+			entries = append(entries, e)
+		}
+		if len(resp.Data.Content) == 0 || page*s.perPage >= resp.Data.Total {
+			break
+		}
+	}
+
+	dir := fs.normPath(path.Join(s.mount, rel))
+	seen := make(map[string]bool, len(entries))
+	var subdirs []string
+
+	fs.mu.Lock()
+	for _, e := range entries {
+		if !fs.filter.Allow(e.path, e.isDir) {
+			continue
+		}
+		// 子目录按 Alist 返回的原名继续抓取, 只有虚拟树中的键做规范化
+		if e.isDir {
+			subdirs = append(subdirs, strings.TrimPrefix(path.Join(rel, path.Base(e.path)), "/"))
+		}
+		e.path = fs.normPath(e.path)
+		seen[e.path] = true
+		fs.upsertSourceEntryLocked(e)
+	}
+	fs.pruneSourceDirLocked(dir, seen)
+	fs.mu.Unlock()
+
+	return subdirs, nil
+}
+
+func (s *AlistSource) list(ctx context.Context, remote string, page int) (*alistListResponse, error) {
+	if s.limiter != nil {
+		select {
+		case <-s.limiter:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"path":     remote,
+		"password": "",
+		"page":     page,
+		"per_page": s.perPage,
+		"refresh":  false,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.base.String()+"/api/fs/list", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("列出 %s 返回 %s", remote, resp.Status)
+	}
+
+	var result alistListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析 Alist 响应失败: %v", err)
+	}
+	if result.Code != http.StatusOK {
+		return nil, fmt.Errorf("列出 %s 失败: %d %s", remote, result.Code, result.Message)
+	}
+	return &result, nil
+}
+
+// downloadURL 返回文件的 /d/ 下载链接, 开启了签名的 Alist 需要带上 sign
+func (s *AlistSource) downloadURL(remote, sign string) string {
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/d" + remote
+	if sign != "" {
+		u.RawQuery = url.Values{"sign": {sign}}.Encode()
+	}
+	return u.String()
+}
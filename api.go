@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const apiPathPrefix = "/api/"
+
+func (fs *TextWebDAVFileSystem) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPathPrefix+"mismatches", fs.handleMismatches)
+	mux.HandleFunc(apiPathPrefix+"stats", fs.handleStats)
+	mux.HandleFunc(apiPathPrefix+"metrics", fs.handleMetrics)
+	mux.HandleFunc(apiPathPrefix+"skipped", fs.handleSkipped)
+	mux.HandleFunc(apiPathPrefix+"missing", fs.handleMissing)
+	mux.HandleFunc(apiPathPrefix+"batch", fs.handleBatch)
+	return mux
+}
+
+// handleStats 汇总各个子系统的运行统计, 未启用的子系统不出现在结果中
+func (fs *TextWebDAVFileSystem) handleStats(w http.ResponseWriter, r *http.Request) {
+	fs.mu.RLock()
+	stats := map[string]interface{}{
+		"version":        version,
+		"uptime_seconds": int64(time.Since(startTime).Seconds()),
+		"entries":        len(fs.Files),
+	}
+	fs.mu.RUnlock()
+
+	stats["mounts"] = fs.mountCounts()
+	stats["reload"] = fs.reloadStats()
+	stats["streams"] = fs.streams.Snapshot()
+	stats["missing"] = len(fs.missing.List())
+	if backends := fs.health.Snapshot(); len(backends) > 0 {
+		stats["backends"] = backends
+	}
+	if metrics := fs.backendMetrics.Snapshot(); len(metrics) > 0 {
+		stats["backend_metrics"] = metrics
+	}
+	if probes := fs.prober.Snapshot(); len(probes) > 0 {
+		stats["backend_health"] = probes
+	}
+	if fs.fetcher != nil && fs.fetcher.limit != nil {
+		stats["backend_limit"] = fs.fetcher.limit.Stats()
+	}
+	if fs.resolved != nil {
+		stats["resolved_urls"] = fs.resolved.Len()
+	}
+	lastLoad.mu.Lock()
+	if lastLoad.report != nil {
+		stats["skipped_lines"] = lastLoad.report.SkippedCount
+	}
+	lastLoad.mu.Unlock()
+	if fs.transfer != nil {
+		stats["transfer"] = fs.transfer.Stats()
+	}
+	if fs.contentCache != nil {
+		stats["content_cache"] = fs.contentCache.Stats()
+	}
+	if fs.throttle != nil {
+		stats["throttle"] = fs.throttle.Settings()
+	}
+	if fs.mirror != nil {
+		stats["mirror"] = fs.mirror.Status()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
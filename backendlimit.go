@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackendLimiter 限制同时进行的上游请求数. 一个请求从发出到响应体关闭都占用一个名额,
+// 名额用完时后来的请求排队, 等待期间请求被取消或超时就放弃. 带 exemptBackendLimit
+// 标记的请求 (PROPFIND 中的查询、健康探测) 不受限制
+type BackendLimiter struct {
+	max int
+	sem chan struct{}
+
+	// waiting 是正在排队的请求数, 原子读写
+	waiting int64
+
+	mu       sync.Mutex
+	waited   int64
+	waitTime time.Duration
+	maxWait  time.Duration
+	gaveUp   int64
+}
+
+// BackendLimitStats 是 /api/stats 中的排队统计, 用来调整 -backend-max-concurrency
+type BackendLimitStats struct {
+	Max       int     `json:"max"`
+	InFlight  int     `json:"in_flight"`
+	Waiting   int64   `json:"waiting"`
+	Waited    int64   `json:"waited_total"`
+	WaitSecs  float64 `json:"wait_seconds_total"`
+	MaxWaitMs int64   `json:"max_wait_ms"`
+	GaveUp    int64   `json:"gave_up_total"`
+	AvgWaitMs int64   `json:"avg_wait_ms"`
+}
+
+// NewBackendLimiter 在 max 不大于 0 时返回 nil, 即不限制
+func NewBackendLimiter(max int) *BackendLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &BackendLimiter{max: max, sem: make(chan struct{}, max)}
+}
+
+type backendLimitExemptKey struct{}
+
+// exemptBackendLimit 标记 ctx 下的上游请求不占用名额
+func exemptBackendLimit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backendLimitExemptKey{}, true)
+}
+
+// acquire 取得一个名额, 返回释放它的函数. 不受限制的请求返回空操作
+func (l *BackendLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil || ctx.Value(backendLimitExemptKey{}) != nil {
+		return func() {}, nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return l.releaser(), nil
+	default:
+	}
+
+	atomic.AddInt64(&l.waiting, 1)
+	start := time.Now()
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		atomic.AddInt64(&l.waiting, -1)
+		l.mu.Lock()
+		l.gaveUp++
+		l.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	atomic.AddInt64(&l.waiting, -1)
+	wait := time.Since(start)
+	l.mu.Lock()
+	l.waited++
+	l.waitTime += wait
+	if wait > l.maxWait {
+		l.maxWait = wait
+	}
+	l.mu.Unlock()
+	return l.releaser(), nil
+}
+
+func (l *BackendLimiter) releaser() func() {
+	var once sync.Once
+	return func() { once.Do(func() { <-l.sem }) }
+}
+
+func (l *BackendLimiter) Stats() BackendLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := BackendLimitStats{
+		Max:       l.max,
+		InFlight:  len(l.sem),
+		Waiting:   atomic.LoadInt64(&l.waiting),
+		Waited:    l.waited,
+		WaitSecs:  l.waitTime.Seconds(),
+		MaxWaitMs: l.maxWait.Milliseconds(),
+		GaveUp:    l.gaveUp,
+	}
+	if l.waited > 0 {
+		st.AvgWaitMs = (l.waitTime / time.Duration(l.waited)).Milliseconds()
+	}
+	return st
+}
+
+// limitedBody 在响应体关闭时归还名额
+type limitedBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
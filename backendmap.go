@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// BackendMap 按虚拟路径前缀选择内容请求的上游, 最长匹配的前缀优先.
+// 匹配后把去掉前缀的剩余路径接到该上游地址后面, 例如 /movies=http://alist1:5244/d
+// 会把 /movies/a.mkv 转发到 http://alist1:5244/d/a.mkv.
+// 一个前缀可以用逗号分隔多个镜像地址, 按先后顺序作为故障时的备选
+type BackendMap []backendMapping
+
+type backendMapping struct {
+	prefix string
+	bases  []*url.URL
+}
+
+func (m *BackendMap) Add(rule string) error {
+	prefix, raw, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("上游映射格式错误, 需要 /前缀=地址: %s", redactURL(rule))
+	}
+	prefix = strings.TrimSpace(prefix)
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("上游映射的路径必须以 / 开头: %q", prefix)
+	}
+	bm := backendMapping{prefix: path.Clean(prefix)}
+	for _, item := range strings.Split(raw, ",") {
+		base, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(item), "/"))
+		if err != nil || base.Scheme == "" || base.Host == "" {
+			return fmt.Errorf("上游映射的地址无效: %s", redactURL(item))
+		}
+		bm.bases = append(bm.bases, base)
+	}
+	*m = append(*m, bm)
+	return nil
+}
+
+// applyBackendTimeouts 解析 "/movies=dial=5s,read=20s", 把超时应用到该映射的所有上游主机
+func (fs *TextWebDAVFileSystem) applyBackendTimeouts(rule string) error {
+	prefix, spec, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("上游超时格式错误, 需要 /前缀=dial=5s,...: %q", rule)
+	}
+	return fs.configureMapping(strings.TrimSpace(prefix), func(opts FetcherOptions) (FetcherOptions, error) {
+		return parseTimeouts(opts, spec)
+	})
+}
+
+// applyBackendProxy 解析 -backend-proxy: "socks5://host:1080" 覆盖环境变量作用于所有上游,
+// "/prefix=socks5://host:1080" 或 "/prefix=direct" 只作用于该映射的上游主机
+func (fs *TextWebDAVFileSystem) applyBackendProxy(rule string) error {
+	if !strings.HasPrefix(rule, "/") {
+		return fs.fetcher.setDefaultProxy(rule)
+	}
+	prefix, raw, _ := strings.Cut(rule, "=")
+	return fs.configureMapping(strings.TrimSpace(prefix), func(opts FetcherOptions) (FetcherOptions, error) {
+		err := opts.setProxy(raw)
+		return opts, err
+	})
+}
+
+// configureMapping 用 change 修改 prefix 映射中每个上游主机的连接设置
+func (fs *TextWebDAVFileSystem) configureMapping(prefix string, change func(FetcherOptions) (FetcherOptions, error)) error {
+	prefix = path.Clean(prefix)
+	for _, bm := range fs.backends {
+		if bm.prefix != prefix {
+			continue
+		}
+		for _, base := range bm.bases {
+			opts, err := change(fs.fetcher.hostOptions(base.String()))
+			if err != nil {
+				return err
+			}
+			fs.fetcher.configureHost(base.String(), opts)
+		}
+		return nil
+	}
+	return fmt.Errorf("前缀 %s 没有对应的 -backend-map", prefix)
+}
+
+// Resolve 按优先顺序返回 p 对应的上游地址, 没有匹配的前缀时返回 nil.
+// 前缀按路径段匹配, /movies 不会匹配 /movies2
+func (m BackendMap) Resolve(p string) []string {
+	best := -1
+	for i, bm := range m {
+		if (p == bm.prefix || strings.HasPrefix(p, bm.prefix+"/") || bm.prefix == "/") && (best < 0 || len(bm.prefix) > len(m[best].prefix)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	rest := strings.TrimPrefix(p, m[best].prefix)
+	if m[best].prefix == "/" {
+		rest = p
+	}
+	targets := make([]string, 0, len(m[best].bases))
+	for _, base := range m[best].bases {
+		targets = append(targets, base.ResolveReference(&url.URL{Path: path.Join(base.Path, rest)}).String())
+	}
+	return targets
+}
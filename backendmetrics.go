@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ttfbBuckets 是首字节时间直方图的上界, 单位秒
+var ttfbBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// BackendMetrics 按上游 (协议 + 主机, 与 -backend-fail-threshold 的统计相同) 累计内容请求的
+// 次数、字节数、首字节时间和错误, 不按文件区分, 上游数量决定指标的条数
+type BackendMetrics struct {
+	mu    sync.Mutex
+	hosts map[string]*backendCounters
+}
+
+type backendCounters struct {
+	requests  int64
+	errors    int64
+	status4xx int64
+	status5xx int64
+	retries   int64
+	failovers int64
+	// bytes 在每次读取时累加, 原子读写
+	bytes int64
+
+	// ttfb[i] 是不超过 ttfbBuckets[i] 的次数 (不累积), 最后一个是超过所有上界的次数
+	ttfb    []int64
+	ttfbSum time.Duration
+}
+
+// BackendMetricsSummary 是 /api/stats 中一个上游的汇总
+type BackendMetricsSummary struct {
+	Backend   string `json:"backend"`
+	Requests  int64  `json:"requests"`
+	Bytes     int64  `json:"bytes"`
+	Errors    int64  `json:"errors"`
+	Status4xx int64  `json:"status_4xx"`
+	Status5xx int64  `json:"status_5xx"`
+	Retries   int64  `json:"retries"`
+	Failovers int64  `json:"failovers"`
+	AvgTTFBMs int64  `json:"avg_ttfb_ms"`
+}
+
+func NewBackendMetrics() *BackendMetrics {
+	return &BackendMetrics{hosts: make(map[string]*backendCounters)}
+}
+
+func (m *BackendMetrics) counters(target string) *backendCounters {
+	key := backendKey(target)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.hosts[key]
+	if c == nil {
+		c = &backendCounters{ttfb: make([]int64, len(ttfbBuckets)+1)}
+		m.hosts[key] = c
+	}
+	return c
+}
+
+// observe 记录对 target 的一次请求. 连接失败时 resp 为 nil, 不计入首字节时间
+func (m *BackendMetrics) observe(target string, resp *http.Response, err error, ttfb time.Duration, retry bool) {
+	if m == nil {
+		return
+	}
+	c := m.counters(target)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.requests++
+	if retry {
+		c.retries++
+	}
+	if err != nil {
+		c.errors++
+		return
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		c.status5xx++
+	case resp.StatusCode >= 400:
+		c.status4xx++
+	}
+	i := sort.SearchFloat64s(ttfbBuckets, ttfb.Seconds())
+	c.ttfb[i]++
+	c.ttfbSum += ttfb
+}
+
+// retry 记录传输中断后的续传, 计在中断的上游上
+func (m *BackendMetrics) retry(target string) {
+	if m == nil {
+		return
+	}
+	c := m.counters(target)
+	m.mu.Lock()
+	c.retries++
+	m.mu.Unlock()
+}
+
+// failover 记录因 target 失败而改用下一个上游
+func (m *BackendMetrics) failover(target string) {
+	if m == nil {
+		return
+	}
+	c := m.counters(target)
+	m.mu.Lock()
+	c.failovers++
+	m.mu.Unlock()
+}
+
+func (m *BackendMetrics) addBytes(target string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&m.counters(target).bytes, n)
+}
+
+func (m *BackendMetrics) sortedKeys() []string {
+	keys := make([]string, 0, len(m.hosts))
+	for key := range m.hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *BackendMetrics) Snapshot() []BackendMetricsSummary {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]BackendMetricsSummary, 0, len(m.hosts))
+	for _, key := range m.sortedKeys() {
+		c := m.hosts[key]
+		s := BackendMetricsSummary{
+			Backend:   key,
+			Requests:  c.requests,
+			Bytes:     atomic.LoadInt64(&c.bytes),
+			Errors:    c.errors,
+			Status4xx: c.status4xx,
+			Status5xx: c.status5xx,
+			Retries:   c.retries,
+			Failovers: c.failovers,
+		}
+		if n := c.requests - c.errors; n > 0 {
+			s.AvgTTFBMs = (c.ttfbSum / time.Duration(n)).Milliseconds()
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// writePrometheus 按 Prometheus 文本格式输出各上游的指标
+func (m *BackendMetrics) writePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := m.sortedKeys()
+	counter := func(name, help string, value func(*backendCounters) int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s{backend=%q} %d\n", name, key, value(m.hosts[key]))
+		}
+	}
+	counter("xiaoya_backend_requests_total", "发往上游的请求数", func(c *backendCounters) int64 { return c.requests })
+	counter("xiaoya_backend_bytes_total", "从上游读取的内容字节数", func(c *backendCounters) int64 { return atomic.LoadInt64(&c.bytes) })
+	counter("xiaoya_backend_errors_total", "没有得到响应的失败请求数", func(c *backendCounters) int64 { return c.errors })
+	counter("xiaoya_backend_retries_total", "重试和传输中断后续传的次数", func(c *backendCounters) int64 { return c.retries })
+	counter("xiaoya_backend_failovers_total", "失败后改用下一个上游的次数", func(c *backendCounters) int64 { return c.failovers })
+
+	name := "xiaoya_backend_responses_total"
+	fmt.Fprintf(w, "# HELP %s 上游按状态码类别统计的响应数\n# TYPE %s counter\n", name, name)
+	for _, key := range keys {
+		c := m.hosts[key]
+		fmt.Fprintf(w, "%s{backend=%q,class=\"4xx\"} %d\n", name, key, c.status4xx)
+		fmt.Fprintf(w, "%s{backend=%q,class=\"5xx\"} %d\n", name, key, c.status5xx)
+	}
+
+	name = "xiaoya_backend_ttfb_seconds"
+	fmt.Fprintf(w, "# HELP %s 收到上游响应头的时间\n# TYPE %s histogram\n", name, name)
+	for _, key := range keys {
+		c := m.hosts[key]
+		var cum int64
+		for i, le := range ttfbBuckets {
+			cum += c.ttfb[i]
+			fmt.Fprintf(w, "%s_bucket{backend=%q,le=%q} %d\n", name, key, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		cum += c.ttfb[len(ttfbBuckets)]
+		fmt.Fprintf(w, "%s_bucket{backend=%q,le=\"+Inf\"} %d\n", name, key, cum)
+		fmt.Fprintf(w, "%s_sum{backend=%q} %g\n", name, key, c.ttfbSum.Seconds())
+		fmt.Fprintf(w, "%s_count{backend=%q} %d\n", name, key, cum)
+	}
+}
+
+// handleMetrics 是 /api/metrics 和管理端口的 /metrics, 供 Prometheus 抓取
+func (fs *TextWebDAVFileSystem) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if fs.backendMetrics != nil {
+		fs.backendMetrics.writePrometheus(w)
+	}
+	if fs.fetcher != nil && fs.fetcher.limit != nil {
+		st := fs.fetcher.limit.Stats()
+		fmt.Fprintf(w, "# HELP xiaoya_backend_queue_waiting 排队等待上游名额的请求数\n# TYPE xiaoya_backend_queue_waiting gauge\nxiaoya_backend_queue_waiting %d\n", st.Waiting)
+		fmt.Fprintf(w, "# HELP xiaoya_backend_in_flight 占用名额的上游请求数\n# TYPE xiaoya_backend_in_flight gauge\nxiaoya_backend_in_flight %d\n", st.InFlight)
+		fmt.Fprintf(w, "# HELP xiaoya_backend_queue_wait_seconds_total 等待上游名额的总时间\n# TYPE xiaoya_backend_queue_wait_seconds_total counter\nxiaoya_backend_queue_wait_seconds_total %g\n", st.WaitSecs)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func metricsFor(fs *TextWebDAVFileSystem, srv *httptest.Server) BackendMetricsSummary {
+	for _, s := range fs.backendMetrics.Snapshot() {
+		if s.Backend == backendKey(srv.URL) {
+			return s
+		}
+	}
+	return BackendMetricsSummary{}
+}
+
+func TestBackendMetricsCountRequests(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 4096)
+	ok := rangedUpstream(t, body)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	dropping, _ := droppingUpstream(t, body, 1000)
+
+	fs := newTestFS(t, "/ok.mkv#4096#a.mkv\n/5xx.mkv#4096#b.mkv\n/drop.mkv#4096#c.mkv\n")
+	fs.Files["/ok.mkv"].URL = ok.URL + "/a.mkv"
+	fs.Files["/5xx.mkv"].URL = failing.URL + "/b.mkv"
+	fs.Files["/drop.mkv"].URL = dropping.URL + "/c.mkv"
+	fs.upstreamRetries = 1
+
+	if got := metricsFor(fs, ok); got.Requests != 0 {
+		t.Fatalf("counters before any request: %+v", got)
+	}
+	for _, name := range []string{"/ok.mkv", "/5xx.mkv", "/drop.mkv"} {
+		getUpstream(fs, name, nil)
+	}
+
+	if got := metricsFor(fs, ok); got.Requests != 1 || got.Bytes != 4096 || got.Errors != 0 || got.Status5xx != 0 {
+		t.Errorf("successful backend: %+v", got)
+	}
+	if got := metricsFor(fs, failing); got.Requests != 2 || got.Status5xx != 2 || got.Retries != 1 || got.Bytes != 0 {
+		t.Errorf("failing backend: %+v, want two 5xx responses and one retry", got)
+	}
+	if got := metricsFor(fs, dropping); got.Requests != 2 || got.Retries != 1 || got.Bytes != 4096 {
+		t.Errorf("dropping backend: %+v, want a resume and every byte counted once", got)
+	}
+
+	w := httptest.NewRecorder()
+	fs.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/api/metrics", nil))
+	for _, line := range []string{
+		fmt.Sprintf("xiaoya_backend_requests_total{backend=%q} 1", backendKey(ok.URL)),
+		fmt.Sprintf("xiaoya_backend_responses_total{backend=%q,class=\"5xx\"} 2", backendKey(failing.URL)),
+		fmt.Sprintf("xiaoya_backend_ttfb_seconds_count{backend=%q} 1", backendKey(ok.URL)),
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("/api/metrics lacks %s:\n%s", line, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+type batchRequest struct {
+	Operations      []batchOp `json:"operations"`
+	DryRun          bool      `json:"dry_run"`
+	ContinueOnError bool      `json:"continue_on_error"`
+}
+
+// batchOp 是批量操作中的一步:
+// create (path, dir 或 size/display_name/url), delete (path), rename (path, to),
+// setprops (path, props, 可选 version)
+type batchOp struct {
+	Op          string        `json:"op"`
+	Path        string        `json:"path"`
+	To          string        `json:"to,omitempty"`
+	Dir         bool          `json:"dir,omitempty"`
+	Size        int64         `json:"size,omitempty"`
+	DisplayName string        `json:"display_name,omitempty"`
+	URL         string        `json:"url,omitempty"`
+	Props       []JournalProp `json:"props,omitempty"`
+	Version     *int64        `json:"version,omitempty"`
+}
+
+type batchOpResult struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Committed bool            `json:"committed"`
+	DryRun    bool            `json:"dry_run"`
+	Applied   int             `json:"applied"`
+	Failed    int             `json:"failed"`
+	Results   []batchOpResult `json:"results"`
+}
+
+// handleBatch 在写锁内把一组操作依次应用到目录树的副本上, 全部成功后整体替换,
+// 第一个失败就放弃整批 (continue_on_error 时跳过失败的操作继续). dry_run 只返回结果不生效
+func (fs *TextWebDAVFileSystem) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if fs.rejectReadOnly(w) {
+		return
+	}
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求体不是合法的 JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) == 0 {
+		http.Error(w, "没有操作", http.StatusBadRequest)
+		return
+	}
+	if fs.batchMaxOps > 0 && len(req.Operations) > fs.batchMaxOps {
+		http.Error(w, fmt.Sprintf("单批最多 %d 个操作", fs.batchMaxOps), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp := batchResponse{DryRun: req.DryRun, Results: make([]batchOpResult, 0, len(req.Operations))}
+	var records []JournalRecord
+
+	fs.mu.Lock()
+	work := fs.workingCopyLocked()
+	aborted := false
+	for i, op := range req.Operations {
+		res := batchOpResult{Index: i, Op: op.Op, Path: cleanAdminPath(op.Path)}
+		recs, err := work.applyBatchOpLocked(op)
+		if err != nil {
+			res.Error = batchErrorText(err)
+			resp.Failed++
+			resp.Results = append(resp.Results, res)
+			if !req.ContinueOnError {
+				aborted = true
+				break
+			}
+			continue
+		}
+		res.OK = true
+		resp.Applied++
+		resp.Results = append(resp.Results, res)
+		records = append(records, recs...)
+	}
+	if !aborted && !req.DryRun && len(records) > 0 {
+		fs.Files = work.Files
+		fs.children.invalidate()
+		for _, rec := range records {
+			fs.recordMutation(rec)
+		}
+		resp.Committed = true
+	}
+	fs.mu.Unlock()
+
+	if resp.Committed || req.DryRun {
+		fs.emitEvent("batch", "", fmt.Sprintf("ops=%d applied=%d failed=%d dry_run=%v committed=%v",
+			len(req.Operations), resp.Applied, resp.Failed, req.DryRun, resp.Committed))
+	}
+
+	status := http.StatusOK
+	if aborted {
+		// 整批已回滚, 已应用的步骤也不生效
+		resp.Applied = 0
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, resp)
+}
+
+// workingCopyLocked 返回批量操作使用的目录树副本, 带上 applyBatchOpLocked 调用的 *Locked
+// 方法读取的全部配置: 路径规范化、过滤规则、重命名策略, 以及判断挂载点的远端源.
+// 副本没有子项、大小写和配额索引, 这些方法在副本上遍历整棵树. 调用方持有 fs.mu 写锁
+func (fs *TextWebDAVFileSystem) workingCopyLocked() *TextWebDAVFileSystem {
+	return &TextWebDAVFileSystem{
+		Files:            cloneFiles(fs.Files),
+		filter:           fs.filter,
+		renamePolicy:     fs.renamePolicy,
+		displayTemplates: fs.displayTemplates,
+		pathForm:         fs.pathForm,
+		sources:          fs.sources,
+	}
+}
+
+// applyBatchOpLocked 在工作副本上执行一个操作, 返回需要写入日志的记录.
+// 记录的顺序与重放顺序一致, 重放时能得到同样的结果
+func (fs *TextWebDAVFileSystem) applyBatchOpLocked(op batchOp) ([]JournalRecord, error) {
+	name := fs.normPath(cleanAdminPath(op.Path))
+	if name == "/" {
+		return nil, os.ErrPermission
+	}
+
+	switch op.Op {
+	case "create":
+		if _, ok := fs.Files[name]; ok {
+			return nil, os.ErrExist
+		}
+		if !fs.filter.Allow(name, op.Dir) {
+			return nil, errFiltered
+		}
+		if op.Dir {
+			if parent := path.Dir(name); parent != "/" {
+				if meta, ok := fs.Files[parent]; !ok || !meta.IsDir {
+					return nil, errNoParent
+				}
+			}
+			if err := fs.mkdirLocked(name); err != nil {
+				return nil, err
+			}
+			return []JournalRecord{{Op: JournalMkdir, Path: name}}, nil
+		}
+		if op.Size < 0 {
+			return nil, errBadSize
+		}
+		if _, err := fs.putLocked(name, op.Size, op.DisplayName, op.URL); err != nil {
+			return nil, err
+		}
+		return []JournalRecord{{Op: JournalPut, Path: name, Size: op.Size, Name: op.DisplayName, URL: op.URL}}, nil
+
+	case "delete":
+		if err := fs.removeAllLocked(name); err != nil {
+			return nil, err
+		}
+		return []JournalRecord{{Op: JournalDelete, Path: name}}, nil
+
+	case "rename":
+		to := fs.normPath(cleanAdminPath(op.To))
+		if err := fs.renameLocked(name, to); err != nil {
+			return nil, err
+		}
+		return []JournalRecord{{Op: JournalRename, Path: name, To: to}}, nil
+
+	case "setprops":
+		for _, p := range op.Props {
+			pn := xml.Name{Space: p.Space, Local: p.Name}
+			if protectedProps[pn] {
+				return nil, os.ErrPermission
+			}
+			if !p.Remove && pn == displayNameProp && strings.TrimSpace(propText(p.Value)) == "" {
+				return nil, errEmptyDisplayName
+			}
+		}
+		if op.Version != nil {
+			if err := fs.checkPropVersionLocked(name, strconv.FormatInt(*op.Version, 10)); err != nil {
+				return nil, err
+			}
+		}
+		if err := fs.patchLocked(name, op.Props); err != nil {
+			return nil, err
+		}
+		return []JournalRecord{{Op: JournalProppatch, Path: name, Props: op.Props}}, nil
+
+	default:
+		return nil, fmt.Errorf("未知操作 %q", op.Op)
+	}
+}
+
+var (
+	errFiltered         = errors.New("条目被过滤规则排除")
+	errNoParent         = errors.New("上级目录不存在")
+	errBadSize          = errors.New("大小无效")
+	errBadTarget        = errors.New("目标路径无效")
+	errEmptyDisplayName = errors.New("displayname 不能为空")
+)
+
+func batchErrorText(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return "条目不存在"
+	case os.IsExist(err):
+		return "条目已存在"
+	case os.IsPermission(err):
+		return "不允许修改"
+	}
+	return err.Error()
+}
+
+// cloneFiles 深拷贝目录树, 批量操作在副本上进行, 失败时原树不受影响
+func cloneFiles(files map[string]*FileMeta) map[string]*FileMeta {
+	clone := make(map[string]*FileMeta, len(files))
+	for p, meta := range files {
+		m := *meta
+		if meta.Props != nil {
+			m.Props = make(map[xml.Name]webdav.Property, len(meta.Props))
+			for k, v := range meta.Props {
+				m.Props[k] = v
+			}
+		}
+		clone[p] = &m
+	}
+	return clone
+}
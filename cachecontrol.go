@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 按扩展名划分的内容类别, 用于缓存等按类型生效的配置
+var contentClasses = map[string]string{
+	".mkv":  "video",
+	".mp4":  "video",
+	".avi":  "video",
+	".ts":   "video",
+	".m2ts": "video",
+	".iso":  "video",
+	".rmvb": "video",
+	".jpg":  "artwork",
+	".jpeg": "artwork",
+	".png":  "artwork",
+	".webp": "artwork",
+	".gif":  "artwork",
+	".nfo":  "metadata",
+	".strm": "metadata",
+	".srt":  "subtitle",
+	".ass":  "subtitle",
+	".ssa":  "subtitle",
+	".vtt":  "subtitle",
+}
+
+func contentClass(name string) string {
+	return contentClasses[strings.ToLower(path.Ext(name))]
+}
+
+// CacheRules 决定 GET/HEAD 响应的 Cache-Control: 最长匹配的路径前缀优先,
+// 其次是扩展名和内容类别, 都不匹配时为 no-cache; 没有内置的规则, 海报等需要长期缓存时
+// 用 class:artwork=... 显式配置. 命令行中的规则之外还可以有规则文件, 重新加载列表时重新读取
+type CacheRules struct {
+	mu    sync.RWMutex
+	table *cacheTable
+	rules []string // -cache-control 的规则
+	file  string   // -cache-control-file, 为空时没有规则文件
+}
+
+type cacheTable struct {
+	prefixes map[string]string
+	exts     map[string]string
+	classes  map[string]string
+	fallback string
+}
+
+func NewCacheRules() *CacheRules {
+	return &CacheRules{table: newCacheTable()}
+}
+
+func newCacheTable() *cacheTable {
+	return &cacheTable{
+		prefixes: make(map[string]string),
+		exts:     make(map[string]string),
+		classes:  make(map[string]string),
+		fallback: "no-cache",
+	}
+}
+
+// Add 解析一条规则, 形如 "/posters=max-age=604800"、"ext:.nfo=private, max-age=86400"、
+// "class:metadata=max-age=86400" 或 "default=no-store", 值为空表示不设置
+func (c *CacheRules) Add(rule string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.table.add(rule); err != nil {
+		return err
+	}
+	c.rules = append(c.rules, rule)
+	return nil
+}
+
+// LoadFile 设置并读取规则文件: 每行一条规则, 格式与 -cache-control 相同, 空行和 # 开头的行忽略.
+// 文件中的规则在命令行的规则之后生效
+func (c *CacheRules) LoadFile(file string) error {
+	c.mu.Lock()
+	c.file = file
+	c.mu.Unlock()
+	return c.Reload()
+}
+
+// Reload 重新读取规则文件. 读取或解析失败时保留原来的规则
+func (c *CacheRules) Reload() error {
+	c.mu.RLock()
+	file, rules := c.file, c.rules
+	c.mu.RUnlock()
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	t := newCacheTable()
+	for _, rule := range rules {
+		if err := t.add(rule); err != nil {
+			return err
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := t.add(line); err != nil {
+			return fmt.Errorf("%s 第 %d 行: %w", file, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.table = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (t *cacheTable) add(rule string) error {
+	key, value, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("缓存规则格式错误: %q", rule)
+	}
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if err := checkCacheControl(value); err != nil {
+		return fmt.Errorf("缓存规则 %q: %w", rule, err)
+	}
+
+	if key == "default" {
+		t.fallback = value
+		return nil
+	}
+	if class, ok := strings.CutPrefix(key, "class:"); ok {
+		t.classes[class] = value
+		return nil
+	}
+	if ext, ok := strings.CutPrefix(key, "ext:"); ok {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		t.exts[strings.ToLower(ext)] = value
+		return nil
+	}
+	if !strings.HasPrefix(key, "/") {
+		return fmt.Errorf("缓存规则的路径前缀必须以 / 开头: %q", rule)
+	}
+	t.prefixes[strings.TrimSuffix(key, "/")] = value
+	return nil
+}
+
+// checkCacheControl 只接受常见的响应指令, 拼错的指令客户端会直接忽略, 不如启动时报错
+func checkCacheControl(value string) error {
+	if value == "" {
+		return nil
+	}
+	for _, d := range strings.Split(value, ",") {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(d), "=")
+		switch strings.ToLower(name) {
+		case "max-age", "s-maxage", "stale-while-revalidate", "stale-if-error":
+			if n, err := strconv.Atoi(arg); !hasArg || err != nil || n < 0 {
+				return fmt.Errorf("%s 需要不小于 0 的秒数", name)
+			}
+		case "public", "private", "no-cache", "no-store", "no-transform", "must-revalidate", "proxy-revalidate", "immutable":
+		default:
+			return fmt.Errorf("未知的 Cache-Control 指令 %q", name)
+		}
+	}
+	return nil
+}
+
+func (c *CacheRules) For(name string) string {
+	c.mu.RLock()
+	t := c.table
+	c.mu.RUnlock()
+
+	best := -1
+	value := ""
+	for prefix, v := range t.prefixes {
+		if (name == prefix || strings.HasPrefix(name, prefix+"/") || prefix == "") && len(prefix) > best {
+			best, value = len(prefix), v
+		}
+	}
+	if best >= 0 {
+		return value
+	}
+	if v, ok := t.exts[strings.ToLower(path.Ext(name))]; ok {
+		return v
+	}
+	if v, ok := t.classes[contentClass(name)]; ok {
+		return v
+	}
+	return t.fallback
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCacheRulesDefaults(t *testing.T) {
+	c := NewCacheRules()
+	for _, name := range []string{"/movies/a.mkv", "/posters/a.jpg", "/a.nfo", "/dir"} {
+		if got := c.For(name); got != "no-cache" {
+			t.Errorf("For(%q) = %q, want no-cache", name, got)
+		}
+	}
+}
+
+func TestCacheRulesPrecedence(t *testing.T) {
+	c := NewCacheRules()
+	for _, rule := range []string{
+		"default=no-store",
+		"class:artwork=public, max-age=604800, immutable",
+		"ext:nfo=private, max-age=86400",
+		"/posters=max-age=60",
+		"/posters/raw=",
+	} {
+		if err := c.Add(rule); err != nil {
+			t.Fatalf("Add(%q): %v", rule, err)
+		}
+	}
+	tests := []struct{ name, want string }{
+		{"/movies/a.mkv", "no-store"},
+		{"/movies/a.JPG", "public, max-age=604800, immutable"},
+		{"/movies/a.nfo", "private, max-age=86400"},
+		{"/posters/a.nfo", "max-age=60"},
+		{"/posters", "max-age=60"},
+		{"/postersx/a.mkv", "no-store"},
+		{"/posters/raw/a.jpg", ""},
+	}
+	for _, tt := range tests {
+		if got := c.For(tt.name); got != tt.want {
+			t.Errorf("For(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCacheRulesRejectsUnknownDirective(t *testing.T) {
+	c := NewCacheRules()
+	for _, rule := range []string{"/a=max-age", "/a=max-age=-1", "/a=maxage=60", "a=no-cache", "noequals"} {
+		if err := c.Add(rule); err == nil {
+			t.Errorf("Add(%q) accepted an invalid rule", rule)
+		}
+	}
+}
+
+func TestCacheRulesReloadKeepsOldRulesOnError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(file, []byte("# posters\n/posters=max-age=60\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCacheRules()
+	if err := c.Add("default=no-store"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.For("/posters/a.jpg"); got != "max-age=60" {
+		t.Fatalf("after load: %q", got)
+	}
+
+	if err := os.WriteFile(file, []byte("/posters=bogus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Fatal("Reload accepted an invalid rules file")
+	}
+	if got := c.For("/posters/a.jpg"); got != "max-age=60" {
+		t.Errorf("after failed reload: %q", got)
+	}
+
+	if err := os.WriteFile(file, []byte("/posters=max-age=120\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.For("/posters/a.jpg"); got != "max-age=120" {
+		t.Errorf("after reload: %q", got)
+	}
+	if got := c.For("/a.mkv"); got != "no-store" {
+		t.Errorf("command-line rule lost on reload: %q", got)
+	}
+}
+
+func TestRevalidationUnderLongMaxAge(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.ServeContent(w, r, "", testModTime, strings.NewReader("0123456789"))
+	}))
+	defer srv.Close()
+	fs := newTestFS(t, "/posters/a.jpg#10#a.jpg###2024-01-02T03:04:05Z\n")
+	withBackend(t, fs, srv)
+	if err := fs.cacheRules.Add("class:artwork=public, max-age=31536000, immutable"); err != nil {
+		t.Fatal(err)
+	}
+	meta := *fs.Files["/posters/a.jpg"]
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/posters/a.jpg", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		// 与 main 中的处理函数一样, 先设置 Cache-Control 再转发
+		w.Header().Set("Cache-Control", fs.cacheRules.For(r.URL.Path))
+		m := meta
+		fs.serveUpstream(w, r, &m)
+		return w
+	}
+
+	w := serve(nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+		t.Fatalf("status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+
+	for name, header := range map[string]http.Header{
+		"If-None-Match":     {"If-None-Match": {etag}},
+		"If-Modified-Since": {"If-Modified-Since": {lastModified}},
+	} {
+		before := hits
+		w := serve(header)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: status %d, want 304", name, w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+			t.Errorf("%s: Cache-Control = %q", name, got)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("%s: ETag = %q, want %q", name, w.Header().Get("ETag"), etag)
+		}
+		if hits != before {
+			t.Errorf("%s: the 304 went to the upstream", name)
+		}
+	}
+
+	if w := serve(http.Header{"If-None-Match": {`W/"stale"`}}); w.Code != http.StatusOK {
+		t.Errorf("stale ETag: status %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CaseIndex 是 -case-insensitive 时按小写路径查找条目的索引. 部分平台的 Kodi 会把请求路径
+// 转成小写, 索引把它们对应回列表中的原始路径, 列表和显示名仍然保持原来的大小写.
+// 目录树修改后整体作废, 下一次查找时重建
+type CaseIndex struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+// NewCaseIndex 在 enabled 为 false 时返回 nil, 即区分大小写
+func NewCaseIndex(enabled bool) *CaseIndex {
+	if !enabled {
+		return nil
+	}
+	return &CaseIndex{}
+}
+
+func (c *CaseIndex) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.paths = nil
+	c.mu.Unlock()
+}
+
+// treeChanged 在目录树修改后调用, 作废按整棵树计算的缓存. 子项索引由 setEntryLocked 和
+// deleteEntryLocked 逐项更新, 不在这里作废
+func (fs *TextWebDAVFileSystem) treeChanged() {
+	fs.quota.invalidate()
+	fs.caseIndex.invalidate()
+}
+
+// lookupCaseLocked 返回与 p 只有大小写不同的条目路径. 调用方持有 fs.mu 读锁
+func (fs *TextWebDAVFileSystem) lookupCaseLocked(p string) (string, bool) {
+	c := fs.caseIndex
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paths == nil {
+		c.paths = make(map[string]string, len(fs.Files))
+		for name := range fs.Files {
+			key := strings.ToLower(name)
+			// 只有大小写不同的多个条目取排序最前的一个, 结果不随 map 的遍历顺序变化
+			if cur, ok := c.paths[key]; !ok || name < cur {
+				c.paths[key] = name
+			}
+		}
+	}
+	name, ok := c.paths[strings.ToLower(p)]
+	return name, ok
+}
+
+// resolveCaseLocked 把请求路径换成目录树中的原始路径. 路径本身不存在时 (新建的文件)
+// 只换掉已存在的上级目录部分
+func (fs *TextWebDAVFileSystem) resolveCaseLocked(p string) string {
+	if p == "/" {
+		return p
+	}
+	if _, ok := fs.Files[p]; ok {
+		return p
+	}
+	if name, ok := fs.lookupCaseLocked(p); ok {
+		return name
+	}
+	return path.Join(fs.resolveCaseLocked(path.Dir(p)), path.Base(p))
+}
+
+// resolveRequestPath 对请求路径做大小写查找, 保留结尾的 /. 没有变化时原样返回
+func (fs *TextWebDAVFileSystem) resolveRequestPath(p string) string {
+	clean := fs.normPath(path.Clean("/" + p))
+	fs.mu.RLock()
+	resolved := fs.resolveCaseLocked(clean)
+	fs.mu.RUnlock()
+	if resolved == clean {
+		return p
+	}
+	if strings.HasSuffix(p, "/") && resolved != "/" {
+		resolved += "/"
+	}
+	return resolved
+}
+
+// caseMiddleware 在进入各个处理函数前把请求路径和 Destination 头换成原始路径,
+// OpenFile、Stat、Rename、RemoveAll 和 PROPFIND 因此都按不区分大小写查找
+func (fs *TextWebDAVFileSystem) caseMiddleware(next http.Handler) http.Handler {
+	if fs.caseIndex == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := fs.resolveRequestPath(r.URL.Path); p != r.URL.Path {
+			r.URL.Path = p
+			r.URL.RawPath = ""
+		}
+		if dst := r.Header.Get("Destination"); dst != "" {
+			if u, err := url.Parse(dst); err == nil {
+				if p := fs.resolveRequestPath(u.Path); p != u.Path {
+					u.Path = p
+					u.RawPath = ""
+					r.Header.Set("Destination", u.String())
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reportCaseConflicts 打印只有大小写不同的条目. 大小写与列表不符的请求只能对应到其中一个
+func (fs *TextWebDAVFileSystem) reportCaseConflicts() int {
+	if fs.caseIndex == nil {
+		return 0
+	}
+	fs.mu.RLock()
+	groups := make(map[string][]string)
+	for name := range fs.Files {
+		key := strings.ToLower(name)
+		groups[key] = append(groups[key], name)
+	}
+	fs.mu.RUnlock()
+
+	var conflicts []string
+	for _, names := range groups {
+		if len(names) > 1 {
+			sort.Strings(names)
+			conflicts = append(conflicts, strings.Join(names, " 与 "))
+		}
+	}
+	sort.Strings(conflicts)
+	for _, c := range conflicts {
+		fmt.Printf("大小写冲突: %s, 大小写不符的请求只对应第一个\n", c)
+	}
+	return len(conflicts)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+var charsets = map[string]encoding.Encoding{
+	"gbk":       simplifiedchinese.GBK,
+	"gb18030":   simplifiedchinese.GB18030,
+	"big5":      traditionalchinese.Big5,
+	"shift-jis": japanese.ShiftJIS,
+	"sjis":      japanese.ShiftJIS,
+}
+
+// charsetProfile 为不使用 UTF-8 路径的老客户端转码, 按 User-Agent 子串或来源网段匹配,
+// 只对匹配到的请求生效, 同一台服务器上的其它客户端不受影响
+type charsetProfile struct {
+	charset   string
+	enc       encoding.Encoding
+	userAgent string
+	network   *net.IPNet
+}
+
+// parseCharsetProfile 解析 "gbk:ua=Kodi/16" 或 "big5:cidr=192.168.1.0/24" 形式的配置
+func parseCharsetProfile(spec string) (*charsetProfile, error) {
+	charset, match, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("编码配置格式错误: %q", spec)
+	}
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	enc, ok := charsets[charset]
+	if !ok {
+		return nil, fmt.Errorf("不支持的编码 %q", charset)
+	}
+
+	p := &charsetProfile{charset: charset, enc: enc}
+	key, value, _ := strings.Cut(match, "=")
+	switch strings.TrimSpace(key) {
+	case "ua":
+		p.userAgent = value
+	case "cidr":
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("编码配置的网段无效: %q", value)
+		}
+		p.network = network
+	default:
+		return nil, fmt.Errorf("编码配置只能按 ua= 或 cidr= 匹配: %q", spec)
+	}
+	if p.userAgent == "" && p.network == nil {
+		return nil, fmt.Errorf("编码配置缺少匹配条件: %q", spec)
+	}
+	return p, nil
+}
+
+func (p *charsetProfile) matches(r *http.Request) bool {
+	if p.userAgent != "" {
+		return strings.Contains(r.UserAgent(), p.userAgent)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && p.network.Contains(ip)
+}
+
+// decode 把客户端发来的路径转成 UTF-8. 已经是合法 UTF-8 的路径原样返回,
+// 避免客户端偶尔发 UTF-8 时被二次解码成乱码
+func (p *charsetProfile) decode(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	if decoded, err := p.enc.NewDecoder().String(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+type charsetKey struct{}
+
+// charsetMiddleware 为匹配到配置的请求转码路径和 Destination 头,
+// 并把配置放进 context, 让响应中的 href 按同一编码输出
+func (fs *TextWebDAVFileSystem) charsetMiddleware(next http.Handler) http.Handler {
+	if len(fs.charsetProfiles) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var profile *charsetProfile
+		for _, p := range fs.charsetProfiles {
+			if p.matches(r) {
+				profile = p
+				break
+			}
+		}
+		if profile == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), charsetKey{}, profile))
+		r.URL.Path = profile.decode(r.URL.Path)
+		r.URL.RawPath = ""
+		if dst := r.Header.Get("Destination"); dst != "" {
+			if u, err := url.Parse(dst); err == nil {
+				u.Path = profile.decode(u.Path)
+				u.RawPath = ""
+				r.Header.Set("Destination", u.String())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hrefFor 返回响应中引用 name 的 href, 按 UTF-8 做百分号转义. 原样输出时文件名中的
+// %、#、? 和空格会被客户端按 URL 语法解析, 再请求时就对不上; 有匹配的编码配置时
+// 先转成客户端的编码, 非 UTF-8 的字节同样不能直接写进 XML
+func hrefFor(r *http.Request, name string) string {
+	profile, _ := r.Context().Value(charsetKey{}).(*charsetProfile)
+	if profile != nil {
+		if encoded, err := profile.enc.NewEncoder().String(name); err == nil {
+			name = encoded
+		}
+	}
+	return (&url.URL{Path: name}).EscapedPath()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"time"
+)
+
+// contentHasher 同时计算 MD5 和 SHA-1. 打开写入的句柄边写边算: 内存中的文件在 writeMemory 中,
+// 上传用 io.MultiWriter 把它和管道串起来, 关闭时不需要再完整读一遍内容
+type contentHasher struct {
+	md5  hash.Hash
+	sha1 hash.Hash
+}
+
+func newContentHasher() *contentHasher {
+	return &contentHasher{md5: md5.New(), sha1: sha1.New()}
+}
+
+func (h *contentHasher) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	h.sha1.Write(p)
+	return len(p), nil
+}
+
+func (h *contentHasher) Sums() (string, string) {
+	return hex.EncodeToString(h.md5.Sum(nil)), hex.EncodeToString(h.sha1.Sum(nil))
+}
+
+// 后台补算校验和时每次处理的块大小
+const checksumChunkSize = 256 * 1024
+
+// runChecksumJob 周期性地为有本地内容但还没有校验和的条目补算校验和, 总速率不超过 rate 字节/秒.
+// 已算好的条目会被跳过, 因此任务中断后下一轮自然从剩下的条目继续
+func (fs *TextWebDAVFileSystem) runChecksumJob(rate int64, interval time.Duration) {
+	for {
+		hashed := 0
+		for _, meta := range fs.pendingChecksums() {
+			if fs.fillChecksum(meta, rate) {
+				hashed++
+			}
+		}
+		if hashed > 0 {
+			fmt.Printf("补算校验和: %d 个条目\n", hashed)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (fs *TextWebDAVFileSystem) pendingChecksums() []*FileMeta {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	var pending []*FileMeta
+	for _, meta := range fs.Files {
+		if !meta.IsDir && meta.Content != nil && meta.SHA1 == "" {
+			pending = append(pending, meta)
+		}
+	}
+	return pending
+}
+
+// fillChecksum 在锁外按速率计算一个条目的校验和, 写回前确认内容在此期间没有被替换
+func (fs *TextWebDAVFileSystem) fillChecksum(meta *FileMeta, rate int64) bool {
+	fs.mu.RLock()
+	content := meta.Content
+	fs.mu.RUnlock()
+
+	h := newContentHasher()
+	start := time.Now()
+	for off := 0; off < len(content); off += checksumChunkSize {
+		end := min(off+checksumChunkSize, len(content))
+		h.Write(content[off:end])
+		if rate > 0 {
+			due := time.Duration(float64(end) / float64(rate) * float64(time.Second))
+			if wait := due - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+	md5sum, sha1sum := h.Sums()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !sameContent(meta.Content, content) || meta.SHA1 != "" {
+		return false
+	}
+	meta.MD5, meta.SHA1 = md5sum, sha1sum
+	return true
+}
+
+func sameContent(a, b []byte) bool {
+	if len(a) != len(b) || (a == nil) != (b == nil) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
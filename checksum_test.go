@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestPutComputesChecksums(t *testing.T) {
+	var mu sync.Mutex
+	uploaded := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		uploaded[r.URL.Path] = data
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	fs := newTestFS(t, "/d/a.txt#0#a.txt#"+base64.StdEncoding.EncodeToString([]byte("old"))+"\n/inbox/old.mkv#1#old.mkv\n")
+	if err := fs.uploads.Add("/inbox=" + srv.URL + "/dav"); err != nil {
+		t.Fatal(err)
+	}
+	h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+
+	body := bytes.Repeat([]byte("字幕 checksum\n"), 5000)
+	md5sum, sha1sum := md5.Sum(body), sha1.Sum(body)
+	wantMD5, wantETag := hex.EncodeToString(md5sum[:]), `"`+hex.EncodeToString(sha1sum[:])+`"`
+	query := propfindBody(`<D:prop><D:getcontentmd5/><D:getetag/></D:prop>`)
+
+	// 覆盖内存中的文件、新建文件和上传到可写目录
+	for _, name := range []string{"/d/a.txt", "/d/new.txt", "/inbox/up.mkv"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, name, bytes.NewReader(body)))
+		if w.Code >= 300 {
+			t.Fatalf("PUT %s: status %d", name, w.Code)
+		}
+		values := okValues(t, propfind(fs, name, "0", query).Body.String())
+		if etag := propText(values["getetag"]); values["getcontentmd5"] != wantMD5 || etag != wantETag {
+			t.Errorf("%s: getcontentmd5 %q, getetag %s; want %s, %s", name, values["getcontentmd5"], etag, wantMD5, wantETag)
+		}
+	}
+	mu.Lock()
+	if !bytes.Equal(uploaded["/dav/up.mkv"], body) {
+		t.Errorf("upstream received %d bytes, want %d", len(uploaded["/dav/up.mkv"]), len(body))
+	}
+	mu.Unlock()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/d/new.txt", nil))
+	if got := w.Header().Get("ETag"); got != wantETag {
+		t.Errorf("GET ETag %s, want %s", got, wantETag)
+	}
+
+	// 写入不是从开头连续进行时不保存校验和, ETag 退回弱 ETag
+	ctx := context.Background()
+	for desc, write := range map[string]func(f webdav.File){
+		"overwrite in place": func(f webdav.File) { f.Write([]byte("X")) },
+		"seek back": func(f webdav.File) {
+			f.Write([]byte("abc"))
+			f.Seek(1, io.SeekStart)
+			f.Write([]byte("x"))
+		},
+		"seek past the end": func(f webdav.File) {
+			f.Seek(2, io.SeekStart)
+			f.Write([]byte("x"))
+		},
+	} {
+		flag := os.O_RDWR | os.O_TRUNC
+		if desc == "overwrite in place" {
+			flag = os.O_RDWR
+		}
+		f, err := fs.OpenFile(ctx, "/d/new.txt", flag, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(f)
+		if err := f.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", desc, err)
+		}
+		if meta := fs.Files["/d/new.txt"]; meta.MD5 != "" || meta.SHA1 != "" || !strings.HasPrefix(meta.etag(), "W/") {
+			t.Errorf("%s: MD5 %q, SHA-1 %q, ETag %s", desc, meta.MD5, meta.SHA1, meta.etag())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"path"
+	"sync"
+)
+
+// ChildIndex 记录每个目录的直接子项路径. 列出目录 (Readdir、PROPFIND Depth: 1、目录页)
+// 只需处理该目录的子项, 不必遍历整棵树; 几万个子项的目录也能很快列出.
+// 第一次列出时整体建立, 之后条目的增删通过 setEntryLocked/deleteEntryLocked 只更新上级目录的
+// 子项集合; 只有整棵树被替换 (批量操作提交、重新加载) 时才作废重建
+type ChildIndex struct {
+	mu       sync.Mutex
+	children map[string]map[string]struct{}
+}
+
+func NewChildIndex() *ChildIndex {
+	return &ChildIndex{}
+}
+
+func (c *ChildIndex) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.children = nil
+	c.mu.Unlock()
+}
+
+// add 把新条目 p 加入上级目录的子项集合. 索引还没有建立时什么也不做
+func (c *ChildIndex) add(p string) {
+	if c == nil || p == "/" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.children == nil {
+		return
+	}
+	parent := path.Dir(p)
+	set := c.children[parent]
+	if set == nil {
+		set = make(map[string]struct{})
+		c.children[parent] = set
+	}
+	set[p] = struct{}{}
+}
+
+// remove 把 p 从上级目录的子项集合中删除, 并丢掉 p 自己的子项集合
+func (c *ChildIndex) remove(p string) {
+	if c == nil || p == "/" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.children == nil {
+		return
+	}
+	parent := path.Dir(p)
+	if set := c.children[parent]; set != nil {
+		delete(set, p)
+		if len(set) == 0 {
+			delete(c.children, parent)
+		}
+	}
+	delete(c.children, p)
+}
+
+// setEntryLocked 在目录树中放入或替换 name 的条目, 新增时更新子项索引. 调用方持有 fs.mu 写锁
+func (fs *TextWebDAVFileSystem) setEntryLocked(name string, meta *FileMeta) {
+	if _, ok := fs.Files[name]; !ok {
+		fs.children.add(name)
+	}
+	fs.Files[name] = meta
+}
+
+// deleteEntryLocked 从目录树中删除 name 这一个条目 (不含子项), 并更新子项索引. 调用方持有 fs.mu 写锁
+func (fs *TextWebDAVFileSystem) deleteEntryLocked(name string) {
+	if _, ok := fs.Files[name]; ok {
+		delete(fs.Files, name)
+		fs.children.remove(name)
+	}
+}
+
+// childPathsLocked 返回 dir 的直接子项路径, 顺序不定. 没有索引时 (批量操作和重新加载
+// 使用的临时目录树) 遍历整棵树. 调用方持有 fs.mu 读锁
+func (fs *TextWebDAVFileSystem) childPathsLocked(dir string) []string {
+	c := fs.children
+	if c == nil {
+		var paths []string
+		for p := range fs.Files {
+			if p != dir && path.Dir(p) == dir {
+				paths = append(paths, p)
+			}
+		}
+		return paths
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.children == nil {
+		c.children = make(map[string]map[string]struct{})
+		for p := range fs.Files {
+			if p != "/" {
+				parent := path.Dir(p)
+				set := c.children[parent]
+				if set == nil {
+					set = make(map[string]struct{})
+					c.children[parent] = set
+				}
+				set[p] = struct{}{}
+			}
+		}
+	}
+	set := c.children[dir]
+	paths := make([]string, 0, len(set))
+	for p := range set {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// descendantsLocked 按先序返回 dir 下的所有条目, 每层的顺序与 childrenLocked 相同.
+// 超过 limit 个时停止并返回 false, limit 不大于 0 表示不限制. 调用方持有 fs.mu 读锁
+func (fs *TextWebDAVFileSystem) descendantsLocked(dir string, limit int) ([]*FileMeta, bool) {
+	var entries []*FileMeta
+	var walk func(dir string) bool
+	walk = func(dir string) bool {
+		for _, meta := range fs.childrenLocked(dir) {
+			if limit > 0 && len(entries) >= limit {
+				return false
+			}
+			entries = append(entries, meta)
+			if meta.IsDir && !walk(meta.Path) {
+				return false
+			}
+		}
+		return true
+	}
+	return entries, walk(dir)
+}
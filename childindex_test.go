@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// scanChildren 是不用索引、遍历整棵树得到的子项, 作为索引的参照
+func scanChildren(fs *TextWebDAVFileSystem, dir string) []string {
+	var paths []string
+	for p := range fs.Files {
+		if p != "/" && path.Dir(p) == dir {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func indexedChildren(fs *TextWebDAVFileSystem, dir string) []string {
+	paths := fs.childPathsLocked(dir)
+	sort.Strings(paths)
+	return paths
+}
+
+func TestChildIndexFollowsMutations(t *testing.T) {
+	fs := newTestFS(t, "/a/1.mkv#1#1.mkv\n/a/2.mkv#1#2.mkv\n/b/c/3.mkv#1#3.mkv\n")
+	rng := rand.New(rand.NewSource(1))
+	dirs := []string{"/", "/a", "/b", "/b/c", "/d", "/d/e"}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// 先列出一次, 让索引建立起来, 之后的修改都在索引上逐项更新
+	fs.childPathsLocked("/")
+	for i := 0; i < 2000; i++ {
+		dir := dirs[rng.Intn(len(dirs))]
+		name := path.Join(dir, fmt.Sprintf("f%d", rng.Intn(20)))
+		var err error
+		switch rng.Intn(6) {
+		case 0:
+			err = fs.mkdirLocked(name)
+		case 1:
+			_, err = fs.putLocked(name, 1, "", "")
+		case 2:
+			err = fs.removeAllLocked(name)
+		case 3:
+			err = fs.renameLocked(name, path.Join(dirs[rng.Intn(len(dirs))], fmt.Sprintf("f%d", rng.Intn(20))))
+		case 4:
+			err = fs.copyLocked(name, path.Join(dirs[rng.Intn(len(dirs))], fmt.Sprintf("f%d", rng.Intn(20))), true)
+		case 5:
+			fs.pruneEmptyParentsLocked(name + "/x")
+		}
+		if err == nil {
+			fs.treeChanged()
+		}
+
+		for _, d := range append(dirs, name) {
+			if got, want := indexedChildren(fs, d), scanChildren(fs, d); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("step %d: children of %s = %v, want %v", i, d, got, want)
+			}
+		}
+	}
+}
+
+func TestChildIndexRebuiltAfterBatch(t *testing.T) {
+	fs := newTestFS(t, "/a/1.mkv#1#1.mkv\n")
+	fs.mu.Lock()
+	fs.childPathsLocked("/a")
+	work := fs.workingCopyLocked()
+	if _, err := work.applyBatchOpLocked(batchOp{Op: "create", Path: "/a/2.mkv", Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	fs.Files = work.Files
+	fs.children.invalidate()
+	got := indexedChildren(fs, "/a")
+	fs.mu.Unlock()
+	if strings.Join(got, ",") != "/a/1.mkv,/a/2.mkv" {
+		t.Errorf("children after a swap: %v", got)
+	}
+}
+
+// largeTree 生成 dir 下 n 个文件, 以及其它目录下 rest 个文件
+func largeTree(b *testing.B, dir string, n, rest int) *TextWebDAVFileSystem {
+	fs := newTestFS(b, "")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%s/%06d.mkv", dir, i)
+		fs.setEntryLocked(name, &FileMeta{Path: name, Size: 1, DisplayName: path.Base(name), ModTime: testModTime})
+	}
+	for i := 0; i < rest; i++ {
+		name := fmt.Sprintf("/other/%03d/%06d.mkv", i%1000, i)
+		fs.setEntryLocked(name, &FileMeta{Path: name, Size: 1, DisplayName: path.Base(name), ModTime: testModTime})
+		fs.ensureParentsLocked(name)
+	}
+	fs.ensureParentsLocked(dir + "/x")
+	// 索引在第一次列出时建立, 不计入基准
+	fs.childPathsLocked("/")
+	return fs
+}
+
+// BenchmarkListLargeDirectory 列出有 60000 个子项的目录, 整棵树共 260000 个条目
+func BenchmarkListLargeDirectory(b *testing.B) {
+	fs := largeTree(b, "/big", 60000, 200000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs.mu.RLock()
+		if n := len(fs.childrenLocked("/big")); n != 60000 {
+			b.Fatalf("listed %d entries", n)
+		}
+		fs.mu.RUnlock()
+	}
+}
+
+// BenchmarkListAfterMutation 每次列出之前新增并删除一个条目. 修改只更新上级目录的子项,
+// 不会让下一次列出重建整棵树的索引
+func BenchmarkListAfterMutation(b *testing.B) {
+	fs := largeTree(b, "/big", 60000, 200000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs.mu.Lock()
+		if _, err := fs.putLocked("/other/000/new.mkv", 1, "", ""); err != nil {
+			b.Fatal(err)
+		}
+		fs.treeChanged()
+		fs.deleteEntryLocked("/other/000/new.mkv")
+		fs.treeChanged()
+		n := len(fs.childrenLocked("/other/000"))
+		fs.mu.Unlock()
+		if n != 200 {
+			b.Fatalf("listed %d entries", n)
+		}
+	}
+}
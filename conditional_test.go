@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConditionalGet(t *testing.T) {
+	body := []byte("0123456789")
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+	fs := newTestFS(t, "/a.mkv#10#a.mkv##abc\n")
+	withBackend(t, fs, srv)
+	fs.Files["/a.mkv"].ModTime = testModTime
+
+	const etag = `"abc"`
+	current := httpDate(testModTime)
+	older := httpDate(testModTime.Add(-time.Hour))
+	tests := []struct {
+		desc   string
+		header http.Header
+		want   int
+	}{
+		{"no validators", nil, http.StatusOK},
+		{"If-Modified-Since current", http.Header{"If-Modified-Since": {current}}, http.StatusNotModified},
+		{"If-Modified-Since older", http.Header{"If-Modified-Since": {older}}, http.StatusOK},
+		{"If-None-Match current", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"If-None-Match weak form", http.Header{"If-None-Match": {`W/"abc"`}}, http.StatusNotModified},
+		{"If-None-Match stale", http.Header{"If-None-Match": {`"old"`}}, http.StatusOK},
+		// 两者都有时 If-None-Match 优先, If-Modified-Since 被忽略
+		{"both match", http.Header{"If-None-Match": {etag}, "If-Modified-Since": {current}}, http.StatusNotModified},
+		{"stale ETag, current date", http.Header{"If-None-Match": {`"old"`}, "If-Modified-Since": {current}}, http.StatusOK},
+		{"current ETag, older date", http.Header{"If-None-Match": {etag}, "If-Modified-Since": {older}}, http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		before := atomic.LoadInt32(&hits)
+		w := getUpstream(fs, "/a.mkv", tt.header)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.desc, w.Code, tt.want)
+			continue
+		}
+		if tt.want == http.StatusNotModified {
+			if w.Body.Len() != 0 || w.Header().Get("ETag") != etag || w.Header().Get("Last-Modified") != current {
+				t.Errorf("%s: body %q, ETag %q, Last-Modified %q", tt.desc, w.Body.Bytes(), w.Header().Get("ETag"), w.Header().Get("Last-Modified"))
+			}
+			if atomic.LoadInt32(&hits) != before {
+				t.Errorf("%s: the 304 went to the upstream", tt.desc)
+			}
+		} else if !bytes.Equal(w.Body.Bytes(), body) {
+			t.Errorf("%s: body %q", tt.desc, w.Body.Bytes())
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	body := []byte("0123456789")
+	fs := newTestFS(t, "/a.mkv#10#a.mkv##abc\n")
+	withBackend(t, fs, rangedUpstream(t, body))
+	fs.Files["/a.mkv"].ModTime = testModTime
+
+	for _, tt := range []struct {
+		ifRange string
+		want    int
+	}{
+		{`"abc"`, http.StatusPartialContent},
+		{httpDate(testModTime), http.StatusPartialContent},
+		{`"old"`, http.StatusOK},
+		{`W/"abc"`, http.StatusOK},
+		{httpDate(testModTime.Add(-time.Hour)), http.StatusOK},
+	} {
+		w := getUpstream(fs, "/a.mkv", http.Header{"Range": {"bytes=2-4"}, "If-Range": {tt.ifRange}})
+		want := body
+		if tt.want == http.StatusPartialContent {
+			want = body[2:5]
+		}
+		if w.Code != tt.want || !bytes.Equal(w.Body.Bytes(), want) {
+			t.Errorf("If-Range %s: status %d, body %q; want %d, %q", tt.ifRange, w.Code, w.Body.Bytes(), tt.want, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// ContentCache 在内存中缓存小文件 (字幕、nfo、海报等) 的完整内容, 刮削器反复读取时
+// 直接从内存回答 GET 和 Range, 不再请求上游. 按最近使用淘汰, 总字节数不超过 max.
+// 键包含路径、大小、修改时间和 ETag, 条目的任何一项变化后旧内容自然不再命中
+type ContentCache struct {
+	mu    sync.Mutex
+	max   int64
+	limit int64
+	used  int64
+	order *list.List // 前面是最近使用的
+	items map[string]*list.Element
+
+	hits, misses int64
+}
+
+type cachedContent struct {
+	key  string
+	data []byte
+	// ctype 是上游返回的 Content-Type, 扩展名推断不出类型时使用
+	ctype string
+}
+
+// ContentCacheStats 是 /api/stats 中的缓存统计
+type ContentCacheStats struct {
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFile  int64 `json:"max_file_bytes"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+}
+
+// NewContentCache 在 max 或 limit 不大于 0 时返回 nil, 即不缓存
+func NewContentCache(max, limit int64) *ContentCache {
+	if max <= 0 || limit <= 0 {
+		return nil
+	}
+	if limit > max {
+		limit = max
+	}
+	return &ContentCache{max: max, limit: limit, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func contentCacheKey(meta *FileMeta) string {
+	return fmt.Sprintf("%s\x00%d\x00%d\x00%s", meta.Path, meta.Size, meta.ModTime.UnixNano(), meta.etag())
+}
+
+// accepts 报告 meta 是否应该经过缓存: 没有本地内容、大小已知且不超过单个文件的上限
+func (c *ContentCache) accepts(meta *FileMeta) bool {
+	return c != nil && !meta.IsDir && meta.Content == nil && meta.Size > 0 && meta.Size <= c.limit
+}
+
+func (c *ContentCache) get(meta *FileMeta) (*cachedContent, bool) {
+	if !c.accepts(meta) {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[contentCacheKey(meta)]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedContent), true
+}
+
+func (c *ContentCache) put(meta *FileMeta, data []byte, ctype string) {
+	if !c.accepts(meta) || int64(len(data)) != meta.Size {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := contentCacheKey(meta)
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cachedContent{key: key, data: data, ctype: ctype})
+	c.used += int64(len(data))
+	for c.used > c.max {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*cachedContent)
+		c.order.Remove(oldest)
+		delete(c.items, entry.key)
+		c.used -= int64(len(entry.data))
+	}
+}
+
+// Purge 清空缓存, 在重新加载目录树后调用
+func (c *ContentCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	c.used = 0
+}
+
+func (c *ContentCache) Stats() ContentCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ContentCacheStats{Entries: len(c.items), Bytes: c.used, MaxBytes: c.max, MaxFile: c.limit, Hits: c.hits, Misses: c.misses}
+}
+
+// serveCached 在缓存命中时直接回答 GET, 返回 false 时由调用方照常请求上游
+func (fs *TextWebDAVFileSystem) serveCached(w http.ResponseWriter, r *http.Request, meta *FileMeta) bool {
+	entry, ok := fs.contentCache.get(meta)
+	if !ok {
+		return false
+	}
+	fs.serveContent(w, r, meta, entry.data, entry.ctype)
+	return true
+}
+
+// fillCache 读完 resp 中的完整内容存入缓存并回答客户端. 读取失败时返回错误, 这时还没有写出任何内容.
+// resp 必须是长度与 meta.Size 一致的 200 响应
+func (fs *TextWebDAVFileSystem) fillCache(w http.ResponseWriter, r *http.Request, meta *FileMeta, resp *http.Response) error {
+	data := make([]byte, meta.Size)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return err
+	}
+	ctype := resp.Header.Get("Content-Type")
+	fs.contentCache.put(meta, data, ctype)
+	fs.serveContent(w, r, meta, data, ctype)
+	return nil
+}
+
+// serveContent 用内存中的内容回答 GET, Range 和条件请求由 http.ServeContent 处理
+func (fs *TextWebDAVFileSystem) serveContent(w http.ResponseWriter, r *http.Request, meta *FileMeta, data []byte, ctype string) {
+	h := w.Header()
+	h.Set("ETag", meta.etag())
+	if t := mime.TypeByExtension(path.Ext(meta.Path)); t != "" {
+		ctype = t
+	}
+	if ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	http.ServeContent(w, r, meta.Path, meta.ModTime, bytes.NewReader(data))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// HandleCopy 在目录树内复制条目. webdav.Handler 的通用实现会读出源文件再写入新文件,
+// 对只有上游地址的条目只能得到空内容, 并丢掉死属性. 这里直接复制元数据: 大小、显示名、
+// 上游地址、本地内容和死属性都保留, 目录按 Depth 复制整棵子树或只复制目录本身
+func (fs *TextWebDAVFileSystem) HandleCopy(w http.ResponseWriter, r *http.Request) {
+	src := fs.keyPath(r.URL.Path)
+	if src == "" {
+		src = "/"
+	}
+
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		http.Error(w, "Destination 无效", http.StatusBadRequest)
+		return
+	}
+	if u.Host != "" && u.Host != r.Host {
+		http.Error(w, "不能复制到其它服务器", http.StatusBadGateway)
+		return
+	}
+	dst := fs.keyPath(path.Clean(u.Path))
+
+	recursive := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		recursive = false
+	default:
+		http.Error(w, "Depth 只能是 0 或 infinity", http.StatusBadRequest)
+		return
+	}
+	overwrite := true
+	switch r.Header.Get("Overwrite") {
+	case "", "T":
+	case "F":
+		overwrite = false
+	default:
+		http.Error(w, "Overwrite 只能是 T 或 F", http.StatusBadRequest)
+		return
+	}
+
+	_, span := startSpan(r.Context(), "vfs.Copy")
+	span.SetPath("path", src)
+	span.SetPath("destination", dst)
+	defer span.End()
+
+	if src == "/" || dst == "/" || src == dst {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// 与 webdav.Handler 的 COPY 一样只检查目标上的锁
+	release, status, err := fs.confirmLocks(r, "", dst)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer release()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.Files[src]; !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if parent := path.Dir(dst); parent != "/" {
+		if meta, ok := fs.Files[parent]; !ok || !meta.IsDir {
+			http.Error(w, errNoParent.Error(), http.StatusConflict)
+			return
+		}
+	}
+	_, exists := fs.Files[dst]
+	if exists {
+		if !overwrite {
+			http.Error(w, "目标已存在", http.StatusPreconditionFailed)
+			return
+		}
+		if err := fs.removeAllLocked(dst); err != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		fs.recordMutation(JournalRecord{Op: JournalDelete, Path: dst})
+	}
+
+	if err := fs.copyLocked(src, dst, recursive); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fs.recordMutation(JournalRecord{Op: JournalCopy, Path: src, To: dst, Shallow: !recursive})
+
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// copyLocked 把 src 复制到 dst, recursive 时包括目录下的所有条目. 调用方已确认 dst 不存在.
+// 先收集再写入, 复制到自己的子目录时不会把刚复制出的条目再复制一遍
+func (fs *TextWebDAVFileSystem) copyLocked(src, dst string, recursive bool) error {
+	root, ok := fs.Files[src]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	copies := map[string]*FileMeta{dst: cloneMeta(root, dst)}
+	copies[dst].DisplayName = fs.renamedDisplayName(root, dst)
+	if recursive && root.IsDir {
+		for p, meta := range fs.Files {
+			if strings.HasPrefix(p, src+"/") {
+				name := dst + strings.TrimPrefix(p, src)
+				copies[name] = cloneMeta(meta, name)
+			}
+		}
+	}
+	for name, meta := range copies {
+		fs.setEntryLocked(name, meta)
+	}
+	fs.ensureParentsLocked(dst)
+	return nil
+}
+
+// cloneMeta 复制条目的元数据, 属性版本从头计数
+func cloneMeta(meta *FileMeta, name string) *FileMeta {
+	c := *meta
+	c.Path = name
+	c.Content = bytes.Clone(meta.Content)
+	c.Props = maps.Clone(meta.Props)
+	c.PropVersion = 0
+	return &c
+}
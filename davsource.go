@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const davPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:displayname/><D:getcontentlength/><D:getlastmodified/><D:resourcetype/></D:prop></D:propfind>`
+
+// WebDAVSource 从另一个 WebDAV 服务逐层 PROPFIND Depth:1 抓取目录树,
+// 挂载到虚拟树的 mount 下, 文件内容地址指向远端的 GET 地址
+type WebDAVSource struct {
+	base    *url.URL
+	mount   string
+	workers int
+	client  *http.Client
+
+	mu      sync.Mutex
+	dirMods map[string]string
+}
+
+type davResponse struct {
+	Href     string `xml:"href"`
+	Propstat []struct {
+		Status string `xml:"status"`
+		Prop   struct {
+			DisplayName   string `xml:"displayname"`
+			ContentLength string `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+			ResourceType  struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"prop"`
+	} `xml:"propstat"`
+}
+
+type davEntry struct {
+	rel          string
+	href         string
+	displayName  string
+	size         int64
+	lastModified string
+	isDir        bool
+}
+
+func NewWebDAVSource(rawURL, mount string, workers int) (*WebDAVSource, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("WebDAV 源地址无效: %q", redactURL(rawURL))
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	if mount == "" {
+		mount = "/"
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &WebDAVSource{
+		base:    base,
+		mount:   path.Clean("/" + mount),
+		workers: workers,
+		client:  &http.Client{Timeout: 5 * time.Minute},
+		dirMods: make(map[string]string),
+	}, nil
+}
+
+// Crawl 抓取整棵远端目录树. 再次抓取时, getlastmodified 与上次相同的子目录整棵跳过,
+// 沿用内存中已有的条目; 单个目录抓取失败时保留该目录原有内容
+func (s *WebDAVSource) Crawl(ctx context.Context, fs *TextWebDAVFileSystem) error {
+	start := time.Now()
+
+	fs.mu.Lock()
+	fs.ensureMountLocked(s.mount)
+	fs.mu.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		pending []davEntry
+		failed  int
+	)
+	sem := make(chan struct{}, s.workers)
+	visit := func(dir davEntry) {
+		defer wg.Done()
+		defer func() { <-sem }()
+
+		children, err := s.listDir(ctx, fs, dir.rel)
+		if err != nil {
+			fmt.Printf("抓取 WebDAV 目录 %s 失败: %v\n", dir.rel, err)
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			return
+		}
+
+		// 只有目录本身抓取成功才记下它的修改时间, 失败的目录下次一定重新抓取
+		s.mu.Lock()
+		s.dirMods[dir.rel] = dir.lastModified
+		s.mu.Unlock()
+
+		mu.Lock()
+		pending = append(pending, children...)
+		mu.Unlock()
+	}
+
+	queue, err := s.listDir(ctx, fs, "")
+	if err != nil {
+		return err
+	}
+	for len(queue) > 0 {
+		for _, dir := range queue {
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go visit(dir)
+		}
+		wg.Wait()
+		queue, pending = pending, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("WebDAV 源 %s 抓取完成, 用时 %v, 失败目录 %d 个\n", redactURL(s.base.String()), time.Since(start), failed)
+	return nil
+}
+
+// listDir 抓取一个目录的直接子项并合并进虚拟树, 返回修改时间有变化、需要继续抓取的子目录
+func (s *WebDAVSource) listDir(ctx context.Context, fs *TextWebDAVFileSystem, rel string) ([]davEntry, error) {
+	var entries []davEntry
+	err := s.propfind(ctx, fs, rel, "1", func(e davEntry) {
+		if e.rel != rel {
+			entries = append(entries, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dir := fs.normPath(s.virtualPath(rel))
+	seen := make(map[string]bool, len(entries))
+	var subdirs []davEntry
+
+	fs.mu.Lock()
+	for _, e := range entries {
+		vpath := fs.normPath(s.virtualPath(e.rel))
+		if !fs.filter.Allow(vpath, e.isDir) {
+			continue
+		}
+		seen[vpath] = true
+
+		modTime := time.Now()
+		if t, err := http.ParseTime(e.lastModified); err == nil {
+			modTime = t
+		}
+		entry := sourceEntry{path: vpath, displayName: e.displayName, size: e.size, modTime: modTime, isDir: e.isDir}
+		if !e.isDir {
+			entry.url = s.base.ResolveReference(&url.URL{Path: e.href}).String()
+		}
+		ok := fs.upsertSourceEntryLocked(entry)
+		if !e.isDir {
+			continue
+		}
+
+		s.mu.Lock()
+		unchanged := ok && e.lastModified != "" && s.dirMods[e.rel] == e.lastModified
+		s.mu.Unlock()
+		if !unchanged {
+			subdirs = append(subdirs, e)
+		}
+	}
+
+	fs.pruneSourceDirLocked(dir, seen)
+	fs.mu.Unlock()
+
+	return subdirs, nil
+}
+
+func (s *WebDAVSource) virtualPath(rel string) string {
+	return path.Join(s.mount, rel)
+}
+
+// propfind 发送 PROPFIND 并流式解析 multistatus, 每解析出一个 response 就回调一次,
+// 不会把巨大的 Depth:1 响应整体读进内存
+func (s *WebDAVSource) propfind(ctx context.Context, fs *TextWebDAVFileSystem, rel, depth string, fn func(davEntry)) error {
+	target := s.base.ResolveReference(&url.URL{Path: strings.TrimPrefix(rel, "/")})
+	if rel != "" {
+		target.Path += "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", target.String(), strings.NewReader(davPropfindBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	fs.authorizeUpstream(req)
+	fs.upstreamHeaders.apply(req, target.String())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return fmt.Errorf("PROPFIND %s 返回 %s", redactURL(target.String()), resp.Status)
+	}
+
+	dec := xml.NewDecoder(resp.Body)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("解析 PROPFIND 响应失败: %v", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Space != "DAV:" || start.Name.Local != "response" {
+			continue
+		}
+		var r davResponse
+		if err := dec.DecodeElement(&r, &start); err != nil {
+			return fmt.Errorf("解析 PROPFIND 响应失败: %v", err)
+		}
+		if e, ok := s.toEntry(r); ok {
+			fn(e)
+		}
+	}
+}
+
+func (s *WebDAVSource) toEntry(r davResponse) (davEntry, bool) {
+	href, err := url.Parse(strings.TrimSpace(r.Href))
+	if err != nil || !strings.HasPrefix(href.Path+"/", s.base.Path) {
+		return davEntry{}, false
+	}
+
+	e := davEntry{
+		rel:  strings.Trim(strings.TrimPrefix(href.Path+"/", s.base.Path), "/"),
+		href: href.Path,
+	}
+	for _, ps := range r.Propstat {
+		if !strings.Contains(ps.Status, " 200") {
+			continue
+		}
+		e.displayName = strings.TrimSpace(ps.Prop.DisplayName)
+		e.lastModified = strings.TrimSpace(ps.Prop.LastModified)
+		e.isDir = ps.Prop.ResourceType.Collection != nil
+		if n, err := strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64); err == nil {
+			e.size = n
+		}
+	}
+	return e, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// destinationMiddleware 校验 MOVE/COPY 的 Destination 是否指向本服务器. RFC 4918 规定
+// 指向其它服务器时返回 502, 不能只取路径部分在本地移动. 反向代理改写了 Host 时客户端写的是
+// 代理对外的地址, 与 X-Forwarded-Host 或 -dest-host 中的主机名相同也算本服务器,
+// 这时把 Destination 的主机换成 Host, 后面的处理函数按原来的方式比较
+func (fs *TextWebDAVFileSystem) destinationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dst := r.Header.Get("Destination")
+		if dst == "" || r.Method != "MOVE" && r.Method != "COPY" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u, err := url.Parse(dst)
+		if err != nil || u.Path == "" {
+			http.Error(w, "Destination 无效", http.StatusBadRequest)
+			return
+		}
+		// 只有路径的 Destination 本来就指向本服务器
+		if u.Host != "" {
+			if u.Scheme != "http" && u.Scheme != "https" || !fs.localDestination(r, u) {
+				fmt.Printf("%s 的 Destination 指向其它服务器: %s\n", r.Method, dst)
+				http.Error(w, "Destination 指向其它服务器", http.StatusBadGateway)
+				return
+			}
+			if u.Host != r.Host {
+				u.Host = r.Host
+				r.Header.Set("Destination", u.String())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localDestination 报告 Destination 的主机是否为本服务器: 与 Host、X-Forwarded-Host
+// 或 -dest-host 之一相同. 比较时不区分大小写, 并忽略协议的默认端口
+func (fs *TextWebDAVFileSystem) localDestination(r *http.Request, u *url.URL) bool {
+	host := canonicalHost(u.Host, u.Scheme)
+	candidates := []string{r.Host}
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		// 经过多层代理时第一个是客户端请求的地址
+		candidates = append(candidates, strings.TrimSpace(strings.Split(fwd, ",")[0]))
+	}
+	candidates = append(candidates, fs.destHosts...)
+	for _, c := range candidates {
+		if canonicalHost(c, u.Scheme) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalHost 把主机名转成小写, 去掉与 scheme 对应的默认端口
+func canonicalHost(host, scheme string) string {
+	host = strings.ToLower(host)
+	switch {
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		return strings.TrimSuffix(host, ":80")
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		return strings.TrimSuffix(host, ":443")
+	}
+	return host
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// 移动/重命名时 displayname 的处理方式
+const (
+	RenamePreserve = "preserve" // 保留原来的显示名
+	RenameBasename = "basename" // 改成新的文件名
+	RenameTemplate = "template" // 按新路径所在前缀的模板重新生成, 没有匹配的模板时改成新的文件名
+)
+
+// displayTemplate 为某个路径前缀下的条目生成显示名. 模板中可用
+// {name} 文件名, {stem} 去掉扩展名的文件名, {ext} 扩展名 (不含点), {parent} 上级目录名
+type displayTemplate struct {
+	prefix string
+	tmpl   string
+}
+
+func (fs *TextWebDAVFileSystem) addDisplayTemplate(rule string) error {
+	prefix, tmpl, ok := strings.Cut(rule, "=")
+	if !ok || !strings.HasPrefix(prefix, "/") || tmpl == "" {
+		return fmt.Errorf("显示名模板格式错误, 需要 /prefix=模板: %q", rule)
+	}
+	fs.displayTemplates = append(fs.displayTemplates, displayTemplate{prefix: path.Clean(prefix), tmpl: tmpl})
+	return nil
+}
+
+func parseRenamePolicy(s string) (string, error) {
+	switch s {
+	case RenamePreserve, RenameBasename, RenameTemplate:
+		return s, nil
+	}
+	return "", fmt.Errorf("未知的重命名显示名策略 %q, 可选 preserve、basename、template", s)
+}
+
+// renamedDisplayName 返回条目移动到 newPath 后的显示名. 文件名没有变化时
+// (只是换了目录) 总是保留原显示名, 避免覆盖手工设置的名字
+func (fs *TextWebDAVFileSystem) renamedDisplayName(meta *FileMeta, newPath string) string {
+	base := path.Base(newPath)
+	if base == path.Base(meta.Path) {
+		return meta.DisplayName
+	}
+
+	switch fs.renamePolicy {
+	case RenameBasename:
+		return base
+	case RenameTemplate:
+		if t := fs.displayTemplateFor(newPath); t != nil {
+			return expandDisplayTemplate(t.tmpl, newPath)
+		}
+		return base
+	}
+	// 显示名就是原文件名时不是手工设置的, 保留策略下也跟着改成新文件名
+	if meta.DisplayName == path.Base(meta.Path) {
+		return base
+	}
+	return meta.DisplayName
+}
+
+// displayTemplateFor 返回最长前缀匹配的模板
+func (fs *TextWebDAVFileSystem) displayTemplateFor(p string) *displayTemplate {
+	var best *displayTemplate
+	for i := range fs.displayTemplates {
+		t := &fs.displayTemplates[i]
+		if (t.prefix == "/" || strings.HasPrefix(p, t.prefix+"/")) && (best == nil || len(t.prefix) > len(best.prefix)) {
+			best = t
+		}
+	}
+	return best
+}
+
+func expandDisplayTemplate(tmpl, p string) string {
+	name := path.Base(p)
+	ext := path.Ext(name)
+	return strings.NewReplacer(
+		"{name}", name,
+		"{stem}", strings.TrimSuffix(name, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{parent}", path.Base(path.Dir(p)),
+	).Replace(tmpl)
+}
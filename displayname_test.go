@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRenameBumpsPropVersionWithDisplayName(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#1#a.mkv\n/b.mkv#1#Custom Name\n/d/c.mkv#1#c.mkv\n")
+	fs.renamePolicy = RenamePreserve
+
+	tests := []struct {
+		from, to string
+		name     string
+		bumped   bool
+	}{
+		// 显示名是原文件名, 跟着改名
+		{"/a.mkv", "/x.mkv", "x.mkv", true},
+		// 手工设置的显示名保留
+		{"/b.mkv", "/y.mkv", "Custom Name", false},
+		// 只换目录, 显示名不变
+		{"/d/c.mkv", "/c.mkv", "c.mkv", false},
+	}
+	for _, tt := range tests {
+		before := fs.Files[tt.from].PropVersion
+		if err := fs.renameLocked(tt.from, tt.to); err != nil {
+			t.Fatalf("rename %s: %v", tt.from, err)
+		}
+		meta := fs.Files[tt.to]
+		if meta.DisplayName != tt.name {
+			t.Errorf("%s: displayname %q, want %q", tt.to, meta.DisplayName, tt.name)
+		}
+		if bumped := meta.PropVersion != before; bumped != tt.bumped {
+			t.Errorf("%s: version %d -> %d, bumped = %v, want %v", tt.to, before, meta.PropVersion, bumped, tt.bumped)
+		}
+	}
+}
+
+func TestRenameTemplateBumpsPropVersion(t *testing.T) {
+	fs := newTestFS(t, "/in/a.mkv#1#a.mkv\n")
+	fs.renamePolicy = RenameTemplate
+	if err := fs.addDisplayTemplate("/out={stem} (moved)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.mkdirLocked("/out"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.checkPropVersionLocked("/in/a.mkv", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.renameLocked("/in/a.mkv", "/out/b.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Files["/out/b.mkv"].DisplayName; got != "b (moved)" {
+		t.Errorf("displayname %q", got)
+	}
+	if err := fs.checkPropVersionLocked("/out/b.mkv", "0"); err != errVersionMismatch {
+		t.Errorf("stale version accepted after the displayname changed: %v", err)
+	}
+}
+
+func TestRenamePolicies(t *testing.T) {
+	const list = "/电影/战狼2.mkv#1#战狼2(2017)\n/电影/流浪地球.mkv#1#流浪地球.mkv\n/电影/重制版/keep.mkv#1#keep.mkv\n"
+	tests := []struct {
+		policy   string
+		from, to string
+		want     string
+	}{
+		{RenamePreserve, "/电影/战狼2.mkv", "/电影/战狼2-重制版.mkv", "战狼2(2017)"},
+		{RenamePreserve, "/电影/流浪地球.mkv", "/电影/流浪地球2.mkv", "流浪地球2.mkv"},
+		{RenameBasename, "/电影/战狼2.mkv", "/电影/战狼2-重制版.mkv", "战狼2-重制版.mkv"},
+		{RenameTemplate, "/电影/战狼2.mkv", "/电影/重制版/战狼2-重制版.mkv", "战狼2-重制版 [重制版]"},
+		{RenameTemplate, "/电影/战狼2.mkv", "/电影/战狼2-重制版.mkv", "战狼2-重制版.mkv"},
+		// 只换目录时任何策略都保留显示名
+		{RenameBasename, "/电影/战狼2.mkv", "/电影/重制版/战狼2.mkv", "战狼2(2017)"},
+	}
+	entryPoints := map[string]func(fs *TextWebDAVFileSystem, from, to string) error{
+		"MOVE": func(fs *TextWebDAVFileSystem, from, to string) error {
+			return fs.Rename(context.Background(), from, to)
+		},
+		"batch": func(fs *TextWebDAVFileSystem, from, to string) error {
+			body, _ := json.Marshal(map[string]interface{}{"operations": []batchOp{{Op: "rename", Path: from, To: to}}})
+			w := httptest.NewRecorder()
+			fs.handleBatch(w, httptest.NewRequest(http.MethodPost, "/api/batch", bytes.NewReader(body)))
+			if w.Code != http.StatusOK {
+				return fmt.Errorf("status %d: %s", w.Code, w.Body.String())
+			}
+			return nil
+		},
+	}
+	for entry, rename := range entryPoints {
+		for _, tt := range tests {
+			fs := newTestFS(t, list)
+			fs.renamePolicy = tt.policy
+			if err := fs.addDisplayTemplate("/电影/重制版={stem} [{parent}]"); err != nil {
+				t.Fatal(err)
+			}
+			if err := rename(fs, tt.from, tt.to); err != nil {
+				t.Fatalf("%s %s %s -> %s: %v", entry, tt.policy, tt.from, tt.to, err)
+			}
+			if got := fs.Files[tt.to].DisplayName; got != tt.want {
+				t.Errorf("%s %s %s -> %s: displayname %q, want %q", entry, tt.policy, tt.from, tt.to, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestMoveKeepsPropertiesTwoLevelsDown(t *testing.T) {
+	fs := newTestFS(t, "/a/b/c.mkv#1#c.mkv\n/a/b/d.mkv#1#d.mkv\n/dst/keep#0#keep\n")
+	fs.renamePolicy = RenamePreserve
+	set := `<D:set><D:prop><D:displayname>%s</D:displayname><Z:tag>%s</Z:tag></D:prop></D:set>`
+	for name, values := range map[string][2]string{
+		"/a/b":       {"第二层", "dir"},
+		"/a/b/c.mkv": {"战狼2 (2017)", "file"},
+	} {
+		if w := proppatch(fs, name, propertyUpdate(fmt.Sprintf(set, values[0], values[1]))); w.Code != http.StatusMultiStatus {
+			t.Fatalf("PROPPATCH %s: status %d", name, w.Code)
+		}
+	}
+
+	if code := davMove(fs, "/a", "/dst/moved", ""); code != http.StatusCreated {
+		t.Fatalf("MOVE: status %d", code)
+	}
+	named := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:test"><D:prop><D:displayname/><Z:tag/></D:prop></D:propfind>`
+	for name, want := range map[string][2]string{
+		"/dst/moved/b":       {"第二层", "dir"},
+		"/dst/moved/b/c.mkv": {"战狼2 (2017)", "file"},
+		"/dst/moved/b/d.mkv": {"d.mkv", ""},
+	} {
+		values := okValues(t, propfind(fs, name, "0", named).Body.String())
+		if values["displayname"] != want[0] || values["tag"] != want[1] {
+			t.Errorf("%s: %v, want displayname %q and tag %q", name, values, want[0], want[1])
+		}
+	}
+	if fs.Files["/a"] != nil || fs.Files["/a/b/c.mkv"] != nil {
+		t.Error("entries left at the source")
+	}
+}
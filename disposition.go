@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// setContentDisposition 让浏览器和播放器下载时使用显示名而不是上游的文件名:
+// filename* 按 RFC 5987 带上 UTF-8 的显示名, filename 是只含 ASCII 的后备.
+// 显示名没有文件的扩展名时补上, 客户端据此判断类型
+func (fs *TextWebDAVFileSystem) setContentDisposition(w http.ResponseWriter, meta *FileMeta) {
+	if !fs.contentDisposition || meta.IsDir {
+		return
+	}
+	name := meta.DisplayName
+	if name == "" {
+		name = path.Base(meta.Path)
+	}
+	if ext := path.Ext(meta.Path); ext != "" && !strings.EqualFold(path.Ext(name), ext) {
+		name += ext
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"; filename*=UTF-8''%s`, asciiFilename(name), rfc5987Escape(name)))
+}
+
+// rfc5987Escape 按 RFC 5987 的 attr-char 转义, 其余字节 (包括括号、空格和所有非 ASCII) 写成 %XX.
+// url.PathEscape 不转义括号和 ' 等字符, 不能直接使用
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// asciiFilename 生成 filename 参数的后备值: 非 ASCII 字符和控制字符换成 _, 引号和反斜杠转义
+func asciiFilename(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r >= utf8.RuneSelf || r == 0x7f:
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
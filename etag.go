@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// normalizeETag 把列表中声明的 etag 规范成带引号的形式, 保留弱标记 W/
+func normalizeETag(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	weak := ""
+	if strings.HasPrefix(s, "W/") {
+		weak, s = "W/", s[2:]
+	}
+	if !strings.HasPrefix(s, `"`) || !strings.HasSuffix(s, `"`) || len(s) < 2 {
+		s = `"` + strings.Trim(s, `"`) + `"`
+	}
+	return weak + s
+}
+
+// etag 返回条目的 ETag. 列表未声明时, 已知内容 SHA-1 的用它作强 ETag,
+// 否则由 path+size+modTime 生成弱 ETag, 这几个字段不变时重启前后结果一致. 列表没有给出
+// 修改时间时 modTime 是每次启动都不同的进程启动时间, 只用 path+size. 目录没有 ETag
+func (m *FileMeta) etag() string {
+	if m.IsDir {
+		return ""
+	}
+	if m.ETag != "" {
+		return m.ETag
+	}
+	if m.SHA1 != "" {
+		return `"` + m.SHA1 + `"`
+	}
+
+	mod := m.ModTime.UnixNano()
+	if m.ModTime.Equal(defaultModTime()) {
+		mod = 0
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%d", m.Path, m.Size, mod)))
+	return fmt.Sprintf(`W/"%x"`, sum[:12])
+}
+
+// httpDate 格式化 getlastmodified 和 Last-Modified 头. 两者都要求 RFC 1123 的 GMT 时间,
+// time.RFC1123 会带上服务器时区 (如 CST), rclone 等客户端无法解析, 必须先转成 UTC
+func httpDate(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// ETag 实现 webdav.ETager, 让 GET/HEAD 的 ETag 头与 PROPFIND 的 getetag 一致,
+// If-None-Match 由 http.ServeContent 据此返回 304
+func (fi *VirtualFileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.etag, nil
+}
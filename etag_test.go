@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestETagStableAcrossRestartWithoutModTime(t *testing.T) {
+	list := "/a.mkv#100#a.mkv\n"
+	first := newTestFS(t, list).Files["/a.mkv"].etag()
+
+	saved := startTime
+	defer func() { startTime = saved }()
+	startTime = saved.Add(time.Hour)
+	second := newTestFS(t, list).Files["/a.mkv"].etag()
+
+	if first != second {
+		t.Errorf("ETag changed across a restart: %s -> %s", first, second)
+	}
+}
+
+func TestETagFollowsListModTime(t *testing.T) {
+	a := &FileMeta{Path: "/a.mkv", Size: 100, ModTime: testModTime}
+	b := &FileMeta{Path: "/a.mkv", Size: 100, ModTime: testModTime.Add(time.Second)}
+	if a.etag() == b.etag() {
+		t.Error("different modification times produced the same ETag")
+	}
+	c := &FileMeta{Path: "/a.mkv", Size: 101, ModTime: testModTime}
+	if a.etag() == c.etag() {
+		t.Error("different sizes produced the same ETag")
+	}
+}
+
+func TestNormalizeETag(t *testing.T) {
+	tests := map[string]string{
+		"":        "",
+		"abc":     `"abc"`,
+		`"abc"`:   `"abc"`,
+		`W/"abc"`: `W/"abc"`,
+		"W/abc":   `W/"abc"`,
+		`"abc`:    `"abc"`,
+	}
+	for in, want := range tests {
+		if got := normalizeETag(in); got != want {
+			t.Errorf("normalizeETag(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLastModifiedIsGMTRegardlessOfTimeZone(t *testing.T) {
+	saved := time.Local
+	defer func() { time.Local = saved }()
+	time.Local = time.FixedZone("CST", 8*60*60)
+
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	fs.Files["/a.mkv"].ModTime = time.Date(2024, 1, 2, 11, 4, 5, 0, time.Local)
+	const want = "Tue, 02 Jan 2024 03:04:05 GMT"
+
+	w := propfind(fs, "/a.mkv", "0", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getlastmodified/></D:prop></D:propfind>`)
+	if got := okValues(t, w.Body.String())["getlastmodified"]; got != want {
+		t.Errorf("getlastmodified = %q, want %q", got, want)
+	}
+	if got := head(fs, "/a.mkv", nil).Header().Get("Last-Modified"); got != want {
+		t.Errorf("Last-Modified = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event 是值得运维关注的运行时事件, 输出到日志并保留最近的若干条
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Path   string    `json:"path"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+const recentEventLimit = 100
+
+var recentEvents struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (fs *TextWebDAVFileSystem) emitEvent(kind, path, detail string) {
+	ev := Event{Time: time.Now(), Type: kind, Path: path, Detail: detail}
+	fmt.Printf("事件 [%s] %s %s\n", ev.Type, ev.Path, ev.Detail)
+
+	recentEvents.mu.Lock()
+	recentEvents.events = append(recentEvents.events, ev)
+	if len(recentEvents.events) > recentEventLimit {
+		recentEvents.events = recentEvents.events[len(recentEvents.events)-recentEventLimit:]
+	}
+	recentEvents.mu.Unlock()
+}
+
+// RecentEvents 返回最近的事件, 最新的在前
+func RecentEvents() []Event {
+	recentEvents.mu.Lock()
+	defer recentEvents.mu.Unlock()
+
+	events := make([]Event, len(recentEvents.events))
+	for i, ev := range recentEvents.events {
+		events[len(events)-1-i] = ev
+	}
+	return events
+}
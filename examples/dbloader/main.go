@@ -0,0 +1,192 @@
+// dbloader 演示用自己的数据 (这里是内存中的"数据库") 提供目录树和文件内容, 不需要事先生成文本列表.
+//
+// 代理是单个 main 包, 不能被其它程序导入, 外部程序通过两个 HTTP 接口接入:
+//
+//   - Loader: /list 按 Load 返回的条目输出列表, 代理用 -list 读取, 重新加载 (SIGHUP、-refresh、
+//     /admin/reload) 时再次请求, 数据库变化随之生效. 条目的字段与代理内部的 Entry 一一对应
+//   - ContentProvider: /content/ 下按路径返回文件内容, 支持 Range, 代理用 -backend 转发内容请求
+//
+// 运行:
+//
+//	go run ./examples/dbloader -addr :8080
+//	go run . -list http://localhost:8080/list -backend http://localhost:8080/content -refresh 1m
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 是提供给代理的一个条目, 字段与代理的 Entry 相同
+type Entry struct {
+	Path        string
+	Size        int64
+	DisplayName string
+	ModTime     time.Time
+	// URL 为空时代理按 -backend 加路径请求内容
+	URL   string
+	Dir   bool
+	Props map[string]string
+}
+
+// Loader 与代理的 Loader 接口相同
+type Loader interface {
+	Load(ctx context.Context) ([]Entry, error)
+}
+
+// ContentProvider 按路径提供文件内容
+type ContentProvider interface {
+	Open(ctx context.Context, name string) (io.ReadSeeker, time.Time, error)
+}
+
+// propNamespace 是示例写入的死属性的命名空间
+const propNamespace = "urn:example:media"
+
+// inventory 是示例的"数据库": 路径到内容和附加信息
+type inventory struct {
+	mu    sync.RWMutex
+	items map[string]item
+}
+
+type item struct {
+	title   string
+	data    []byte
+	modTime time.Time
+	genre   string
+}
+
+func (inv *inventory) Load(ctx context.Context) ([]Entry, error) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(inv.items))
+	for name, it := range inv.items {
+		entries = append(entries, Entry{
+			Path:        name,
+			Size:        int64(len(it.data)),
+			DisplayName: it.title,
+			ModTime:     it.modTime,
+			Props:       map[string]string{"genre": it.genre},
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func (inv *inventory) Open(ctx context.Context, name string) (io.ReadSeeker, time.Time, error) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	it, ok := inv.items[name]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("%s 不存在", name)
+	}
+	return bytes.NewReader(it.data), it.modTime, nil
+}
+
+// fieldEscaper 转义列表字段中的 #、% 和换行, 与代理的 unescapeField 对应
+var fieldEscaper = strings.NewReplacer("%", "%25", "#", "%23", "\n", "%0A", "\r", "%0D")
+
+// writeList 按代理的列表格式 path#size#displayname#content#etag#modtime#created#url#props 输出条目
+func writeList(w io.Writer, entries []Entry) error {
+	for _, e := range entries {
+		name, size := e.Path, fmt.Sprint(e.Size)
+		if e.Dir {
+			name, size = strings.TrimSuffix(name, "/")+"/", "0"
+		}
+		displayName := e.DisplayName
+		if displayName == "" {
+			displayName = path.Base(e.Path)
+		}
+		var modTime string
+		if !e.ModTime.IsZero() {
+			modTime = e.ModTime.UTC().Format(time.RFC3339)
+		}
+		props, err := encodeProps(e.Props)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s#%s#%s###%s##%s#%s\n",
+			fieldEscaper.Replace(name), size, fieldEscaper.Replace(displayName), modTime, fieldEscaper.Replace(e.URL), props)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeProps 按列表第九列的格式编码死属性: base64 编码的 [{"ns","name","value"}]
+func encodeProps(props map[string]string) (string, error) {
+	if len(props) == 0 {
+		return "", nil
+	}
+	type prop struct {
+		Space string `json:"ns"`
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	list := make([]prop, 0, len(props))
+	for name, value := range props {
+		list = append(list, prop{Space: propNamespace, Name: name, Value: value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// newHandler 用 loader 提供 /list, 用 content 提供 /content/ 下的文件内容
+func newHandler(loader Loader, content ContentProvider) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list", func(w http.ResponseWriter, r *http.Request) {
+		entries, err := loader.Load(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := writeList(w, entries); err != nil {
+			fmt.Printf("输出列表失败: %v\n", err)
+		}
+	})
+	mux.HandleFunc("/content/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/content")
+		rs, modTime, err := content.Open(r.Context(), name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, path.Base(name), modTime, rs)
+	})
+	return mux
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "监听地址")
+	flag.Parse()
+
+	now := time.Now()
+	inv := &inventory{items: map[string]item{
+		"/电影/流浪地球.mkv":   {title: "流浪地球 (2019)", data: bytes.Repeat([]byte("mkv"), 1<<16), modTime: now, genre: "科幻"},
+		"/电影/哪吒.mkv":     {title: "哪吒之魔童降世", data: bytes.Repeat([]byte("mkv"), 1<<15), modTime: now, genre: "动画"},
+		"/剧集/S01E01.mp4": {title: "第 1 集", data: bytes.Repeat([]byte("mp4"), 1<<14), modTime: now, genre: "剧情"},
+	}}
+
+	fmt.Printf("列表: http://localhost%s/list\n内容: http://localhost%s/content\n", *addr, *addr)
+	if err := http.ListenAndServe(*addr, newHandler(inv, inv)); err != nil {
+		fmt.Printf("服务器错误: %v\n", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerServesListAndContent(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	inv := &inventory{items: map[string]item{
+		"/a#b/x.mkv": {title: "X\n片", data: []byte("0123456789"), modTime: modTime, genre: "科幻"},
+	}}
+	srv := httptest.NewServer(newHandler(inv, inv))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	fields := strings.Split(strings.TrimSuffix(string(data), "\n"), "#")
+	if len(fields) != 9 || fields[0] != "/a%23b/x.mkv" || fields[1] != "10" || fields[2] != "X%0A片" || fields[5] != "2024-01-02T03:04:05Z" {
+		t.Fatalf("list line %q", data)
+	}
+	props, err := base64.StdEncoding.DecodeString(fields[8])
+	if err != nil || string(props) != `[{"ns":"urn:example:media","name":"genre","value":"科幻"}]` {
+		t.Errorf("props %q, %v", props, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/content/a%23b/x.mkv", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(data) != "234" {
+		t.Errorf("ranged GET: status %d, %q", resp.StatusCode, data)
+	}
+}
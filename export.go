@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+var fieldEscaper = strings.NewReplacer("%", "%25", "#", "%23", "\n", "%0A", "\r", "%0D")
+
+var fieldUnescaper = strings.NewReplacer("%25", "%", "%23", "#", "%0A", "\n", "%0a", "\n", "%0D", "\r", "%0d", "\r")
+
+func escapeField(s string) string {
+	return fieldEscaper.Replace(s)
+}
+
+func unescapeField(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	return fieldUnescaper.Replace(s)
+}
+
+// treeEntry 是导出时目录树中一个条目的快照
+type treeEntry struct {
+	FileMeta
+	// HasChildren 表示目录下有子项, 这样的目录可以由子项的路径推出
+	HasChildren bool
+}
+
+// walkTree 在读锁内对目录树做一份按路径排序的快照, 各种导出都基于它,
+// 导出过程中不持有锁
+func (fs *TextWebDAVFileSystem) walkTree() []treeEntry {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	paths := make([]string, 0, len(fs.Files))
+	hasChildren := make(map[string]bool)
+	for path := range fs.Files {
+		paths = append(paths, path)
+		hasChildren[filepath.Dir(path)] = true
+	}
+	sort.Strings(paths)
+
+	entries := make([]treeEntry, 0, len(paths))
+	for _, path := range paths {
+		meta := fs.Files[path]
+		entries = append(entries, treeEntry{FileMeta: *meta, HasChildren: hasChildren[path]})
+	}
+	return entries
+}
+
+// emptyContent 是列表内容列中表示内容为空的本地文件的标记. 空的 base64 与没有内容列无法区分,
+// 后者是内容在上游的文件
+const emptyContent = "-"
+
+// Export 把当前内存中的目录树按列表格式写出, 按路径排序, 重新加载后得到等价的目录树:
+// 上游地址、死属性、纳秒精度的时间和空的本地文件都保留. 作为上级目录隐式存在的目录
+// 不单独输出, 只有空目录, 或带死属性、改过显示名的目录才输出一行以 / 结尾的记录
+func (fs *TextWebDAVFileSystem) Export(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, e := range fs.walkTree() {
+		if e.IsDir && e.HasChildren && len(e.Props) == 0 && e.DisplayName == path.Base(e.Path) {
+			continue
+		}
+		line, err := exportLine(&e.FileMeta)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Flush()
+}
+
+// exportLine 按 parseLine 的格式写出一个条目, 省略末尾为空的列
+func exportLine(m *FileMeta) (string, error) {
+	props, err := encodeListProps(m.Props)
+	if err != nil {
+		return "", err
+	}
+	if m.IsDir {
+		return strings.TrimRight(strings.Join([]string{
+			escapeField(m.Path) + "/", "0", escapeField(m.DisplayName),
+			"", "", exportTime(m.ModTime), "", "", props,
+		}, "#"), "#"), nil
+	}
+
+	content := ""
+	if m.Content != nil {
+		content = emptyContent
+		if len(m.Content) > 0 {
+			content = base64.StdEncoding.EncodeToString(m.Content)
+		}
+	}
+	created := ""
+	if !m.Created.IsZero() {
+		created = exportTime(m.Created)
+	}
+	return strings.TrimRight(strings.Join([]string{
+		escapeField(m.Path), strconv.FormatInt(m.Size, 10), escapeField(m.DisplayName),
+		content, m.ETag, exportTime(m.ModTime), created, escapeField(m.URL), props,
+	}, "#"), "#"), nil
+}
+
+// exportTime 以纳秒精度写出时间, 生成的 ETag 在导入后不变. 列表没有给出修改时间的条目
+// 写空, 导入时同样取默认时间
+func exportTime(t time.Time) string {
+	if t.Equal(defaultModTime()) {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// encodeListProps 把死属性编码成列表的属性列: 按名字排序的 JSON 再做 base64, 值是属性的 XML 内容
+func encodeListProps(props map[xml.Name]webdav.Property) (string, error) {
+	if len(props) == 0 {
+		return "", nil
+	}
+	list := make([]JournalProp, 0, len(props))
+	for name, p := range props {
+		list = append(list, JournalProp{Space: name.Space, Name: name.Local, Value: string(p.InnerXML)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Space != list[j].Space {
+			return list[i].Space < list[j].Space
+		}
+		return list[i].Name < list[j].Name
+	})
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decodeListProps(s string) (map[xml.Name]webdav.Property, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var list []JournalProp
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	props := make(map[xml.Name]webdav.Property, len(list))
+	for _, p := range list {
+		name := xml.Name{Space: p.Space, Local: p.Name}
+		props[name] = webdav.Property{XMLName: name, InnerXML: propInnerXML(p.Value)}
+	}
+	return props, nil
+}
+
+func (fs *TextWebDAVFileSystem) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="export.txt"`)
+	if err := fs.Export(w); err != nil {
+		fmt.Printf("导出失败: %v\n", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	fs := newTestFS(t, "/movies/a%231.mkv#100#A %25 movie\n/empty/#0#Empty\n/tv/s1/e1.mkv#-1#e1.mkv\n")
+	fs.mu.Lock()
+	a := fs.Files["/movies/a#1.mkv"]
+	a.URL = "http://example.com/a%20b.mkv?sig=1#frag"
+	a.ModTime = time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	a.Created = time.Date(2023, 1, 1, 0, 0, 0, 42, time.UTC)
+	if err := fs.patchLocked("/movies/a#1.mkv", []JournalProp{
+		{Space: "urn:x", Name: "rating", Value: "5 & up"},
+		{Space: "urn:x", Name: "tags", Value: "<x:tag xmlns:x=\"urn:x\">drama</x:tag>"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.patchLocked("/tv", []JournalProp{{Space: "urn:x", Name: "genre", Value: "tv"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.createLocked("/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.createLocked("/strm.strm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.setContentLocked("/strm.strm", []byte("http://example.com/x")); err != nil {
+		t.Fatal(err)
+	}
+	fs.mu.Unlock()
+
+	var out bytes.Buffer
+	if err := fs.Export(&out); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestFS(t, out.String())
+
+	if len(imported.Files) != len(fs.Files) {
+		t.Errorf("imported %d entries, exported %d:\n%s", len(imported.Files), len(fs.Files), out.String())
+	}
+	for p, want := range fs.Files {
+		got := imported.Files[p]
+		if got == nil {
+			t.Errorf("%s missing after import", p)
+			continue
+		}
+		if got.IsDir != want.IsDir || got.Size != want.Size || got.DisplayName != want.DisplayName || got.URL != want.URL {
+			t.Errorf("%s: imported %+v, want %+v", p, got, want)
+		}
+		if !got.ModTime.Equal(want.ModTime) || !got.Created.Equal(want.Created) {
+			t.Errorf("%s: times %v/%v, want %v/%v", p, got.ModTime, got.Created, want.ModTime, want.Created)
+		}
+		if (got.Content == nil) != (want.Content == nil) || !bytes.Equal(got.Content, want.Content) {
+			t.Errorf("%s: content %q (nil %v), want %q (nil %v)", p, got.Content, got.Content == nil, want.Content, want.Content == nil)
+		}
+		if got.etag() != want.etag() {
+			t.Errorf("%s: ETag %s, want %s", p, got.etag(), want.etag())
+		}
+		if !reflect.DeepEqual(propValues(got.Props), propValues(want.Props)) {
+			t.Errorf("%s: props %v, want %v", p, propValues(got.Props), propValues(want.Props))
+		}
+	}
+	if !strings.Contains(out.String(), "/empty/#0#Empty\n") || !strings.Contains(out.String(), "/tv/#0#tv") {
+		t.Errorf("empty or annotated directory not exported:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "/movies/#") {
+		t.Errorf("implicit parent directory exported:\n%s", out.String())
+	}
+}
+
+func propValues(props map[xml.Name]webdav.Property) map[xml.Name]string {
+	values := make(map[xml.Name]string, len(props))
+	for name, p := range props {
+		values[name] = string(p.InnerXML)
+	}
+	return values
+}
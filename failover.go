@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BackendHealth 按上游主机统计连续失败次数. 连续失败达到 threshold 次后在 cooldown
+// 内把该上游排到备选的最后, 冷却结束后再按原来的顺序尝试一次
+type BackendHealth struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	hosts     map[string]*backendState
+}
+
+type backendState struct {
+	failures  int
+	openUntil time.Time
+}
+
+// BackendStatus 是 /api/stats 中一个上游的状态
+type BackendStatus struct {
+	Host      string     `json:"host"`
+	Failures  int        `json:"failures"`
+	SkipUntil *time.Time `json:"skip_until,omitempty"`
+}
+
+// NewBackendHealth 在 threshold 不大于 0 时返回 nil, 即不跳过任何上游
+func NewBackendHealth(threshold int, cooldown time.Duration) *BackendHealth {
+	if threshold <= 0 {
+		return nil
+	}
+	return &BackendHealth{threshold: threshold, cooldown: cooldown, hosts: make(map[string]*backendState)}
+}
+
+// backendKey 是上游的统计键, 只取协议和主机, 不含路径和认证信息
+func backendKey(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (h *BackendHealth) fail(target string) {
+	if h == nil {
+		return
+	}
+	key := backendKey(target)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := h.hosts[key]
+	if st == nil {
+		st = &backendState{}
+		h.hosts[key] = st
+	}
+	st.failures++
+	if st.failures >= h.threshold && time.Now().After(st.openUntil) {
+		st.openUntil = time.Now().Add(h.cooldown)
+		fmt.Printf("上游 %s 连续失败 %d 次, %v 内优先使用其它上游\n", key, st.failures, h.cooldown)
+	}
+}
+
+func (h *BackendHealth) succeed(target string) {
+	if h == nil {
+		return
+	}
+	key := backendKey(target)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.hosts, key)
+}
+
+// order 返回尝试的顺序: 正常的上游保持原顺序在前, avoid (刚刚中断的上游) 其次,
+// 处于冷却中的最后. 所有上游都不可用时仍会依次尝试, 而不是直接失败
+func (h *BackendHealth) order(targets []string, avoid string) []string {
+	if len(targets) < 2 {
+		return targets
+	}
+	rank := func(target string) int {
+		if h != nil {
+			h.mu.Lock()
+			st := h.hosts[backendKey(target)]
+			cooling := st != nil && time.Now().Before(st.openUntil)
+			h.mu.Unlock()
+			if cooling {
+				return 2
+			}
+		}
+		if avoid != "" && target == avoid {
+			return 1
+		}
+		return 0
+	}
+
+	out := append([]string(nil), targets...)
+	ranks := make(map[string]int, len(out))
+	for _, t := range out {
+		ranks[t] = rank(t)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return ranks[out[i]] < ranks[out[j]] })
+	return out
+}
+
+func (h *BackendHealth) Snapshot() []BackendStatus {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make([]BackendStatus, 0, len(h.hosts))
+	for key, st := range h.hosts {
+		bs := BackendStatus{Host: key, Failures: st.failures}
+		if time.Now().Before(st.openUntil) {
+			until := st.openUntil
+			bs.SkipUntil = &until
+		}
+		out = append(out, bs)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FetcherOptions 是访问上游的连接参数
+type FetcherOptions struct {
+	MaxIdleConnsPerHost   int
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	// ReadIdleTimeout 是读取响应体时最长多久收不到数据, 超过后中止请求, 0 表示不限制
+	ReadIdleTimeout time.Duration
+	// TLSConfig 用于连接 HTTPS 上游 (自签名证书等), 为 nil 时使用系统默认
+	TLSConfig *tls.Config
+	// Proxy 是访问上游使用的代理 (http/https/socks5), 为 nil 时按 HTTP_PROXY 等环境变量,
+	// DirectProxy 为 true 时不使用任何代理
+	Proxy       *url.URL
+	DirectProxy bool
+}
+
+var defaultFetcherOptions = FetcherOptions{
+	MaxIdleConnsPerHost:   16,
+	DialTimeout:           10 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	ReadIdleTimeout:       60 * time.Second,
+}
+
+// String 用于启动日志
+func (o FetcherOptions) String() string {
+	proxy := "环境变量"
+	if o.DirectProxy {
+		proxy = "直连"
+	} else if o.Proxy != nil {
+		proxy = redactURL(o.Proxy.String())
+	}
+	return fmt.Sprintf("连接 %v, TLS 握手 %v, 响应头 %v, 读取停顿 %v, 代理 %s", o.DialTimeout, o.TLSHandshakeTimeout, o.ResponseHeaderTimeout, o.ReadIdleTimeout, proxy)
+}
+
+// setProxy 解析 -backend-proxy 的值: direct 表示直连, 否则是 http://、https:// 或 socks5:// 地址
+func (o *FetcherOptions) setProxy(raw string) error {
+	raw = strings.TrimSpace(raw)
+	if raw == "direct" {
+		o.Proxy, o.DirectProxy = nil, true
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("代理地址无效: %s", redactURL(raw))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("不支持的代理协议 %q, 可用 http、https、socks5", u.Scheme)
+	}
+	o.Proxy, o.DirectProxy = u, false
+	return nil
+}
+
+// backendTLSConfig 按 -backend-ca-file 和 -backend-insecure-skip-verify 构造连接上游的 TLS 设置,
+// 只用于上游连接. caFile 中的证书加到系统根证书之后, 跳转到的公网直链仍然可以正常验证
+func backendTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	if caFile != "" && insecure {
+		return nil, fmt.Errorf("-backend-ca-file 和 -backend-insecure-skip-verify 不能同时使用")
+	}
+	if insecure {
+		fmt.Printf("警告: 不验证上游的 HTTPS 证书\n")
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 证书失败: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s 中没有有效的 PEM 证书", caFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// parseTimeouts 在 base 的基础上解析 "dial=5s,tls=5s,header=10s,read=20s", 没写的项保持不变
+func parseTimeouts(base FetcherOptions, spec string) (FetcherOptions, error) {
+	opts := base
+	for _, item := range strings.Split(spec, ",") {
+		name, raw, ok := strings.Cut(strings.TrimSpace(item), "=")
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || err != nil || d < 0 {
+			return base, fmt.Errorf("超时设置格式错误, 需要 dial|tls|header|read=时长: %q", item)
+		}
+		switch strings.TrimSpace(name) {
+		case "dial":
+			opts.DialTimeout = d
+		case "tls":
+			opts.TLSHandshakeTimeout = d
+		case "header":
+			opts.ResponseHeaderTimeout = d
+		case "read":
+			opts.ReadIdleTimeout = d
+		default:
+			return base, fmt.Errorf("未知的超时项 %q, 可用 dial、tls、header、read", name)
+		}
+	}
+	return opts, nil
+}
+
+// Fetcher 持有所有上游请求共用的连接池. 转发内容、抓取远端源和健康探测都经过它,
+// 同时播放的多个流可以复用到同一上游的连接, 不必每次重新握手.
+// 按 -backend-timeout 或 -backend-proxy 单独配置过的上游主机使用各自的连接池
+type Fetcher struct {
+	opts      FetcherOptions
+	transport *http.Transport
+
+	mu    sync.RWMutex
+	hosts map[string]*fetcherHost
+
+	// limit 限制同时进行的上游请求数, 为 nil 时不限制
+	limit *BackendLimiter
+
+	// client 不设总超时, 大文件可能要传输很久, 只限制连接、等待响应头和读取停顿的时间
+	client *http.Client
+}
+
+type fetcherHost struct {
+	opts      FetcherOptions
+	transport *http.Transport
+}
+
+// defaultFetcher 在没有注入 Fetcher 时使用 (例如嵌入方自己构造的文件系统)
+var defaultFetcher = NewFetcher(defaultFetcherOptions)
+
+func NewFetcher(opts FetcherOptions) *Fetcher {
+	f := &Fetcher{opts: opts, transport: newUpstreamTransport(opts), hosts: make(map[string]*fetcherHost)}
+	f.client = &http.Client{Transport: f}
+	return f
+}
+
+func newUpstreamTransport(opts FetcherOptions) *http.Transport {
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+	proxy := http.ProxyFromEnvironment
+	if opts.DirectProxy {
+		proxy = nil
+	} else if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		TLSClientConfig:       opts.TLSConfig,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		MaxIdleConns:          opts.MaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		// 媒体文件本身已经压缩过, 而且 gzip 之后 Range 和 Content-Length 都对不上
+		DisableCompression: true,
+	}
+}
+
+// setDefaultProxy 设置所有上游默认使用的代理, 覆盖环境变量. 只在启动时、单独配置各主机之前调用
+func (f *Fetcher) setDefaultProxy(raw string) error {
+	opts := f.opts
+	if err := opts.setProxy(raw); err != nil {
+		return err
+	}
+	f.opts, f.transport = opts, newUpstreamTransport(opts)
+	return nil
+}
+
+// hostOptions 返回发往 target 所在主机的请求当前使用的设置
+func (f *Fetcher) hostOptions(target string) FetcherOptions {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if h := f.hosts[backendKey(target)]; h != nil {
+		return h.opts
+	}
+	return f.opts
+}
+
+// configureHost 让发往 target 所在主机的请求使用 opts. 同一主机配置多次时以最后一次为准
+func (f *Fetcher) configureHost(target string, opts FetcherOptions) {
+	key := backendKey(target)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if old := f.hosts[key]; old != nil {
+		old.transport.CloseIdleConnections()
+	}
+	f.hosts[key] = &fetcherHost{opts: opts, transport: newUpstreamTransport(opts)}
+}
+
+// Describe 返回默认和各主机生效的超时和代理设置, 用于启动日志
+func (f *Fetcher) Describe() []string {
+	if f == nil {
+		f = defaultFetcher
+	}
+	lines := []string{"上游连接: " + f.opts.String()}
+	if f.limit != nil {
+		lines = append(lines, fmt.Sprintf("上游并发上限: %d", f.limit.max))
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	keys := make([]string, 0, len(f.hosts))
+	for key := range f.hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("上游连接 %s: %s", key, f.hosts[key].opts))
+	}
+	return lines
+}
+
+func (f *Fetcher) Do(req *http.Request) (*http.Response, error) {
+	if f == nil {
+		f = defaultFetcher
+	}
+	return f.client.Do(req)
+}
+
+// RoundTrip 按请求的主机选择连接池, 并给响应体加上读取停顿的检测.
+// 跳转到其它主机 (例如网盘的直链) 时使用默认设置. 开启了并发限制时先排队取得名额,
+// 响应体关闭后归还
+func (f *Fetcher) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, opts := f.transport, f.opts
+	f.mu.RLock()
+	if h := f.hosts[req.URL.Scheme+"://"+req.URL.Host]; h != nil {
+		transport, opts = h.transport, h.opts
+	}
+	f.mu.RUnlock()
+
+	release, err := f.limit.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.roundTrip(transport, opts, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+func (f *Fetcher) roundTrip(transport *http.Transport, opts FetcherOptions, req *http.Request) (*http.Response, error) {
+	if opts.ReadIdleTimeout <= 0 {
+		return transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &stallReader{body: resp.Body, host: req.URL.Host, timeout: opts.ReadIdleTimeout, cancel: cancel}
+	return resp, nil
+}
+
+// share 让 c 使用共享的连接池, 保留 c 自己的超时设置
+func (f *Fetcher) share(c *http.Client) {
+	if f == nil {
+		f = defaultFetcher
+	}
+	c.Transport = f
+}
+
+// stallReader 在一次读取超过 timeout 还没有收到任何数据时中止请求.
+// 只在调用方等待数据时计时, 客户端暂停播放不读的时间不算
+type stallReader struct {
+	body    io.ReadCloser
+	host    string
+	timeout time.Duration
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stalled bool
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.timeout, s.stall)
+	} else {
+		s.timer.Reset(s.timeout)
+	}
+	s.mu.Unlock()
+
+	n, err := s.body.Read(p)
+
+	s.mu.Lock()
+	s.timer.Stop()
+	stalled := s.stalled
+	s.mu.Unlock()
+	if stalled && err != nil {
+		err = fmt.Errorf("上游 %s 超过 %v 没有数据", s.host, s.timeout)
+	}
+	return n, err
+}
+
+func (s *stallReader) stall() {
+	s.mu.Lock()
+	s.stalled = true
+	s.mu.Unlock()
+	s.cancel()
+}
+
+func (s *stallReader) Close() error {
+	err := s.body.Close()
+	s.cancel()
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetcherReusesConnections(t *testing.T) {
+	body := []byte("0123456789")
+	var conns, hits int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(body))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n/b.mkv#10#b.mkv\n")
+	withBackend(t, fs, srv)
+	for i := 0; i < 10; i++ {
+		name := []string{"/a.mkv", "/b.mkv"}[i%2]
+		if w := getUpstream(fs, name, nil); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), body) {
+			t.Fatalf("%s: status %d, %q", name, w.Code, w.Body.Bytes())
+		}
+	}
+	if got := atomic.LoadInt32(&hits); got < 10 {
+		t.Fatalf("%d upstream requests, want at least 10", got)
+	}
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("%d connections for %d requests, want one reused connection", got, atomic.LoadInt32(&hits))
+	}
+}
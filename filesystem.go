@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// FileSystemOptions 是 newFileSystem 使用的设置, 与同名命令行参数一一对应.
+// 需要解析的规则 (-map-prefix、-upload 等) 和依赖已加载列表的步骤 (重放日志、抓取远端源)
+// 由调用方在构造之后完成
+type FileSystemOptions struct {
+	Port       int
+	ListSource string
+	// Loader 不为 nil 时重新加载从它读取目录树, 代替 ListSource. 第一次加载由调用方用 LoadEntries 进行
+	Loader     Loader
+	AdminToken string
+	Strict     bool
+	ReadOnly   bool
+
+	Fetcher FetcherOptions
+	// BackendMaxConcurrency 是同时进行的上游请求数上限, 0 表示不限制
+	BackendMaxConcurrency int
+	FailThreshold         int
+	FailCooldown          time.Duration
+	ResolveTTL            time.Duration
+	ResolveSize           int
+	UpstreamRetries       int
+	ResumeAttempts        int
+	RetryBackoff          time.Duration
+	MissingTTL            time.Duration
+	// ParallelConns 为 0 时不并发抓取
+	ParallelConns int
+	ParallelChunk int64
+	ReadAhead     int
+	CacheSize     int64
+	CacheMaxFile  int64
+	MaxStreamRate int64
+	MaxTotalRate  int64
+
+	Quota               int64
+	CaseInsensitive     bool
+	ContentDisposition  bool
+	HTMLIndex           bool
+	OptionsNoAuth       bool
+	PropfindInfinity    bool
+	PropfindInfinityMax int
+	ContentLanguage     string
+	SortOrder           string
+	DirsFirst           bool
+	ProppatchMaxProps   int
+	ProppatchMaxBody    int64
+	MaxMemoryFile       int64
+	BatchMaxOps         int
+
+	// Peers 是接收修改推送的其它实例, 需要同时设置 PeerToken
+	Peers     []string
+	PeerToken string
+	// OTLPEndpoint 非空时向该收集器发送追踪数据
+	OTLPEndpoint   string
+	TraceAnonymize bool
+}
+
+var defaultFileSystemOptions = FileSystemOptions{
+	Port:                39124,
+	Fetcher:             defaultFetcherOptions,
+	FailThreshold:       3,
+	FailCooldown:        30 * time.Second,
+	ResolveTTL:          10 * time.Minute,
+	ResolveSize:         10000,
+	UpstreamRetries:     3,
+	ResumeAttempts:      5,
+	RetryBackoff:        500 * time.Millisecond,
+	MissingTTL:          10 * time.Minute,
+	CacheMaxFile:        1 << 20,
+	ContentDisposition:  true,
+	PropfindInfinityMax: 10000,
+	ContentLanguage:     "zh-CN",
+	SortOrder:           SortName,
+	ProppatchMaxProps:   1000,
+	ProppatchMaxBody:    1 << 20,
+	MaxMemoryFile:       16 << 20,
+	BatchMaxOps:         500,
+}
+
+// newFileSystem 按 opts 构造空的文件系统, main 和测试都通过它构造, 避免两处的默认组件不一致
+func newFileSystem(opts FileSystemOptions) (*TextWebDAVFileSystem, error) {
+	if opts.UpstreamRetries < 0 || opts.RetryBackoff < 0 || opts.ResumeAttempts < 0 {
+		return nil, fmt.Errorf("-upstream-retries、-upstream-resume-attempts 和 -upstream-retry-backoff 不能为负数")
+	}
+	if len(opts.Peers) > 0 && opts.PeerToken == "" {
+		return nil, fmt.Errorf("-peers 需要同时设置 -peer-token")
+	}
+	sortOrder, err := parseSortOrder(opts.SortOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &TextWebDAVFileSystem{
+		Files: make(map[string]*FileMeta),
+		Auth:  make(map[string]string),
+		Port:  opts.Port,
+
+		fetcher:    NewFetcher(opts.Fetcher),
+		health:     NewBackendHealth(opts.FailThreshold, opts.FailCooldown),
+		resolved:   NewResolveCache(opts.ResolveTTL, opts.ResolveSize),
+		sizes:      NewSizeResolver(),
+		quota:      NewQuotaUsage(opts.Quota),
+		children:   NewChildIndex(),
+		propagate:  make(PropagateRules),
+		userAgents: make(UserAgentRules),
+		throttle:   NewThrottle(opts.MaxStreamRate, opts.MaxTotalRate),
+		readAhead:  opts.ReadAhead,
+		cacheRules: NewCacheRules(),
+		headers:    NewHeaderRules(),
+		streams:    NewStreamTracker(),
+		missing:    NewMissingReport(opts.MissingTTL),
+		adminToken: opts.AdminToken,
+		listSource: opts.ListSource,
+		loader:     opts.Loader,
+		strict:     opts.Strict,
+		readOnly:   opts.ReadOnly,
+		caseIndex:  NewCaseIndex(opts.CaseInsensitive),
+
+		contentDisposition: opts.ContentDisposition,
+		htmlIndex:          opts.HTMLIndex,
+		optionsNoAuth:      opts.OptionsNoAuth,
+
+		propfindInfinity:    opts.PropfindInfinity,
+		propfindInfinityMax: opts.PropfindInfinityMax,
+		contentLanguage:     opts.ContentLanguage,
+		sortOrder:           sortOrder,
+		dirsFirst:           opts.DirsFirst,
+
+		proppatchMaxProps: opts.ProppatchMaxProps,
+		proppatchMaxBody:  opts.ProppatchMaxBody,
+		maxMemoryFile:     opts.MaxMemoryFile,
+		batchMaxOps:       opts.BatchMaxOps,
+
+		contentCache:   NewContentCache(opts.CacheSize, opts.CacheMaxFile),
+		backendMetrics: NewBackendMetrics(),
+		parallelConns:  opts.ParallelConns,
+		parallelChunk:  opts.ParallelChunk,
+
+		upstreamRetries: opts.UpstreamRetries,
+		retryBackoff:    opts.RetryBackoff,
+		resumeAttempts:  opts.ResumeAttempts,
+	}
+	fs.fetcher.limit = NewBackendLimiter(opts.BackendMaxConcurrency)
+	if len(opts.Peers) > 0 {
+		fs.peers = NewPeers(opts.Peers, opts.PeerToken)
+	}
+	if opts.OTLPEndpoint != "" {
+		tracer = NewTracer(opts.OTLPEndpoint, "xiaoya-webdav-proxy", opts.TraceAnonymize)
+	}
+	return fs, nil
+}
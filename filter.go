@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// PathFilter 决定哪些条目进入虚拟树. 规则以 re: 开头时是正则, 匹配完整路径;
+// 否则是通配符, 含 / 时匹配完整路径, 不含 / 时只匹配文件名
+type PathFilter struct {
+	include []pathPattern
+	exclude []pathPattern
+}
+
+type pathPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// NewPathFilter 解析逗号分隔的包含和排除规则, 两者都为空时返回 nil, 表示不过滤
+func NewPathFilter(include, exclude string) (*PathFilter, error) {
+	f := &PathFilter{}
+	var err error
+	if f.include, err = parsePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parsePatterns(exclude); err != nil {
+		return nil, err
+	}
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func parsePatterns(list string) ([]pathPattern, error) {
+	var patterns []pathPattern
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(s, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("过滤规则 %q 不是有效的正则: %v", s, err)
+			}
+			patterns = append(patterns, pathPattern{re: re})
+			continue
+		}
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, fmt.Errorf("过滤规则 %q 不是有效的通配符: %v", s, err)
+		}
+		patterns = append(patterns, pathPattern{glob: s})
+	}
+	return patterns, nil
+}
+
+func (p pathPattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	if !strings.Contains(p.glob, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+// Allow 报告条目是否保留. 被排除的目录下的所有条目一并排除;
+// 包含规则只作用于文件, 目录只看排除规则
+func (f *PathFilter) Allow(name string, isDir bool) bool {
+	if f == nil {
+		return true
+	}
+	for dir := name; dir != "/" && dir != "."; dir = path.Dir(dir) {
+		for _, p := range f.exclude {
+			if p.match(dir) {
+				return false
+			}
+		}
+	}
+	if isDir || len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneEmptyDirsLocked 删除 dirs 中以及它们上级里已经没有子项的目录
+func (fs *TextWebDAVFileSystem) pruneEmptyDirsLocked(dirs map[string]bool) int {
+	hasChildren := make(map[string]bool, len(fs.Files))
+	for p := range fs.Files {
+		hasChildren[path.Dir(p)] = true
+	}
+
+	pruned := 0
+	for dir := range dirs {
+		for ; dir != "/" && dir != "."; dir = path.Dir(dir) {
+			meta, ok := fs.Files[dir]
+			if !ok || !meta.IsDir || hasChildren[dir] {
+				break
+			}
+			fs.deleteEntryLocked(dir)
+			pruned++
+			// 上级目录可能因此变空, 重新计算它是否还有子项
+			parent := path.Dir(dir)
+			hasChildren[parent] = false
+			for p := range fs.Files {
+				if path.Dir(p) == parent {
+					hasChildren[parent] = true
+					break
+				}
+			}
+		}
+	}
+	return pruned
+}
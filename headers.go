@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// 这些响应头由服务端按实际内容生成, 不允许通过配置覆盖
+var protectedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Etag":              true,
+	"Www-Authenticate":  true,
+	"Connection":        true,
+	"Location":          true,
+	"Set-Cookie":        true,
+}
+
+// HeaderRules 按路径前缀、内容类别或响应的 Content-Type 给响应加上固定的头.
+// 同名的头以最具体的规则为准: 最长路径前缀 > 内容类别 > Content-Type
+type HeaderRules struct {
+	prefixes map[string][]headerValue
+	classes  map[string][]headerValue
+	types    map[string][]headerValue
+}
+
+type headerValue struct {
+	name  string
+	value string
+}
+
+func NewHeaderRules() *HeaderRules {
+	return &HeaderRules{
+		prefixes: make(map[string][]headerValue),
+		classes:  make(map[string][]headerValue),
+		types:    make(map[string][]headerValue),
+	}
+}
+
+// Add 解析一条规则, 形如 "/=X-Robots-Tag: noindex"、"class:video=X-Audio-Track: {displayname}"
+// 或 "type:text/html=Content-Security-Policy: default-src 'self'".
+// 值中可以使用 {displayname} 和 {contenttype}
+func (h *HeaderRules) Add(rule string) error {
+	key, header, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("响应头规则格式错误: %q", rule)
+	}
+	name, value, ok := strings.Cut(header, ":")
+	if !ok {
+		return fmt.Errorf("响应头规则需要 名称: 值: %q", rule)
+	}
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	if name == "" {
+		return fmt.Errorf("响应头规则需要 名称: 值: %q", rule)
+	}
+	if protectedHeaders[name] {
+		return fmt.Errorf("响应头 %s 由服务端生成, 不能通过配置设置", name)
+	}
+	hv := headerValue{name: name, value: strings.TrimSpace(value)}
+
+	key = strings.TrimSpace(key)
+	switch {
+	case strings.HasPrefix(key, "class:"):
+		class := strings.TrimPrefix(key, "class:")
+		h.classes[class] = append(h.classes[class], hv)
+	case strings.HasPrefix(key, "type:"):
+		ctype := strings.ToLower(strings.TrimPrefix(key, "type:"))
+		h.types[ctype] = append(h.types[ctype], hv)
+	case strings.HasPrefix(key, "/"):
+		prefix := strings.TrimSuffix(key, "/")
+		h.prefixes[prefix] = append(h.prefixes[prefix], hv)
+	default:
+		return fmt.Errorf("响应头规则的键必须是 /前缀、class:类别 或 type:类型: %q", rule)
+	}
+	return nil
+}
+
+func (h *HeaderRules) empty() bool {
+	return h == nil || len(h.prefixes)+len(h.classes)+len(h.types) == 0
+}
+
+// resolve 按优先级从低到高依次写入, 高优先级的同名头覆盖低优先级的
+func (h *HeaderRules) resolve(name, ctype string) map[string]string {
+	out := make(map[string]string)
+	apply := func(values []headerValue) {
+		for _, hv := range values {
+			out[hv.name] = hv.value
+		}
+	}
+
+	ctype = strings.ToLower(ctype)
+	for t, values := range h.types {
+		if strings.HasPrefix(ctype, t) {
+			apply(values)
+		}
+	}
+	apply(h.classes[contentClass(name)])
+
+	var matched []string
+	for prefix := range h.prefixes {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") || prefix == "" {
+			matched = append(matched, prefix)
+		}
+	}
+	// 短的前缀先写, 长的覆盖
+	sort.Slice(matched, func(i, j int) bool { return len(matched[i]) < len(matched[j]) })
+	for _, prefix := range matched {
+		apply(h.prefixes[prefix])
+	}
+	return out
+}
+
+// middleware 在响应头写出前注入配置的头, 这时才知道响应的 Content-Type
+func (h *HeaderRules) middleware(fs *TextWebDAVFileSystem, next http.Handler) http.Handler {
+	if h.empty() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&headerWriter{ResponseWriter: w, rules: h, fs: fs, path: fs.normPath(r.URL.Path)}, r)
+	})
+}
+
+type headerWriter struct {
+	http.ResponseWriter
+	rules   *HeaderRules
+	fs      *TextWebDAVFileSystem
+	path    string
+	written bool
+}
+
+func (w *headerWriter) inject() {
+	if w.written {
+		return
+	}
+	w.written = true
+
+	ctype := w.Header().Get("Content-Type")
+	headers := w.rules.resolve(w.path, ctype)
+	if len(headers) == 0 {
+		return
+	}
+
+	displayName := path.Base(w.path)
+	w.fs.mu.RLock()
+	if meta, ok := w.fs.Files[w.path]; ok {
+		displayName = meta.DisplayName
+	}
+	w.fs.mu.RUnlock()
+	replacer := strings.NewReplacer("{displayname}", displayName, "{contenttype}", ctype)
+
+	for name, value := range headers {
+		w.Header().Set(name, headerSafe(replacer.Replace(value)))
+	}
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	w.inject()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	w.inject()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headerWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// headerSafe 把控制字符和非 ASCII 字节转成 %XX, 响应头中不能直接出现
+func headerSafe(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type dirIndexItem struct {
+	Href        string
+	DisplayName string
+	Dir         bool
+	Size        int64
+	ModTime     time.Time
+}
+
+var dirIndexTemplate = template.Must(template.New("dirindex").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Dir}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+th, td { padding: 3px 12px; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Dir}}</h1>
+<table>
+<tr><th>名称</th><th>大小</th><th>修改时间</th></tr>
+{{if .Parent}}<tr><td><a href="{{.Parent}}">..</a></td><td></td><td></td></tr>
+{{end}}{{range .Items}}<tr><td><a href="{{.Href}}">{{.DisplayName}}{{if .Dir}}/{{end}}</a></td><td class="size">{{if not .Dir}}{{bytes .Size}}{{end}}</td><td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveDirIndex 用目录列表回答目录的 GET, 方便在浏览器里检查目录树. 目录在前, 同类按显示名排序;
+// Accept 不含 html 的客户端 (curl 等) 得到每行一个条目的纯文本
+func (fs *TextWebDAVFileSystem) serveDirIndex(w http.ResponseWriter, r *http.Request, dir string) {
+	fs.mu.RLock()
+	var items []dirIndexItem
+	for _, p := range fs.childPathsLocked(dir) {
+		child := fs.Files[p]
+		href := hrefFor(r, p)
+		if child.IsDir {
+			href += "/"
+		}
+		items = append(items, dirIndexItem{Href: href, DisplayName: child.DisplayName, Dir: child.IsDir, Size: child.Size, ModTime: child.ModTime})
+	}
+	fs.mu.RUnlock()
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Dir != items[j].Dir {
+			return items[i].Dir
+		}
+		return items[i].DisplayName < items[j].DisplayName
+	})
+
+	w.Header().Set("Cache-Control", "no-store")
+	if !strings.Contains(r.Header.Get("Accept"), "html") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, item := range items {
+			name := item.DisplayName
+			if item.Dir {
+				name += "/"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", name, item.Size, item.ModTime.UTC().Format(time.RFC3339))
+		}
+		return
+	}
+
+	parent := ""
+	if dir != "/" {
+		parent = hrefFor(r, path.Dir(dir))
+		if parent != "/" {
+			parent += "/"
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dirIndexTemplate.Execute(w, map[string]interface{}{"Dir": dir, "Parent": parent, "Items": items}); err != nil {
+		fmt.Printf("渲染目录列表失败: %v\n", err)
+	}
+}
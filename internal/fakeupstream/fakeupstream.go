@@ -0,0 +1,198 @@
+//go:build e2e
+
+// Package fakeupstream 提供可编排的假上游, 以及把整个代理进程接到假上游上的辅助函数,
+// 用于代理转发、链接刷新、多上游切换、Alist 抓取等场景的端到端验证.
+// 只在 -tags e2e 时编译, 不影响普通构建
+package fakeupstream
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response 是脚本中的一步. Status 为 0 时按 200 返回 Body (支持 Range 时按需返回 206)
+type Response struct {
+	Status int
+	Header http.Header
+	Delay  time.Duration
+	// DropAfter 大于 0 时写出这么多字节后直接断开连接
+	DropAfter int64
+	// DropRandom 为 true 时在响应体中随机的位置断开连接, 用于验证续传不重复也不缺失
+	DropRandom bool
+}
+
+// Script 描述一个路径的行为. Responses 按请求顺序依次使用, 用完后重复最后一个
+type Script struct {
+	Body      []byte
+	NoRange   bool // 忽略 Range, 总是返回 200 和完整内容
+	User      string
+	Pass      string
+	UserAgent string // 非空时只接受这个 User-Agent, 其它一律返回 403, 模拟 115 等驱动
+	Responses []Response
+}
+
+// Hit 记录一次收到的请求
+type Hit struct {
+	Method string
+	Path   string
+	Header http.Header
+}
+
+// Server 是可编排的假上游
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	scripts map[string]*Script
+	next    map[string]int
+	hits    []Hit
+}
+
+func New() *Server {
+	s := &Server{scripts: make(map[string]*Script), next: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Handle 为 path 设置脚本, 覆盖之前的设置并重置其进度
+func (s *Server) Handle(path string, script *Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[path] = script
+	s.next[path] = 0
+}
+
+// File 是最常用的情况: 一个支持 Range 的普通文件
+func (s *Server) File(path string, body []byte) {
+	s.Handle(path, &Script{Body: body})
+}
+
+// Hits 返回收到的请求, path 非空时只返回该路径的
+func (s *Server) Hits(path string) []Hit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []Hit
+	for _, h := range s.hits {
+		if path == "" || h.Path == path {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.hits = append(s.hits, Hit{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone()})
+	script, ok := s.scripts[r.URL.Path]
+	var step Response
+	if ok && len(script.Responses) > 0 {
+		i := s.next[r.URL.Path]
+		if i >= len(script.Responses) {
+			i = len(script.Responses) - 1
+		} else {
+			s.next[r.URL.Path] = i + 1
+		}
+		step = script.Responses[i]
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if step.Delay > 0 {
+		time.Sleep(step.Delay)
+	}
+	if script.User != "" {
+		if u, p, ok := r.BasicAuth(); !ok || u != script.User || p != script.Pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if script.UserAgent != "" && r.Header.Get("User-Agent") != script.UserAgent {
+		http.Error(w, "forbidden user agent", http.StatusForbidden)
+		return
+	}
+	for k, v := range step.Header {
+		w.Header()[k] = v
+	}
+	if step.Status != 0 && step.Status != http.StatusOK {
+		w.WriteHeader(step.Status)
+		return
+	}
+
+	body := script.Body
+	status := http.StatusOK
+	if rh := r.Header.Get("Range"); rh != "" && !script.NoRange {
+		start, end, ok := parseRange(rh, int64(len(body)))
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		body = body[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	drop := step.DropAfter
+	if step.DropRandom && len(body) > 1 {
+		drop = 1 + rand.Int63n(int64(len(body)-1))
+	}
+	if drop > 0 && drop < int64(len(body)) {
+		w.Write(body[:drop])
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+		return
+	}
+	w.Write(body)
+}
+
+func parseRange(h string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(h, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if e, err := strconv.ParseInt(last, 10, 64); err == nil && e < end {
+			end = e
+		}
+	}
+	return start, end, end >= start
+}
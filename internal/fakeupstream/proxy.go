@@ -0,0 +1,114 @@
+//go:build e2e
+
+package fakeupstream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Proxy 是一个以子进程方式运行的完整代理
+type Proxy struct {
+	URL  string
+	User string
+	Pass string
+
+	cmd    *exec.Cmd
+	dir    string
+	Output bytes.Buffer
+}
+
+// StartProxy 用 list 作为列表启动 bin 指向的代理可执行文件, upstream 非空时作为 -backend,
+// 额外参数原样传入, 等端口可以连接后返回
+func StartProxy(bin string, upstream *Server, list string, args ...string) (*Proxy, error) {
+	dir, err := os.MkdirTemp("", "fakeupstream")
+	if err != nil {
+		return nil, err
+	}
+	if upstream != nil {
+		args = append([]string{"-backend", upstream.URL}, args...)
+	}
+	listPath := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(listPath, []byte(list), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	p := &Proxy{URL: fmt.Sprintf("http://127.0.0.1:%d", port), User: "1", Pass: "1", dir: dir}
+	p.cmd = exec.Command(bin, append([]string{"-port", fmt.Sprint(port), "-list", listPath}, args...)...)
+	p.cmd.Dir = dir
+	p.cmd.Stdout = &p.Output
+	p.cmd.Stderr = &p.Output
+	if err := p.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+			return p, nil
+		}
+		if time.Now().After(deadline) {
+			p.Stop()
+			return nil, fmt.Errorf("代理没有在 10 秒内启动: %s", p.Output.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Do 以代理的账号发出请求
+func (p *Proxy) Do(method, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, p.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.SetBasicAuth(p.User, p.Pass)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	return client.Do(req)
+}
+
+// Get 读取完整响应, 返回状态码和内容
+func (p *Proxy) Get(path string, header http.Header) (int, []byte, error) {
+	resp, err := p.Do(http.MethodGet, path, header, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+func (p *Proxy) Stop() {
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+	}
+	os.RemoveAll(p.dir)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,141 @@
+//go:build e2e
+
+package fakeupstream
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// proxyBin 是 TestMain 编译出的代理可执行文件
+var proxyBin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "fakeupstream-bin")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	proxyBin = filepath.Join(dir, "proxy")
+	build := exec.Command("go", "build", "-o", proxyBin, "../..")
+	if out, err := build.CombinedOutput(); err != nil {
+		fmt.Printf("编译代理失败: %v\n%s", err, out)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func startProxy(t *testing.T, upstream *Server, list string, args ...string) *Proxy {
+	t.Helper()
+	args = append([]string{"-upstream-retry-backoff", "10ms"}, args...)
+	p, err := StartProxy(proxyBin, upstream, list, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.Stop()
+		if t.Failed() {
+			t.Logf("代理输出:\n%s", p.Output.String())
+		}
+	})
+	return p
+}
+
+func gets(s *Server, path string) []Hit {
+	var out []Hit
+	for _, h := range s.Hits(path) {
+		if h.Method == http.MethodGet {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func TestRetryAfter5xx(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 100)
+	up := New()
+	defer up.Close()
+	up.Handle("/a.mkv", &Script{Body: body, Responses: []Response{
+		{Status: http.StatusServiceUnavailable},
+		{Status: http.StatusBadGateway},
+		{},
+	}})
+	p := startProxy(t, up, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+
+	status, data, err := p.Get("/a.mkv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || !bytes.Equal(data, body) {
+		t.Fatalf("status %d, %d bytes, want 200 and the full body", status, len(data))
+	}
+	if n := len(gets(up, "/a.mkv")); n != 3 {
+		t.Errorf("upstream saw %d GETs, want 3 (two 5xx, then success)", n)
+	}
+}
+
+func TestResumeAfterDrop(t *testing.T) {
+	body := bytes.Repeat([]byte("abcdefghijklmnop"), 4096)
+	up := New()
+	defer up.Close()
+	up.Handle("/a.mkv", &Script{Body: body, Responses: []Response{
+		{DropAfter: 10000},
+		{},
+	}})
+	p := startProxy(t, up, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+
+	status, data, err := p.Get("/a.mkv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || !bytes.Equal(data, body) {
+		t.Fatalf("status %d, %d of %d bytes, want the full body without gaps or duplicates", status, len(data), len(body))
+	}
+	hits := gets(up, "/a.mkv")
+	if len(hits) != 2 {
+		t.Fatalf("upstream saw %d GETs, want 2", len(hits))
+	}
+	if got := hits[1].Header.Get("Range"); !strings.HasPrefix(got, "bytes=10000-") {
+		t.Errorf("resume Range = %q, want it to start at the dropped offset", got)
+	}
+}
+
+func TestFailoverOrder(t *testing.T) {
+	body := []byte("served by the mirror")
+	primary, mirror := New(), New()
+	defer primary.Close()
+	defer mirror.Close()
+	primary.Handle("/x/a.mkv", &Script{Body: body, Responses: []Response{{Status: http.StatusInternalServerError}}})
+	mirror.File("/y/a.mkv", body)
+
+	p := startProxy(t, nil, fmt.Sprintf("/m/a.mkv#%d#a.mkv\n", len(body)),
+		"-backend-map", "/m="+primary.URL+"/x,"+mirror.URL+"/y",
+		"-backend-fail-threshold", "1",
+		"-upstream-retries", "0")
+
+	for i := 0; i < 2; i++ {
+		status, data, err := p.Get("/m/a.mkv", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != http.StatusOK || !bytes.Equal(data, body) {
+			t.Fatalf("request %d: status %d, body %q", i+1, status, data)
+		}
+	}
+	// 第一次先试主上游再换镜像, 主上游达到失败阈值后第二次直接使用镜像
+	if n := len(gets(primary, "/x/a.mkv")); n != 1 {
+		t.Errorf("primary saw %d GETs, want 1", n)
+	}
+	if n := len(gets(mirror, "/y/a.mkv")); n != 2 {
+		t.Errorf("mirror saw %d GETs, want 2", n)
+	}
+}
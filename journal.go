@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// JournalRecord 是一次内存修改的紧凑记录, 每条记录在日志文件中占一行
+type JournalRecord struct {
+	Op    string        `json:"op"`
+	Path  string        `json:"path"`
+	To    string        `json:"to,omitempty"`
+	Props []JournalProp `json:"props,omitempty"`
+	Size  int64         `json:"size,omitempty"`
+	Name  string        `json:"name,omitempty"`
+	URL   string        `json:"url,omitempty"`
+	// Shallow 表示 COPY 带 Depth: 0, 只复制了目录本身
+	Shallow bool `json:"shallow,omitempty"`
+	// Content 是写入内存的文件的完整内容, 受 -max-memory-file 限制
+	Content []byte `json:"content,omitempty"`
+}
+
+type JournalProp struct {
+	Space  string `json:"ns,omitempty"`
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
+	Remove bool   `json:"remove,omitempty"`
+}
+
+const (
+	JournalMkdir     = "mkdir"
+	JournalCreate    = "create"
+	JournalDelete    = "delete"
+	JournalRename    = "rename"
+	JournalProppatch = "proppatch"
+	JournalPut       = "put"
+	JournalCopy      = "copy"
+	JournalTruncate  = "truncate"
+	JournalContent   = "content"
+)
+
+// Journal 是只追加的修改日志. 写入只进缓冲区, 由后台定时批量 fsync,
+// 因此不会给单个请求增加磁盘延迟
+type Journal struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	buf   *bufio.Writer
+	dirty bool
+	done  chan struct{}
+}
+
+func OpenJournal(path string, interval time.Duration) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开日志文件失败: %v", err)
+	}
+
+	j := &Journal{
+		path: path,
+		file: file,
+		buf:  bufio.NewWriter(file),
+		done: make(chan struct{}),
+	}
+	go j.syncLoop(interval)
+	return j, nil
+}
+
+func (j *Journal) Append(rec JournalRecord) {
+	if j == nil {
+		return
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		fmt.Printf("日志记录编码失败: %v\n", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.buf.Write(line)
+	j.buf.WriteByte('\n')
+	j.dirty = true
+}
+
+func (j *Journal) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.Sync()
+		case <-j.done:
+			return
+		}
+	}
+}
+
+func (j *Journal) Sync() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.dirty {
+		return nil
+	}
+	if err := j.buf.Flush(); err != nil {
+		fmt.Printf("日志写入失败: %v\n", err)
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		fmt.Printf("日志同步失败: %v\n", err)
+		return err
+	}
+	j.dirty = false
+	return nil
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	close(j.done)
+	err := j.Sync()
+	j.file.Close()
+	return err
+}
+
+// ReadJournal 读取日志中的全部完整记录. 只有最后一行可能是崩溃时写了一半的记录,
+// 它会被截断丢弃; 中间损坏的行跳过并报告, 之后的记录照常读取
+func ReadJournal(path string) ([]JournalRecord, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取日志文件失败: %v", err)
+	}
+
+	var records []JournalRecord
+	offset, torn := 0, -1
+	for n := 1; offset < len(data); n++ {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			torn = offset
+			break
+		}
+
+		var rec JournalRecord
+		if err := json.Unmarshal(data[offset:offset+end], &rec); err != nil || rec.Op == "" {
+			if offset+end+1 == len(data) {
+				torn = offset
+				break
+			}
+			fmt.Printf("日志文件 %s 第 %d 行损坏, 跳过\n", path, n)
+		} else {
+			records = append(records, rec)
+		}
+		offset += end + 1
+	}
+
+	if torn >= 0 {
+		fmt.Printf("日志文件 %s 末尾的记录不完整, 丢弃偏移 %d 之后的 %d 字节\n", path, torn, len(data)-torn)
+		if err := os.Truncate(path, int64(torn)); err != nil {
+			return nil, fmt.Errorf("截断日志文件失败: %v", err)
+		}
+	}
+
+	return records, nil
+}
+
+// CompactJournal 用给定记录原子地重写日志文件
+func CompactJournal(path string, records []JournalRecord) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("创建日志临时文件失败: %v", err)
+	}
+
+	if err := writeJournalRecords(file, records); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("同步日志临时文件失败: %v", err)
+	}
+	file.Close()
+
+	return os.Rename(tmp, path)
+}
+
+func writeJournalRecords(w io.Writer, records []JournalRecord) error {
+	buf := bufio.NewWriter(w)
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("日志记录编码失败: %v", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Flush()
+}
+
+// ReplayJournal 在加载列表之后重放日志, 跳过目标已不存在的记录,
+// 重放成功后压缩日志并打开它继续追加
+func (fs *TextWebDAVFileSystem) ReplayJournal(path string, interval time.Duration) error {
+	records, err := ReadJournal(path)
+	if err != nil {
+		return err
+	}
+
+	var applied []JournalRecord
+	fs.mu.Lock()
+	for _, rec := range records {
+		if err := fs.applyRecord(rec); err != nil {
+			fmt.Printf("跳过日志记录 %s %s: %v\n", rec.Op, rec.Path, err)
+			continue
+		}
+		applied = append(applied, rec)
+	}
+	fs.mu.Unlock()
+
+	if err := CompactJournal(path, applied); err != nil {
+		return err
+	}
+	fmt.Printf("重放日志: %d 条记录, 跳过 %d 条\n", len(applied), len(records)-len(applied))
+
+	journal, err := OpenJournal(path, interval)
+	if err != nil {
+		return err
+	}
+	fs.journal = journal
+	return nil
+}
+
+// recordMutation 在修改成功后调用, 写入本地日志并推送给其它实例
+func (fs *TextWebDAVFileSystem) recordMutation(rec JournalRecord) {
+	fs.treeChanged()
+	fs.journal.Append(rec)
+	fs.peers.Broadcast(rec)
+}
+
+func (fs *TextWebDAVFileSystem) applyRecord(rec JournalRecord) error {
+	fs.treeChanged()
+	switch rec.Op {
+	case JournalMkdir:
+		return fs.mkdirLocked(rec.Path)
+	case JournalCreate:
+		_, err := fs.createLocked(rec.Path)
+		return err
+	case JournalDelete:
+		return fs.removeAllLocked(rec.Path)
+	case JournalRename:
+		return fs.renameLocked(rec.Path, rec.To)
+	case JournalProppatch:
+		return fs.patchLocked(rec.Path, rec.Props)
+	case JournalPut:
+		_, err := fs.putLocked(rec.Path, rec.Size, rec.Name, rec.URL)
+		return err
+	case JournalCopy:
+		return fs.copyLocked(rec.Path, rec.To, !rec.Shallow)
+	case JournalTruncate:
+		return fs.truncateLocked(rec.Path)
+	case JournalContent:
+		return fs.setContentLocked(rec.Path, rec.Content)
+	default:
+		return fmt.Errorf("未知操作 %q", rec.Op)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeJournalFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "journal")
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func journalPaths(records []JournalRecord) string {
+	var paths []string
+	for _, rec := range records {
+		paths = append(paths, rec.Path)
+	}
+	return strings.Join(paths, ",")
+}
+
+func TestReadJournal(t *testing.T) {
+	const (
+		a = `{"op":"mkdir","path":"/a"}` + "\n"
+		b = `{"op":"mkdir","path":"/b"}` + "\n"
+		c = `{"op":"mkdir","path":"/c"}` + "\n"
+	)
+	tests := []struct {
+		name    string
+		content string
+		paths   string
+		// kept 是读取后文件剩下的内容
+		kept string
+	}{
+		{"complete", a + b, "/a,/b", a + b},
+		{"torn final line", a + b + `{"op":"mk`, "/a,/b", a + b},
+		{"corrupt final line", a + b + "garbage\n", "/a,/b", a + b},
+		{"corrupt middle line", a + "garbage\n" + b + `{}` + "\n" + c, "/a,/b,/c", a + "garbage\n" + b + `{}` + "\n" + c},
+		{"corrupt middle and torn end", a + "garbage\n" + b + `{"op"`, "/a,/b", a + "garbage\n" + b},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeJournalFile(t, tt.content)
+			records, err := ReadJournal(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := journalPaths(records); got != tt.paths {
+				t.Errorf("records %s, want %s", got, tt.paths)
+			}
+			data, _ := os.ReadFile(file)
+			if string(data) != tt.kept {
+				t.Errorf("file after read = %q, want %q", data, tt.kept)
+			}
+		})
+	}
+}
+
+func TestReadJournalMissingFile(t *testing.T) {
+	records, err := ReadJournal(filepath.Join(t.TempDir(), "none"))
+	if err != nil || records != nil {
+		t.Errorf("ReadJournal of a missing file = %v, %v", records, err)
+	}
+}
+
+func TestReplayJournalRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "journal")
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	if err := fs.ReplayJournal(file, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	fs.mu.Lock()
+	for _, rec := range []JournalRecord{
+		{Op: JournalMkdir, Path: "/d"},
+		{Op: JournalRename, Path: "/a.mkv", To: "/d/b.mkv"},
+		{Op: JournalProppatch, Path: "/d/b.mkv", Props: []JournalProp{{Space: "urn:x", Name: "p", Value: "v"}}},
+	} {
+		if err := fs.applyRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+		fs.recordMutation(rec)
+	}
+	fs.mu.Unlock()
+	if err := fs.journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	if err := restarted.ReplayJournal(file, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.journal.Close()
+	meta := restarted.Files["/d/b.mkv"]
+	if meta == nil || restarted.Files["/a.mkv"] != nil {
+		t.Fatal("rename not replayed")
+	}
+	if _, ok := meta.Props[xml.Name{Space: "urn:x", Local: "p"}]; !ok {
+		t.Error("dead property not replayed")
+	}
+}
+
+// 重命名、PROPPATCH 和新建的文件在重启后仍然可以通过 PROPFIND 看到
+func TestRestartKeepsClientChanges(t *testing.T) {
+	const list = "/电影/战狼2.mkv#10#战狼2.mkv\n/电影/流浪地球.mkv#10#流浪地球.mkv\n"
+	file := filepath.Join(t.TempDir(), "state.json")
+	start := func() *TextWebDAVFileSystem {
+		fs := newTestFS(t, list)
+		fs.renamePolicy = RenameBasename
+		if err := fs.ReplayJournal(file, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+
+	fs := start()
+	ctx := context.Background()
+	if err := fs.Rename(ctx, "/电影/战狼2.mkv", "/电影/战狼2-重制版.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if w := proppatch(fs, "/电影/流浪地球.mkv", propertyUpdate(`<D:set><D:prop><D:displayname>流浪地球 (2019)</D:displayname></D:prop></D:set>`)); w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPPATCH: status %d", w.Code)
+	}
+	f, err := fs.OpenFile(ctx, "/电影/note.nfo", os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("<movie/>"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := start()
+	defer restarted.journal.Close()
+	w := propfind(restarted, "/电影/", "1", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:displayname/></D:prop></D:propfind>`)
+	var ms multistatus
+	if err := xml.Unmarshal(w.Body.Bytes(), &ms); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	for _, resp := range ms.Responses {
+		href, _ := url.PathUnescape(resp.Href)
+		for _, ps := range resp.Propstats {
+			for _, p := range ps.Prop.Props {
+				names[href] = p.InnerXML
+			}
+		}
+	}
+	want := map[string]string{
+		"/电影":             "电影",
+		"/电影/战狼2-重制版.mkv": "战狼2-重制版.mkv",
+		"/电影/流浪地球.mkv":    "流浪地球 (2019)",
+		"/电影/note.nfo":    "note.nfo",
+	}
+	for href, name := range want {
+		if names[href] != name {
+			t.Errorf("PROPFIND %s displayname = %q, want %q (all: %v)", href, names[href], name, names)
+		}
+	}
+	if len(names) != len(want) {
+		t.Errorf("PROPFIND listed %v", names)
+	}
+	if got := string(restarted.Files["/电影/note.nfo"].Content); got != "<movie/>" {
+		t.Errorf("created file content after restart = %q", got)
+	}
+}
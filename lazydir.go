@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LazyDirs 让 Alist 源按需抓取: 启动时只列出挂载点, 其它目录第一次被访问时才调用
+// /api/fs/list 列出这一层, 结果在 ttl 内直接使用, 过期后下一次访问时重新列出.
+// 列出失败时不记录时间, 目录保持原有内容 (从未列出过时为空), 下一次访问重试
+type LazyDirs struct {
+	source *AlistSource
+	ttl    time.Duration
+
+	mu   sync.Mutex
+	dirs map[string]*lazyDir
+}
+
+type lazyDir struct {
+	// rel 是目录在 Alist 中相对于 root 的原始路径, 虚拟树中的键可能做过规范化
+	rel     string
+	listed  time.Time
+	loading chan struct{}
+}
+
+func NewLazyDirs(source *AlistSource, ttl time.Duration) *LazyDirs {
+	l := &LazyDirs{source: source, ttl: ttl, dirs: make(map[string]*lazyDir)}
+	source.lazy = l
+	return l
+}
+
+// fresh 报告目录的列表是否仍在有效期内. ttl 为 0 时列出一次后不再刷新
+func (d *lazyDir) fresh(ttl time.Duration) bool {
+	return !d.listed.IsZero() && (ttl <= 0 || time.Since(d.listed) < ttl)
+}
+
+// crawlRoot 代替完整抓取, 只列出挂载点. 重新加载时其它目录全部视为过期
+func (l *LazyDirs) crawlRoot(ctx context.Context, fs *TextWebDAVFileSystem) error {
+	fs.mu.Lock()
+	fs.ensureMountLocked(l.source.mount)
+	fs.mu.Unlock()
+
+	root := fs.normPath(l.source.mount)
+	l.mu.Lock()
+	for _, d := range l.dirs {
+		d.listed = time.Time{}
+	}
+	if l.dirs[root] == nil {
+		l.dirs[root] = &lazyDir{}
+	}
+	l.mu.Unlock()
+	return l.list(ctx, fs, root)
+}
+
+// list 列出 dir 这一层. 同一目录同时只有一个请求在列出, 其它请求等待它的结果
+func (l *LazyDirs) list(ctx context.Context, fs *TextWebDAVFileSystem, dir string) error {
+	l.mu.Lock()
+	d := l.dirs[dir]
+	if d == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	if d.loading != nil {
+		done := d.loading
+		l.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+	d.loading = make(chan struct{})
+	rel, done := d.rel, d.loading
+	l.mu.Unlock()
+
+	subdirs, err := l.source.listDir(ctx, fs, rel)
+
+	l.mu.Lock()
+	d.loading = nil
+	if err == nil {
+		d.listed = time.Now()
+		for _, sub := range subdirs {
+			key := fs.normPath(path.Join(l.source.mount, sub))
+			if l.dirs[key] == nil {
+				l.dirs[key] = &lazyDir{rel: sub}
+			}
+		}
+	}
+	l.mu.Unlock()
+	close(done)
+
+	if err != nil {
+		return fmt.Errorf("列出 Alist 目录 %s 失败: %v", path.Join(l.source.root, rel), err)
+	}
+	return nil
+}
+
+// populate 列出从挂载点到 p 路径上还没有列出或已经过期的目录. refresh 为 false 时
+// p 已在虚拟树中就不做任何事, 只有 PROPFIND 和 Readdir 会刷新过期的目录
+func (fs *TextWebDAVFileSystem) populate(ctx context.Context, p string, refresh bool) {
+	l := fs.lazyDirs
+	if l == nil {
+		return
+	}
+	p = path.Clean("/" + p)
+	mount := fs.normPath(l.source.mount)
+	if p != mount && mount != "/" && !strings.HasPrefix(p, mount+"/") {
+		return
+	}
+	if !refresh {
+		fs.mu.RLock()
+		_, ok := fs.Files[p]
+		fs.mu.RUnlock()
+		if ok {
+			return
+		}
+	}
+
+	// 发起列出的请求提前结束时, 等待同一目录的其它请求仍然需要它的结果
+	ctx = context.WithoutCancel(ctx)
+	dir := mount
+	rest := strings.Split(strings.Trim(strings.TrimPrefix(p, mount), "/"), "/")
+	for i := 0; ; i++ {
+		l.mu.Lock()
+		d := l.dirs[dir]
+		stale := d != nil && !d.fresh(l.ttl)
+		l.mu.Unlock()
+		// 不是按需抓取的目录 (文件或不存在的路径) 时停下
+		if d == nil {
+			return
+		}
+		if stale {
+			if err := l.list(ctx, fs, dir); err != nil {
+				fmt.Printf("%v\n", err)
+				return
+			}
+		}
+		if i >= len(rest) || rest[i] == "" {
+			return
+		}
+		dir = path.Join(dir, rest[i])
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// unknownSize 是列表 size 列中的 -1, 表示生成列表时不知道大小. 第一次 PROPFIND、Stat
+// 或 HEAD 时向上游查询, 查到后记在条目上, 之后的请求直接使用
+const unknownSize = -1
+
+const (
+	// sizeQueryTimeout 限制一次查询的时间, PROPFIND 不会因为某个上游没有响应而一直等待
+	sizeQueryTimeout = 15 * time.Second
+	// sizeRetryAfter 是上游没有给出长度的文件多久后再查询
+	sizeRetryAfter = 10 * time.Minute
+	// sizeQueryWorkers 是一次 PROPFIND 中同时查询的文件数
+	sizeQueryWorkers = 8
+)
+
+// SizeResolver 合并同一文件的并发查询, 同一时刻每个文件最多向上游发出一个请求
+type SizeResolver struct {
+	mu    sync.Mutex
+	calls map[string]chan struct{}
+	// unknown 记录上游没有给出长度的文件和查询的时间
+	unknown map[string]time.Time
+}
+
+func NewSizeResolver() *SizeResolver {
+	return &SizeResolver{calls: make(map[string]chan struct{}), unknown: make(map[string]time.Time)}
+}
+
+// needsSize 报告 meta 是否是还不知道大小、需要查询上游的文件. 调用方持有 fs.mu
+func needsSize(meta *FileMeta) bool {
+	return meta != nil && !meta.IsDir && meta.Content == nil && meta.Size == unknownSize
+}
+
+// contentLength 返回 PROPFIND 中的 getcontentlength, 大小未知时为 nil, 不输出该属性
+func (m *FileMeta) contentLength() *int64 {
+	if m.Size < 0 {
+		return nil
+	}
+	return &m.Size
+}
+
+// resolveSize 在 name 的大小未知时向上游查询并记录. 已有相同的查询在进行时等待它的结果,
+// 查询失败或上游没有给出长度时条目保持未知
+func (fs *TextWebDAVFileSystem) resolveSize(ctx context.Context, name string) {
+	// 查询在锁外进行, 用锁内复制的副本, 不与同时进行的 MOVE、PROPPATCH 竞争
+	fs.mu.RLock()
+	var meta FileMeta
+	cur := fs.Files[name]
+	need := needsSize(cur)
+	if need {
+		meta = *cur
+	}
+	fs.mu.RUnlock()
+	if !need || fs.sizes == nil {
+		return
+	}
+
+	s := fs.sizes
+	s.mu.Lock()
+	if at, ok := s.unknown[name]; ok && time.Since(at) < sizeRetryAfter {
+		s.mu.Unlock()
+		return
+	}
+	if done, ok := s.calls[name]; ok {
+		s.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return
+	}
+	done := make(chan struct{})
+	s.calls[name] = done
+	s.mu.Unlock()
+
+	// 发起查询的请求提前结束时, 等待同一结果的其它请求仍然需要它
+	qctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sizeQueryTimeout)
+	size, ok, err := fs.querySize(qctx, &meta)
+	cancel()
+
+	s.mu.Lock()
+	delete(s.calls, name)
+	switch {
+	case err != nil:
+		fmt.Printf("查询大小失败: %s: %v\n", name, err)
+	case !ok:
+		fmt.Printf("上游没有给出 %s 的大小\n", name)
+		s.unknown[name] = time.Now()
+	default:
+		delete(s.unknown, name)
+	}
+	s.mu.Unlock()
+	if ok {
+		fs.recordSize(name, size)
+	}
+	close(done)
+}
+
+// resolveSizes 并发查询多个文件的大小, 用于 PROPFIND 列出目录
+func (fs *TextWebDAVFileSystem) resolveSizes(ctx context.Context, names []string) {
+	sem := make(chan struct{}, sizeQueryWorkers)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fs.resolveSize(ctx, name)
+		}(name)
+	}
+	wg.Wait()
+}
+
+// unknownSizes 返回 dir 本身或其直接子条目中大小未知的文件, 没有需要查询的时返回 nil
+func (fs *TextWebDAVFileSystem) unknownSizes(dir string) []string {
+	if fs.sizes == nil {
+		return nil
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if meta := fs.Files[dir]; meta != nil && !meta.IsDir {
+		if needsSize(meta) {
+			return []string{dir}
+		}
+		return nil
+	}
+	var names []string
+	for _, p := range fs.childPathsLocked(dir) {
+		if needsSize(fs.Files[p]) {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
+// querySize 用只要第一个字节的 Range 请求查询文件大小. 不支持 Range 的上游返回 200,
+// 这时以 Content-Length 为准, 只读响应头不读内容
+func (fs *TextWebDAVFileSystem) querySize(ctx context.Context, meta *FileMeta) (int64, bool, error) {
+	resp, _, err := fs.openUpstream(ctx, meta, "bytes=0-0", "")
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	if fs.checkUpstreamGone(meta.Path, resp.StatusCode) {
+		return 0, false, fmt.Errorf("上游文件已不存在")
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, false, fmt.Errorf("上游返回 %d", resp.StatusCode)
+	}
+	size, ok := upstreamSize(resp)
+	return size, ok, nil
+}
+
+// recordSize 在条目的大小仍然未知时记下上游给出的大小, 与条目的大小相同时标记为已确认.
+// GET 转发时也会调用, 不必再单独查询
+func (fs *TextWebDAVFileSystem) recordSize(name string, size int64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	meta := fs.Files[name]
+	if meta == nil || size < 0 {
+		return
+	}
+	if needsSize(meta) {
+		meta.Size = size
+		fs.treeChanged()
+	}
+	if meta.Size == size && meta.Content == nil {
+		meta.SizeVerified = true
+	}
+}
+
+// verifiedSize 返回上游确认过的文件大小. meta 是请求开始时的副本, 还没有确认过时用 Range
+// 请求查询一次并记录, 查询失败或上游不给长度时返回 false
+func (fs *TextWebDAVFileSystem) verifiedSize(ctx context.Context, meta *FileMeta) (int64, bool) {
+	fs.mu.RLock()
+	if cur, ok := fs.Files[meta.Path]; ok {
+		snapshot := *cur
+		meta = &snapshot
+	}
+	fs.mu.RUnlock()
+	size, verified := meta.Size, meta.SizeVerified
+	if verified {
+		return size, true
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, sizeQueryTimeout)
+	defer cancel()
+	size, ok, err := fs.querySize(qctx, meta)
+	if err != nil || !ok {
+		return 0, false
+	}
+	fs.recordSize(meta.Path, size)
+	return size, true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// openListSource 打开本地文件或 http(s) 地址形式的列表,
+// 通过 gzip 魔数或 .gz 后缀识别压缩列表并流式解压
+func openListSource(src string) (*listReader, error) {
+	var raw io.ReadCloser
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := http.Get(src)
+		if err != nil {
+			return nil, fmt.Errorf("下载列表 %s 失败: %v", redactURL(src), redactError(err))
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("下载列表 %s 失败: %s", redactURL(src), resp.Status)
+		}
+		raw = resp.Body
+	} else {
+		file, err := os.Open(src)
+		if err != nil {
+			return nil, fmt.Errorf("打开列表 %s 失败: %v", src, err)
+		}
+		raw = file
+	}
+
+	br := bufio.NewReader(raw)
+	magic, _ := br.Peek(2)
+	isGzip := len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+	if !isGzip && !strings.HasSuffix(strings.SplitN(src, "?", 2)[0], ".gz") {
+		return &listReader{Reader: br, closer: raw}, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("列表 %s 不是有效的 gzip 文件: %v", redactURL(src), err)
+	}
+	return &listReader{Reader: gz, closer: raw}, nil
+}
+
+type listReader struct {
+	io.Reader
+	closer io.Closer
+	err    error
+}
+
+// Read 记下底层流的错误. 流被截断时扫描器会先把残缺的最后一行交给解析,
+// 这样加载失败时仍能报告真正的原因
+func (r *listReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *listReader) Close() error {
+	return r.closer.Close()
+}
+
+func (fs *TextWebDAVFileSystem) LoadFromSource(src string) (*LoadReport, error) {
+	rc, err := openListSource(src)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	report, err := fs.LoadFromReader(rc)
+	if err != nil {
+		if rc.err != nil {
+			return report, fmt.Errorf("读取列表 %s 失败: %v", redactURL(src), redactError(rc.err))
+		}
+		return report, fmt.Errorf("加载列表 %s 失败: %v", redactURL(src), err)
+	}
+	return report, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Entry 是目录树中一个条目的公开描述, 不依赖内部的 FileMeta. 自定义的 Loader 返回它,
+// 字段与文本列表的各列一一对应
+type Entry struct {
+	Path string
+	// Size 为 -1 表示未知, 第一次使用时向上游查询
+	Size        int64
+	DisplayName string
+	ModTime     time.Time
+	Created     time.Time
+	URL         string
+	ETag        string
+	Dir         bool
+	// Content 非 nil 时是文件的本地内容, 大小以它为准
+	Content []byte
+	// Props 是附加的死属性, 值为文本, 输出时转义
+	Props map[xml.Name]string
+}
+
+// Loader 从文本列表以外的地方 (例如数据库) 提供目录树. 通过 FileSystemOptions.Loader
+// 设置后, 重新加载也从它读取, 代替 -list
+type Loader interface {
+	Load(ctx context.Context) ([]Entry, error)
+}
+
+// LoadEntries 调用 loader 并把结果合并进目录树, 与文本列表经过同样的校验、前缀映射、
+// 过滤规则和路径规范化. 报告中第 n 行对应第 n 个条目
+func (fs *TextWebDAVFileSystem) LoadEntries(ctx context.Context, loader Loader) (*LoadReport, error) {
+	entries, err := loader.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l := fs.newListLoad()
+	for i, e := range entries {
+		meta, err := e.fileMeta()
+		if err != nil {
+			if err := l.skip(i+1, err, e.Path); err != nil {
+				return l.report, err
+			}
+			continue
+		}
+		l.add(meta)
+	}
+	return l.finish(), nil
+}
+
+// fileMeta 校验 e 并转换成目录树中的条目, 规则与 parseLine 相同
+func (e Entry) fileMeta() (*FileMeta, error) {
+	name, isDir, err := normalizeListPath(e.Path)
+	if err != nil {
+		return nil, err
+	}
+	if name == "/" {
+		return nil, fmt.Errorf("路径不能是根目录")
+	}
+	if e.Size < unknownSize {
+		return nil, fmt.Errorf("大小不能为负数, 不知道大小时写 -1")
+	}
+
+	meta := &FileMeta{
+		Path:        name,
+		Size:        e.Size,
+		DisplayName: e.DisplayName,
+		Content:     e.Content,
+		IsDir:       isDir || e.Dir,
+		ModTime:     e.ModTime,
+		Created:     e.Created,
+		ETag:        normalizeETag(e.ETag),
+		URL:         e.URL,
+	}
+	if meta.DisplayName == "" {
+		meta.DisplayName = path.Base(name)
+	}
+	if meta.ModTime.IsZero() {
+		meta.ModTime = defaultModTime()
+	}
+	if meta.IsDir {
+		meta.Size, meta.Content, meta.ETag, meta.URL = 0, nil, "", ""
+	} else if meta.Content != nil {
+		meta.Size = int64(len(meta.Content))
+	}
+	for pn, v := range e.Props {
+		if meta.Props == nil {
+			meta.Props = make(map[xml.Name]webdav.Property, len(e.Props))
+		}
+		meta.Props[pn] = webdav.Property{XMLName: pn, InnerXML: propInnerXML(v)}
+	}
+	return meta, nil
+}
+
+// listLoad 是一次加载的状态, 文本列表和 Loader 返回的条目都经过它放入目录树
+type listLoad struct {
+	fs           *TextWebDAVFileSystem
+	report       *LoadReport
+	filteredDirs map[string]bool
+}
+
+func (fs *TextWebDAVFileSystem) newListLoad() *listLoad {
+	return &listLoad{fs: fs, report: &LoadReport{}, filteredDirs: make(map[string]bool)}
+}
+
+// skip 记录第 line 行 (或第 line 个条目) 的错误, -strict 时返回错误中止加载
+func (l *listLoad) skip(line int, err error, text string) error {
+	if l.fs.strict {
+		return fmt.Errorf("第 %d 行: %v", line, err)
+	}
+	l.report.skip(line, err.Error(), text)
+	return nil
+}
+
+// add 对解析好的条目应用前缀映射、路径规范化和过滤规则, 然后放入目录树
+func (l *listLoad) add(meta *FileMeta) {
+	fs := l.fs
+	if len(fs.prefixMap) > 0 {
+		meta.Path = fs.prefixMap.Apply(meta.Path)
+		if meta.Path == "/" {
+			return
+		}
+	}
+	meta.Path = fs.normPath(meta.Path)
+	path := meta.Path
+
+	if !fs.filter.Allow(path, meta.IsDir) {
+		l.report.Filtered++
+		l.filteredDirs[filepath.Dir(path)] = true
+		return
+	}
+
+	fs.mu.Lock()
+	fs.setEntryLocked(path, meta)
+	fs.ensureParentsLocked(path)
+	fs.mu.Unlock()
+	l.report.Loaded++
+
+	fmt.Printf("加载文件: %s (%d bytes)\n", path, meta.Size)
+}
+
+// finish 删除因过滤而变空的目录并输出加载结果
+func (l *listLoad) finish() *LoadReport {
+	fs, report := l.fs, l.report
+	if report.Filtered > 0 {
+		fs.mu.Lock()
+		pruned := fs.pruneEmptyDirsLocked(l.filteredDirs)
+		fs.mu.Unlock()
+		fmt.Printf("过滤规则排除了 %d 个条目, 删除了 %d 个因此变空的目录\n", report.Filtered, pruned)
+	}
+	fmt.Printf("加载了 %d 个条目, 跳过 %d 行\n", report.Loaded, report.SkippedCount)
+	for _, sk := range report.Skipped {
+		fmt.Printf("  第 %d 行: %s\n", sk.Line, sk.Reason)
+	}
+	fs.reportCaseConflicts()
+	return report
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loaderFunc 让普通函数实现 Loader, 相当于嵌入方从数据库读取条目
+type loaderFunc func(ctx context.Context) ([]Entry, error)
+
+func (f loaderFunc) Load(ctx context.Context) ([]Entry, error) { return f(ctx) }
+
+func TestLoaderServesPropfindAndGet(t *testing.T) {
+	body := []byte("movie bytes from the upstream")
+	upstream := rangedUpstream(t, body)
+	tag := xml.Name{Space: "urn:test", Local: "tag"}
+	entries := []Entry{
+		{Path: "/db/movie.mkv", Size: int64(len(body)), DisplayName: "电影", URL: upstream.URL + "/m.mkv", ModTime: testModTime, Props: map[xml.Name]string{tag: "a & b"}},
+		{Path: "/db/sub.srt", Content: []byte("字幕")},
+		{Path: "/db/empty", Dir: true},
+		{Path: "", DisplayName: "no path"},
+	}
+	loader := loaderFunc(func(ctx context.Context) ([]Entry, error) { return entries, nil })
+
+	opts := defaultFileSystemOptions
+	opts.Loader = loader
+	fs, err := newFileSystem(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := fs.LoadEntries(context.Background(), loader)
+	if err != nil || report.Loaded != 3 || report.SkippedCount != 1 || report.Skipped[0].Line != 4 {
+		t.Fatalf("LoadEntries = %+v, %v", report, err)
+	}
+	srv := lockingServer(t, fs)
+
+	do := func(method, name, body string, header ...string) (int, []byte) {
+		t.Helper()
+		r, err := http.NewRequest(method, srv.URL+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	code, data := do("PROPFIND", "/db", "", "Depth", "1")
+	want := []string{"/db", "/db/empty", "/db/movie.mkv", "/db/sub.srt"}
+	if got := hrefs(t, data); code != http.StatusMultiStatus || !slices.Equal(got, want) {
+		t.Fatalf("PROPFIND /db: status %d, %v; want %v", code, got, want)
+	}
+	_, data = do("PROPFIND", "/db/movie.mkv", propfindBody(`<D:prop><D:displayname/><D:getcontentlength/><Z:tag/></D:prop>`), "Depth", "0")
+	values := okValues(t, string(data))
+	if values["displayname"] != "电影" || values["getcontentlength"] != "29" || propText(values["tag"]) != "a & b" {
+		t.Errorf("PROPFIND /db/movie.mkv: %v", values)
+	}
+
+	for name, want := range map[string]string{"/db/movie.mkv": string(body), "/db/sub.srt": "字幕"} {
+		if code, data := do(http.MethodGet, name, ""); code != http.StatusOK || string(data) != want {
+			t.Errorf("GET %s: status %d, %q; want %q", name, code, data, want)
+		}
+	}
+	if code, data := do(http.MethodGet, "/db/movie.mkv", "", "Range", "bytes=6-10"); code != http.StatusPartialContent || string(data) != "bytes" {
+		t.Errorf("ranged GET: status %d, %q", code, data)
+	}
+
+	// 重新加载从 Loader 读取, 代替列表
+	entries = []Entry{{Path: "/db/new.mkv", Size: 1, URL: upstream.URL + "/n.mkv"}}
+	if _, err := fs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	_, data = do("PROPFIND", "/db", "", "Depth", "1")
+	if got := hrefs(t, data); !slices.Equal(got, []string{"/db", "/db/new.mkv"}) {
+		t.Errorf("PROPFIND after Reload: %v", got)
+	}
+}
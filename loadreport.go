@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 报告中最多保留的跳过行数, 超出的只计数
+const maxSkippedLines = 1000
+
+// LoadReport 是一次加载列表的结果. 宽松模式下格式错误的行被跳过并记录在这里
+type LoadReport struct {
+	Loaded       int           `json:"loaded"`
+	Filtered     int           `json:"filtered"`
+	SkippedCount int           `json:"skipped_count"`
+	Skipped      []SkippedLine `json:"skipped"`
+}
+
+type SkippedLine struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+	Text   string `json:"text"`
+}
+
+func (r *LoadReport) skip(line int, reason, text string) {
+	r.SkippedCount++
+	if len(r.Skipped) < maxSkippedLines {
+		if len(text) > 200 {
+			text = text[:200] + "..."
+		}
+		r.Skipped = append(r.Skipped, SkippedLine{Line: line, Reason: reason, Text: redactLine(text)})
+	}
+}
+
+// redactLine 只隐去行中看起来像 URL 的字段里的敏感参数, 其余原样保留
+func redactLine(text string) string {
+	fields := strings.Split(text, "#")
+	for i, f := range fields {
+		if strings.Contains(f, "://") {
+			fields[i] = redactURL(f)
+		}
+	}
+	return strings.Join(fields, "#")
+}
+
+var lastLoad struct {
+	mu     sync.Mutex
+	report *LoadReport
+}
+
+func setLastLoadReport(report *LoadReport) {
+	lastLoad.mu.Lock()
+	lastLoad.report = report
+	lastLoad.mu.Unlock()
+}
+
+// handleSkipped 返回最近一次成功加载列表时跳过的行, 方便修正生成列表的脚本
+func (fs *TextWebDAVFileSystem) handleSkipped(w http.ResponseWriter, r *http.Request) {
+	lastLoad.mu.Lock()
+	report := lastLoad.report
+	lastLoad.mu.Unlock()
+
+	if report == nil {
+		report = &LoadReport{}
+	}
+	writeJSON(w, http.StatusOK, report)
+}
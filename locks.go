@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// LockTracker 包装 webdav.Handler 使用的 LockSystem, 记下经过本实例创建的锁,
+// PROPFIND 据此返回 lockdiscovery. LockSystem 接口不能按路径列出锁, 只能在创建时记录;
+// 使用 -lock-peer 时其它实例创建的锁不会出现在本实例的 lockdiscovery 中, 但仍然生效
+type LockTracker struct {
+	webdav.LockSystem
+	mu    sync.Mutex
+	locks map[string]trackedLock
+}
+
+type trackedLock struct {
+	details webdav.LockDetails
+	expires time.Time // 零值表示不过期
+}
+
+func NewLockTracker(ls webdav.LockSystem) *LockTracker {
+	return &LockTracker{LockSystem: ls, locks: make(map[string]trackedLock)}
+}
+
+func (t *LockTracker) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	token, err := t.LockSystem.Create(now, details)
+	if err == nil {
+		t.mu.Lock()
+		t.locks[token] = trackedLock{details: details, expires: lockExpiry(now, details.Duration)}
+		t.mu.Unlock()
+	}
+	return token, err
+}
+
+func (t *LockTracker) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	details, err := t.LockSystem.Refresh(now, token, duration)
+	t.mu.Lock()
+	if err == nil {
+		t.locks[token] = trackedLock{details: details, expires: lockExpiry(now, details.Duration)}
+	} else if err == webdav.ErrNoSuchLock {
+		delete(t.locks, token)
+	}
+	t.mu.Unlock()
+	return details, err
+}
+
+func (t *LockTracker) Unlock(now time.Time, token string) error {
+	err := t.LockSystem.Unlock(now, token)
+	if err == nil || err == webdav.ErrNoSuchLock {
+		t.mu.Lock()
+		delete(t.locks, token)
+		t.mu.Unlock()
+	}
+	return err
+}
+
+func lockExpiry(now time.Time, d time.Duration) time.Time {
+	if d < 0 {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+type activeLock struct {
+	token string
+	trackedLock
+}
+
+// active 返回作用于 name 的锁: 锁在 name 本身, 或在上级目录且深度为 infinity.
+// webdav.Handler 在没有 If 头的修改请求期间创建的临时锁 (无 owner、不过期、深度 0) 不列出
+func (t *LockTracker) active(name string, now time.Time) []activeLock {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []activeLock
+	for token, l := range t.locks {
+		if !l.expires.IsZero() && now.After(l.expires) {
+			delete(t.locks, token)
+			continue
+		}
+		d := l.details
+		if d.Duration < 0 && d.ZeroDepth && d.OwnerXML == "" {
+			continue
+		}
+		root := path.Clean("/" + d.Root)
+		if root == name || !d.ZeroDepth && (root == "/" || strings.HasPrefix(name, root+"/")) {
+			out = append(out, activeLock{token, l})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].token < out[j].token })
+	return out
+}
+
+// lockDiscovery 生成 name 的 lockdiscovery 属性内容, 没有锁时为空
+func (fs *TextWebDAVFileSystem) lockDiscovery(r *http.Request, name string) string {
+	var b bytes.Buffer
+	now := time.Now()
+	for _, l := range fs.locks.active(name, now) {
+		depth := "infinity"
+		if l.details.ZeroDepth {
+			depth = "0"
+		}
+		timeout := "Infinite"
+		if !l.expires.IsZero() {
+			timeout = fmt.Sprintf("Second-%d", int64(l.expires.Sub(now).Seconds()))
+		}
+		b.WriteString("<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>")
+		fmt.Fprintf(&b, "<D:depth>%s</D:depth>", depth)
+		if l.details.OwnerXML != "" {
+			fmt.Fprintf(&b, "<D:owner>%s</D:owner>", l.details.OwnerXML)
+		}
+		fmt.Fprintf(&b, "<D:timeout>%s</D:timeout>", timeout)
+		b.WriteString("<D:locktoken><D:href>")
+		xml.EscapeText(&b, []byte(l.token))
+		b.WriteString("</D:href></D:locktoken><D:lockroot><D:href>")
+		xml.EscapeText(&b, []byte(hrefFor(r, path.Clean("/"+l.details.Root))))
+		b.WriteString("</D:href></D:lockroot></D:activelock>")
+	}
+	return b.String()
+}
+
+// supportedLock 是 supportedlock 属性的内容: 只支持排他写锁, 与 webdav.Handler 的 LOCK 一致
+const supportedLock = "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"
+
+// lockProps 返回 PROPFIND 中 name 的 supportedlock 和 lockdiscovery. 只读模式不支持锁, 都不返回
+func (fs *TextWebDAVFileSystem) lockProps(r *http.Request, name string) (supported, discovery *rawXML) {
+	if fs.readOnly || fs.locks == nil {
+		return nil, nil
+	}
+	return &rawXML{Inner: supportedLock}, &rawXML{Inner: fs.lockDiscovery(r, name)}
+}
+
+var errInvalidIfHeader = errors.New("If 头格式错误")
+
+// confirmLocks 对 webdav.Handler 之外处理的修改请求 (PROPPATCH、COPY) 做与 Handler 相同的锁检查:
+// 没有 If 头时在 src 和 dst 上各加一个临时锁, 被其它客户端锁住时返回 423;
+// 有 If 头时其中任一列表的锁令牌和 ETag 条件成立即可, 都不成立时返回 412.
+// 成功时调用方在请求结束后调用 release
+func (fs *TextWebDAVFileSystem) confirmLocks(r *http.Request, src, dst string) (release func(), status int, err error) {
+	if fs.locks == nil {
+		return func() {}, 0, nil
+	}
+	hdr := r.Header.Get("If")
+	if hdr == "" {
+		now := time.Now()
+		var tokens []string
+		release = func() {
+			for _, token := range tokens {
+				fs.locks.Unlock(now, token)
+			}
+		}
+		for _, name := range []string{src, dst} {
+			if name == "" {
+				continue
+			}
+			token, err := fs.locks.Create(now, webdav.LockDetails{Root: name, Duration: -1, ZeroDepth: true})
+			if err != nil {
+				release()
+				if err == webdav.ErrLocked {
+					return nil, http.StatusLocked, err
+				}
+				return nil, http.StatusInternalServerError, err
+			}
+			tokens = append(tokens, token)
+		}
+		return release, 0, nil
+	}
+
+	lists, ok := parseIfHeader(hdr)
+	if !ok {
+		return nil, http.StatusBadRequest, errInvalidIfHeader
+	}
+	for _, l := range lists {
+		lsrc := src
+		if l.resourceTag != "" {
+			u, err := url.Parse(l.resourceTag)
+			if err != nil || u.Host != r.Host {
+				continue
+			}
+			lsrc = fs.keyPath(u.Path)
+		}
+		release, err := fs.locks.Confirm(time.Now(), lsrc, dst, l.conditions...)
+		if err == webdav.ErrConfirmationFailed {
+			continue
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return release, 0, nil
+	}
+	return nil, http.StatusPreconditionFailed, webdav.ErrLocked
+}
+
+type ifList struct {
+	resourceTag string
+	conditions  []webdav.Condition
+}
+
+// parseIfHeader 解析 RFC 4918 第 10.4 节的 If 头, 如
+// (<urn:uuid:...>) 或 <http://host/a> (Not <token> ["etag"]).
+// 资源标记作用于其后的各个列表, 直到下一个资源标记
+func parseIfHeader(s string) ([]ifList, bool) {
+	var lists []ifList
+	tag := ""
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return lists, len(lists) > 0
+		}
+		switch s[0] {
+		case '<':
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return nil, false
+			}
+			tag, s = s[1:end], s[end+1:]
+		case '(':
+			end := strings.IndexByte(s, ')')
+			if end < 0 {
+				return nil, false
+			}
+			conds, ok := parseIfConditions(s[1:end])
+			if !ok {
+				return nil, false
+			}
+			lists = append(lists, ifList{resourceTag: tag, conditions: conds})
+			s = s[end+1:]
+		default:
+			return nil, false
+		}
+	}
+}
+
+func parseIfConditions(s string) ([]webdav.Condition, bool) {
+	var conds []webdav.Condition
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return conds, len(conds) > 0
+		}
+		var c webdav.Condition
+		if strings.HasPrefix(s, "Not") {
+			c.Not = true
+			s = strings.TrimLeft(s[3:], " \t")
+		}
+		if s == "" {
+			return nil, false
+		}
+		var end int
+		switch s[0] {
+		case '<':
+			end = strings.IndexByte(s, '>')
+			if end < 0 {
+				return nil, false
+			}
+			c.Token = s[1:end]
+		case '[':
+			end = strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, false
+			}
+			c.ETag = s[1:end]
+		default:
+			return nil, false
+		}
+		conds = append(conds, c)
+		s = s[end+1:]
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// lockingServer 按 main 中的方式分派 PROPFIND、PROPPATCH 和 COPY, 其余交给 webdav.Handler
+func lockingServer(t *testing.T, fs *TextWebDAVFileSystem) *httptest.Server {
+	fs.locks = NewLockTracker(webdav.NewMemLS())
+	dav := &webdav.Handler{FileSystem: fs, LockSystem: fs.locks}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PROPFIND":
+			fs.HandlePropfind(w, r)
+		case "PROPPATCH":
+			fs.HandleProppatch(w, r)
+		case "COPY":
+			fs.HandleCopy(w, r)
+		default:
+			dav.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLockBlocksOtherClients(t *testing.T) {
+	fs := newTestFS(t, "/d/a.txt#0#a.txt#-\n/d/b.txt#0#b.txt#-\n")
+	srv := lockingServer(t, fs)
+	do := func(method, name, body string, header ...string) *http.Response {
+		t.Helper()
+		r, err := http.NewRequest(method, srv.URL+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("LOCK", "/d/a.txt", `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>client 1</D:owner></D:lockinfo>`, "Timeout", "Second-600")
+	token := resp.Header.Get("Lock-Token")
+	if resp.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("LOCK: status %d, token %q", resp.StatusCode, token)
+	}
+
+	w := propfind(fs, "/d/a.txt", "0", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:lockdiscovery/></D:prop></D:propfind>`)
+	if !strings.Contains(w.Body.String(), strings.Trim(token, "<>")) {
+		t.Errorf("lockdiscovery lacks the token:\n%s", w.Body.String())
+	}
+
+	// 没有锁令牌的第二个客户端
+	for _, tt := range []struct {
+		method, name, body string
+		header             []string
+	}{
+		{http.MethodPut, "/d/a.txt", "client 2", nil},
+		{http.MethodDelete, "/d/a.txt", "", nil},
+		{"PROPPATCH", "/d/a.txt", propertyUpdate(`<D:set><D:prop><Z:tag>x</Z:tag></D:prop></D:set>`), nil},
+		{"MOVE", "/d/a.txt", "", []string{"Destination", srv.URL + "/d/c.txt"}},
+		{"COPY", "/d/b.txt", "", []string{"Destination", srv.URL + "/d/a.txt"}},
+	} {
+		if resp := do(tt.method, tt.name, tt.body, tt.header...); resp.StatusCode != http.StatusLocked {
+			t.Errorf("%s %s without the token: status %d, want 423", tt.method, tt.name, resp.StatusCode)
+		}
+	}
+	if got := string(fs.Files["/d/a.txt"].Content); got != "" || fs.Files["/d/a.txt"].Props != nil {
+		t.Fatalf("the locked file changed: %q, %v", got, fs.Files["/d/a.txt"].Props)
+	}
+
+	// 锁的持有者可以写入
+	if resp := do(http.MethodPut, "/d/a.txt", "client 1", "If", "("+token+")"); resp.StatusCode >= 300 {
+		t.Errorf("PUT with the token: status %d", resp.StatusCode)
+	}
+	if resp := do("UNLOCK", "/d/a.txt", "", "Lock-Token", token); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("UNLOCK: status %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPut, "/d/a.txt", "client 2"); resp.StatusCode >= 300 {
+		t.Errorf("PUT after UNLOCK: status %d", resp.StatusCode)
+	}
+	if got := string(fs.Files["/d/a.txt"].Content); got != "client 2" {
+		t.Errorf("content %q", got)
+	}
+}
@@ -3,18 +3,26 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/webdav"
+	"golang.org/x/text/unicode/norm"
 )
 
 type FileMeta struct {
@@ -24,20 +32,159 @@ type FileMeta struct {
 	Content     []byte
 	IsDir       bool
 	ModTime     time.Time
+	Props       map[xml.Name]webdav.Property
+	ETag        string
+	URL         string
+	PropVersion int64
+	MD5         string
+	SHA1        string
+	// Created 是列表第七列或客户端通过 Win32CreationTime / creationdate 设置的创建时间,
+	// 未设置时为零值
+	Created time.Time
+	// SizeVerified 表示 Size 已由上游的响应确认 (查询过或转发时记录过), 列表中的大小可能已经过时
+	SizeVerified bool
 }
 
 type TextWebDAVFileSystem struct {
-	mu    sync.RWMutex
-	Files map[string]*FileMeta
-	Auth  map[string]string
-	Port  int
+	mu      sync.RWMutex
+	Files   map[string]*FileMeta
+	Auth    map[string]string
+	Port    int
+	journal *Journal
+	peers   *Peers
+
+	mismatches *MismatchReport
+	fixSize    bool
+	missing    *MissingReport
+	cacheRules *CacheRules
+	headers    *HeaderRules
+	redirects  *RedirectRules
+	transfer   *TransferMeter
+	throttle   *Throttle
+	// 从上游预读的缓冲区大小, 0 表示不预读
+	readAhead  int
+	mirror     *Mirror
+	adminToken string
+	listSource string
+	// 不为 nil 时重新加载从它读取目录树, 代替 listSource
+	loader Loader
+	// 远端源 (WebDAV、Alist), 重新加载时与列表一起重新抓取
+	sources     []treeSource
+	reloadState reloadState
+	// 按需抓取的 Alist 目录, 为 nil 时启动时抓取整棵树
+	lazyDirs *LazyDirs
+
+	// 小文件内容缓存, 为 nil 时不缓存
+	contentCache *ContentCache
+	// 按上游统计的请求、字节数和首字节时间
+	backendMetrics *BackendMetrics
+	// 并发抓取的连接数和每块的大小, 连接数为 0 时不并发
+	parallelConns int
+	parallelChunk int64
+
+	upstreamCreds   []upstreamCredential
+	upstreamHeaders UpstreamHeaders
+	charsetProfiles []*charsetProfile
+	filter          *PathFilter
+	strict          bool
+	readOnly        bool
+	prefixMap       PrefixMap
+	streams         *StreamTracker
+	// 文件的 GET/HEAD 带上按显示名生成的 Content-Disposition
+	contentDisposition bool
+	// 目录的 GET 返回可以在浏览器中查看的列表
+	htmlIndex bool
+	// PROPPATCH 和 COPY 也经过 webdav.Handler 使用的锁系统检查锁
+	locks *LockTracker
+	// OPTIONS 不要求认证, 部分客户端先探测再发送凭据
+	optionsNoAuth bool
+	// PROPFIND Depth: infinity 默认返回 403; 允许时最多列出 propfindInfinityMax 个条目
+	propfindInfinity    bool
+	propfindInfinityMax int
+	// 文件的 getcontentlanguage, 为空时不返回该属性
+	contentLanguage string
+	// MOVE/COPY 的 Destination 中可以使用的外部主机名, 用于反向代理改写了 Host 的部署
+	destHosts []string
+
+	pathForm         *norm.Form
+	renamePolicy     string
+	displayTemplates []displayTemplate
+	sortOrder        string
+	dirsFirst        bool
+
+	proppatchMaxProps int
+	proppatchMaxBody  int64
+	maxMemoryFile     int64
+	batchMaxOps       int
+	// 路径匹配 backends 的按映射的上游转发内容请求, 其次使用条目自己的地址,
+	// 都没有时按 backend + 路径转发
+	backend  *url.URL
+	backends BackendMap
+	fetcher  *Fetcher
+	uploads  UploadRules
+	health   *BackendHealth
+	// 定期探测配置的上游, 离线时文件请求直接返回 503, 为 nil 时不探测
+	prober *BackendProber
+	// 可写目录下删除和移动同步到上游的方式
+	propagate PropagateRules
+	// 请求上游时使用的 User-Agent
+	userAgents UserAgentRules
+	// 没有单独地址的条目按模板生成上游地址
+	urlTemplates URLTemplates
+	// 上游地址跳转后的最终地址, 为 nil 时不缓存
+	resolved *ResolveCache
+	// 列表中大小为 -1 的文件第一次使用时向上游查询大小
+	sizes *SizeResolver
+	// 目录的已用空间和 -quota 配置的总空间
+	quota *QuotaUsage
+	// 不区分大小写查找路径的索引, 为 nil 时区分大小写
+	caseIndex *CaseIndex
+	// 每个目录的直接子项, 为 nil 时列出目录遍历整棵树
+	children *ChildIndex
+	// 请求上游前为地址计算签名, signPrefix 限定需要签名的地址
+	signer     URLSigner
+	signPrefix string
+	// 上游连接失败或 5xx 时的重试次数和首次重试前的等待时间, 之后每次翻倍
+	upstreamRetries int
+	retryBackoff    time.Duration
+	// 传输中断后连续续传的次数上限, 0 表示不续传
+	resumeAttempts int
 }
 
 type VirtualFile struct {
-	meta  *FileMeta
+	meta *FileMeta
+	// path 是打开时条目的路径. MOVE 在锁内修改 meta.Path, 句柄在锁外只用这份快照
+	path  string
 	pos   int64
 	fs    *TextWebDAVFileSystem
 	flags int
+	// 从上游读取时复用的响应体, bodyPos 是它当前对应的文件位置
+	body    io.ReadCloser
+	bodyPos int64
+	// ctx 是打开文件的请求的上下文, 请求结束时上游读取随之停止
+	ctx context.Context
+	// created 表示条目是这次打开时新建的; upload 是写入内容时进行中的上传
+	created  bool
+	upload   *upload
+	writeErr error
+	// dirEntries 是第一次 Readdir 时的子项快照, dirPos 是下一次返回的位置
+	dirEntries []os.FileInfo
+	dirPos     int
+	// appendMode 对应 O_APPEND, 每次 Write 都写到文件末尾; truncated 表示打开时带 O_TRUNC,
+	// 没有写入任何内容时关闭也要在可写目录的上游留下空文件
+	appendMode bool
+	truncated  bool
+	// buf 是写入内存的文件在这个句柄上的内容, 关闭时替换条目的内容; memory 表示写入走内存
+	buf    []byte
+	memory bool
+	// content 和 length 是打开时条目的本地内容和大小. 其它请求替换内容或查到大小时在锁内修改条目,
+	// 句柄只读这份快照, 不与它们竞争
+	content []byte
+	length  int64
+	// hasher 边写边计算写入内容的 MD5 和 SHA-1, 关闭时随内容一起保存, hashed 是已经算进去的字节数.
+	// 写入不是从开头连续进行时 (往回 Seek、跳过一段或改写已有内容) 置为 nil, 不保存校验和
+	hasher *contentHasher
+	hashed int64
 }
 
 type VirtualFileInfo struct {
@@ -46,19 +193,13 @@ type VirtualFileInfo struct {
 	path    string
 	isDir   bool
 	modTime time.Time
+	etag    string
 }
 
-func main() {
-	fs := &TextWebDAVFileSystem{
-		Files: make(map[string]*FileMeta),
-		Auth:  make(map[string]string),
-		Port:  39124,
-	}
+// 退出时等待进行中的请求结束的最长时间, 之后仍未结束的连接被关闭
+const shutdownTimeout = 30 * time.Second
 
-	fs.Auth["1"] = "1"
-	fmt.Printf("WebDAV 模拟器已启动\n用户名: 1\n密码: 1\n")
-
-	err := fs.LoadFromText(`
+const demoList = `
 /玫瑰的故事(2025)/1.mkv#1024#1.mkv
 /玫瑰的故事(2025)/2.mkv#1024#2.mkv
 /红楼梦(1987)/1.mkv#1024#1.mkv
@@ -66,99 +207,811 @@ func main() {
 /西游记(1986)^/1.mkv#1024#1.mkv
 /西游记(1986)^/2.mkv#1024#2.mkv
 /哪吒2(2025)_1.mkv#1024#哪吒2(2025)_1.mkv
-`)
+`
+
+func main() {
+	port := flag.Int("port", defaultFileSystemOptions.Port, "监听端口")
+	listSource := flag.String("list", "", "列表文件路径或 http(s) 地址, 支持 gzip 压缩, 为空则加载内置示例")
+	journalPath := flag.String("journal", "", "修改日志文件路径, 为空则不记录")
+	flag.StringVar(journalPath, "state", "", "同 -journal, 保存客户端修改 (PROPPATCH/新建/移动/删除) 的状态文件")
+	journalInterval := flag.Duration("journal-sync", 200*time.Millisecond, "修改日志批量同步间隔")
+	peerToken := flag.String("peer-token", "", "实例间通信的共享密钥, 为空则不启用多实例模式")
+	lockPeer := flag.String("lock-peer", "", "锁权威实例地址, 为空则本实例自己管理锁")
+	peerList := flag.String("peers", "", "接收本实例修改推送的其它实例地址, 逗号分隔")
+	verifySize := flag.Bool("verify-size", false, "校验上游返回的文件大小与列表是否一致")
+	fixSize := flag.Bool("fix-size", false, "校验发现大小不一致时自动修正内存中的大小")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP 追踪收集器地址, 例如 http://localhost:4318, 为空则不追踪")
+	traceAnonymize := flag.Bool("trace-anonymize", false, "追踪数据中只记录路径的哈希")
+	var cacheControl stringList
+	flag.Var(&cacheControl, "cache-control", "Cache-Control 规则, 形如 /posters=max-age=604800、ext:.nfo=private, max-age=86400、class:artwork=no-cache 或 default=no-store, 可重复; 都不匹配时为 no-cache")
+	cacheControlFile := flag.String("cache-control-file", "", "Cache-Control 规则文件, 每行一条, 格式与 -cache-control 相同, 重新加载列表时重新读取")
+	redirect := flag.Bool("redirect", false, "文件的 GET/HEAD 返回 302 直接指向上游地址, 不经本进程转发内容")
+	var redirectRules stringList
+	flag.Var(&redirectRules, "redirect-rule", "按路径前缀开启或关闭重定向, 形如 /电影=on 或 /直播=off, 可重复, 最长匹配优先")
+	var headerRules stringList
+	flag.Var(&headerRules, "header", "附加的响应头, 形如 /=X-Robots-Tag: noindex、class:video=X-Title: {displayname} 或 type:text/html=Content-Security-Policy: ..., 可重复")
+	davSource := flag.String("webdav-source", "", "要镜像的远端 WebDAV 地址")
+	davUser := flag.String("webdav-user", "", "远端 WebDAV 用户名")
+	davPass := flag.String("webdav-pass", "", "远端 WebDAV 密码")
+	davMount := flag.String("webdav-mount", "/", "远端 WebDAV 在虚拟树中的挂载路径")
+	davWorkers := flag.Int("webdav-workers", 4, "抓取远端 WebDAV 的并发目录数")
+	davRecrawl := flag.Duration("webdav-recrawl", 0, "增量重新抓取远端 WebDAV 的间隔, 0 表示只在启动时抓取")
+	missingTTL := flag.Duration("missing-ttl", defaultFileSystemOptions.MissingTTL, "上游返回 404/410 的条目多久后重新尝试, 0 表示每次都重新尝试")
+	backend := flag.String("backend", "", "内容请求的上游地址, 没有单独地址的条目按该地址加路径转发, 例如 http://alist:5244/d")
+	mirrorDest := flag.String("mirror", "", "定期导出静态镜像的位置, 本地目录或 http(s):// 开头的 WebDAV 地址, 为空则不导出")
+	mirrorInterval := flag.Duration("mirror-interval", time.Hour, "静态镜像的导出间隔, 0 表示只在启动时导出一次")
+	mirrorUser := flag.String("mirror-user", "", "上传静态镜像的 WebDAV 用户名")
+	mirrorPass := flag.String("mirror-pass", "", "上传静态镜像的 WebDAV 密码")
+	signScheme := flag.String("sign-scheme", "", "请求上游时为下载地址计算签名的方式, 目前支持 alist, 为空则不签名")
+	signSecret := flag.String("sign-secret", "", "计算签名使用的密钥, Alist 为其令牌")
+	signTTL := flag.Duration("sign-ttl", 0, "签名的有效期, 0 表示永不过期")
+	signPrefix := flag.String("sign-prefix", "", "只为以此开头的上游地址签名, 为空则为所有上游地址签名")
+	upstreamRetries := flag.Int("upstream-retries", defaultFileSystemOptions.UpstreamRetries, "上游连接失败或返回 5xx 时的重试次数")
+	upstreamIdle := flag.Int("upstream-max-idle", defaultFetcherOptions.MaxIdleConnsPerHost, "每个上游保留的最大空闲连接数")
+	upstreamDialTimeout := flag.Duration("upstream-dial-timeout", defaultFetcherOptions.DialTimeout, "连接上游的超时时间")
+	upstreamTLSTimeout := flag.Duration("upstream-tls-timeout", defaultFetcherOptions.TLSHandshakeTimeout, "与上游 TLS 握手的超时时间")
+	upstreamHeaderTimeout := flag.Duration("upstream-header-timeout", defaultFetcherOptions.ResponseHeaderTimeout, "等待上游响应头的超时时间, 0 表示不限制")
+	upstreamIdleTimeout := flag.Duration("upstream-idle-timeout", defaultFetcherOptions.IdleConnTimeout, "上游空闲连接保留多久")
+	upstreamReadTimeout := flag.Duration("upstream-read-timeout", defaultFetcherOptions.ReadIdleTimeout, "读取上游内容时多久收不到数据就中止并重试, 0 表示不限制")
+	backendInsecure := flag.Bool("backend-insecure-skip-verify", false, "不验证 HTTPS 上游的证书, 只影响对上游的连接")
+	backendCA := flag.String("backend-ca-file", "", "验证 HTTPS 上游时额外信任的 CA 证书 (PEM), 用于自签名证书")
+	var backendProxies stringList
+	flag.Var(&backendProxies, "backend-proxy", "访问上游使用的代理, 如 socks5://127.0.0.1:1080, 覆盖 HTTP_PROXY 等环境变量; 形如 /prefix=socks5://... 或 /prefix=direct 时只用于该 -backend-map 前缀, 可重复")
+	var backendTimeouts stringList
+	flag.Var(&backendTimeouts, "backend-timeout", "覆盖某个 -backend-map 前缀上游的超时, 形如 /movies=dial=5s,tls=5s,header=10s,read=20s, 可重复")
+	resolveTTL := flag.Duration("resolve-cache-ttl", defaultFileSystemOptions.ResolveTTL, "缓存上游地址跳转后的最终地址多久, 0 表示不缓存")
+	resolveSize := flag.Int("resolve-cache-size", defaultFileSystemOptions.ResolveSize, "最多缓存多少个跳转后的地址, 超出时淘汰最久未用的")
+	resumeAttempts := flag.Int("upstream-resume-attempts", defaultFileSystemOptions.ResumeAttempts, "传输中途断开后最多连续续传几次, 续传后又正常传输了 1MB 时重新计数, 0 表示不续传")
+	retryBackoff := flag.Duration("upstream-retry-backoff", defaultFileSystemOptions.RetryBackoff, "第一次重试前的等待时间, 之后每次翻倍")
+	refresh := flag.Duration("refresh", 0, "后台重新加载列表和远端源的间隔, 例如 30m, 0 表示不刷新")
+	alistURL := flag.String("alist-url", "", "Alist (小雅) 地址, 通过 /api/fs/list 抓取目录树")
+	alistToken := flag.String("alist-token", "", "Alist 的访问令牌")
+	alistRoot := flag.String("alist-root", "/", "要抓取的 Alist 目录")
+	alistMount := flag.String("alist-mount", "/", "Alist 目录在虚拟树中的挂载路径")
+	alistWorkers := flag.Int("alist-workers", 4, "抓取 Alist 的并发目录数")
+	alistRate := flag.Float64("alist-rate", 5, "抓取 Alist 时每秒最多发出的请求数, 0 表示不限制")
+	alistLazy := flag.Bool("alist-lazy", false, "按需抓取 Alist: 启动时只列出挂载点, 其它目录第一次被访问时才列出")
+	alistLazyTTL := flag.Duration("alist-lazy-ttl", 10*time.Minute, "按需抓取的目录列表的有效期, 过期后下一次访问时重新列出, 0 表示不过期")
+	var stripPrefix, mapPrefix stringList
+	flag.Var(&stripPrefix, "strip-prefix", "加载列表时去掉的路径前缀, 例如 /data/xiaoya, 可重复")
+	flag.Var(&mapPrefix, "map-prefix", "加载列表时替换的路径前缀, 形如 /old=/new, 可重复, 最长匹配优先")
+	caseInsensitive := flag.Bool("case-insensitive", false, "路径查找不区分大小写, 列表和显示名保持原样; 加载时报告只有大小写不同的条目")
+	readOnly := flag.Bool("read-only", false, "只读模式, 拒绝 PUT、DELETE、MKCOL、MOVE、COPY、PROPPATCH 和 LOCK")
+	contentDisposition := flag.Bool("content-disposition", defaultFileSystemOptions.ContentDisposition, "文件的 GET/HEAD 返回 Content-Disposition, 浏览器和播放器下载时使用显示名; 客户端处理不了时设为 false")
+	htmlIndex := flag.Bool("html-index", false, "目录的 GET 返回 HTML 列表 (Accept 不含 html 时为纯文本), 默认交给 WebDAV 处理")
+	optionsNoAuth := flag.Bool("options-no-auth", false, "OPTIONS 请求不要求认证, 兼容先探测再发送凭据的客户端")
+	propfindInfinity := flag.Bool("propfind-infinity", false, "允许 PROPFIND Depth: infinity 列出整棵子树, 默认返回 403 (propfind-finite-depth), 客户端改为逐层列出")
+	propfindInfinityMax := flag.Int("propfind-infinity-max", defaultFileSystemOptions.PropfindInfinityMax, "Depth: infinity 最多列出的条目数, 超出时返回 403, 0 表示不限制")
+	contentLanguage := flag.String("content-language", defaultFileSystemOptions.ContentLanguage, "PROPFIND 中文件的 getcontentlanguage, 为空则不返回")
+	var destHosts stringList
+	flag.Var(&destHosts, "dest-host", "MOVE/COPY 的 Destination 可以使用的外部主机名, 如 dav.example.com:8443, 反向代理改写了 Host 时使用, 可重复; 其它主机返回 502")
+	strict := flag.Bool("strict", false, "列表中有格式错误的行时中止加载, 默认跳过错误行继续加载")
+	include := flag.String("include", "", "只加载匹配的文件, 逗号分隔的通配符或 re: 开头的正则")
+	exclude := flag.String("exclude", "", "不加载匹配的文件和目录, 逗号分隔的通配符或 re: 开头的正则")
+	proppatchMaxProps := flag.Int("proppatch-max-props", defaultFileSystemOptions.ProppatchMaxProps, "单个 PROPPATCH 最多修改的属性数, 0 表示不限制")
+	unicodeNorm := flag.String("unicode-norm", "", "把列表路径和请求路径统一成 nfc 或 nfd, 兼容 macOS Finder, 为空则不转换")
+	renamePolicy := flag.String("rename-displayname", RenamePreserve, "移动/重命名时显示名的处理: preserve 保留, basename 改成新文件名, template 按 -displayname-template 重新生成")
+	sortOrder := flag.String("sort", defaultFileSystemOptions.SortOrder, "目录列表的顺序: name 按文件名, displayname 按显示名, mtime 按修改时间 (新的在前)")
+	dirsFirst := flag.Bool("dirs-first", false, "目录列表中目录排在文件前面")
+	var displayTemplates stringList
+	flag.Var(&displayTemplates, "displayname-template", "显示名模板, 形如 /电影={stem}, 可用 {name} {stem} {ext} {parent}, 可重复, 最长前缀优先")
+	batchMaxOps := flag.Int("batch-max-ops", defaultFileSystemOptions.BatchMaxOps, "/api/batch 单批最多的操作数, 0 表示不限制")
+	proppatchMaxBody := byteSize(defaultFileSystemOptions.ProppatchMaxBody)
+	flag.Var(&proppatchMaxBody, "proppatch-max-body", "单个 PROPPATCH 请求体的最大字节数, 0 表示不限制")
+	var quotaSize byteSize
+	flag.Var(&quotaSize, "quota", "PROPFIND 中报告的总空间 (quota-available-bytes 为其减去已用), 如 4TB, 0 表示不限制")
+	maxMemoryFile := byteSize(defaultFileSystemOptions.MaxMemoryFile)
+	flag.Var(&maxMemoryFile, "max-memory-file", "不在 -upload 可写目录下的文件 (客户端新建的或列表中带内容的) 写入时保存在内存中, 单个文件的上限, 0 表示不允许写入")
+	var charsetProfiles stringList
+	flag.Var(&charsetProfiles, "charset-profile", "为老客户端转码路径, 形如 gbk:ua=Kodi/16 或 big5:cidr=192.168.1.0/24, 可重复")
+	var backendMap stringList
+	flag.Var(&backendMap, "backend-map", "按路径前缀选择内容请求的上游, 形如 /movies=http://alist1:5244/d, 最长前缀优先, 可重复")
+	var urlTemplates stringList
+	flag.Var(&urlTemplates, "url-template", "没有单独地址的条目按模板生成上游地址, 如 http://alist:5244/d{path}?sign={sign}, 可用 {path} {rel} {name} {sign}; 形如 /电影=模板 时只用于该前缀, 可重复, 最长前缀优先")
+	printURL := flag.String("print-url", "", "加载列表后打印该虚拟路径实际请求的上游地址 (含签名) 并退出, 用于排查地址配置")
+	failThreshold := flag.Int("backend-fail-threshold", defaultFileSystemOptions.FailThreshold, "上游连续失败多少次后暂时改用其它镜像, 0 表示不跳过")
+	failCooldown := flag.Duration("backend-cooldown", defaultFileSystemOptions.FailCooldown, "连续失败的上游多久后重新优先使用")
+	probeInterval := flag.Duration("health-interval", 0, "探测 -backend 和 -backend-map 上游是否在线的间隔, 0 表示不探测")
+	probeThreshold := flag.Int("health-fail-threshold", 2, "连续探测失败多少次后把上游标记为离线")
+	backendMaxConcurrency := flag.Int("backend-max-concurrency", 0, "同时进行的上游请求数上限, 超出的请求排队等待, 请求从发出到内容传完都占用名额; PROPFIND 和健康探测不受限制, 0 表示不限制")
+	probePath := flag.String("health-path", "", "探测时请求的路径, 如 Alist 的 /ping; 为空时对配置的地址发 HEAD")
+	var uploadRules stringList
+	flag.Var(&uploadRules, "upload", "可写目录, PUT 的内容转发到上游, 形如 /inbox=http://nas/dav/inbox 或 /inbox=alist:http://alist:5244/远端目录, 可重复")
+	var userAgentRules stringList
+	flag.Var(&userAgentRules, "upstream-user-agent", "请求上游时使用的 User-Agent: forward 表示转发客户端的, 否则为固定字符串; 形如 /115=forward 时只用于该前缀, 可重复")
+	var propagateRules stringList
+	flag.Var(&propagateRules, "propagate", "可写目录下的删除和移动是否同步到上游, 形如 /inbox/保留=off 或 /inbox=dry-run, 默认同步, 可重复")
+	var upstreamHeaders stringList
+	flag.Var(&upstreamHeaders, "upstream-header", "发往上游的固定请求头, 形如 \"Authorization: Bearer xxx\", 前面加 \"http://上游前缀=\" 只用于该上游, 可重复")
+	var redactParams stringList
+	flag.Var(&redactParams, "redact-param", "日志和导出中需要隐藏值的上游地址查询参数名, 可重复")
+	adminPort := flag.Int("admin-port", 0, "单独的管理端口, 根路径是状态页, 0 表示不开启")
+	adminToken := flag.String("admin-token", "", "管理接口 /admin/ 的 Bearer 令牌, 为空则使用 WebDAV 账号认证")
+	var checksumRate byteSize = 32 << 20
+	flag.Var(&checksumRate, "checksum-rate", "后台为本地内容补算校验和的速率, 每秒字节数, 0 表示不补算")
+	transferState := flag.String("transfer-state", "", "流量统计持久化文件, 为空则重启后重新计数")
+	transferTZ := flag.String("transfer-tz", "Local", "流量统计按月划分使用的时区, 例如 Asia/Shanghai")
+	var transferSoft, transferCap, transferSoftRate byteSize
+	flag.Var(&transferSoft, "transfer-soft", "本月流量超过该值后每个流限速, 例如 1.5TB, 0 表示不限速")
+	flag.Var(&transferCap, "transfer-cap", "本月流量上限, 超过后内容请求返回 503, 例如 2TB, 0 表示不限制")
+	flag.Var(&transferSoftRate, "transfer-soft-rate", "超过软阈值后每个流的速率上限, 每秒字节数, 例如 512KB")
+	var readAhead byteSize
+	flag.Var(&readAhead, "read-ahead", "从上游读取内容时在后台预读的缓冲区大小, 例如 16MB, 0 表示不预读")
+	parallelFetch := flag.Bool("parallel-fetch", false, "顺序读取大文件时用多个 Range 请求并发抓取后面的内容, 上游不支持 Range 时自动退回单连接")
+	parallelConns := flag.Int("parallel-connections", 4, "并发抓取时同时使用的连接数")
+	var parallelChunk byteSize = 4 << 20
+	flag.Var(&parallelChunk, "parallel-chunk", "并发抓取时每个 Range 请求的大小, 例如 4MB")
+	var cacheSize byteSize
+	flag.Var(&cacheSize, "cache-size", "小文件内容缓存的总大小, 例如 256MB, 0 表示不缓存")
+	cacheMaxFile := byteSize(defaultFileSystemOptions.CacheMaxFile)
+	flag.Var(&cacheMaxFile, "cache-max-file", "只缓存不超过该大小的文件, 例如 2MB")
+	var maxStreamRate, maxTotalRate byteSize
+	flag.Var(&maxStreamRate, "max-bps-per-stream", "每个内容 GET 的发送速率上限, 每秒字节数, 例如 2MB, 0 表示不限制")
+	flag.Var(&maxTotalRate, "max-bps-total", "所有内容 GET 共享的发送速率上限, 每秒字节数, 例如 5MB, 0 表示不限制")
+	flag.Parse()
+
+	backendTLS, err := backendTLSConfig(*backendCA, *backendInsecure)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n", err)
+		return
+	}
+	var peers []string
+	if *peerList != "" {
+		peers = strings.Split(*peerList, ",")
+	}
+	fsOpts := FileSystemOptions{
+		Port:       *port,
+		ListSource: *listSource,
+		AdminToken: *adminToken,
+		Strict:     *strict,
+		ReadOnly:   *readOnly,
+
+		Fetcher: FetcherOptions{
+			MaxIdleConnsPerHost:   *upstreamIdle,
+			DialTimeout:           *upstreamDialTimeout,
+			TLSHandshakeTimeout:   *upstreamTLSTimeout,
+			ResponseHeaderTimeout: *upstreamHeaderTimeout,
+			IdleConnTimeout:       *upstreamIdleTimeout,
+			ReadIdleTimeout:       *upstreamReadTimeout,
+			TLSConfig:             backendTLS,
+		},
+		BackendMaxConcurrency: *backendMaxConcurrency,
+		FailThreshold:         *failThreshold,
+		FailCooldown:          *failCooldown,
+		ResolveTTL:            *resolveTTL,
+		ResolveSize:           *resolveSize,
+		UpstreamRetries:       *upstreamRetries,
+		ResumeAttempts:        *resumeAttempts,
+		RetryBackoff:          *retryBackoff,
+		MissingTTL:            *missingTTL,
+		ReadAhead:             int(readAhead),
+		CacheSize:             int64(cacheSize),
+		CacheMaxFile:          int64(cacheMaxFile),
+		MaxStreamRate:         int64(maxStreamRate),
+		MaxTotalRate:          int64(maxTotalRate),
+
+		Quota:               int64(quotaSize),
+		CaseInsensitive:     *caseInsensitive,
+		ContentDisposition:  *contentDisposition,
+		HTMLIndex:           *htmlIndex,
+		OptionsNoAuth:       *optionsNoAuth,
+		PropfindInfinity:    *propfindInfinity,
+		PropfindInfinityMax: *propfindInfinityMax,
+		ContentLanguage:     *contentLanguage,
+		SortOrder:           *sortOrder,
+		DirsFirst:           *dirsFirst,
+		ProppatchMaxProps:   *proppatchMaxProps,
+		ProppatchMaxBody:    int64(proppatchMaxBody),
+		MaxMemoryFile:       int64(maxMemoryFile),
+		BatchMaxOps:         *batchMaxOps,
+
+		Peers:          peers,
+		PeerToken:      *peerToken,
+		OTLPEndpoint:   *otlpEndpoint,
+		TraceAnonymize: *traceAnonymize,
+	}
+	if *parallelFetch && *parallelConns > 1 && parallelChunk > 0 {
+		fsOpts.ParallelConns, fsOpts.ParallelChunk = *parallelConns, int64(parallelChunk)
+	}
+	fs, err := newFileSystem(fsOpts)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n", err)
+		return
+	}
+	for _, prefix := range stripPrefix {
+		mapPrefix = append(mapPrefix, prefix+"=/")
+	}
+	for _, rule := range mapPrefix {
+		if err := fs.prefixMap.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	policy, err := parseRenamePolicy(*renamePolicy)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n", err)
+		return
+	}
+	fs.renamePolicy = policy
+	fs.destHosts = destHosts
+	if *backend != "" {
+		fs.backend, err = url.Parse(strings.TrimSuffix(*backend, "/"))
+		if err != nil || fs.backend.Scheme == "" || fs.backend.Host == "" {
+			fmt.Printf("参数错误: -backend 地址无效: %s\n", redactURL(*backend))
+			return
+		}
+	}
+	for _, rule := range uploadRules {
+		if err := fs.uploads.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	for _, rule := range userAgentRules {
+		if err := fs.userAgents.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	for _, rule := range propagateRules {
+		if err := fs.propagate.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	for _, rule := range backendMap {
+		if err := fs.backends.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	// 先设置全局代理, 各前缀单独的代理和超时在它的基础上修改
+	sort.SliceStable(backendProxies, func(i, j int) bool {
+		return !strings.HasPrefix(backendProxies[i], "/") && strings.HasPrefix(backendProxies[j], "/")
+	})
+	for _, rule := range backendProxies {
+		if err := fs.applyBackendProxy(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	for _, rule := range backendTimeouts {
+		if err := fs.applyBackendTimeouts(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	for _, line := range fs.fetcher.Describe() {
+		fmt.Println(line)
+	}
+	if *signScheme != "" {
+		fs.signer, err = newURLSigner(*signScheme, *signSecret, *signTTL)
+		if err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+		fs.signPrefix = *signPrefix
+	}
+	for _, rule := range urlTemplates {
+		if err := fs.urlTemplates.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	if fs.urlTemplates.signed() && fs.signer == nil {
+		fmt.Printf("参数错误: 地址模板使用了 {sign}, 需要同时设置 -sign-scheme 和 -sign-secret\n")
+		return
+	}
+	for _, rule := range upstreamHeaders {
+		if err := fs.upstreamHeaders.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	fs.pathForm, err = parseUnicodeForm(*unicodeNorm)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n", err)
+		return
+	}
+	for _, rule := range displayTemplates {
+		if err := fs.addDisplayTemplate(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	filter, err := NewPathFilter(*include, *exclude)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n", err)
+		return
+	}
+	fs.filter = filter
+	for _, spec := range charsetProfiles {
+		profile, err := parseCharsetProfile(spec)
+		if err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+		fs.charsetProfiles = append(fs.charsetProfiles, profile)
+	}
+	for _, name := range redactParams {
+		addSensitiveParam(name)
+	}
+	fs.redirects = NewRedirectRules(*redirect)
+	for _, rule := range redirectRules {
+		if err := fs.redirects.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	for _, rule := range headerRules {
+		if err := fs.headers.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	for _, rule := range cacheControl {
+		if err := fs.cacheRules.Add(rule); err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+	}
+	if *cacheControlFile != "" {
+		if err := fs.cacheRules.LoadFile(*cacheControlFile); err != nil {
+			fmt.Printf("读取缓存规则失败: %v\n", err)
+			return
+		}
+	}
+	if transferSoft > 0 || transferCap > 0 || *transferState != "" {
+		loc, err := time.LoadLocation(*transferTZ)
+		if err != nil {
+			fmt.Printf("参数错误: 时区 %q 无效: %v\n", *transferTZ, err)
+			return
+		}
+		if transferSoft > 0 && transferSoftRate == 0 {
+			transferSoftRate = 512 * 1024
+		}
+		fs.transfer, err = NewTransferMeter(*transferState, loc, int64(transferSoft), int64(transferCap), int64(transferSoftRate))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	}
+	if *verifySize {
+		fs.mismatches = NewMismatchReport()
+		fs.fixSize = *fixSize
+	}
+
+	fs.Auth["1"] = "1"
+	fmt.Printf("WebDAV 模拟器已启动\n用户名: 1\n密码: 1\n")
+
+	var report *LoadReport
+	if *listSource != "" {
+		report, err = fs.LoadFromSource(*listSource)
+	} else if *davSource == "" && *alistURL == "" {
+		report, err = fs.LoadFromText(demoList)
+	}
+	if report != nil && err == nil {
+		setLastLoadReport(report)
+	}
 	if err != nil {
 		fmt.Printf("加载数据错误: %v\n", err)
 		return
 	}
 
+	if *davSource != "" {
+		source, err := NewWebDAVSource(*davSource, *davMount, *davWorkers)
+		if err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+		fs.addUpstreamCredential(source.base.String(), *davUser, *davPass)
+		fs.fetcher.share(source.client)
+		fs.sources = append(fs.sources, source)
+		if err := source.Crawl(context.Background(), fs); err != nil {
+			fmt.Printf("抓取 WebDAV 源失败: %v\n", err)
+			return
+		}
+		if *davRecrawl > 0 {
+			go func() {
+				for range time.Tick(*davRecrawl) {
+					if err := source.Crawl(context.Background(), fs); err != nil {
+						fmt.Printf("重新抓取 WebDAV 源失败: %v\n", err)
+					}
+				}
+			}()
+		}
+	}
+
+	if *alistURL != "" {
+		source, err := NewAlistSource(*alistURL, *alistToken, *alistRoot, *alistMount, *alistWorkers, *alistRate)
+		if err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+		fs.fetcher.share(source.client)
+		fs.sources = append(fs.sources, source)
+		if *alistLazy {
+			fs.lazyDirs = NewLazyDirs(source, *alistLazyTTL)
+		}
+		if err := source.Crawl(context.Background(), fs); err != nil {
+			fmt.Printf("抓取 Alist 源失败: %v\n", err)
+			return
+		}
+	}
+
+	fs.reloadState.lastGood = time.Now()
+
+	if *printURL != "" {
+		os.Exit(fs.printUpstreamURLs(*printURL))
+	}
+
+	if *journalPath != "" {
+		if err := fs.ReplayJournal(*journalPath, *journalInterval); err != nil {
+			fmt.Printf("重放日志错误: %v\n", err)
+			return
+		}
+		defer fs.journal.Close()
+	}
+
+	var lockSystem webdav.LockSystem = webdav.NewMemLS()
+	if *lockPeer != "" {
+		if *peerToken == "" {
+			fmt.Printf("-lock-peer 需要同时设置 -peer-token\n")
+			return
+		}
+		lockSystem = NewPeerLockSystem(*lockPeer, *peerToken)
+	}
+
+	if *refresh > 0 {
+		go fs.refreshLoop(*refresh)
+	}
+	if fs.prober = NewBackendProber(fs, *probeInterval, *probeThreshold, *probePath); fs.prober != nil {
+		go fs.prober.loop()
+	}
+	if *mirrorDest != "" {
+		fs.mirror, err = NewMirror(fs, *mirrorDest, *mirrorUser, *mirrorPass)
+		if err != nil {
+			fmt.Printf("参数错误: %v\n", err)
+			return
+		}
+		go fs.mirror.loop(*mirrorInterval)
+	}
+	if checksumRate > 0 {
+		go fs.runChecksumJob(int64(checksumRate), time.Minute)
+	}
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if _, err := fs.Reload(); err != nil {
+				fmt.Printf("重新加载失败: %v\n", err)
+			}
+		}
+	}()
+
+	fs.locks = NewLockTracker(lockSystem)
 	handler := &webdav.Handler{
 		FileSystem: fs,
-		LockSystem: webdav.NewMemLS(),
+		LockSystem: fs.locks,
 	}
 
 	wrappedHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withClientUserAgent(r)
+		if fs.rejectWrite(w, r) {
+			return
+		}
+		// PROPFIND 中查询大小、列出目录的上游请求不受 -backend-max-concurrency 限制
+		if r.Method == "PROPFIND" {
+			r = r.WithContext(exemptBackendLimit(r.Context()))
+		}
+		// 按需抓取时先列出路径上还没有列出的 Alist 目录, PROPFIND 同时刷新过期的目录
+		fs.populate(r.Context(), fs.keyPath(r.URL.Path), r.Method == "PROPFIND")
+		if r.Method == http.MethodOptions {
+			fs.serveOptions(w, r)
+			return
+		}
 		if r.Method == "PROPFIND" {
 			fs.HandlePropfind(w, r)
 			return
 		}
+		if fs.rejectMismatch(w, r) {
+			return
+		}
+		if r.Method == "PROPPATCH" {
+			fs.HandleProppatch(w, r)
+			return
+		}
+		if r.Method == "COPY" {
+			fs.HandleCopy(w, r)
+			return
+		}
+		if r.Method == http.MethodPut {
+			if fs.rejectOversizedPut(w, r) {
+				return
+			}
+			r = withUploadSize(r)
+		}
+		if r.Method == http.MethodDelete || r.Method == "MOVE" {
+			w, r = withPropagation(w, r)
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if fs.missing.Blocked(fs.keyPath(r.URL.Path)) {
+				http.Error(w, "上游文件已不存在", http.StatusNotFound)
+				return
+			}
+			if cc := fs.cacheRules.For(r.URL.Path); cc != "" {
+				w.Header().Set("Cache-Control", cc)
+			}
+		}
+		// 没有本地内容的文件重定向或直接转发给上游, 客户端的 Range 由上游处理;
+		// 文件的 HEAD 只用元数据回答
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			// 之后的处理都在锁外读取条目, 用锁内复制的副本, 不与同时进行的 PUT、PROPPATCH 竞争
+			fs.mu.RLock()
+			var meta *FileMeta
+			cur, ok := fs.Files[fs.keyPath(r.URL.Path)]
+			if ok {
+				snapshot := *cur
+				meta = &snapshot
+			}
+			fs.mu.RUnlock()
+			if ok {
+				fs.setContentDisposition(w, meta)
+			}
+			if ok && r.Method == http.MethodGet && fs.serveCached(w, r, meta) {
+				return
+			}
+			// 上游都已离线时立即返回 503, 不让播放器等到超时
+			if ok && r.Method == http.MethodGet && !meta.IsDir && meta.Content == nil && fs.prober.offline(fs.upstreamURLs(meta)) {
+				fs.prober.serveOffline(w)
+				return
+			}
+			if ok && fs.redirectUpstream(w, r, meta) {
+				return
+			}
+			if ok && r.Method == http.MethodHead && !meta.IsDir {
+				fs.serveHead(w, r, meta)
+				return
+			}
+			if ok && r.Method == http.MethodGet && !meta.IsDir && meta.Content == nil {
+				fs.serveUpstream(w, r, meta)
+				return
+			}
+			// 根目录不在 Files 中
+			if fs.htmlIndex && (ok && meta.IsDir || fs.keyPath(r.URL.Path) == "/") {
+				fs.serveDirIndex(w, r, fs.keyPath(r.URL.Path))
+				return
+			}
+		}
 		handler.ServeHTTP(w, r)
 	})
 
-	authHandler := fs.authMiddleware(wrappedHandler)
+	mux := http.NewServeMux()
+	mux.Handle(apiPathPrefix, fs.authMiddleware(fs.apiHandler()))
+	mux.Handle(adminPathPrefix, fs.adminAuth(fs.adminHandler()))
+	if *peerToken != "" {
+		// 只有锁权威对外提供锁接口, 其余实例只接收修改推送
+		var peerLocks webdav.LockSystem
+		if *lockPeer == "" {
+			peerLocks = fs.locks
+		}
+		mux.Handle(peerPathPrefix, NewPeerServer(fs, peerLocks, *peerToken))
+	}
+	mux.Handle("/", fs.authMiddleware(fs.destinationMiddleware(fs.charsetMiddleware(pathNormMiddleware(fs.decodeMiddleware(fs.caseMiddleware(fs.streams.middleware(fs, fs.transfer.middleware(fs.throttle.middleware(wrappedHandler))))))))))
+
+	if *adminPort != 0 {
+		go func() {
+			fmt.Printf("管理端口 %d\n", *adminPort)
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", *adminPort), tracingMiddleware(fs.headers.middleware(fs, fs.adminPortHandler()))); err != nil {
+				fmt.Printf("管理端口错误: %v\n", err)
+			}
+		}()
+	}
 
 	addr := fmt.Sprintf(":%d", fs.Port)
 	fmt.Printf("服务器运行在端口 %d\n访问地址: http://localhost:%d\n", fs.Port, fs.Port)
 
-	err = http.ListenAndServe(addr, authHandler)
-	if err != nil {
+	// 收到 SIGINT/SIGTERM 时不直接退出, 等进行中的请求结束后从 main 返回,
+	// 让上面的 defer 关闭并同步日志, 再保存流量统计
+	srv := &http.Server{Addr: addr, Handler: tracingMiddleware(fs.headers.middleware(fs, mux))}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		fmt.Printf("正在关闭服务器\n")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Printf("等待请求结束超时: %v\n", err)
+		}
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		fmt.Printf("服务器错误: %v\n", err)
+		return
+	}
+	<-stopped
+	if err := fs.transfer.Save(); err != nil {
+		fmt.Printf("保存流量统计失败: %v\n", err)
 	}
 }
 
-func (fs *TextWebDAVFileSystem) LoadFromText(text string) error {
-	scanner := bufio.NewScanner(strings.NewReader(text))
+// 单行列表的最大长度, 超长路径或内联内容的行需要比 bufio 默认的 64KB 更大的缓冲
+const maxListLineSize = 4 * 1024 * 1024
+
+func (fs *TextWebDAVFileSystem) LoadFromText(text string) (*LoadReport, error) {
+	return fs.LoadFromReader(strings.NewReader(text))
+}
+
+// LoadFromReader 逐行流式解析列表, 任何时候只持有当前一行, 不会把整个列表读进内存.
+// 默认跳过格式错误的行并记入报告, -strict 时遇到第一个错误行就中止. 解析出的条目与
+// LoadEntries 一样经过 listLoad 放入目录树
+func (fs *TextWebDAVFileSystem) LoadFromReader(r io.Reader) (*LoadReport, error) {
+	l := fs.newListLoad()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxListLineSize)
+	lineNo := 0
 	for scanner.Scan() {
+		lineNo++
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		parts := strings.Split(line, "#")
-		if len(parts) < 3 {
-			return fmt.Errorf("格式错误: 需要 path#size#displayname")
-		}
-
-		path := strings.TrimSpace(parts[0])
-		size, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		meta, err := parseLine(line)
 		if err != nil {
-			return fmt.Errorf("大小格式错误: %v", err)
+			if err := l.skip(lineNo, err, line); err != nil {
+				return l.report, err
+			}
+			continue
 		}
+		l.add(meta)
+	}
+	if err := scanner.Err(); err != nil {
+		return l.report, err
+	}
+	return l.finish(), nil
+}
 
-		displayName := strings.TrimSpace(parts[2])
-		if path == "" || displayName == "" {
-			return fmt.Errorf("路径或显示名不能为空")
+// ensureParentsLocked 为路径补齐所有尚不存在的上级目录
+func (fs *TextWebDAVFileSystem) ensureParentsLocked(path string) {
+	for dir := filepath.Dir(path); dir != "/"; dir = filepath.Dir(dir) {
+		if _, ok := fs.Files[dir]; ok {
+			return
 		}
+		fs.setEntryLocked(dir, &FileMeta{
+			Path:        dir,
+			DisplayName: filepath.Base(dir),
+			IsDir:       true,
+			ModTime:     defaultModTime(),
+		})
+	}
+}
 
-		if !strings.HasPrefix(path, "/") {
-			path = "/" + path
-		}
+// parseLine 解析一行列表: path#size#displayname[#content[#etag[#modtime[#created[#url[#props]]]]]].
+// path 以 / 结尾表示目录; path、displayname 和 url 中的 #、%、换行用 %23、%25、%0A、%0D 转义
+func parseLine(line string) (*FileMeta, error) {
+	parts := strings.Split(line, "#")
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("格式错误: 需要 path#size#displayname[#content[#etag[#modtime[#created[#url[#props]]]]]]")
+	}
 
-		content := []byte(fmt.Sprintf("模拟文件内容: %s", path))
+	rawPath := unescapeField(strings.TrimSpace(parts[0]))
+	displayName := unescapeField(strings.TrimSpace(parts[2]))
+	if rawPath == "" || displayName == "" {
+		return nil, fmt.Errorf("路径或显示名不能为空")
+	}
 
-		fs.mu.Lock()
-		fs.Files[path] = &FileMeta{
+	path, isDir, err := normalizeListPath(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	if path == "/" {
+		return nil, fmt.Errorf("路径不能是根目录")
+	}
+
+	column := func(i int) string {
+		if i < len(parts) {
+			return strings.TrimSpace(parts[i])
+		}
+		return ""
+	}
+
+	modTime := defaultModTime()
+	if column(5) != "" {
+		parsed, err := parseModTime(column(5))
+		if err != nil {
+			return nil, fmt.Errorf("修改时间格式错误: %v", err)
+		}
+		modTime = parsed
+	}
+	// 可选的第九列是导出时写出的死属性
+	props, err := decodeListProps(column(8))
+	if err != nil {
+		return nil, fmt.Errorf("属性格式错误: %v", err)
+	}
+	if isDir {
+		return &FileMeta{
 			Path:        path,
-			Size:        size,
 			DisplayName: displayName,
-			Content:     content,
-			IsDir:       false,
-			ModTime:     time.Now(),
-		}
-		fs.mu.Unlock()
-
-		dir := filepath.Dir(path)
-		if dir != "/" {
-			fs.mu.Lock()
-			if _, ok := fs.Files[dir]; !ok {
-				fs.Files[dir] = &FileMeta{
-					Path:        dir,
-					DisplayName: filepath.Base(dir),
-					IsDir:       true,
-					ModTime:     time.Now(),
-				}
-			}
-			fs.mu.Unlock()
+			IsDir:       true,
+			ModTime:     modTime,
+			Props:       props,
+		}, nil
+	}
+
+	// 可选的第四列是 base64 编码的文件内容(用于 .strm/.nfo 等小文件),
+	// 存在时文件大小以解码后的长度为准, 忽略 size 列. 内容为空的本地文件写 -
+	var size int64
+	var content []byte
+	if column(3) == emptyContent {
+		content = []byte{}
+	} else if column(3) != "" {
+		decoded, err := base64.StdEncoding.DecodeString(column(3))
+		if err != nil {
+			return nil, fmt.Errorf("内容格式错误: %v", err)
+		}
+		content = decoded
+		size = int64(len(content))
+	} else {
+		parsed, err := strconv.ParseInt(column(1), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("大小格式错误: %v", err)
 		}
+		if parsed < unknownSize {
+			return nil, fmt.Errorf("大小不能为负数, 不知道大小时写 -1")
+		}
+		size = parsed
+	}
 
-		fmt.Printf("加载文件: %s (%d bytes)\n", path, size)
+	// 可选的第七列是创建时间, 格式同修改时间, 没有时 creationdate 取修改时间
+	var created time.Time
+	if column(6) != "" {
+		parsed, err := parseModTime(column(6))
+		if err != nil {
+			return nil, fmt.Errorf("创建时间格式错误: %v", err)
+		}
+		created = parsed
 	}
 
-	return nil
+	return &FileMeta{
+		Path:        path,
+		Size:        size,
+		DisplayName: displayName,
+		Content:     content,
+		IsDir:       false,
+		ModTime:     modTime,
+		Created:     created,
+		ETag:        normalizeETag(column(4)),
+		URL:         unescapeField(column(7)),
+		Props:       props,
+	}, nil
+}
+
+// defaultModTime 是列表没有给出修改时间的条目使用的时间. 取进程启动时间而不是加载时间,
+// 重新加载后未变的条目的 ETag 和 getlastmodified 保持不变, 客户端不会重新下载
+func defaultModTime() time.Time {
+	return startTime
+}
+
+// parseModTime 接受 Unix 秒数或 RFC3339 格式的时间
+func parseModTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	// RFC3339 也接受导出时写出的纳秒
+	return time.Parse(time.RFC3339, s)
 }
 
 func (fs *TextWebDAVFileSystem) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 直接回答, 不把未认证的请求交给后面的处理函数
+		if fs.optionsNoAuth && r.Method == http.MethodOptions {
+			fs.serveOptions(w, r)
+			return
+		}
 		username, password, ok := r.BasicAuth()
 		if !ok {
 			w.Header().Set("WWW-Authenticate", `Basic realm="WebDAV"`)
@@ -176,106 +1029,157 @@ func (fs *TextWebDAVFileSystem) authMiddleware(next http.Handler) http.Handler {
 }
 
 func (fs *TextWebDAVFileSystem) HandlePropfind(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
+	// 客户端列出目录时通常带结尾的 /, 虚拟树中的键不带, 按子项的父目录比较前先去掉
+	path := fs.keyPath(r.URL.Path)
 	if path == "" {
 		path = "/"
 	}
 
+	_, span := startSpan(r.Context(), "vfs.PropFind")
+	span.SetPath("path", path)
+	defer span.End()
+
+	pf, err := parsePropfind(r)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	depth, ok := parseDepth(r.Header.Get("Depth"))
+	if !ok {
+		http.Error(w, "Depth 只能是 0、1 或 infinity", http.StatusBadRequest)
+		return
+	}
+	if depth == depthInfinity && !fs.propfindInfinity {
+		writeFiniteDepthError(w)
+		return
+	}
+
+	// 大小未知的文件先向上游查询, 查不到时不输出 getcontentlength
+	fs.resolveSizes(r.Context(), fs.unknownSizes(path))
+
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
-	_, ok := fs.Files[path]
+	_, ok = fs.Files[path]
 	if !ok && path != "/" {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
-	type Prop struct {
-		XMLName        xml.Name `xml:"D:prop"`
-		Displayname     *string `xml:"D:displayname,omitempty"`
-		Getcontenttype  *string `xml:"D:getcontenttype,omitempty"`
-		Getcontentlength *int64  `xml:"D:getcontentlength,omitempty"`
-		Getlastmodified *string `xml:"D:getlastmodified,omitempty"`
-		Resourcetype    *struct {
-			Collection *struct{} `xml:"D:collection,omitempty"`
-		} `xml:"D:resourcetype,omitempty"`
-	}
-
-	type Propstat struct {
-		Prop    Prop   `xml:"D:prop"`
-		Status  string `xml:"D:status"`
+	var entries []*FileMeta
+	switch {
+	case depth == 0:
+	case depth == 1:
+		entries = fs.childrenLocked(path)
+	default:
+		var complete bool
+		if entries, complete = fs.descendantsLocked(path, fs.propfindInfinityMax); !complete {
+			fmt.Printf("PROPFIND Depth: infinity 超过 %d 个条目, 拒绝: %s\n", fs.propfindInfinityMax, path)
+			writeFiniteDepthError(w)
+			return
+		}
 	}
 
-	type Response struct {
-		Href     string   `xml:"D:href"`
-		Propstat Propstat `xml:"D:propstat"`
+	// 目录的配额属性 (RFC 4331) 只在 allprop 或明确请求时返回
+	quota := func(dir string) (available, used *int64) {
+		if !pf.wants(quotaAvailableProp) && !pf.wants(quotaUsedProp) {
+			return nil, nil
+		}
+		u, a := fs.usage(dir)
+		if pf.wants(quotaAvailableProp) {
+			available = &a
+		}
+		if pf.wants(quotaUsedProp) {
+			used = &u
+		}
+		return available, used
 	}
 
-	responses := []Response{}
+	responses := []propfindResponse{}
 
 	if path == "/" || (ok && fs.Files[path].IsDir) {
 		displayName := "/"
 		modTime := time.Now()
+		creationDate := strPtr(defaultModTime().UTC().Format(time.RFC3339))
 		if path != "/" {
 			displayName = fs.Files[path].DisplayName
 			modTime = fs.Files[path].ModTime
+			creationDate = fs.Files[path].creationDate()
 		}
 
-		responses = append(responses, Response{
-			Href: path,
-			Propstat: Propstat{
-				Status: "HTTP/1.1 200 OK",
-				Prop: Prop{
-					Displayname:     &displayName,
-					Getlastmodified: strPtr(modTime.UTC().Format(http.TimeFormat)),
-					Resourcetype: &struct {
-						Collection *struct{} `xml:"D:collection,omitempty"`
-					}{
-						Collection: &struct{}{},
-					},
+		self := &FileMeta{Path: "/", IsDir: true}
+		var dead []webdav.Property
+		if path != "/" {
+			self = fs.Files[path]
+			dead = self.deadProps()
+		}
+		dead = fs.withSynthesized(pf, self, dead)
+		quotaAvailable, quotaUsed := quota(path)
+		supportedLock, lockDiscovery := fs.lockProps(r, path)
+
+		responses = append(responses, propfindResponse{
+			Href: hrefFor(r, path),
+			Propstats: pf.propstats(propfindProp{
+				Displayname:     &displayName,
+				Creationdate:    creationDate,
+				Getlastmodified: strPtr(httpDate(modTime)),
+				Resourcetype: &struct {
+					Collection *struct{} `xml:"D:collection,omitempty"`
+				}{
+					Collection: &struct{}{},
 				},
-			},
+				QuotaAvailable: quotaAvailable,
+				QuotaUsed:      quotaUsed,
+				Supportedlock:  supportedLock,
+				Lockdiscovery:  lockDiscovery,
+				Dead:           dead,
+			}),
 		})
 
-		for filePath, meta := range fs.Files {
-			if filepath.Dir(filePath) == path && filePath != path {
-				contentType := "application/octet-stream"
-				if strings.HasSuffix(filePath, ".txt") {
-					contentType = "text/plain"
-				} else if strings.HasSuffix(filePath, ".pdf") {
-					contentType = "application/pdf"
-				} else if strings.HasSuffix(filePath, ".mkv") {
-					contentType = "video/x-matroska"
-				}
-
-				var resourcetype *struct {
-					Collection *struct{} `xml:"D:collection,omitempty"`
-				}
-				if meta.IsDir {
-					resourcetype = &struct {
-						Collection *struct{} `xml:"D:collection,omitempty"`
-					}{
-						Collection: &struct{}{},
-					}
-				}
+		for _, meta := range entries {
+			filePath := meta.Path
+			contentType := "application/octet-stream"
+			if strings.HasSuffix(filePath, ".txt") {
+				contentType = "text/plain"
+			} else if strings.HasSuffix(filePath, ".pdf") {
+				contentType = "application/pdf"
+			} else if strings.HasSuffix(filePath, ".mkv") {
+				contentType = "video/x-matroska"
+			}
 
-				responses = append(responses, Response{
-					Href: filePath,
-					Propstat: Propstat{
-						Status: "HTTP/1.1 200 OK",
-						Prop: Prop{
-							Displayname:     &meta.DisplayName,
-							Getcontenttype:  &contentType,
-							Getcontentlength: &meta.Size,
-							Getlastmodified: strPtr(meta.ModTime.UTC().Format(http.TimeFormat)),
-							Resourcetype:    resourcetype,
-						},
-					},
-				})
+			// 文件的 resourcetype 为空元素, 按名请求时不应返回 404
+			resourcetype := &struct {
+				Collection *struct{} `xml:"D:collection,omitempty"`
+			}{}
+			var quotaAvailable, quotaUsed *int64
+			if meta.IsDir {
+				resourcetype.Collection = &struct{}{}
+				quotaAvailable, quotaUsed = quota(filePath)
 			}
+			supportedLock, lockDiscovery := fs.lockProps(r, filePath)
+
+			responses = append(responses, propfindResponse{
+				Href: hrefFor(r, filePath),
+				Propstats: pf.propstats(propfindProp{
+					Displayname:      &meta.DisplayName,
+					Getcontenttype:   &contentType,
+					Getcontentlength: meta.contentLength(),
+					Getetag:          optionalStr(meta.etag()),
+					Getcontentmd5:    optionalStr(meta.MD5),
+					Creationdate:     meta.creationDate(),
+					Getlastmodified:  strPtr(httpDate(meta.ModTime)),
+					Resourcetype:     resourcetype,
+					QuotaAvailable:   quotaAvailable,
+					QuotaUsed:        quotaUsed,
+					Supportedlock:    supportedLock,
+					Lockdiscovery:    lockDiscovery,
+					Dead:             fs.withSynthesized(pf, meta, meta.deadProps()),
+				}),
+			})
 		}
 	} else {
 		meta := fs.Files[path]
+		supportedLock, lockDiscovery := fs.lockProps(r, path)
 		contentType := "application/octet-stream"
 		if strings.HasSuffix(path, ".txt") {
 			contentType = "text/plain"
@@ -285,30 +1189,39 @@ func (fs *TextWebDAVFileSystem) HandlePropfind(w http.ResponseWriter, r *http.Re
 			contentType = "video/x-matroska"
 		}
 
-		responses = append(responses, Response{
-			Href: path,
-			Propstat: Propstat{
-				Status: "HTTP/1.1 200 OK",
-				Prop: Prop{
-					Displayname:     &meta.DisplayName,
-					Getcontenttype:  &contentType,
-					Getcontentlength: &meta.Size,
-					Getlastmodified: strPtr(meta.ModTime.UTC().Format(http.TimeFormat)),
-				},
-			},
+		responses = append(responses, propfindResponse{
+			Href: hrefFor(r, path),
+			Propstats: pf.propstats(propfindProp{
+				Displayname:      &meta.DisplayName,
+				Getcontenttype:   &contentType,
+				Getcontentlength: meta.contentLength(),
+				Getetag:          optionalStr(meta.etag()),
+				Getcontentmd5:    optionalStr(meta.MD5),
+				Creationdate:     meta.creationDate(),
+				Getlastmodified:  strPtr(httpDate(meta.ModTime)),
+				Resourcetype: &struct {
+					Collection *struct{} `xml:"D:collection,omitempty"`
+				}{},
+				Supportedlock: supportedLock,
+				Lockdiscovery: lockDiscovery,
+				Dead:          fs.withSynthesized(pf, meta, meta.deadProps()),
+			}),
 		})
 	}
 
+	span.SetInt("entries", int64(len(responses)))
+
 	multistatus := struct {
-		XMLName    xml.Name   `xml:"D:multistatus"`
-		XmlnsD     string     `xml:"xmlns:D,attr"`
-		Responses  []Response `xml:"D:response"`
+		XMLName   xml.Name           `xml:"D:multistatus"`
+		XmlnsD    string             `xml:"xmlns:D,attr"`
+		Responses []propfindResponse `xml:"D:response"`
 	}{
 		XmlnsD:    "DAV:",
 		Responses: responses,
 	}
 
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
 	w.WriteHeader(http.StatusMultiStatus)
 	xml.NewEncoder(w).Encode(multistatus)
 }
@@ -317,11 +1230,101 @@ func strPtr(s string) *string {
 	return &s
 }
 
+// stringList 是可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// byteSize 是字节数参数, 支持 KB/MB/GB/TB 后缀 (1024 进制) 和小数, 例如 1.5TB
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	mult := 1.0
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = strings.TrimSpace(num), u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("字节数格式错误: %q", s)
+	}
+	return int64(f * mult), nil
+}
+
+func optionalStr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (m *FileMeta) deadProps() []webdav.Property {
+	props := make([]webdav.Property, 0, len(m.Props))
+	for _, p := range m.Props {
+		props = append(props, p)
+	}
+	sort.Slice(props, func(i, j int) bool {
+		if props[i].XMLName.Space != props[j].XMLName.Space {
+			return props[i].XMLName.Space < props[j].XMLName.Space
+		}
+		return props[i].XMLName.Local < props[j].XMLName.Local
+	})
+	return append(props, webdav.Property{
+		XMLName:  propVersionProp,
+		InnerXML: []byte(strconv.FormatInt(m.PropVersion, 10)),
+	})
+}
+
 func (fs *TextWebDAVFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
-	fs.mu.RLock()
-	defer fs.mu.RUnlock()
+	name = fs.keyPath(name)
+	_, span := startSpan(ctx, "vfs.OpenFile")
+	span.SetPath("path", name)
+	span.SetInt("flag", int64(flag))
+	defer span.End()
+
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+	} else {
+		fs.mu.RLock()
+		defer fs.mu.RUnlock()
+	}
 
 	if name == "/" {
+		if writing {
+			return nil, os.ErrInvalid
+		}
 		return &VirtualFile{
 			meta: &FileMeta{
 				Path:        "/",
@@ -330,24 +1333,66 @@ func (fs *TextWebDAVFileSystem) OpenFile(ctx context.Context, name string, flag
 				IsDir:       true,
 				ModTime:     time.Now(),
 			},
-			fs: fs,
+			path: "/",
+			fs:   fs,
 		}, nil
 	}
 
 	meta, ok := fs.Files[name]
 	if !ok {
-		return nil, os.ErrNotExist
+		if flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		created, err := fs.createLocked(name)
+		if err != nil {
+			return nil, err
+		}
+		fs.recordMutation(JournalRecord{Op: JournalCreate, Path: name})
+		meta = created
+	}
+	if meta.IsDir && writing {
+		return nil, os.ErrInvalid
 	}
 
-	return &VirtualFile{
-		meta:  meta,
-		pos:   0,
-		fs:    fs,
-		flags: flag,
-	}, nil
+	f := &VirtualFile{
+		meta:       meta,
+		path:       name,
+		pos:        0,
+		fs:         fs,
+		flags:      flag,
+		ctx:        ctx,
+		created:    flag&os.O_CREATE != 0 && !ok,
+		appendMode: flag&os.O_APPEND != 0,
+		truncated:  flag&os.O_TRUNC != 0,
+	}
+	if writing {
+		f.hasher = newContentHasher()
+	}
+	// 可写目录下的本地内容立即清空, 只有上游地址的文件由上传完成时的新条目整体替换;
+	// 写入内存的文件在句柄上从空内容开始, 关闭时才生效. 不能写入的文件保持原样, 随后的 Write 会失败
+	if ok && f.truncated && meta.Content != nil {
+		switch {
+		case fs.writable(name):
+			fs.truncateLocked(name)
+			fs.recordMutation(JournalRecord{Op: JournalTruncate, Path: name})
+		case fs.inMemory(meta):
+			f.buf, f.memory = []byte{}, true
+		}
+	}
+	f.content, f.length = meta.Content, meta.Size
+	if f.appendMode {
+		f.pos = f.size()
+	}
+	return f, nil
 }
 
 func (fs *TextWebDAVFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = fs.keyPath(name)
+	_, span := startSpan(ctx, "vfs.Stat")
+	span.SetPath("path", name)
+	defer span.End()
+
+	fs.resolveSize(ctx, name)
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
@@ -372,43 +1417,320 @@ func (fs *TextWebDAVFileSystem) Stat(ctx context.Context, name string) (os.FileI
 		path:    meta.Path,
 		isDir:   meta.IsDir,
 		modTime: meta.ModTime,
+		etag:    meta.etag(),
 	}, nil
 }
 
 func (fs *TextWebDAVFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
-	return os.ErrPermission
+	name = fs.keyPath(name)
+	_, span := startSpan(ctx, "vfs.Mkdir")
+	span.SetPath("path", name)
+	defer span.End()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.mkdirLocked(name); err != nil {
+		return err
+	}
+	fs.recordMutation(JournalRecord{Op: JournalMkdir, Path: name})
+	return nil
 }
 
 func (fs *TextWebDAVFileSystem) RemoveAll(ctx context.Context, name string) error {
-	return os.ErrPermission
+	name = fs.keyPath(name)
+	_, span := startSpan(ctx, "vfs.RemoveAll")
+	span.SetPath("path", name)
+	defer span.End()
+
+	if fs.protectedPath(name) {
+		return os.ErrPermission
+	}
+	fs.mu.RLock()
+	meta, ok := fs.Files[name]
+	fs.mu.RUnlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+	// 可写目录下先删除上游的文件, 成功后才改动目录树
+	failed := fs.removeTree(ctx, name, meta.IsDir)
+	if len(failed) == 0 {
+		return nil
+	}
+	if err, ok := failed[name]; ok {
+		notePropagation(ctx, err)
+		return err
+	}
+	notePartialRemove(ctx, failed)
+	return errPartialRemove
 }
 
 func (fs *TextWebDAVFileSystem) Rename(ctx context.Context, oldName, newName string) error {
-	return os.ErrPermission
+	oldName, newName = fs.keyPath(oldName), fs.keyPath(newName)
+	_, span := startSpan(ctx, "vfs.Rename")
+	span.SetPath("path", oldName)
+	span.SetPath("destination", newName)
+	defer span.End()
+
+	// 可写目录下会先移动上游的文件, 目标不合法时不能等到修改目录树才发现
+	fs.mu.RLock()
+	meta := fs.Files[oldName]
+	err := fs.checkRenameLocked(oldName, newName)
+	fs.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	moved, err := fs.propagateRename(ctx, oldName, newName, meta.IsDir)
+	if err != nil {
+		notePropagation(ctx, err)
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.renameLocked(oldName, newName); err != nil {
+		return err
+	}
+	fs.recordMutation(JournalRecord{Op: JournalRename, Path: oldName, To: newName})
+	if moved {
+		for _, rec := range fs.rebaseUploadsLocked(oldName, newName) {
+			fs.recordMutation(rec)
+		}
+	}
+	return nil
+}
+
+// checkParentLocked 确认 name 的上级目录存在. RFC 4918 要求上级目录不存在时 MKCOL 和 PUT 返回 409,
+// webdav.Handler 按 os.ErrNotExist 映射; 上级是文件时返回 ENOTDIR. 不检查时新条目不会出现在任何列表中
+func (fs *TextWebDAVFileSystem) checkParentLocked(op, name string) error {
+	parent := filepath.Dir(name)
+	if parent == "/" {
+		return nil
+	}
+	meta, ok := fs.Files[parent]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if !meta.IsDir {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+func (fs *TextWebDAVFileSystem) mkdirLocked(name string) error {
+	if name == "/" {
+		return os.ErrExist
+	}
+	if _, ok := fs.Files[name]; ok {
+		return os.ErrExist
+	}
+	if err := fs.checkParentLocked("mkdir", name); err != nil {
+		return err
+	}
+
+	fs.setEntryLocked(name, &FileMeta{
+		Path:        name,
+		DisplayName: filepath.Base(name),
+		IsDir:       true,
+		ModTime:     time.Now(),
+	})
+	return nil
+}
+
+func (fs *TextWebDAVFileSystem) createLocked(name string) (*FileMeta, error) {
+	if name == "/" {
+		return nil, os.ErrExist
+	}
+	if _, ok := fs.Files[name]; ok {
+		return nil, os.ErrExist
+	}
+	if err := fs.checkParentLocked("open", name); err != nil {
+		return nil, err
+	}
+
+	meta := &FileMeta{
+		Path:        name,
+		DisplayName: filepath.Base(name),
+		Content:     []byte{},
+		ModTime:     time.Now(),
+	}
+	fs.setEntryLocked(name, meta)
+	return meta, nil
+}
+
+// truncateLocked 清空本地内容文件, 条目的校验和与 ETag 随之失效
+func (fs *TextWebDAVFileSystem) truncateLocked(name string) error {
+	meta, ok := fs.Files[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if meta.IsDir {
+		return os.ErrInvalid
+	}
+	meta.Content = []byte{}
+	meta.Size = 0
+	meta.ETag = ""
+	meta.MD5, meta.SHA1 = "", ""
+	meta.ModTime = time.Now()
+	return nil
+}
+
+func (fs *TextWebDAVFileSystem) removeAllLocked(name string) error {
+	if fs.protectedPath(name) {
+		return os.ErrPermission
+	}
+	if _, ok := fs.Files[name]; !ok {
+		return os.ErrNotExist
+	}
+
+	// 按 name+"/" 匹配子项, 删除 /a 不会误删 /ab 下的条目
+	for path := range fs.Files {
+		if path == name || strings.HasPrefix(path, name+"/") {
+			fs.deleteEntryLocked(path)
+		}
+	}
+	return nil
+}
+
+// checkRenameLocked 校验 oldName 能否移动到 newName. 目标已存在时返回 os.ErrExist,
+// 覆盖由 webdav.Handler 按 Overwrite 头先删除目标再移动, 不在这里静默替换
+func (fs *TextWebDAVFileSystem) checkRenameLocked(oldName, newName string) error {
+	if _, ok := fs.Files[oldName]; !ok {
+		return os.ErrNotExist
+	}
+	// 目录不能移动到自己下面, 否则整棵子树会从目录树中脱离
+	if oldName == "/" || newName == "/" || newName == oldName || strings.HasPrefix(newName, oldName+"/") {
+		return errBadTarget
+	}
+	if _, ok := fs.Files[newName]; ok {
+		return os.ErrExist
+	}
+	if parent := filepath.Dir(newName); parent != "/" {
+		if meta, ok := fs.Files[parent]; !ok || !meta.IsDir {
+			return errNoParent
+		}
+	}
+	return nil
+}
+
+func (fs *TextWebDAVFileSystem) renameLocked(oldName, newName string) error {
+	if err := fs.checkRenameLocked(oldName, newName); err != nil {
+		return err
+	}
+	root := fs.Files[oldName]
+	// 只有被移动的条目本身按策略处理显示名, 子项的文件名不变. displayname 是属性之一,
+	// 改变时属性版本随之增加, 按旧版本发出的 PROPPATCH 会得到 412
+	if name := fs.renamedDisplayName(root, newName); name != root.DisplayName {
+		root.DisplayName = name
+		root.PropVersion++
+	}
+
+	moved := make(map[string]*FileMeta)
+	for path, meta := range fs.Files {
+		if path == oldName || strings.HasPrefix(path, oldName+"/") {
+			moved[newName+strings.TrimPrefix(path, oldName)] = meta
+			fs.deleteEntryLocked(path)
+		}
+	}
+	for path, meta := range moved {
+		meta.Path = path
+		fs.setEntryLocked(path, meta)
+	}
+	return nil
 }
 
 func (f *VirtualFile) Close() error {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.memory {
+		return f.finishMemoryWrite()
+	}
+	// 可写目录下新建或清空的文件没有写入内容时, 也要在上游创建空文件
+	if (f.created || f.truncated) && f.upload == nil && f.writeErr == nil {
+		if _, _, ok := f.fs.uploads.For(f.path); ok {
+			f.upload, f.writeErr = f.fs.startUpload(f.ctx, f.path)
+		}
+	}
+	if f.upload != nil || f.writeErr != nil {
+		return f.finishWrite()
+	}
 	return nil
 }
 
+// size 返回文件内容的长度, 有本地内容时以内容为准, 否则是列表声明的大小
+func (f *VirtualFile) size() int64 {
+	if f.buf != nil {
+		return int64(len(f.buf))
+	}
+	if f.content != nil {
+		return int64(len(f.content))
+	}
+	return f.length
+}
+
+// Read 读取本地内容, 没有本地内容的条目从上游读取
 func (f *VirtualFile) Read(p []byte) (int, error) {
 	if f.meta.IsDir {
 		return 0, io.EOF
 	}
-	if f.pos >= int64(len(f.meta.Content)) {
+	// 新建后写入内存的文件只有 buf
+	if f.content == nil && f.buf == nil {
+		return f.readUpstream(p)
+	}
+	data := f.content
+	if f.buf != nil {
+		data = f.buf
+	}
+	if f.pos >= int64(len(data)) {
 		return 0, io.EOF
 	}
-	n := copy(p, f.meta.Content[f.pos:])
+	n := copy(p, data[f.pos:])
 	f.pos += int64(n)
 	return n, nil
 }
 
+// Write 把内容转发给可写目录的上游, 其它目录不能写入内容
 func (f *VirtualFile) Write(p []byte) (int, error) {
-	return 0, os.ErrPermission
+	if f.appendMode {
+		// 上传会整体替换上游的文件, 不能在已有内容后追加
+		if !f.created && !f.truncated && f.content == nil && f.size() > 0 {
+			return 0, os.ErrInvalid
+		}
+		f.pos = f.size()
+	}
+	f.fs.mu.RLock()
+	memory := f.fs.inMemory(f.meta)
+	f.fs.mu.RUnlock()
+	if memory {
+		return f.writeMemory(p)
+	}
+	if f.upload == nil && f.writeErr == nil {
+		f.upload, f.writeErr = f.fs.startUpload(f.ctx, f.path)
+	}
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	// 上传整体替换上游的文件, 送进管道的数据就是新的内容
+	w := io.Writer(f.upload)
+	if f.hasher != nil {
+		w = io.MultiWriter(f.upload, f.hasher)
+	}
+	n, err := w.Write(p)
+	if err != nil {
+		f.writeErr = err
+	}
+	return n, err
 }
 
 func (f *VirtualFile) Seek(offset int64, whence int) (int64, error) {
 	if f.meta.IsDir {
+		// 回到开头时重新读取子项
+		if offset == 0 && whence == io.SeekStart {
+			f.dirEntries = nil
+		}
 		return 0, nil
 	}
 	var newPos int64
@@ -418,45 +1740,82 @@ func (f *VirtualFile) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		newPos = f.pos + offset
 	case io.SeekEnd:
-		newPos = int64(len(f.meta.Content)) + offset
+		size := f.size()
+		if size < 0 {
+			// 大小未知时找不到结尾
+			return 0, &os.PathError{Op: "seek", Path: f.path, Err: errors.ErrUnsupported}
+		}
+		newPos = size + offset
 	default:
-		return 0, fmt.Errorf("invalid whence")
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: os.ErrInvalid}
 	}
 
+	// 与 os.File 一样可以移到结尾之后, 之后的 Read 返回 io.EOF. 负数 (包括相加溢出)
+	// 报错, 位置保持不变
 	if newPos < 0 {
-		return 0, fmt.Errorf("negative position")
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: os.ErrInvalid}
 	}
 
+	// 之后的写入会改写已经算进校验和的内容
+	if newPos < f.pos {
+		f.hasher = nil
+	}
 	f.pos = newPos
 	return f.pos, nil
 }
 
+// Readdir 与 os.File 相同: count 大于 0 时每次接着上一次返回最多 count 个子项,
+// 没有剩余时返回 io.EOF; count 不大于 0 时返回剩下的全部子项. 子项在第一次调用时
+// 按路径排序固定下来, Seek 到开头后重新读取
 func (f *VirtualFile) Readdir(count int) ([]os.FileInfo, error) {
 	if !f.meta.IsDir {
 		return nil, os.ErrInvalid
 	}
+	if f.dirEntries == nil {
+		f.dirEntries = f.readChildren()
+		f.dirPos = 0
+	}
+
+	rest := f.dirEntries[f.dirPos:]
+	if count <= 0 {
+		f.dirPos = len(f.dirEntries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.dirPos += count
+	return rest[:count], nil
+}
+
+func (f *VirtualFile) readChildren() []os.FileInfo {
+	if f.ctx != nil {
+		f.fs.populate(f.ctx, f.path, true)
+	}
 
-	var children []os.FileInfo
 	f.fs.mu.RLock()
 	defer f.fs.mu.RUnlock()
 
-	for path, meta := range f.fs.Files {
-		if filepath.Dir(path) == f.meta.Path && path != f.meta.Path {
-			children = append(children, &VirtualFileInfo{
-				name:    meta.DisplayName,
-				size:    meta.Size,
-				path:    meta.Path,
-				isDir:   meta.IsDir,
-				modTime: meta.ModTime,
-			})
-		}
+	entries := f.fs.childrenLocked(f.path)
+	children := make([]os.FileInfo, 0, len(entries))
+	for _, meta := range entries {
+		children = append(children, &VirtualFileInfo{
+			name:    meta.DisplayName,
+			size:    meta.Size,
+			path:    meta.Path,
+			isDir:   meta.IsDir,
+			modTime: meta.ModTime,
+			etag:    meta.etag(),
+		})
 	}
-
-	return children, nil
+	return children
 }
 
 func (f *VirtualFile) Stat() (os.FileInfo, error) {
-	return f.fs.Stat(context.Background(), f.meta.Path)
+	return f.fs.Stat(context.Background(), f.path)
 }
 
 func (fi *VirtualFileInfo) Name() string       { return fi.name }
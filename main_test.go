@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// testModTime 是测试列表和假上游使用的固定修改时间
+var testModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newTestFS 按 main 中的默认参数构造文件系统, 只缩短重试等待, list 非空时按列表格式加载
+func newTestFS(t testing.TB, list string) *TextWebDAVFileSystem {
+	t.Helper()
+	opts := defaultFileSystemOptions
+	opts.RetryBackoff = time.Millisecond
+	opts.ResumeAttempts = 3
+	fs, err := newFileSystem(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Auth["1"] = "1"
+	if list != "" {
+		if _, err := fs.LoadFromText(list); err != nil {
+			t.Fatalf("LoadFromText: %v", err)
+		}
+	}
+	return fs
+}
+
+// mountSource 是只有挂载点的远端源, 用于验证挂载点不能被删除或覆盖
+type mountSource string
+
+func (s mountSource) Crawl(ctx context.Context, fs *TextWebDAVFileSystem) error { return nil }
+func (s mountSource) Mount() string                                             { return string(s) }
+
+// listGenerator 逐行生成 n 行列表, 不在内存中保留整个列表
+type listGenerator struct {
+	n, i int
+	buf  []byte
+}
+
+func (g *listGenerator) Read(p []byte) (int, error) {
+	for len(g.buf) < len(p) && g.i < g.n {
+		g.buf = fmt.Appendf(g.buf, "/movies/%03d/file%07d.mkv#%d#电影%07d.mkv\n", g.i%1000, g.i, 1<<30+g.i, g.i)
+		g.i++
+	}
+	if len(g.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, g.buf)
+	g.buf = g.buf[n:]
+	return n, nil
+}
+
+// quietStdout 在 f 执行期间丢弃逐条加载的日志
+func quietStdout(tb testing.TB, f func()) {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}()
+	f()
+}
+
+// peakHeap 在 f 执行期间采样堆内存, 返回比执行前多出的峰值
+func peakHeap(f func()) uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	base, peak := m.HeapAlloc, m.HeapAlloc
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > peak {
+				peak = m.HeapAlloc
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+	f()
+	close(done)
+	<-sampled
+	return peak - base
+}
+
+// BenchmarkLoadMillionLines 比较流式读取生成的一百万行列表与先把整个列表读进字符串再加载的
+// 峰值堆内存 (peak-MB), 后者是原来 strings.Split 实现至少要付出的代价
+func BenchmarkLoadMillionLines(b *testing.B) {
+	const lines = 1000000
+	b.Run("reader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fs := newTestFS(b, "")
+			var peak uint64
+			quietStdout(b, func() {
+				peak = peakHeap(func() {
+					if _, err := fs.LoadFromReader(&listGenerator{n: lines}); err != nil {
+						b.Fatal(err)
+					}
+				})
+			})
+			b.ReportMetric(float64(peak)/(1<<20), "peak-MB")
+		}
+	})
+	b.Run("whole-text", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fs := newTestFS(b, "")
+			var peak uint64
+			quietStdout(b, func() {
+				peak = peakHeap(func() {
+					text, err := io.ReadAll(&listGenerator{n: lines})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := fs.LoadFromText(string(text)); err != nil {
+						b.Fatal(err)
+					}
+				})
+			})
+			b.ReportMetric(float64(peak)/(1<<20), "peak-MB")
+		}
+	})
+}
+
+func davMove(fs *TextWebDAVFileSystem, from, to, overwrite string) int {
+	h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	r := httptest.NewRequest("MOVE", from, nil)
+	r.Header.Set("Destination", "http://example.com"+to)
+	if overwrite != "" {
+		r.Header.Set("Overwrite", overwrite)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRenameFileOverFile(t *testing.T) {
+	const list = "/a.mkv#1#a.mkv\n/b.mkv#2#b.mkv\n"
+	fs := newTestFS(t, list)
+	if err := fs.Rename(context.Background(), "/a.mkv", "/b.mkv"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Rename onto an existing file: %v, want os.ErrExist", err)
+	}
+	if code := davMove(fs, "/a.mkv", "/b.mkv", "F"); code != http.StatusPreconditionFailed {
+		t.Errorf("MOVE with Overwrite: F: status %d, want 412", code)
+	}
+	if fs.Files["/a.mkv"] == nil || fs.Files["/b.mkv"].Size != 2 {
+		t.Fatal("a refused MOVE changed the tree")
+	}
+
+	if code := davMove(fs, "/a.mkv", "/b.mkv", "T"); code != http.StatusNoContent {
+		t.Errorf("MOVE with Overwrite: T: status %d, want 204", code)
+	}
+	if fs.Files["/a.mkv"] != nil || fs.Files["/b.mkv"] == nil || fs.Files["/b.mkv"].Size != 1 || fs.Files["/b.mkv"].DisplayName != "b.mkv" {
+		t.Errorf("after overwrite: %+v", fs.Files["/b.mkv"])
+	}
+}
+
+func TestRenameDirOverDir(t *testing.T) {
+	fs := newTestFS(t, "/src/a.mkv#1#a.mkv\n/src/sub/b.mkv#1#b.mkv\n/dst/old.mkv#1#old.mkv\n/dst/deep/c.mkv#1#c.mkv\n")
+	if code := davMove(fs, "/src", "/dst", "F"); code != http.StatusPreconditionFailed {
+		t.Errorf("MOVE with Overwrite: F: status %d, want 412", code)
+	}
+	if code := davMove(fs, "/src", "/dst", "T"); code != http.StatusNoContent {
+		t.Fatalf("MOVE with Overwrite: T: status %d, want 204", code)
+	}
+	want := map[string]bool{"/dst": true, "/dst/a.mkv": true, "/dst/sub": true, "/dst/sub/b.mkv": true}
+	for name, meta := range fs.Files {
+		if !want[name] {
+			t.Errorf("unexpected entry %s after replacing the destination subtree", name)
+		} else if meta.Path != name {
+			t.Errorf("%s: meta.Path = %q", name, meta.Path)
+		}
+	}
+	if len(fs.Files) != len(want) {
+		t.Errorf("%d entries, want %d", len(fs.Files), len(want))
+	}
+	if names := fs.childPathsLocked("/dst"); len(names) != 2 {
+		t.Errorf("children of /dst = %v", names)
+	}
+}
+
+func TestRenameDirIntoOwnChild(t *testing.T) {
+	fs := newTestFS(t, "/a/b/c.mkv#1#c.mkv\n")
+	for _, to := range []string{"/a/b/a", "/a/x", "/a"} {
+		if err := fs.Rename(context.Background(), "/a", to); err != errBadTarget {
+			t.Errorf("Rename(/a, %s) = %v, want errBadTarget", to, err)
+		}
+	}
+	if code := davMove(fs, "/a", "/a/b/a", "T"); code < 400 {
+		t.Errorf("MOVE into its own child: status %d", code)
+	}
+	if fs.Files["/a/b/c.mkv"] == nil || len(fs.Files) != 3 {
+		t.Errorf("the tree changed: %v", fs.Files)
+	}
+}
+
+// 在 -race 下运行: PROPFIND、GET 的元数据读取与 MOVE、PROPPATCH、重新加载同时进行
+func TestConcurrentPropfindAndMove(t *testing.T) {
+	var list string
+	for i := 0; i < 50; i++ {
+		list += fmt.Sprintf("/d/f%02d.mkv#%d#f%02d.mkv\n", i, i+1, i)
+	}
+	// 大小未知、要向上游查询的文件和写入内存的文件, 在打开的句柄上读写时被移动
+	list += "/d/u.mkv#-1#u.mkv\n/d/n.txt#0#n.txt#-\n"
+	fs := newTestFS(t, list)
+	withBackend(t, fs, rangedUpstream(t, []byte("0123456789")))
+	fs.listSource = filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(fs.listSource, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				f(i)
+			}
+		}()
+	}
+	quietStdout(t, func() {
+		run(func(i int) {
+			if w := propfind(fs, "/d", "1", ""); w.Code != http.StatusMultiStatus {
+				t.Errorf("PROPFIND: status %d", w.Code)
+			}
+		})
+		run(func(i int) {
+			for _, name := range []string{"/d/f00.mkv", "/d/u.mkv", "/d/n.txt"} {
+				from, to := name, name+".moved"
+				if i%2 == 1 {
+					from, to = to, from
+				}
+				fs.Rename(context.Background(), from, to)
+			}
+		})
+		run(func(i int) {
+			ctx := context.Background()
+			for _, name := range []string{"/d/f00.mkv", "/d/f00.mkv.moved", "/d/u.mkv", "/d/u.mkv.moved"} {
+				f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+				if err != nil {
+					continue
+				}
+				f.Stat()
+				f.Read(make([]byte, 4))
+				f.Seek(-1, io.SeekStart)
+				f.Close()
+			}
+			for _, name := range []string{"/d/n.txt", "/d/n.txt.moved"} {
+				f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_TRUNC, 0o644)
+				if err != nil {
+					continue
+				}
+				f.Write([]byte(strconv.Itoa(i)))
+				f.Seek(-1, io.SeekStart)
+				f.Close()
+			}
+		})
+		run(func(i int) {
+			proppatch(fs, fmt.Sprintf("/d/f%02d.mkv", 1+i%10), propertyUpdate(`<D:set><D:prop><D:displayname>名字`+strconv.Itoa(i)+`</D:displayname></D:prop></D:set>`))
+		})
+		run(func(i int) {
+			if fi, err := fs.Stat(context.Background(), "/d/f20.mkv"); err == nil {
+				fi.Size()
+				fi.ModTime()
+			}
+			if f, err := fs.OpenFile(context.Background(), "/d", os.O_RDONLY, 0); err == nil {
+				f.Readdir(-1)
+				f.Close()
+			}
+		})
+		run(func(i int) {
+			if i%10 == 0 {
+				if _, err := fs.Reload(); err != nil {
+					t.Errorf("Reload: %v", err)
+				}
+			}
+		})
+		wg.Wait()
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// errFileTooLarge 是写入内存的文件超过 -max-memory-file 时的错误
+var errFileTooLarge = errors.New("文件超过内存写入上限")
+
+// inMemory 报告写入 meta 的内容是否保存在内存中: 不在可写目录下、没有上游地址的文件,
+// 即客户端新建的文件和列表中带内容的文件. -max-memory-file 为 0 时不允许写入
+func (fs *TextWebDAVFileSystem) inMemory(meta *FileMeta) bool {
+	if fs.maxMemoryFile <= 0 || meta.IsDir || meta.Content == nil {
+		return false
+	}
+	_, _, upload := fs.uploads.For(meta.Path)
+	return !upload
+}
+
+// writeMemory 把 p 写到句柄缓冲区的当前位置. 第一次写入时复制现有内容, 关闭时才替换条目的内容,
+// 写入失败时条目保持原样, 读取同一文件的其它请求不会看到写了一半的内容
+func (f *VirtualFile) writeMemory(p []byte) (int, error) {
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	f.memory = true
+	end := f.pos + int64(len(p))
+	if end > f.fs.maxMemoryFile {
+		f.writeErr = errFileTooLarge
+		return 0, f.writeErr
+	}
+	if f.hasher != nil && f.pos != f.hashed {
+		f.hasher = nil
+	}
+	if f.buf == nil {
+		f.buf = bytes.Clone(f.content)
+	}
+	if end > int64(len(f.buf)) {
+		f.buf = append(f.buf, make([]byte, end-int64(len(f.buf)))...)
+	}
+	copy(f.buf[f.pos:], p)
+	f.pos = end
+	if f.hasher != nil {
+		f.hasher.Write(p)
+		f.hashed = end
+	}
+	return len(p), nil
+}
+
+// finishMemoryWrite 在关闭时提交写入内存的内容. 写入失败时删除这次新建的条目
+func (f *VirtualFile) finishMemoryWrite() error {
+	fs := f.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// 条目可能在写入期间被移动, 在锁内取它当前的路径
+	name := f.meta.Path
+	cur, ok := fs.Files[name]
+	if f.writeErr != nil {
+		if ok && cur == f.meta && f.created {
+			fs.removeAllLocked(name)
+			fs.recordMutation(JournalRecord{Op: JournalDelete, Path: name})
+		}
+		fmt.Printf("写入 %s 失败: %v\n", name, f.writeErr)
+		return f.writeErr
+	}
+	// 写入期间条目被删除或替换时丢弃这次写入
+	if !ok || cur != f.meta {
+		return os.ErrNotExist
+	}
+	fs.setContentLocked(name, f.buf)
+	// 只有从空内容开始连续写满整个文件时, 边写边算的校验和才对应最终的内容
+	if f.hasher != nil && f.hashed == int64(len(f.buf)) {
+		cur.MD5, cur.SHA1 = f.hasher.Sums()
+	}
+	fs.recordMutation(JournalRecord{Op: JournalContent, Path: name, Content: f.buf})
+	return nil
+}
+
+// setContentLocked 替换文件的本地内容, 大小随之改变, 校验和与 ETag 失效
+func (fs *TextWebDAVFileSystem) setContentLocked(name string, data []byte) error {
+	meta, ok := fs.Files[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if meta.IsDir {
+		return os.ErrInvalid
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	meta.Content = data
+	meta.Size = int64(len(data))
+	meta.ETag = ""
+	meta.MD5, meta.SHA1 = "", ""
+	meta.ModTime = time.Now()
+	return nil
+}
+
+// rejectOversizedPut 在声明的长度已经超过上限时直接返回 413, 不必收完请求体才失败
+func (fs *TextWebDAVFileSystem) rejectOversizedPut(w http.ResponseWriter, r *http.Request) bool {
+	if fs.maxMemoryFile <= 0 || r.ContentLength <= fs.maxMemoryFile {
+		return false
+	}
+	if _, _, upload := fs.uploads.For(fs.normPath(r.URL.Path)); upload {
+		return false
+	}
+	http.Error(w, errFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	return true
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 目标中记录上次导出结果的文件, 用来做增量导出
+const mirrorStateFile = ".mirror-state.json"
+
+// mirrorTarget 是静态镜像的存放位置
+type mirrorTarget interface {
+	Get(name string) ([]byte, error)
+	Put(name string, data []byte) error
+	Delete(name string) error
+	String() string
+}
+
+// Mirror 定期把目录树导出成静态镜像: 每个目录一个 index.html, 每个文件一个指向
+// 上游地址的 .strm, 带本地内容的小文件原样写出, 外加 manifest.json.
+// 代理不可用时可以直接用任意静态文件服务器提供这份镜像
+type Mirror struct {
+	fs     *TextWebDAVFileSystem
+	target mirrorTarget
+
+	mu     sync.Mutex
+	hashes map[string]string // 上次导出的文件 -> 内容哈希
+	loaded bool
+	status MirrorStatus
+}
+
+type MirrorStatus struct {
+	Target    string    `json:"target"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Written   int       `json:"written"`
+	Deleted   int       `json:"deleted"`
+	Unchanged int       `json:"unchanged"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+}
+
+// NewMirror 根据目标地址创建镜像: http(s):// 开头的上传到 WebDAV, 其余视为本地目录
+func NewMirror(fs *TextWebDAVFileSystem, dest, user, pass string) (*Mirror, error) {
+	var target mirrorTarget
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		u, err := url.Parse(strings.TrimSuffix(dest, "/"))
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("镜像地址无效: %s", redactURL(dest))
+		}
+		fs.addUpstreamCredential(u.String(), user, pass)
+		target = &davMirrorTarget{fs: fs, base: u, client: &http.Client{Timeout: time.Minute}, dirs: make(map[string]bool)}
+	} else if strings.HasPrefix(dest, "sftp://") {
+		return nil, fmt.Errorf("暂不支持 SFTP 镜像, 可以导出到本地目录后用 rsync 同步")
+	} else {
+		target = localMirrorTarget(dest)
+	}
+	return &Mirror{fs: fs, target: target, status: MirrorStatus{Target: target.String()}}, nil
+}
+
+// Run 执行一次增量导出. 只写出内容有变化的文件, 删除上次导出过而这次没有的文件
+func (m *Mirror) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := time.Now()
+	status := MirrorStatus{Target: m.target.String(), LastRun: start}
+	err := m.runLocked(&status)
+	status.ElapsedMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		m.fs.emitEvent("mirror-failed", "", err.Error())
+	} else {
+		status.OK = true
+		fmt.Printf("静态镜像导出完成: 写入 %d, 删除 %d, 未变化 %d, 用时 %dms\n",
+			status.Written, status.Deleted, status.Unchanged, status.ElapsedMs)
+	}
+	m.status = status
+	return err
+}
+
+func (m *Mirror) runLocked(status *MirrorStatus) error {
+	if !m.loaded {
+		m.hashes = make(map[string]string)
+		if data, err := m.target.Get(mirrorStateFile); err == nil {
+			json.Unmarshal(data, &m.hashes)
+		}
+		m.loaded = true
+	}
+
+	next := make(map[string]string, len(m.hashes))
+	write := func(name string, data []byte) error {
+		sum := sha1.Sum(data)
+		hash := hex.EncodeToString(sum[:])
+		next[name] = hash
+		if m.hashes[name] == hash {
+			status.Unchanged++
+			return nil
+		}
+		if err := m.target.Put(name, data); err != nil {
+			return fmt.Errorf("写入 %s 失败: %v", name, err)
+		}
+		status.Written++
+		return nil
+	}
+
+	if err := m.fs.buildMirror(write); err != nil {
+		return err
+	}
+
+	// 删除时先删文件再删目录, 按路径倒序即可保证子项在前
+	var stale []string
+	for name := range m.hashes {
+		if _, ok := next[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(stale)))
+	for _, name := range stale {
+		if err := m.target.Delete(name); err != nil {
+			// 没删掉的保留在状态中, 下次继续尝试
+			next[name] = m.hashes[name]
+			fmt.Printf("删除镜像文件 %s 失败: %v\n", name, err)
+			continue
+		}
+		status.Deleted++
+	}
+
+	m.hashes = next
+	state, _ := json.Marshal(m.hashes)
+	return m.target.Put(mirrorStateFile, state)
+}
+
+func (m *Mirror) Status() MirrorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// loop 按间隔重复导出, 启动时先导出一次
+func (m *Mirror) loop(interval time.Duration) {
+	for {
+		if err := m.Run(); err != nil {
+			fmt.Printf("静态镜像导出失败: %v\n", err)
+		}
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+type mirrorManifestEntry struct {
+	Path        string `json:"path"`
+	DisplayName string `json:"display_name"`
+	Dir         bool   `json:"dir,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	ModTime     int64  `json:"mtime"`
+	URL         string `json:"url,omitempty"`
+}
+
+type mirrorIndexItem struct {
+	Name        string
+	Href        string
+	DisplayName string
+	Dir         bool
+	Size        int64
+}
+
+var mirrorIndexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Dir}}</title></head>
+<body>
+<h1>{{.Dir}}</h1>
+<ul>
+{{if ne .Dir "/"}}<li><a href="../index.html">..</a></li>
+{{end}}{{range .Items}}<li><a href="{{.Href}}">{{.DisplayName}}</a>{{if not .Dir}} ({{bytes .Size}}){{end}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// buildMirror 基于 walkTree 的快照生成镜像中的全部文件, 每生成一个就交给 write
+func (fs *TextWebDAVFileSystem) buildMirror(write func(name string, data []byte) error) error {
+	entries := fs.walkTree()
+
+	children := make(map[string][]mirrorIndexItem)
+	children["/"] = nil
+	manifest := make([]mirrorManifestEntry, 0, len(entries))
+	for _, e := range entries {
+		parent := path.Dir(e.Path)
+		item := mirrorIndexItem{Name: path.Base(e.Path), DisplayName: e.DisplayName, Dir: e.IsDir, Size: e.Size}
+		me := mirrorManifestEntry{Path: e.Path, DisplayName: e.DisplayName, Dir: e.IsDir, ModTime: e.ModTime.Unix()}
+
+		if e.IsDir {
+			item.Href = url.PathEscape(item.Name) + "/index.html"
+			if _, ok := children[e.Path]; !ok {
+				children[e.Path] = nil
+			}
+		} else {
+			me.Size = e.Size
+			me.ETag = e.etag()
+			me.URL = fs.upstreamURL(&e.FileMeta)
+
+			name := strings.TrimPrefix(e.Path, "/")
+			switch {
+			case e.Content != nil:
+				// .nfo/.strm 等带本地内容的小文件原样写出
+				if err := write(name, e.Content); err != nil {
+					return err
+				}
+				item.Href = url.PathEscape(item.Name)
+			case me.URL != "":
+				strm := strings.TrimSuffix(name, path.Ext(name)) + ".strm"
+				if err := write(strm, []byte(me.URL+"\n")); err != nil {
+					return err
+				}
+				item.Href = url.PathEscape(path.Base(strm))
+			}
+		}
+		me.URL = redactURL(me.URL)
+		children[parent] = append(children[parent], item)
+		manifest = append(manifest, me)
+	}
+
+	for dir, items := range children {
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Dir && !items[j].Dir })
+		var buf bytes.Buffer
+		if err := mirrorIndexTemplate.Execute(&buf, map[string]interface{}{"Dir": dir, "Items": items}); err != nil {
+			return err
+		}
+		if err := write(strings.TrimPrefix(path.Join(dir, "index.html"), "/"), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return write("manifest.json", data)
+}
+
+// localMirrorTarget 把镜像写到本地目录, 先写临时文件再改名, 静态服务器不会读到半个文件
+type localMirrorTarget string
+
+func (t localMirrorTarget) String() string { return string(t) }
+
+func (t localMirrorTarget) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(t), filepath.FromSlash(name)))
+}
+
+func (t localMirrorTarget) Put(name string, data []byte) error {
+	full := filepath.Join(string(t), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	tmp := full + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, full)
+}
+
+func (t localMirrorTarget) Delete(name string) error {
+	full := filepath.Join(string(t), filepath.FromSlash(name))
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// 顺带删掉因此变空的目录, 非空时 Remove 会失败, 忽略即可
+	for dir := filepath.Dir(full); dir != filepath.Clean(string(t)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// davMirrorTarget 把镜像上传到另一台主机的 WebDAV, 认证使用 -mirror-user/-mirror-pass
+type davMirrorTarget struct {
+	fs     *TextWebDAVFileSystem
+	base   *url.URL
+	client *http.Client
+	dirs   map[string]bool // 已确认存在的目录
+}
+
+func (t *davMirrorTarget) String() string { return redactURL(t.base.String()) }
+
+func (t *davMirrorTarget) url(name string) string {
+	return t.base.ResolveReference(&url.URL{Path: path.Join(t.base.Path, name)}).String()
+}
+
+func (t *davMirrorTarget) do(method, name string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, t.url(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	t.fs.authorizeUpstream(req)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	return resp, nil
+}
+
+func (t *davMirrorTarget) Get(name string) ([]byte, error) {
+	resp, err := t.do(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET 返回 %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (t *davMirrorTarget) Put(name string, data []byte) error {
+	if err := t.mkdirs(path.Dir(name)); err != nil {
+		return err
+	}
+	resp, err := t.do(http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT 返回 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (t *davMirrorTarget) Delete(name string) error {
+	resp, err := t.do(http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE 返回 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// mkdirs 逐级 MKCOL, 405 表示目录已存在
+func (t *davMirrorTarget) mkdirs(dir string) error {
+	if dir == "." || dir == "" || t.dirs[dir] {
+		return nil
+	}
+	if err := t.mkdirs(path.Dir(dir)); err != nil {
+		return err
+	}
+	resp, err := t.do("MKCOL", dir+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL %s 返回 %d", dir, resp.StatusCode)
+	}
+	t.dirs[dir] = true
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// rejectMismatch 在交给 webdav.Handler 之前回答方法与条目类型不符的请求. Handler 把 OpenFile
+// 的错误一律映射成 404, 把 Mkdir 的 ENOTDIR 映射成 405, 表达不了这几种情况:
+// PUT 到目录返回 405; PUT 或 MKCOL 的上级是文件时返回 409; MOVE 的源不存在时返回 404
+func (fs *TextWebDAVFileSystem) rejectMismatch(w http.ResponseWriter, r *http.Request) bool {
+	name := fs.keyPath(r.URL.Path)
+	fs.mu.RLock()
+	meta, exists := fs.Files[name]
+	var parentErr error
+	if !exists && name != "/" {
+		parentErr = fs.checkParentLocked(r.Method, name)
+	}
+	fs.mu.RUnlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		if name == "/" || exists && meta.IsDir {
+			w.Header().Set("Allow", strings.Join(fs.allowedMethods(name), ", "))
+			http.Error(w, "不能 PUT 到目录", http.StatusMethodNotAllowed)
+			return true
+		}
+		fallthrough
+	case "MKCOL":
+		if errors.Is(parentErr, syscall.ENOTDIR) {
+			http.Error(w, "上级路径不是目录", http.StatusConflict)
+			return true
+		}
+	case "MOVE":
+		if !exists && name != "/" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MissingEntry 记录上游明确返回 404/410 的条目
+type MissingEntry struct {
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Since     time.Time `json:"since"`
+	LastCheck time.Time `json:"last_check"`
+	Checks    int       `json:"checks"`
+}
+
+// MissingReport 保存上游已不存在的条目. 标记独立于目录树保存,
+// 重新加载时仍在列表中的条目保留标记, 过了 ttl 之后放行一次请求重新确认
+type MissingReport struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*MissingEntry
+}
+
+func NewMissingReport(ttl time.Duration) *MissingReport {
+	return &MissingReport{ttl: ttl, entries: make(map[string]*MissingEntry)}
+}
+
+// Blocked 判断是否直接对该路径返回 404. 标记过期后放行, 由下一次上游请求决定是否重新标记
+func (r *MissingReport) Blocked(path string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.entries[path]
+	if !ok {
+		return false
+	}
+	if r.ttl > 0 && time.Since(m.LastCheck) >= r.ttl {
+		return false
+	}
+	return true
+}
+
+func (r *MissingReport) mark(path string, status int) (first bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	m, ok := r.entries[path]
+	if !ok {
+		m = &MissingEntry{Path: path, Since: now}
+		r.entries[path] = m
+	}
+	m.Status = status
+	m.LastCheck = now
+	m.Checks++
+	return !ok
+}
+
+func (r *MissingReport) clear(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[path]; !ok {
+		return false
+	}
+	delete(r.entries, path)
+	return true
+}
+
+// retain 只保留 keep 返回 true 的路径, 重新加载后用来丢掉已从列表中删除的条目
+func (r *MissingReport) retain(keep func(path string) bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for p := range r.entries {
+		if !keep(p) {
+			delete(r.entries, p)
+		}
+	}
+}
+
+func (r *MissingReport) List() []MissingEntry {
+	if r == nil {
+		return []MissingEntry{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]MissingEntry, 0, len(r.entries))
+	for _, m := range r.entries {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// checkUpstreamGone 在打开或解析上游地址后调用. 404/410 标记为上游缺失并返回 true,
+// 其余状态说明上游仍然存在, 清除之前的标记. 5xx 等临时错误不改变标记
+func (fs *TextWebDAVFileSystem) checkUpstreamGone(path string, status int) bool {
+	if fs.missing == nil {
+		return false
+	}
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		if fs.missing.mark(path, status) {
+			fs.emitEvent("missing-at-source", path, fmt.Sprintf("upstream=%d", status))
+		}
+		return true
+	case status < 500:
+		if fs.missing.clear(path) {
+			fs.emitEvent("source-recovered", path, fmt.Sprintf("upstream=%d", status))
+		}
+	}
+	return false
+}
+
+func (fs *TextWebDAVFileSystem) handleMissing(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, fs.missing.List())
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// TestMkcolLitmus 对应 litmus basic 中的 mkcol、mkcol_again、mkcol_no_parent 等用例
+func TestMkcolLitmus(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	mkcol := func(name, body string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("MKCOL", name, strings.NewReader(body)))
+		return w.Code
+	}
+
+	for _, tt := range []struct {
+		name, body string
+		want       int
+	}{
+		{"/litmus", "", http.StatusCreated},
+		{"/litmus", "", http.StatusMethodNotAllowed},
+		{"/litmus/sub", "", http.StatusCreated},
+		{"/missing/sub", "", http.StatusConflict},
+		{"/a.mkv/sub", "", http.StatusMethodNotAllowed},
+		{"/litmus/body", "<x/>", http.StatusUnsupportedMediaType},
+	} {
+		if got := mkcol(tt.name, tt.body); got != tt.want {
+			t.Errorf("MKCOL %s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if fs.Files["/missing"] != nil || fs.Files["/missing/sub"] != nil || fs.Files["/a.mkv/sub"] != nil {
+		t.Error("a refused MKCOL left entries behind")
+	}
+	if got := hrefs(t, propfind(fs, "/litmus", "1", "").Body.Bytes()); len(got) != 2 || got[1] != "/litmus/sub" {
+		t.Errorf("PROPFIND /litmus = %v", got)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestOpenFileFlags(t *testing.T) {
+	list := "/n.txt#0#n.txt#" + base64.StdEncoding.EncodeToString([]byte("hello world")) + "\n" +
+		"/a.mkv#10#a.mkv\n/dir/x.mkv#1#x.mkv\n"
+	const (
+		put        = os.O_RDWR | os.O_CREATE | os.O_TRUNC // webdav.Handler 的 PUT、COPY 和 LOCK 空资源
+		appendOnly = os.O_WRONLY | os.O_APPEND
+	)
+	tests := []struct {
+		desc   string
+		name   string
+		flag   int
+		writes []string
+		want   string
+	}{
+		{"PUT over an existing file", "/n.txt", put, []string{"hi"}, "hi"},
+		{"PUT without a body", "/n.txt", put, nil, ""},
+		{"PUT a new file", "/new.txt", put, []string{"ab", "cd"}, "abcd"},
+		{"append", "/n.txt", appendOnly, []string{"!", "?"}, "hello world!?"},
+		{"append to a new file", "/new.txt", appendOnly | os.O_CREATE, []string{"ab", "cd"}, "abcd"},
+		{"overwrite in place", "/n.txt", os.O_RDWR, []string{"HE"}, "HEllo world"},
+		{"open without writing", "/n.txt", os.O_RDWR, nil, "hello world"},
+	}
+	for _, tt := range tests {
+		fs := newTestFS(t, list)
+		f, err := fs.OpenFile(context.Background(), tt.name, tt.flag, 0o644)
+		if err != nil {
+			t.Fatalf("%s: OpenFile: %v", tt.desc, err)
+		}
+		for _, s := range tt.writes {
+			if _, err := f.Write([]byte(s)); err != nil {
+				t.Fatalf("%s: Write: %v", tt.desc, err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", tt.desc, err)
+		}
+		meta := fs.Files[tt.name]
+		if string(meta.Content) != tt.want || meta.Size != int64(len(tt.want)) {
+			t.Errorf("%s: content %q, size %d; want %q", tt.desc, meta.Content, meta.Size, tt.want)
+		}
+	}
+
+	fs := newTestFS(t, list)
+	ctx := context.Background()
+	for _, name := range []string{"/", "/dir"} {
+		for _, flag := range []int{put, appendOnly, os.O_WRONLY} {
+			if _, err := fs.OpenFile(ctx, name, flag, 0o644); err != os.ErrInvalid {
+				t.Errorf("OpenFile(%s, %#o) = %v, want os.ErrInvalid", name, flag, err)
+			}
+		}
+	}
+	// 只有上游地址的文件不能追加, 上传会整体替换上游的文件
+	f, err := fs.OpenFile(ctx, "/a.mkv", appendOnly, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("appended to an upstream-only file")
+	}
+	f.Close()
+	if meta := fs.Files["/a.mkv"]; meta.Size != 10 || meta.Content != nil {
+		t.Errorf("upstream-only file changed: %+v", meta)
+	}
+}
+
+func TestPutThenGetRoundTrip(t *testing.T) {
+	fs := newTestFS(t, "/d/a.mkv#10#a.mkv\n")
+	h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	data := bytes.Repeat([]byte("字幕\x00\xff"), 1000)
+
+	for i, body := range [][]byte{data, []byte("short")} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/d/sub.srt", bytes.NewReader(body)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("PUT %d: status %d", i, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/d/sub.srt", nil))
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), body) {
+			t.Errorf("GET %d: status %d, %d bytes; want the %d bytes just written", i, w.Code, w.Body.Len(), len(body))
+		}
+		values := okValues(t, propfind(fs, "/d/sub.srt", "0", "").Body.String())
+		if got := values["getcontentlength"]; got != strconv.Itoa(len(body)) {
+			t.Errorf("PROPFIND %d: getcontentlength = %s, want %d", i, got, len(body))
+		}
+	}
+
+	fs.maxMemoryFile = 16
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/d/big.srt", bytes.NewReader(data)))
+	if w.Code < 400 || fs.Files["/d/big.srt"] != nil {
+		t.Errorf("PUT over -max-memory-file: status %d, entry %v", w.Code, fs.Files["/d/big.srt"])
+	}
+}
+
+func TestSeekMatchesOSFile(t *testing.T) {
+	data := []byte("0123456789")
+	fs := newTestFS(t, "/n.txt#0#n.txt#"+base64.StdEncoding.EncodeToString(data)+"\n/u.mkv#-1#u.mkv\n")
+	osFile, err := os.CreateTemp(t.TempDir(), "seek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFile.Close()
+	osFile.Write(data)
+	vf, err := fs.OpenFile(context.Background(), "/n.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vf.Close()
+
+	// ServeContent 先 SeekEnd(0) 取大小再 SeekStart, 之后是越过结尾、负数和非法 whence
+	steps := []struct {
+		offset int64
+		whence int
+	}{
+		{0, io.SeekEnd}, {0, io.SeekStart}, {3, io.SeekCurrent}, {-2, io.SeekEnd},
+		{20, io.SeekStart}, {5, io.SeekCurrent}, {-1, io.SeekStart}, {-20, io.SeekEnd},
+		{-100, io.SeekCurrent}, {0, 7}, {4, io.SeekStart},
+	}
+	buf := make([]byte, 3)
+	for _, s := range steps {
+		wantPos, wantErr := osFile.Seek(s.offset, s.whence)
+		gotPos, gotErr := vf.Seek(s.offset, s.whence)
+		if (gotErr == nil) != (wantErr == nil) || (wantErr == nil && gotPos != wantPos) {
+			t.Errorf("Seek(%d, %d) = %d, %v; os.File gives %d, %v", s.offset, s.whence, gotPos, gotErr, wantPos, wantErr)
+		}
+		wantN, wantErr := osFile.Read(buf)
+		want := string(buf[:wantN])
+		gotN, gotErr := vf.Read(buf)
+		if got := string(buf[:gotN]); got != want || gotErr != wantErr {
+			t.Errorf("Read after Seek(%d, %d) = %q, %v; os.File gives %q, %v", s.offset, s.whence, got, gotErr, want, wantErr)
+		}
+		// 两边读过之后位置仍然一致
+		osFile.Seek(-int64(wantN), io.SeekCurrent)
+		vf.Seek(-int64(gotN), io.SeekCurrent)
+	}
+
+	// 大小未知的文件找不到结尾
+	u, err := fs.OpenFile(context.Background(), "/u.mkv", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	if _, err := u.Seek(0, io.SeekEnd); err == nil {
+		t.Error("SeekEnd on a file of unknown size succeeded")
+	}
+
+	// ServeContent 按 Range 返回正确的字节
+	vf.Seek(0, io.SeekStart)
+	r := httptest.NewRequest(http.MethodGet, "/n.txt", nil)
+	r.Header.Set("Range", "bytes=7-")
+	w := httptest.NewRecorder()
+	http.ServeContent(w, r, "n.txt", testModTime, vf)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "789" {
+		t.Errorf("ServeContent: status %d, body %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// serveOptions 回答 OPTIONS. Windows 的 WebDAV 重定向器和 Documents 等客户端据此判断
+// 能否挂载, 要求 DAV 中有 2 (锁) 并有完整的 Allow. 只读模式不支持锁, 也不列出修改方法
+func (fs *TextWebDAVFileSystem) serveOptions(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Allow", strings.Join(fs.allowedMethods(fs.keyPath(r.URL.Path)), ", "))
+	if fs.readOnly {
+		h.Set("DAV", "1")
+	} else {
+		h.Set("DAV", "1, 2")
+	}
+	h.Set("MS-Author-Via", "DAV")
+	h.Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
+
+// allowedMethods 返回 name 上可用的方法, 用于 OPTIONS 和 405 响应的 Allow 头
+func (fs *TextWebDAVFileSystem) allowedMethods(name string) []string {
+	fs.mu.RLock()
+	meta, ok := fs.Files[name]
+	fs.mu.RUnlock()
+
+	var allow []string
+	switch {
+	case name == "/":
+		allow = []string{"OPTIONS", "PROPFIND", "PROPPATCH", "LOCK", "UNLOCK"}
+	case !ok:
+		allow = []string{"OPTIONS", "PUT", "MKCOL", "LOCK"}
+	case meta.IsDir:
+		allow = []string{"OPTIONS", "PROPFIND", "PROPPATCH", "DELETE", "COPY", "MOVE", "LOCK", "UNLOCK"}
+	default:
+		allow = []string{"OPTIONS", "GET", "HEAD", "PROPFIND", "PROPPATCH", "PUT", "DELETE", "COPY", "MOVE", "LOCK", "UNLOCK"}
+	}
+	// 目录只有 -html-index 时才能 GET
+	if fs.htmlIndex && (name == "/" || ok && meta.IsDir) {
+		allow = slices.Insert(allow, 1, "GET", "HEAD")
+	}
+	kept := allow[:0]
+	for _, m := range allow {
+		switch {
+		case fs.readOnly && writeMethods[m]:
+		case m == http.MethodDelete && fs.protectedPath(name):
+		default:
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// parallelReader 把顺序读取的内容拆成固定大小的块, 同时用多个 Range 请求抓取客户端
+// 位置之后的若干块, 再按顺序交给调用方. 单连接限速的网盘上吞吐可以接近连接数倍.
+// 第一块直接读已经打开的响应, 之后每块单独请求, 中断时各自续传
+type parallelReader struct {
+	fs     *TextWebDAVFileSystem
+	ctx    context.Context
+	cancel context.CancelFunc
+	meta   *FileMeta
+	conns  int
+	chunk  int64
+
+	// first 是已经打开的响应, 只用来读第一块
+	first io.ReadCloser
+	// next 是下一个要发起的块的起点, end 是最后一个字节
+	next int64
+	end  int64
+	// pending 是已经发起、按顺序排队的块, cur 是正在交给调用方的块中剩下的内容
+	pending []*parallelChunk
+	cur     []byte
+	pos     int64
+	err     error
+}
+
+type parallelChunk struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// newParallelReader 在开启了并发抓取、上游支持 Range 并且剩下的内容多于一块时
+// 返回并发读取器, 否则返回 nil
+func (fs *TextWebDAVFileSystem) newParallelReader(ctx context.Context, rr *resumeReader, ranged bool) *parallelReader {
+	if fs.parallelConns < 2 || !ranged || rr.end < 0 || rr.end-rr.pos+1 <= fs.parallelChunk {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// 关闭后第一块也不再续传
+	rr.ctx = ctx
+	return &parallelReader{
+		fs:     fs,
+		ctx:    ctx,
+		cancel: cancel,
+		meta:   rr.meta,
+		conns:  fs.parallelConns,
+		chunk:  fs.parallelChunk,
+		first:  rr,
+		next:   rr.pos,
+		end:    rr.end,
+		pos:    rr.pos,
+	}
+}
+
+// fill 保持最多 conns 个块在途
+func (p *parallelReader) fill() {
+	for len(p.pending) < p.conns && p.next <= p.end {
+		start, end := p.next, p.next+p.chunk-1
+		if end > p.end {
+			end = p.end
+		}
+		c := &parallelChunk{done: make(chan struct{})}
+		if p.first != nil {
+			go p.readFirst(c, p.first, end-start+1)
+			p.first = nil
+		} else {
+			go p.fetch(c, start, end)
+		}
+		p.pending = append(p.pending, c)
+		p.next = end + 1
+	}
+}
+
+func (p *parallelReader) readFirst(c *parallelChunk, body io.ReadCloser, n int64) {
+	defer close(c.done)
+	defer body.Close()
+	c.data = make([]byte, n)
+	_, c.err = io.ReadFull(body, c.data)
+}
+
+func (p *parallelReader) fetch(c *parallelChunk, start, end int64) {
+	defer close(c.done)
+	rangeHeader := "bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10)
+	resp, source, err := p.fs.openUpstream(p.ctx, p.meta, rangeHeader, "")
+	if err != nil {
+		c.err = err
+		return
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		c.err = fmt.Errorf("并发抓取时上游返回 %d", resp.StatusCode)
+		return
+	}
+	rr := p.fs.newResumeReader(p.ctx, p.meta, resp, source)
+	defer rr.Close()
+	if rr.pos != start || rr.end != end {
+		c.err = fmt.Errorf("并发抓取时上游返回的范围不符: %s", resp.Header.Get("Content-Range"))
+		return
+	}
+	c.data = make([]byte, end-start+1)
+	_, c.err = io.ReadFull(rr, c.data)
+}
+
+func (p *parallelReader) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	if len(p.cur) == 0 {
+		p.fill()
+		if len(p.pending) == 0 {
+			return 0, io.EOF
+		}
+		c := p.pending[0]
+		select {
+		case <-c.done:
+		case <-p.ctx.Done():
+			p.err = p.ctx.Err()
+			return 0, p.err
+		}
+		p.pending = p.pending[1:]
+		if c.err != nil {
+			p.err = c.err
+			return 0, p.err
+		}
+		p.cur = c.data
+		// 取走一块后立即补上, 让在途的块数保持不变
+		p.fill()
+	}
+	n := copy(b, p.cur)
+	p.cur = p.cur[n:]
+	p.pos += int64(n)
+	return n, nil
+}
+
+// skipTo 与 readAhead.skipTo 相同, 只能在当前块已收到的范围内向前跳
+func (p *parallelReader) skipTo(pos int64) bool {
+	if pos < p.pos || pos > p.pos+int64(len(p.cur)) {
+		return false
+	}
+	p.cur = p.cur[pos-p.pos:]
+	p.pos = pos
+	return true
+}
+
+func (p *parallelReader) Close() error {
+	p.cancel()
+	if p.first != nil {
+		p.first.Close()
+		p.first = nil
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// throttledUpstream 每个连接每秒只发送 rate 字节, 记录同时进行的请求数的最大值.
+// ranges 为 false 时忽略 Range, 总是返回完整内容
+func throttledUpstream(t *testing.T, body []byte, rate int, ranges bool) (*httptest.Server, *int32, *int32) {
+	var active, peak, requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		data, status := body, http.StatusOK
+		if ranges {
+			w.Header().Set("Accept-Ranges", "bytes")
+			if start, length, ok := parseSingleRange(r.Header.Get("Range"), int64(len(body))); ok {
+				data = body[start : start+length]
+				status = http.StatusPartialContent
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, len(body)))
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		const slice = 1024
+		for len(data) > 0 {
+			n := min(slice, len(data))
+			if _, err := w.Write(data[:n]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			data = data[n:]
+			time.Sleep(time.Second * slice / time.Duration(rate))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &peak, &requests
+}
+
+func TestParallelFetchUsesSeveralConnections(t *testing.T) {
+	body := make([]byte, 256<<10)
+	for i := range body {
+		body[i] = byte(i * 7)
+	}
+	// 每个连接 1MB/s, 单连接需要约 250ms
+	srv, peak, _ := throttledUpstream(t, body, 1<<20, true)
+	fs := newTestFS(t, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+	withBackend(t, fs, srv)
+	fs.parallelConns = 4
+	fs.parallelChunk = 32 << 10
+
+	start := time.Now()
+	w := getUpstream(fs, "/a.mkv", nil)
+	elapsed := time.Since(start)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), body) {
+		t.Fatalf("status %d, %d bytes, want the chunks stitched in order", w.Code, w.Body.Len())
+	}
+	if got := atomic.LoadInt32(peak); got < 2 {
+		t.Errorf("at most %d concurrent upstream requests, want several", got)
+	}
+	t.Logf("%d KB in %v with up to %d connections", len(body)>>10, elapsed, atomic.LoadInt32(peak))
+}
+
+func TestParallelFetchFallsBackWithoutRange(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 8<<10)
+	srv, peak, requests := throttledUpstream(t, body, 64<<20, false)
+	fs := newTestFS(t, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+	withBackend(t, fs, srv)
+	fs.parallelConns = 4
+	fs.parallelChunk = 16 << 10
+
+	w := getUpstream(fs, "/a.mkv", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), body) {
+		t.Fatalf("status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if atomic.LoadInt32(peak) != 1 || atomic.LoadInt32(requests) != 1 {
+		t.Errorf("%d requests, %d concurrent; want a single connection", atomic.LoadInt32(requests), atomic.LoadInt32(peak))
+	}
+}
+
+func TestParallelFetchServesClientRange(t *testing.T) {
+	body := make([]byte, 200<<10)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	srv, _, _ := throttledUpstream(t, body, 64<<20, true)
+	fs := newTestFS(t, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+	withBackend(t, fs, srv)
+	fs.parallelConns = 3
+	fs.parallelChunk = 10 << 10
+
+	var wg sync.WaitGroup
+	for _, r := range [][2]int{{1000, 150000}, {12345, len(body) - 1}, {0, 10239}} {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			w := getUpstream(fs, "/a.mkv", http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, end)}})
+			if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), body[start:end+1]) {
+				t.Errorf("bytes=%d-%d: status %d, %d bytes", start, end, w.Code, w.Body.Len())
+			}
+		}(r[0], r[1])
+	}
+	wg.Wait()
+}
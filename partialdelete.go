@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errPartialRemove 表示 DELETE 只删除了目录下的一部分, 失败的条目记在 propagation 中,
+// 由 propagationWriter 按 RFC 4918 第 9.6.1 节返回 207
+var errPartialRemove = errors.New("部分条目删除失败")
+
+// removeTree 删除 name 及其下的条目, 返回删除失败的条目和原因; 成功的部分已从目录树中删除.
+// 目录下有挂载点或其它可写目录时无法一次删除, 逐个删除子项, 失败的子项和它的上级目录保留
+func (fs *TextWebDAVFileSystem) removeTree(ctx context.Context, name string, isDir bool) map[string]error {
+	if fs.protectedPath(name) {
+		return map[string]error{name: os.ErrPermission}
+	}
+	if isDir && fs.splitRemove(name) {
+		type child struct {
+			path  string
+			isDir bool
+		}
+		fs.mu.RLock()
+		var children []child
+		for _, p := range fs.childPathsLocked(name) {
+			children = append(children, child{p, fs.Files[p].IsDir})
+		}
+		fs.mu.RUnlock()
+
+		// 只在本地的子项最后一起删除, 避免每个子项都遍历一次目录树
+		failed := make(map[string]error)
+		var local []string
+		for _, c := range children {
+			if _, _, ok := fs.uploads.For(c.path); !ok && !fs.protectedPath(c.path) && !(c.isDir && fs.splitRemove(c.path)) {
+				local = append(local, c.path)
+				continue
+			}
+			for p, err := range fs.removeTree(ctx, c.path, c.isDir) {
+				failed[p] = err
+			}
+		}
+		if len(failed) > 0 {
+			fs.mu.Lock()
+			for _, p := range local {
+				if fs.removeAllLocked(p) == nil {
+					fs.recordMutation(JournalRecord{Op: JournalDelete, Path: p})
+				}
+			}
+			fs.mu.Unlock()
+			return failed
+		}
+	}
+
+	if err := fs.propagateRemove(ctx, name, isDir); err != nil {
+		return map[string]error{name: err}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.removeAllLocked(name); err != nil {
+		return map[string]error{name: err}
+	}
+	fs.recordMutation(JournalRecord{Op: JournalDelete, Path: name})
+	return nil
+}
+
+// splitRemove 报告删除 dir 时是否要逐个删除子项: dir 下有远端源的挂载点, 或有另一个可写目录
+// (dir 本身是可写目录的根时, 它的子项各自对应上游的文件)
+func (fs *TextWebDAVFileSystem) splitRemove(dir string) bool {
+	below := func(p string) bool {
+		return dir == "/" || strings.HasPrefix(p, dir+"/")
+	}
+	for _, source := range fs.sources {
+		if below(fs.normPath(source.Mount())) {
+			return true
+		}
+	}
+	for _, rule := range fs.uploads {
+		if rule.prefix == dir || below(rule.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeStatus 是删除失败的条目在 207 中的状态码
+func removeStatus(err error) int {
+	var be *backendError
+	switch {
+	case errors.As(err, &be):
+		return be.status
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// writeRemoveFailures 输出 DELETE 的 207: 只列出删除失败的条目, 没有列出的都已删除
+func writeRemoveFailures(w http.ResponseWriter, r *http.Request, failed map[string]error) {
+	type response struct {
+		Href   string `xml:"D:href"`
+		Status string `xml:"D:status"`
+	}
+	paths := make([]string, 0, len(failed))
+	for p := range failed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	multistatus := struct {
+		XMLName   xml.Name   `xml:"D:multistatus"`
+		XmlnsD    string     `xml:"xmlns:D,attr"`
+		Responses []response `xml:"D:response"`
+	}{XmlnsD: "DAV:"}
+	for _, p := range paths {
+		fmt.Printf("删除 %s 失败: %v\n", p, failed[p])
+		multistatus.Responses = append(multistatus.Responses, response{Href: hrefFor(r, p), Status: statusLine(removeStatus(failed[p]))})
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(multistatus)
+}
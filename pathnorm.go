@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var errRelativePath = errors.New("路径不能是相对路径 (以 ./ 或 ../ 开头或包含 ..)")
+
+// normalizeListPath 把列表中的路径统一成以 / 开头、/ 分隔的形式.
+// Windows 生成的列表可能带 \ 分隔符、盘符 (C:\movies) 或重复的分隔符,
+// 返回的 dir 表示原路径以分隔符结尾, 即目录条目
+func normalizeListPath(p string) (clean string, dir bool, err error) {
+	p = strings.ReplaceAll(p, `\`, "/")
+	if len(p) >= 2 && p[1] == ':' && (p[0]|0x20 >= 'a' && p[0]|0x20 <= 'z') {
+		p = p[2:]
+	}
+	if strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") || p == "." || p == ".." {
+		return "", false, errRelativePath
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", false, errRelativePath
+		}
+	}
+
+	dir = len(p) > 1 && strings.HasSuffix(p, "/")
+	return path.Clean("/" + p), dir, nil
+}
+
+// keyPath 把请求路径和 FileSystem 方法收到的名字转换成目录树的键. 键不带结尾的 /,
+// Windows 重定向器等客户端会给目录加上, 这里去掉一个 (根目录除外) 再做 Unicode 规范化
+func (fs *TextWebDAVFileSystem) keyPath(name string) string {
+	if len(name) > 1 {
+		name = strings.TrimSuffix(name, "/")
+	}
+	return fs.normPath(name)
+}
+
+// pathNormMiddleware 让请求路径与加载时规范化后的键一致: 客户端发来的 %5C
+// 解码后是 \, 统一换成 / 并合并多余的分隔符. Destination 头做同样处理
+func pathNormMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, `\`) || strings.Contains(r.URL.Path, "//") {
+			r.URL.Path = normalizeRequestPath(r.URL.Path)
+			r.URL.RawPath = ""
+		}
+		if dst := r.Header.Get("Destination"); dst != "" {
+			if u, err := url.Parse(dst); err == nil && (strings.Contains(u.Path, `\`) || strings.Contains(u.Path, "//")) {
+				u.Path = normalizeRequestPath(u.Path)
+				u.RawPath = ""
+				r.Header.Set("Destination", u.String())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeMiddleware 处理客户端编码不规范的路径: 二次百分号编码 (解码一次后仍是 %E6%88%98)
+// 和用 + 代替空格. 只有请求的路径不存在、换成另一种解读后存在时才替换, 文件名中
+// 本来就有的 % 和 + 不受影响
+func (fs *TextWebDAVFileSystem) decodeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := fs.redecodePath(r.URL.Path); ok {
+			r.URL.Path = p
+			r.URL.RawPath = ""
+		}
+		if dst := r.Header.Get("Destination"); dst != "" {
+			if u, err := url.Parse(dst); err == nil {
+				if p, ok := fs.redecodePath(u.Path); ok {
+					u.Path = p
+					u.RawPath = ""
+					r.Header.Set("Destination", u.String())
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redecodePath 返回 p 的另一种解读, 没有需要替换的时 ok 为 false
+func (fs *TextWebDAVFileSystem) redecodePath(p string) (string, bool) {
+	if !strings.ContainsAny(p, "%+") {
+		return "", false
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	exists := func(p string) bool {
+		key := fs.keyPath(p)
+		_, ok := fs.Files[key]
+		return ok || key == "/"
+	}
+	if exists(p) {
+		return "", false
+	}
+	var candidates []string
+	if strings.Contains(p, "%") {
+		if decoded, err := url.PathUnescape(p); err == nil {
+			candidates = append(candidates, decoded)
+		}
+	}
+	if strings.Contains(p, "+") {
+		candidates = append(candidates, strings.ReplaceAll(p, "+", " "))
+	}
+	for _, c := range candidates {
+		if exists(c) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func normalizeRequestPath(p string) string {
+	trailing := strings.HasSuffix(p, "/") || strings.HasSuffix(p, `\`)
+	p = path.Clean("/" + strings.ReplaceAll(p, `\`, "/"))
+	if trailing && p != "/" {
+		p += "/"
+	}
+	return p
+}
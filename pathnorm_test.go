@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestNormalizeListPath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		dir  bool
+	}{
+		{`\movies\战狼2.mkv`, "/movies/战狼2.mkv", false},
+		{`C:\movies\战狼2.mkv`, "/movies/战狼2.mkv", false},
+		{`d:/movies\a.mkv`, "/movies/a.mkv", false},
+		{`/movies//2017///a.mkv`, "/movies/2017/a.mkv", false},
+		{`movies/a.mkv`, "/movies/a.mkv", false},
+		{`/movies/./a.mkv`, "/movies/a.mkv", false},
+		{`\movies\剧集\`, "/movies/剧集", true},
+		{`/movies//`, "/movies", true},
+	}
+	for _, tt := range tests {
+		got, dir, err := normalizeListPath(tt.in)
+		if err != nil || got != tt.want || dir != tt.dir {
+			t.Errorf("normalizeListPath(%q) = %q, %v, %v; want %q, %v", tt.in, got, dir, err, tt.want, tt.dir)
+		}
+	}
+	for _, in := range []string{`./movies/a.mkv`, `.\movies\a.mkv`, `../a.mkv`, `/movies/../a.mkv`, `..`} {
+		if _, _, err := normalizeListPath(in); err != errRelativePath {
+			t.Errorf("normalizeListPath(%q) error = %v, want errRelativePath", in, err)
+		}
+	}
+}
+
+func TestWindowsListLoadsNormalizedKeys(t *testing.T) {
+	fs := newTestFS(t, "")
+	report, err := fs.LoadFromText(`\movies\战狼2.mkv#10#战狼2(2017)` + "\n" +
+		`C:\movies\\2019\a.mkv#20#a.mkv` + "\n" +
+		`/movies//b.mkv#30#b.mkv` + "\n" +
+		`./movies/c.mkv#40#c.mkv` + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Loaded != 3 || report.SkippedCount != 1 || report.Skipped[0].Line != 4 {
+		t.Errorf("report = %+v, want the relative path skipped", report)
+	}
+	for _, name := range []string{"/movies", "/movies/战狼2.mkv", "/movies/2019", "/movies/2019/a.mkv", "/movies/b.mkv"} {
+		if fs.Files[name] == nil {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if len(fs.Files) != 5 {
+		t.Errorf("%d entries, want 5: %v", len(fs.Files), fs.Files)
+	}
+}
+
+func TestRequestPathsHitNormalizedKeys(t *testing.T) {
+	var got, dst string
+	h := pathNormMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, dst = r.URL.Path, r.Header.Get("Destination")
+	}))
+	for _, target := range []string{"/movies%5C%E6%88%98%E7%8B%BC2.mkv", "/movies//%E6%88%98%E7%8B%BC2.mkv", "//movies%5C%5C%E6%88%98%E7%8B%BC2.mkv"} {
+		r := httptest.NewRequest("MOVE", target, nil)
+		r.Header.Set("Destination", "http://example.com/movies%5C2017//b.mkv")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got != "/movies/战狼2.mkv" {
+			t.Errorf("%s: path %q", target, got)
+		}
+		if dst != "http://example.com/movies/2017/b.mkv" {
+			t.Errorf("%s: Destination %q", target, dst)
+		}
+	}
+}
+
+func TestCollectionPathsWithAndWithoutTrailingSlash(t *testing.T) {
+	for _, slash := range []string{"", "/"} {
+		fs := newTestFS(t, "/d/a.mkv#10#a.mkv\n/other/b.mkv#5#b.mkv\n")
+		h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+
+		if got := hrefs(t, propfind(fs, "/d"+slash, "1", "").Body.Bytes()); len(got) != 2 {
+			t.Errorf("PROPFIND /d%s = %v", slash, got)
+		}
+		if w := proppatch(fs, "/d"+slash, propertyUpdate(`<D:set><D:prop><Z:tag>x</Z:tag></D:prop></D:set>`)); propStatuses(t, w.Body.String())[http.StatusOK] != "tag" {
+			t.Errorf("PROPPATCH /d%s: %s", slash, w.Body.String())
+		}
+
+		r := httptest.NewRequest("COPY", "/d"+slash, nil)
+		r.Header.Set("Destination", "/copy"+slash)
+		w := httptest.NewRecorder()
+		fs.HandleCopy(w, r)
+		if w.Code != http.StatusCreated || fs.Files["/copy/a.mkv"] == nil {
+			t.Errorf("COPY /d%s: status %d", slash, w.Code)
+		}
+
+		r = httptest.NewRequest("MOVE", "/copy"+slash, nil)
+		r.Header.Set("Destination", "/moved"+slash)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated || fs.Files["/moved/a.mkv"] == nil || fs.Files["/copy"] != nil {
+			t.Errorf("MOVE /copy%s: status %d", slash, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/other"+slash, nil))
+		if w.Code != http.StatusNoContent || fs.Files["/other"] != nil || fs.Files["/other/b.mkv"] != nil {
+			t.Errorf("DELETE /other%s: status %d", slash, w.Code)
+		}
+		if fs.Files["/d"].Props == nil {
+			t.Errorf("/d%s: the PROPPATCH did not reach the entry", slash)
+		}
+	}
+}
+
+func TestEncodedRequestPathsHitTheSameEntry(t *testing.T) {
+	fs := newTestFS(t, "/电影/战狼 2.mkv#10#战狼 2.mkv\n/电影/100%+1.mkv#5#100%+1.mkv\n")
+	h := pathNormMiddleware(fs.decodeMiddleware(http.HandlerFunc(fs.HandlePropfind)))
+	do := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PROPFIND", target, nil)
+		r.Header.Set("Depth", "0")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	const escaped = "/%E7%94%B5%E5%BD%B1/%E6%88%98%E7%8B%BC%202.mkv"
+	for _, target := range []string{
+		escaped,
+		"/电影/战狼%202.mkv",
+		"/%25E7%2594%25B5%25E5%25BD%25B1/%25E6%2588%2598%25E7%258B%25BC%25202.mkv",
+		"/电影/战狼+2.mkv",
+	} {
+		w := do(target)
+		if w.Code != http.StatusMultiStatus {
+			t.Errorf("%s: status %d", target, w.Code)
+			continue
+		}
+		var ms multistatus
+		if err := xml.Unmarshal(w.Body.Bytes(), &ms); err != nil || len(ms.Responses) != 1 || ms.Responses[0].Href != escaped {
+			t.Errorf("%s: %v, want the href %s:\n%s", target, err, escaped, w.Body.String())
+		}
+	}
+
+	// 文件名中本来就有的 % 和 + 不被改写, href 中转义后可以原样再请求
+	w := do("/电影/100%25+1.mkv")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("literal %%+: status %d", w.Code)
+	}
+	href := hrefs(t, w.Body.Bytes())
+	if len(href) != 1 || href[0] != "/电影/100%+1.mkv" {
+		t.Errorf("literal %%+: hrefs %v", href)
+	}
+	if w := do("/电影/战狼%25202.mkv"); w.Code != http.StatusMultiStatus {
+		t.Errorf("double-encoded space: status %d", w.Code)
+	}
+	if w := do("/电影/nope+2.mkv"); w.Code != http.StatusNotFound {
+		t.Errorf("missing entry: status %d", w.Code)
+	}
+}
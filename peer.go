@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// 多实例部署时实例之间通过 /_peer/ 下的 HTTP 接口互通:
+//   - 锁: 指定一个实例作为锁权威, 其余实例用 PeerLockSystem 把所有锁操作转发给它.
+//     权威不可达时所有锁操作返回错误, 由 webdav.Handler 返回 500, 即锁失败时拒绝修改
+//   - 修改: 每个实例把自己的修改记录尽力推送给 -peers 中的其它实例, 推送失败只记日志
+//
+// 单实例部署不配置这些参数, 使用本地 MemLS, 没有任何额外开销
+const peerPathPrefix = "/_peer/"
+
+// 持有的 Confirm 超过该时长未释放时由权威自动释放, 防止调用方崩溃后资源一直被占用
+const peerHoldTimeout = 10 * time.Minute
+
+var peerLockErrors = map[string]error{
+	webdav.ErrConfirmationFailed.Error(): webdav.ErrConfirmationFailed,
+	webdav.ErrForbidden.Error():          webdav.ErrForbidden,
+	webdav.ErrLocked.Error():             webdav.ErrLocked,
+	webdav.ErrNoSuchLock.Error():         webdav.ErrNoSuchLock,
+}
+
+type peerLockRequest struct {
+	Now        time.Time          `json:"now"`
+	Name0      string             `json:"name0,omitempty"`
+	Name1      string             `json:"name1,omitempty"`
+	Conditions []webdav.Condition `json:"conditions,omitempty"`
+	Details    webdav.LockDetails `json:"details"`
+	Token      string             `json:"token,omitempty"`
+	Duration   time.Duration      `json:"duration,omitempty"`
+	HoldID     string             `json:"hold_id,omitempty"`
+}
+
+type peerLockResponse struct {
+	Error   string             `json:"error,omitempty"`
+	Token   string             `json:"token,omitempty"`
+	Details webdav.LockDetails `json:"details"`
+	HoldID  string             `json:"hold_id,omitempty"`
+}
+
+// PeerLockSystem 把 webdav.LockSystem 的调用转发给锁权威实例
+type PeerLockSystem struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewPeerLockSystem(url, token string) *PeerLockSystem {
+	return &PeerLockSystem{
+		url:    strings.TrimSuffix(url, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (ls *PeerLockSystem) call(op string, req peerLockRequest) (*peerLockResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, ls.url+peerPathPrefix+"locks/"+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Peer-Token", ls.token)
+
+	resp, err := ls.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("锁服务不可达: %v", redactError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("锁服务返回 %s", resp.Status)
+	}
+
+	var out peerLockResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("锁服务响应格式错误: %v", err)
+	}
+	if out.Error != "" {
+		if known, ok := peerLockErrors[out.Error]; ok {
+			return nil, known
+		}
+		return nil, errors.New(out.Error)
+	}
+	return &out, nil
+}
+
+func (ls *PeerLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	out, err := ls.call("confirm", peerLockRequest{Now: now, Name0: name0, Name1: name1, Conditions: conditions})
+	if err != nil {
+		return nil, err
+	}
+
+	holdID := out.HoldID
+	return func() {
+		if _, err := ls.call("release", peerLockRequest{HoldID: holdID}); err != nil {
+			fmt.Printf("释放远程锁失败: %v\n", err)
+		}
+	}, nil
+}
+
+func (ls *PeerLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	out, err := ls.call("create", peerLockRequest{Now: now, Details: details})
+	if err != nil {
+		return "", err
+	}
+	return out.Token, nil
+}
+
+func (ls *PeerLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	out, err := ls.call("refresh", peerLockRequest{Now: now, Token: token, Duration: duration})
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	return out.Details, nil
+}
+
+func (ls *PeerLockSystem) Unlock(now time.Time, token string) error {
+	_, err := ls.call("unlock", peerLockRequest{Now: now, Token: token})
+	return err
+}
+
+// PeerServer 处理其它实例发来的锁和修改请求
+type PeerServer struct {
+	fs    *TextWebDAVFileSystem
+	ls    webdav.LockSystem
+	token string
+
+	mu    sync.Mutex
+	holds map[string]*peerHold
+}
+
+type peerHold struct {
+	release func()
+	timer   *time.Timer
+}
+
+func NewPeerServer(fs *TextWebDAVFileSystem, ls webdav.LockSystem, token string) *PeerServer {
+	return &PeerServer{
+		fs:    fs,
+		ls:    ls,
+		token: token,
+		holds: make(map[string]*peerHold),
+	}
+}
+
+func (p *PeerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Peer-Token")), []byte(p.token)) != 1 {
+		http.Error(w, "认证失败", http.StatusUnauthorized)
+		return
+	}
+
+	op := strings.TrimPrefix(r.URL.Path, peerPathPrefix)
+	if op == "mutations" {
+		p.handleMutation(w, r)
+		return
+	}
+	if !strings.HasPrefix(op, "locks/") || p.ls == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var req peerLockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	var out peerLockResponse
+	var err error
+	switch strings.TrimPrefix(op, "locks/") {
+	case "confirm":
+		var release func()
+		release, err = p.ls.Confirm(req.Now, req.Name0, req.Name1, req.Conditions...)
+		if err == nil {
+			out.HoldID = p.hold(release)
+		}
+	case "release":
+		p.release(req.HoldID)
+	case "create":
+		out.Token, err = p.ls.Create(req.Now, req.Details)
+	case "refresh":
+		out.Details, err = p.ls.Refresh(req.Now, req.Token, req.Duration)
+	case "unlock":
+		err = p.ls.Unlock(req.Now, req.Token)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (p *PeerServer) hold(release func()) string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.holds[id] = &peerHold{
+		release: release,
+		timer: time.AfterFunc(peerHoldTimeout, func() {
+			fmt.Printf("远程锁 %s 超时未释放, 自动释放\n", id)
+			p.release(id)
+		}),
+	}
+	return id
+}
+
+func (p *PeerServer) release(id string) {
+	p.mu.Lock()
+	h, ok := p.holds[id]
+	delete(p.holds, id)
+	p.mu.Unlock()
+
+	if ok {
+		h.timer.Stop()
+		h.release()
+	}
+}
+
+func (p *PeerServer) handleMutation(w http.ResponseWriter, r *http.Request) {
+	var rec JournalRecord
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	// 只读实例不接受其它实例的修改, 推送方只记日志
+	if p.fs.readOnly {
+		fmt.Printf("只读模式, 拒绝远程修改 %s %s\n", rec.Op, rec.Path)
+		p.fs.rejectReadOnly(w)
+		return
+	}
+
+	p.fs.mu.Lock()
+	err := p.fs.applyRecord(rec)
+	if err == nil {
+		p.fs.journal.Append(rec)
+	}
+	p.fs.mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("应用远程修改 %s %s 失败: %v\n", rec.Op, rec.Path, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Peers 把本实例的修改尽力推送给其它实例, 每个实例一个有界队列, 队列满时丢弃
+type Peers struct {
+	token  string
+	client *http.Client
+	queues []chan JournalRecord
+}
+
+func NewPeers(urls []string, token string) *Peers {
+	p := &Peers{
+		token:  token,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+	for _, url := range urls {
+		queue := make(chan JournalRecord, 1024)
+		p.queues = append(p.queues, queue)
+		go p.push(strings.TrimSuffix(url, "/"), queue)
+	}
+	return p
+}
+
+func (p *Peers) Broadcast(rec JournalRecord) {
+	if p == nil {
+		return
+	}
+
+	for _, queue := range p.queues {
+		select {
+		case queue <- rec:
+		default:
+			fmt.Printf("修改推送队列已满, 丢弃 %s %s\n", rec.Op, rec.Path)
+		}
+	}
+}
+
+func (p *Peers) push(url string, queue chan JournalRecord) {
+	for rec := range queue {
+		body, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url+peerPathPrefix+"mutations", bytes.NewReader(body))
+		if err != nil {
+			fmt.Printf("推送修改到 %s 失败: %v\n", redactURL(url), err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Peer-Token", p.token)
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			fmt.Printf("推送修改到 %s 失败: %v\n", redactURL(url), redactError(err))
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			fmt.Printf("推送修改到 %s 失败: %s\n", redactURL(url), resp.Status)
+		}
+	}
+}
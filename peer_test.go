@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func lockAuthority(t *testing.T, token string) (*httptest.Server, webdav.LockSystem) {
+	ls := webdav.NewMemLS()
+	srv := httptest.NewServer(NewPeerServer(newTestFS(t, ""), ls, token))
+	t.Cleanup(srv.Close)
+	return srv, ls
+}
+
+func TestPeerLocksAreSharedWithTheAuthority(t *testing.T) {
+	srv, authority := lockAuthority(t, "secret")
+	peer := NewPeerLockSystem(srv.URL, "secret")
+	now := time.Now()
+	details := webdav.LockDetails{Root: "/a.mkv", Duration: time.Minute, OwnerXML: "<D:owner/>"}
+
+	token, err := peer.Create(now, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authority.Create(now, details); err != webdav.ErrLocked {
+		t.Errorf("the authority accepted a conflicting lock: %v", err)
+	}
+	if _, err := peer.Create(now, details); err != webdav.ErrLocked {
+		t.Errorf("a second peer lock: %v, want webdav.ErrLocked", err)
+	}
+	if _, err := peer.Confirm(now, "/a.mkv", ""); err != webdav.ErrConfirmationFailed {
+		t.Errorf("Confirm without the token: %v, want webdav.ErrConfirmationFailed", err)
+	}
+	release, err := peer.Confirm(now, "/a.mkv", "", webdav.Condition{Token: token})
+	if err != nil {
+		t.Fatalf("Confirm with the token: %v", err)
+	}
+	release()
+	if _, err := peer.Refresh(now, token, time.Hour); err != nil {
+		t.Errorf("Refresh: %v", err)
+	}
+	if err := peer.Unlock(now, token); err != nil {
+		t.Errorf("Unlock: %v", err)
+	}
+	if _, err := authority.Create(now, details); err != nil {
+		t.Errorf("lock still held after Unlock through the peer: %v", err)
+	}
+}
+
+func TestPeerLocksFailClosed(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	srv, _ := lockAuthority(t, "secret")
+
+	for name, ls := range map[string]*PeerLockSystem{
+		"unreachable": NewPeerLockSystem(down.URL, "secret"),
+		"wrong token": NewPeerLockSystem(srv.URL, "wrong"),
+	} {
+		now := time.Now()
+		if _, err := ls.Create(now, webdav.LockDetails{Root: "/a.mkv", Duration: time.Minute}); err == nil {
+			t.Errorf("%s: Create succeeded", name)
+		}
+		if _, err := ls.Confirm(now, "/a.mkv", ""); err == nil {
+			t.Errorf("%s: Confirm succeeded", name)
+		}
+		if _, err := ls.Refresh(now, "t", time.Minute); err == nil {
+			t.Errorf("%s: Refresh succeeded", name)
+		}
+		if err := ls.Unlock(now, "t"); err == nil {
+			t.Errorf("%s: Unlock succeeded", name)
+		}
+
+		// 锁权威不可用时修改一律被拒绝, 目录树不变
+		fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+		fs.locks = NewLockTracker(ls)
+		h := &webdav.Handler{FileSystem: fs, LockSystem: fs.locks}
+		for _, r := range []*http.Request{
+			httptest.NewRequest(http.MethodDelete, "/a.mkv", nil),
+			httptest.NewRequest("MKCOL", "/dir", nil),
+			httptest.NewRequest(http.MethodPut, "/b.mkv", strings.NewReader("data")),
+		} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code < 500 {
+				t.Errorf("%s: %s succeeded with status %d", name, r.Method, w.Code)
+			}
+		}
+		if w := proppatch(fs, "/a.mkv", propertyUpdate(`<D:set><D:prop><D:displayname>x</D:displayname></D:prop></D:set>`)); w.Code < 500 {
+			t.Errorf("%s: PROPPATCH succeeded with status %d", name, w.Code)
+		}
+		if fs.Files["/a.mkv"] == nil || fs.Files["/a.mkv"].DisplayName != "a.mkv" || fs.Files["/dir"] != nil || fs.Files["/b.mkv"] != nil {
+			t.Errorf("%s: the tree changed without a lock", name)
+		}
+	}
+}
+
+func TestPeerMutationsArePropagated(t *testing.T) {
+	other := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	srv := httptest.NewServer(NewPeerServer(other, nil, "secret"))
+	defer srv.Close()
+
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	fs.peers = NewPeers([]string{srv.URL}, "secret")
+	if err := fs.Rename(context.Background(), "/a.mkv", "/b.mkv"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		other.mu.RLock()
+		done := other.Files["/b.mkv"] != nil && other.Files["/a.mkv"] == nil
+		other.mu.RUnlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the rename never reached the other instance")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PrefixMap 在加载列表时改写路径前缀, 最长匹配的前缀优先, 不匹配的路径保持不变
+type PrefixMap []prefixMapping
+
+type prefixMapping struct {
+	from string
+	to   string
+}
+
+// Add 添加一条 old=new 映射, new 为空表示去掉前缀
+func (m *PrefixMap) Add(rule string) error {
+	from, to, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("前缀映射格式错误, 需要 old=new: %q", rule)
+	}
+	from = strings.TrimSpace(from)
+	if !strings.HasPrefix(from, "/") {
+		return fmt.Errorf("前缀映射的路径必须以 / 开头: %q", rule)
+	}
+	*m = append(*m, prefixMapping{
+		from: path.Clean(from),
+		to:   path.Clean("/" + strings.TrimSpace(to)),
+	})
+	return nil
+}
+
+// Apply 返回映射后的路径. 前缀按路径段匹配, /data 不会匹配 /database
+func (m PrefixMap) Apply(p string) string {
+	best := -1
+	for i, pm := range m {
+		if (p == pm.from || strings.HasPrefix(p, pm.from+"/") || pm.from == "/") && (best < 0 || len(pm.from) > len(m[best].from)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return p
+	}
+	return path.Join(m[best].to, strings.TrimPrefix(p, m[best].from))
+}
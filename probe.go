@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errBackendOffline = errors.New("上游暂时离线")
+
+// BackendProber 定期探测配置的上游 (-backend 和 -backend-map 中的地址), 连续失败
+// threshold 次后把它标记为离线. 离线上游上的文件直接返回 503, 不用等每个请求各自超时;
+// 探测恢复成功后自动重新上线. 没有配置的上游 (条目自己的地址) 不探测, 视为在线
+type BackendProber struct {
+	fetcher   *Fetcher
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+	// path 非空时探测 scheme://host + path (例如 Alist 的 /ping), 否则对配置的地址发 HEAD
+	path string
+
+	mu    sync.Mutex
+	hosts map[string]*probeState
+}
+
+type probeState struct {
+	target    string
+	userAgent string
+	up        bool
+	failures  int
+	lastErr   string
+	lastCheck time.Time
+	since     time.Time
+}
+
+// ProbeStatus 是 /api/stats 和状态页中一个上游的探测结果
+type ProbeStatus struct {
+	Host      string    `json:"host"`
+	Up        bool      `json:"up"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	LastCheck time.Time `json:"last_check"`
+	Since     time.Time `json:"since"`
+}
+
+// NewBackendProber 在 interval 不大于 0 或没有可探测的上游时返回 nil, 即不探测
+func NewBackendProber(fs *TextWebDAVFileSystem, interval time.Duration, threshold int, probePath string) *BackendProber {
+	if interval <= 0 {
+		return nil
+	}
+	// 探测时使用对应前缀配置的 User-Agent, -backend 使用全局设置
+	var bases []*url.URL
+	var agents []string
+	if fs.backend != nil {
+		bases, agents = append(bases, fs.backend), append(agents, fs.userAgent(nil, ""))
+	}
+	for _, bm := range fs.backends {
+		for _, base := range bm.bases {
+			bases, agents = append(bases, base), append(agents, fs.userAgent(nil, bm.prefix))
+		}
+	}
+	if len(bases) == 0 {
+		return nil
+	}
+	if threshold <= 0 {
+		threshold = 1
+	}
+	timeout := interval
+	if timeout > 10*time.Second {
+		timeout = 10 * time.Second
+	}
+
+	p := &BackendProber{fetcher: fs.fetcher, interval: interval, timeout: timeout, threshold: threshold, path: probePath, hosts: make(map[string]*probeState)}
+	now := time.Now()
+	for i, base := range bases {
+		key := backendKey(base.String())
+		if _, ok := p.hosts[key]; !ok {
+			p.hosts[key] = &probeState{target: base.String(), userAgent: agents[i], up: true, since: now}
+		}
+	}
+	return p
+}
+
+func (p *BackendProber) loop() {
+	for {
+		p.probeAll()
+		time.Sleep(p.interval)
+	}
+}
+
+func (p *BackendProber) probeAll() {
+	p.mu.Lock()
+	states := make(map[string]probeState, len(p.hosts))
+	for key, st := range p.hosts {
+		states[key] = *st
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for key, st := range states {
+		wg.Add(1)
+		go func(key, target, userAgent string) {
+			defer wg.Done()
+			p.record(key, p.probe(target, userAgent))
+		}(key, st.target, st.userAgent)
+	}
+	wg.Wait()
+}
+
+// probe 发出一次探测. 能连上并且不是 5xx 就算在线, 401/404 也说明服务本身正常;
+// 501 只是不支持 HEAD, 同样算在线
+func (p *BackendProber) probe(target, userAgent string) error {
+	// 探测反映的是上游本身, 不与内容请求一起排队
+	ctx, cancel := context.WithTimeout(exemptBackendLimit(context.Background()), p.timeout)
+	defer cancel()
+
+	method := http.MethodHead
+	if p.path != "" {
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		target = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: p.path}).String()
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := p.fetcher.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+		return fmt.Errorf("返回 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *BackendProber) record(key string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := p.hosts[key]
+	now := time.Now()
+	st.lastCheck = now
+	if err == nil {
+		if !st.up {
+			fmt.Printf("上游 %s 恢复在线\n", key)
+			st.since = now
+		}
+		st.up, st.failures, st.lastErr = true, 0, ""
+		return
+	}
+	st.failures++
+	st.lastErr = err.Error()
+	if st.up && st.failures >= p.threshold {
+		fmt.Printf("上游 %s 连续 %d 次探测失败, 标记为离线: %v\n", key, st.failures, err)
+		st.up, st.since = false, now
+	}
+}
+
+// isDown 报告 target 所在的上游是否被探测为离线
+func (p *BackendProber) isDown(target string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.hosts[backendKey(target)]
+	return st != nil && !st.up
+}
+
+// online 去掉 targets 中离线的上游, 顺序不变
+func (p *BackendProber) online(targets []string) []string {
+	if p == nil {
+		return targets
+	}
+	out := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if !p.isDown(t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// offline 报告 targets 是否全部离线. 只要有一个在线或未探测的上游就返回 false
+func (p *BackendProber) offline(targets []string) bool {
+	return p != nil && len(targets) > 0 && len(p.online(targets)) == 0
+}
+
+// serveOffline 返回 503, Retry-After 为下一次探测前的间隔
+func (p *BackendProber) serveOffline(w http.ResponseWriter) {
+	secs := int((p.interval + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, errBackendOffline.Error(), http.StatusServiceUnavailable)
+}
+
+func (p *BackendProber) Snapshot() []ProbeStatus {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]ProbeStatus, 0, len(p.hosts))
+	for key, st := range p.hosts {
+		out = append(out, ProbeStatus{Host: key, Up: st.up, Failures: st.failures, LastError: st.lastErr, LastCheck: st.lastCheck, Since: st.since})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// 可写目录下的删除和移动默认同步到上游, 可以按路径前缀关闭或只记录不执行
+const (
+	propagateOn     = "on"
+	propagateOff    = "off"
+	propagateDryRun = "dry-run"
+)
+
+// PropagateRules 按路径前缀覆盖可写目录的同步方式, 最长匹配优先, 没有匹配时同步
+type PropagateRules map[string]string
+
+// Add 解析一条规则, 形如 "/inbox/保留=off" 或 "/inbox=dry-run"
+func (p PropagateRules) Add(rule string) error {
+	prefix, mode, ok := strings.Cut(rule, "=")
+	prefix = strings.TrimSpace(prefix)
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("同步规则格式错误, 需要 /prefix=on|off|dry-run: %q", rule)
+	}
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case propagateOn, propagateOff, propagateDryRun:
+	default:
+		return fmt.Errorf("同步规则的值必须是 on、off 或 dry-run: %q", rule)
+	}
+	p[strings.TrimSuffix(prefix, "/")] = mode
+	return nil
+}
+
+func (p PropagateRules) For(name string) string {
+	best, mode := -1, propagateOn
+	for prefix, m := range p {
+		if (name == prefix || strings.HasPrefix(name, prefix+"/") || prefix == "") && len(prefix) > best {
+			best, mode = len(prefix), m
+		}
+	}
+	return mode
+}
+
+// backendError 是同步到上游失败的原因, status 是返回给 WebDAV 客户端的状态码
+type backendError struct {
+	status int
+	err    error
+}
+
+func (e *backendError) Error() string { return e.err.Error() }
+
+var errCrossBackend = &backendError{http.StatusForbidden, errors.New("不能在不同的上游之间移动")}
+
+// backendStatusFromHTTP 把上游的状态码转换成返回给客户端的状态码
+func backendStatusFromHTTP(code int) int {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return http.StatusForbidden
+	case http.StatusNotFound, http.StatusGone:
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// propagation 记录一次 DELETE/MOVE 中同步失败的状态码. webdav.Handler 对文件系统的
+// 错误一律返回 405/403, 由 propagationWriter 换成这里记录的状态码.
+// DELETE 只删除了一部分时 failed 记录失败的条目, 改为返回 207
+type propagation struct {
+	status int
+	failed map[string]error
+}
+
+type propagationKey struct{}
+
+func withPropagation(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	p := &propagation{}
+	r = r.WithContext(context.WithValue(r.Context(), propagationKey{}, p))
+	return &propagationWriter{ResponseWriter: w, r: r, p: p}, r
+}
+
+func notePropagation(ctx context.Context, err error) {
+	var be *backendError
+	if p, ok := ctx.Value(propagationKey{}).(*propagation); ok && errors.As(err, &be) {
+		p.status = be.status
+	}
+}
+
+func notePartialRemove(ctx context.Context, failed map[string]error) {
+	if p, ok := ctx.Value(propagationKey{}).(*propagation); ok {
+		p.failed = failed
+	}
+}
+
+type propagationWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	p        *propagation
+	replaced bool
+}
+
+func (w *propagationWriter) WriteHeader(code int) {
+	if code >= 400 && len(w.p.failed) > 0 {
+		w.replaced = true
+		writeRemoveFailures(w.ResponseWriter, w.r, w.p.failed)
+		return
+	}
+	if code >= 400 && w.p.status != 0 {
+		w.replaced = true
+		code = w.p.status
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *propagationWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		// 丢掉 webdav.Handler 按原状态码写的说明文字
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// propagateRemove 在删除 name 之前先删除上游的对应文件. 不在可写目录下或同步被关闭时什么也不做
+func (fs *TextWebDAVFileSystem) propagateRemove(ctx context.Context, name string, isDir bool) error {
+	rule, rest, ok := fs.uploads.For(name)
+	if !ok {
+		return nil
+	}
+	switch fs.propagate.For(name) {
+	case propagateOff:
+		return nil
+	case propagateDryRun:
+		fmt.Printf("同步演练: 将在上游删除 %s\n", name)
+		return nil
+	}
+
+	var err error
+	if rule.kind == uploadAlist {
+		remote := path.Join("/", rule.base.Path, rest)
+		err = fs.alistCall(ctx, name, rule, "/api/fs/remove", map[string]interface{}{
+			"dir":   path.Dir(remote),
+			"names": []string{path.Base(remote)},
+		})
+	} else {
+		err = fs.davCall(ctx, name, http.MethodDelete, rule.davURL(rest, isDir), nil)
+	}
+	if err != nil {
+		fmt.Printf("在上游删除 %s 失败: %v\n", name, err)
+	}
+	return err
+}
+
+// propagateRename 在移动 oldName 之前先在上游移动. 两端必须属于同一个可写目录.
+// 返回 true 表示上游确实移动了, 条目的内容地址需要随之更新
+func (fs *TextWebDAVFileSystem) propagateRename(ctx context.Context, oldName, newName string, isDir bool) (bool, error) {
+	rule, oldRest, oldOK := fs.uploads.For(oldName)
+	newRule, newRest, newOK := fs.uploads.For(newName)
+	if !oldOK && !newOK {
+		return false, nil
+	}
+	// 两端的规则不同时取更保守的
+	mode := propagateOn
+	for _, m := range []string{fs.propagate.For(oldName), fs.propagate.For(newName)} {
+		if m == propagateOff || (m == propagateDryRun && mode == propagateOn) {
+			mode = m
+		}
+	}
+	if mode == propagateOff {
+		return false, nil
+	}
+	if !oldOK || !newOK || rule.prefix != newRule.prefix {
+		return false, errCrossBackend
+	}
+	if mode == propagateDryRun {
+		fmt.Printf("同步演练: 将在上游把 %s 移动到 %s\n", oldName, newName)
+		return false, nil
+	}
+
+	var err error
+	if rule.kind == uploadAlist {
+		oldRemote := path.Join("/", rule.base.Path, oldRest)
+		newRemote := path.Join("/", rule.base.Path, newRest)
+		// Alist 的移动不能改名, 先移动到目标目录再改名
+		if path.Dir(oldRemote) != path.Dir(newRemote) {
+			err = fs.alistCall(ctx, oldName, rule, "/api/fs/move", map[string]interface{}{
+				"src_dir": path.Dir(oldRemote),
+				"dst_dir": path.Dir(newRemote),
+				"names":   []string{path.Base(oldRemote)},
+			})
+		}
+		if err == nil && path.Base(oldRemote) != path.Base(newRemote) {
+			err = fs.alistCall(ctx, oldName, rule, "/api/fs/rename", map[string]interface{}{
+				"path": path.Join(path.Dir(newRemote), path.Base(oldRemote)),
+				"name": path.Base(newRemote),
+			})
+		}
+	} else {
+		header := http.Header{
+			"Destination": {rule.davURL(newRest, isDir)},
+			"Overwrite":   {"T"},
+		}
+		err = fs.davCall(ctx, oldName, "MOVE", rule.davURL(oldRest, isDir), header)
+	}
+	if err != nil {
+		fmt.Printf("在上游移动 %s 失败: %v\n", oldName, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// rebaseUploadsLocked 在上游移动之后把 newName 下仍指向旧上传地址的条目改到新地址,
+// 返回需要写入日志的记录
+func (fs *TextWebDAVFileSystem) rebaseUploadsLocked(oldName, newName string) []JournalRecord {
+	var recs []JournalRecord
+	for p, meta := range fs.Files {
+		if meta.IsDir || (p != newName && !strings.HasPrefix(p, newName+"/")) {
+			continue
+		}
+		oldRule, oldRest, ok1 := fs.uploads.For(oldName + strings.TrimPrefix(p, newName))
+		newRule, newRest, ok2 := fs.uploads.For(p)
+		if !ok1 || !ok2 || meta.URL != oldRule.contentURL(oldRest) {
+			continue
+		}
+		meta.URL = newRule.contentURL(newRest)
+		recs = append(recs, JournalRecord{Op: JournalPut, Path: p, Size: meta.Size, Name: meta.DisplayName, URL: meta.URL})
+	}
+	return recs
+}
+
+// davURL 返回可写目录中 rest 在上游的地址, 目录以 / 结尾
+func (r uploadRule) davURL(rest string, isDir bool) string {
+	p := path.Join(r.base.Path, rest)
+	if isDir {
+		p += "/"
+	}
+	return r.base.ResolveReference(&url.URL{Path: p}).String()
+}
+
+func (fs *TextWebDAVFileSystem) davCall(ctx context.Context, name, method, target string, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	fs.authorizeUpstream(req)
+	fs.setUserAgent(ctx, req, name)
+	fs.upstreamHeaders.apply(req, target)
+
+	resp, err := fs.fetcher.Do(req)
+	if err != nil {
+		return &backendError{http.StatusBadGateway, redactError(err)}
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &backendError{backendStatusFromHTTP(resp.StatusCode), fmt.Errorf("上游返回 %d", resp.StatusCode)}
+	}
+	return nil
+}
+
+func (fs *TextWebDAVFileSystem) alistCall(ctx context.Context, name string, rule uploadRule, api string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	target := (&url.URL{Scheme: rule.base.Scheme, Host: rule.base.Host, Path: api}).String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	fs.authorizeUpstream(req)
+	fs.setUserAgent(ctx, req, name)
+	fs.upstreamHeaders.apply(req, target)
+
+	resp, err := fs.fetcher.Do(req)
+	if err != nil {
+		return &backendError{http.StatusBadGateway, redactError(err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &backendError{backendStatusFromHTTP(resp.StatusCode), fmt.Errorf("Alist 返回 %d", resp.StatusCode)}
+	}
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return &backendError{http.StatusBadGateway, fmt.Errorf("Alist 返回的内容无法解析: %v", err)}
+	}
+	if result.Code != http.StatusOK {
+		status := backendStatusFromHTTP(result.Code)
+		// Alist 找不到对象时 code 是 500, 只能看 message
+		if strings.Contains(strings.ToLower(result.Message), "not found") {
+			status = http.StatusNotFound
+		}
+		return &backendError{status, fmt.Errorf("Alist %s 失败: %d %s", api, result.Code, result.Message)}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	"golang.org/x/net/webdav"
+)
+
+// propfindRequest 是 PROPFIND 请求体. 没有请求体时等同于 allprop; propname 只要属性名;
+// 都不是时按 names 返回请求的属性
+type propfindRequest struct {
+	allprop  bool
+	propname bool
+	names    map[xml.Name]bool
+}
+
+// depthInfinity 是 Depth: infinity 对应的 parseDepth 结果
+const depthInfinity = -1
+
+// parseDepth 解析 PROPFIND 的 Depth 头. RFC 4918 规定没有 Depth 头时按 infinity 处理,
+// 但不带 Depth 的客户端都只是在列目录, 这里按 1 处理
+func parseDepth(s string) (int, bool) {
+	switch s {
+	case "0":
+		return 0, true
+	case "", "1":
+		return 1, true
+	case "infinity":
+		return depthInfinity, true
+	}
+	return 0, false
+}
+
+// writeFiniteDepthError 按 RFC 4918 第 9.1 节拒绝 Depth: infinity, 客户端据此改为逐层列出
+func writeFiniteDepthError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+}
+
+// parsePropfind 解析 PROPFIND 请求体, 格式错误时返回错误, 由调用方返回 400
+func parsePropfind(r *http.Request) (propfindRequest, error) {
+	var body struct {
+		XMLName  xml.Name  `xml:"DAV: propfind"`
+		Allprop  *struct{} `xml:"DAV: allprop"`
+		Propname *struct{} `xml:"DAV: propname"`
+		Prop     struct {
+			Props []struct {
+				XMLName xml.Name
+			} `xml:",any"`
+		} `xml:"DAV: prop"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&body); err != nil {
+		if errors.Is(err, io.EOF) {
+			return propfindRequest{allprop: true}, nil
+		}
+		return propfindRequest{}, err
+	}
+
+	req := propfindRequest{allprop: body.Allprop != nil, propname: body.Propname != nil, names: make(map[xml.Name]bool)}
+	if req.propname {
+		return req, nil
+	}
+	for _, p := range body.Prop.Props {
+		req.names[p.XMLName] = true
+	}
+	if !req.allprop && len(req.names) == 0 {
+		req.allprop = true
+	}
+	return req, nil
+}
+
+// wants 报告请求是否需要 name 属性的值 (propname 时需要知道它是否存在)
+func (pf propfindRequest) wants(name xml.Name) bool {
+	return pf.allprop || pf.propname || pf.names[name]
+}
+
+// propfindProp 是一个条目的全部属性. DAV: 下的属性以 D: 前缀输出,
+// Names 只用于输出没有值的属性名 (propname 和 404 中的属性)
+type propfindProp struct {
+	XMLName          xml.Name `xml:"D:prop"`
+	Displayname      *string  `xml:"D:displayname,omitempty"`
+	Getcontenttype   *string  `xml:"D:getcontenttype,omitempty"`
+	Getcontentlength *int64   `xml:"D:getcontentlength,omitempty"`
+	Getetag          *string  `xml:"D:getetag,omitempty"`
+	Getcontentmd5    *string  `xml:"D:getcontentmd5,omitempty"`
+	Creationdate     *string  `xml:"D:creationdate,omitempty"`
+	Getlastmodified  *string  `xml:"D:getlastmodified,omitempty"`
+	Resourcetype     *struct {
+		Collection *struct{} `xml:"D:collection,omitempty"`
+	} `xml:"D:resourcetype,omitempty"`
+	QuotaAvailable *int64  `xml:"D:quota-available-bytes,omitempty"`
+	QuotaUsed      *int64  `xml:"D:quota-used-bytes,omitempty"`
+	Supportedlock  *rawXML `xml:"D:supportedlock,omitempty"`
+	Lockdiscovery  *rawXML `xml:"D:lockdiscovery,omitempty"`
+	Dead           []webdav.Property
+	Names          []propName
+}
+
+// rawXML 原样输出已经生成好的 XML 内容
+type rawXML struct {
+	Inner string `xml:",innerxml"`
+}
+
+type propstat struct {
+	Prop   propfindProp `xml:"D:prop"`
+	Status string       `xml:"D:status"`
+}
+
+type propfindResponse struct {
+	Href      string     `xml:"D:href"`
+	Propstats []propstat `xml:"D:propstat"`
+}
+
+// propName 输出为空元素 <D:name/>, 其它命名空间的属性带上自己的 xmlns
+type propName xml.Name
+
+func (n propName) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	name := xml.Name(n)
+	if name.Space == "DAV:" {
+		name = xml.Name{Local: "D:" + name.Local}
+	}
+	return e.EncodeElement("", xml.StartElement{Name: name})
+}
+
+type liveProp struct {
+	name    xml.Name
+	present bool
+	clear   func()
+}
+
+// live 列出 p 中的活属性, 用于按名筛选
+func (p *propfindProp) live() []liveProp {
+	dav := func(local string) xml.Name { return xml.Name{Space: "DAV:", Local: local} }
+	return []liveProp{
+		{dav("displayname"), p.Displayname != nil, func() { p.Displayname = nil }},
+		{dav("getcontenttype"), p.Getcontenttype != nil, func() { p.Getcontenttype = nil }},
+		{dav("getcontentlength"), p.Getcontentlength != nil, func() { p.Getcontentlength = nil }},
+		{dav("getetag"), p.Getetag != nil, func() { p.Getetag = nil }},
+		{dav("getcontentmd5"), p.Getcontentmd5 != nil, func() { p.Getcontentmd5 = nil }},
+		{dav("creationdate"), p.Creationdate != nil, func() { p.Creationdate = nil }},
+		{dav("getlastmodified"), p.Getlastmodified != nil, func() { p.Getlastmodified = nil }},
+		{dav("resourcetype"), p.Resourcetype != nil, func() { p.Resourcetype = nil }},
+		{quotaAvailableProp, p.QuotaAvailable != nil, func() { p.QuotaAvailable = nil }},
+		{quotaUsedProp, p.QuotaUsed != nil, func() { p.QuotaUsed = nil }},
+		{dav("supportedlock"), p.Supportedlock != nil, func() { p.Supportedlock = nil }},
+		{dav("lockdiscovery"), p.Lockdiscovery != nil, func() { p.Lockdiscovery = nil }},
+	}
+}
+
+// propstats 按请求方式生成一个条目的 propstat: allprop 原样返回全部属性, propname 只返回
+// 属性名, 按名请求时只返回请求的属性, 条目没有的属性在 404 中列出
+func (pf propfindRequest) propstats(p propfindProp) []propstat {
+	ok := statusLine(http.StatusOK)
+	switch {
+	case pf.propname:
+		var names []propName
+		for _, lp := range p.live() {
+			if lp.present {
+				names = append(names, propName(lp.name))
+			}
+		}
+		for _, d := range p.Dead {
+			names = append(names, propName(d.XMLName))
+		}
+		return []propstat{{Prop: propfindProp{Names: names}, Status: ok}}
+	case pf.allprop:
+		return []propstat{{Prop: p, Status: ok}}
+	}
+
+	found := make(map[xml.Name]bool)
+	for _, lp := range p.live() {
+		if pf.names[lp.name] && lp.present {
+			found[lp.name] = true
+		} else {
+			lp.clear()
+		}
+	}
+	var dead []webdav.Property
+	for _, d := range p.Dead {
+		if pf.names[d.XMLName] {
+			dead = append(dead, d)
+			found[d.XMLName] = true
+		}
+	}
+	p.Dead = dead
+
+	var missing []propName
+	for name := range pf.names {
+		if !found[name] {
+			missing = append(missing, propName(name))
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Space != missing[j].Space {
+			return missing[i].Space < missing[j].Space
+		}
+		return missing[i].Local < missing[j].Local
+	})
+
+	var out []propstat
+	if len(found) > 0 {
+		out = append(out, propstat{Prop: p, Status: ok})
+	}
+	if len(missing) > 0 {
+		out = append(out, propstat{Prop: propfindProp{Names: missing}, Status: statusLine(http.StatusNotFound)})
+	}
+	return out
+}
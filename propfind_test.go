@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func propfindBody(inner string) string {
+	return `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:test">` + inner + `</D:propfind>`
+}
+
+func TestPropfindBodyVariants(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	if w := proppatch(fs, "/a.mkv", propertyUpdate(`<D:set><D:prop><Z:tag>x</Z:tag></D:prop></D:set>`)); w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPPATCH: status %d", w.Code)
+	}
+
+	for name, body := range map[string]string{
+		"no body": "",
+		"allprop": propfindBody(`<D:allprop/>`),
+	} {
+		w := propfind(fs, "/a.mkv", "0", body)
+		values := okValues(t, w.Body.String())
+		for _, prop := range []string{"displayname", "getcontentlength", "getetag", "getlastmodified", "resourcetype", "tag"} {
+			if _, ok := values[prop]; !ok {
+				t.Errorf("%s: %s missing from %v", name, prop, values)
+			}
+		}
+		if values["getcontentlength"] != "10" || values["tag"] != "x" {
+			t.Errorf("%s: values %v", name, values)
+		}
+	}
+
+	w := propfind(fs, "/a.mkv", "0", propfindBody(`<D:propname/>`))
+	statuses := propStatuses(t, w.Body.String())
+	if len(statuses) != 1 || !strings.Contains(statuses[http.StatusOK], "displayname") || !strings.Contains(statuses[http.StatusOK], "tag") {
+		t.Errorf("propname: %v", statuses)
+	}
+	for prop, v := range okValues(t, w.Body.String()) {
+		if v != "" {
+			t.Errorf("propname returned a value for %s: %q", prop, v)
+		}
+	}
+
+	w = propfind(fs, "/a.mkv", "0", propfindBody(`<D:prop><D:displayname/><Z:tag/><Z:missing/><D:getcontentlength/></D:prop>`))
+	statuses = propStatuses(t, w.Body.String())
+	if statuses[http.StatusOK] != "displayname,getcontentlength,tag" || statuses[http.StatusNotFound] != "missing" || len(statuses) != 2 {
+		t.Errorf("named props: %v", statuses)
+	}
+
+	if w := propfind(fs, "/a.mkv", "0", "<D:propfind"); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed body: status %d, want 400", w.Code)
+	}
+}
+
+func TestPropfindEscapesNamesAndValues(t *testing.T) {
+	names := []string{"Tom & Jerry <1>.mkv", "<![CDATA[x]]>.mkv", "a>b&amp;.mkv"}
+	var list strings.Builder
+	for i, name := range names {
+		fmt.Fprintf(&list, "/d/%d.mkv#1#%s\n", i, name)
+	}
+	list.WriteString("/d/<&>.nfo#1#<&>.nfo\n")
+	fs := newTestFS(t, list.String())
+
+	// /api/batch 和 PROPPATCH 设置的值
+	batch := `{"operations":[{"op":"setprops","path":"/d/0.mkv","props":[{"ns":"urn:test","name":"note","value":"a & b < c"}]},` +
+		`{"op":"setprops","path":"/d/1.mkv","props":[{"ns":"urn:test","name":"note","value":"x ]]> y"}]}]}`
+	w := httptest.NewRecorder()
+	fs.handleBatch(w, httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(batch)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/api/batch: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := proppatch(fs, "/d/2.mkv", propertyUpdate(`<D:set><D:prop><Z:note>1 &lt; 2 &amp;&amp; 3 &gt; 2</Z:note></D:prop></D:set>`)); w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPPATCH: status %d", w.Code)
+	}
+
+	w = propfind(fs, "/d", "1", propfindBody(`<D:prop><D:displayname/><Z:note/></D:prop>`))
+	var ms multistatus
+	if err := xml.Unmarshal(w.Body.Bytes(), &ms); err != nil {
+		t.Fatalf("PROPFIND is not well-formed XML: %v\n%s", err, w.Body.String())
+	}
+	got := make(map[string]map[string]string)
+	for _, resp := range ms.Responses {
+		href, _ := url.PathUnescape(resp.Href)
+		got[href] = make(map[string]string)
+		for _, ps := range resp.Propstats {
+			if ps.Status != statusLine(http.StatusOK) {
+				continue
+			}
+			for _, p := range ps.Prop.Props {
+				got[href][p.XMLName.Local] = propText(p.InnerXML)
+			}
+		}
+	}
+	for i, name := range names {
+		if v := got[fmt.Sprintf("/d/%d.mkv", i)]["displayname"]; v != name {
+			t.Errorf("displayname %q, want %q", v, name)
+		}
+	}
+	for href, want := range map[string]string{"/d/0.mkv": "a & b < c", "/d/1.mkv": "x ]]> y", "/d/2.mkv": "1 < 2 && 3 > 2"} {
+		if v := got[href]["note"]; v != want {
+			t.Errorf("%s note = %q, want %q", href, v, want)
+		}
+	}
+	if got["/d/<&>.nfo"]["displayname"] != "<&>.nfo" {
+		t.Errorf("entries: %v", got)
+	}
+}
+
+func TestPropfindDepth(t *testing.T) {
+	fs := newTestFS(t, "/d/a.mkv#1#a.mkv\n/d/s/b.mkv#1#b.mkv\n/d/s/t/c.mkv#1#c.mkv\n")
+
+	for depth, want := range map[string]int{"0": 1, "1": 3, "": 3} {
+		if got := hrefs(t, propfind(fs, "/d", depth, "").Body.Bytes()); len(got) != want {
+			t.Errorf("Depth %q: %v, want %d responses", depth, got, want)
+		}
+	}
+	if w := propfind(fs, "/d", "2", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Depth 2: status %d, want 400", w.Code)
+	}
+
+	// 默认拒绝 infinity, 返回 propfind-finite-depth
+	w := propfind(fs, "/d", "infinity", "")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "propfind-finite-depth") {
+		t.Errorf("Depth infinity, not allowed: status %d\n%s", w.Code, w.Body.String())
+	}
+
+	// -propfind-infinity 时列出整棵子树, 超过 -propfind-infinity-max 时仍然拒绝
+	fs.propfindInfinity = true
+	got := hrefs(t, propfind(fs, "/d", "infinity", "").Body.Bytes())
+	want := []string{"/d", "/d/a.mkv", "/d/s", "/d/s/b.mkv", "/d/s/t", "/d/s/t/c.mkv"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Depth infinity, allowed: %v, want %v", got, want)
+	}
+	fs.propfindInfinityMax = 3
+	if w := propfind(fs, "/d", "infinity", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "propfind-finite-depth") {
+		t.Errorf("Depth infinity over the limit: status %d", w.Code)
+	}
+	if got := hrefs(t, propfind(fs, "/d/s", "infinity", "").Body.Bytes()); len(got) != 4 {
+		t.Errorf("Depth infinity within the limit: %v", got)
+	}
+}
+
+func TestPropfindSynthesizedProps(t *testing.T) {
+	fs := newTestFS(t, "/d/a.mkv#10#a.mkv\n")
+	body := `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:" xmlns:A="http://apache.org/dav/props/" xmlns:Z="urn:test">` +
+		`<D:prop><D:getcontentlanguage/><A:executable/><D:source/><Z:unknown/></D:prop></D:propfind>`
+
+	w := propfind(fs, "/d/a.mkv", "0", body)
+	statuses := propStatuses(t, w.Body.String())
+	if statuses[http.StatusOK] != "executable,getcontentlanguage,source" || statuses[http.StatusNotFound] != "unknown" || len(statuses) != 2 {
+		t.Errorf("file: %v", statuses)
+	}
+	values := okValues(t, w.Body.String())
+	if values["getcontentlanguage"] != "zh-CN" || values["executable"] != "F" || values["source"] != "" {
+		t.Errorf("file values: %v", values)
+	}
+
+	// 目录没有内容语言和可执行标记
+	statuses = propStatuses(t, propfind(fs, "/d", "0", body).Body.String())
+	if statuses[http.StatusOK] != "source" || statuses[http.StatusNotFound] != "executable,getcontentlanguage,unknown" {
+		t.Errorf("collection: %v", statuses)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// 由服务端计算的属性不允许客户端修改, displayname 除外
+var protectedProps = map[xml.Name]bool{
+	{Space: "DAV:", Local: "getcontentlength"}: true,
+	{Space: "DAV:", Local: "getcontenttype"}:   true,
+	{Space: "DAV:", Local: "getlastmodified"}:  true,
+	{Space: "DAV:", Local: "getetag"}:          true,
+	{Space: "DAV:", Local: "getcontentmd5"}:    true,
+	{Space: "DAV:", Local: "resourcetype"}:     true,
+	{Space: "DAV:", Local: "lockdiscovery"}:    true,
+	{Space: "DAV:", Local: "supportedlock"}:    true,
+	propVersionProp:                            true,
+}
+
+var displayNameProp = xml.Name{Space: "DAV:", Local: "displayname"}
+
+// creationDateProp 写入条目的创建时间而不是作为死属性保存, 无法解析的值返回 409
+var creationDateProp = xml.Name{Space: "DAV:", Local: "creationdate"}
+
+// propVersionProp 是条目的属性版本号, 每次属性修改成功后加一. 客户端在 PROPPATCH 时
+// 用 X-Prop-Version 头带上读到的版本号, 版本已变化则返回 412, 避免并发修改互相覆盖
+var propVersionProp = xml.Name{Space: "urn:xiaoya-webdav-proxy", Local: "propversion"}
+
+var errVersionMismatch = errors.New("属性版本不匹配")
+
+func (fs *TextWebDAVFileSystem) HandleProppatch(w http.ResponseWriter, r *http.Request) {
+	path := fs.keyPath(r.URL.Path)
+	if path == "" {
+		path = "/"
+	}
+	release, status, err := fs.confirmLocks(r, path, "")
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer release()
+
+	var update struct {
+		XMLName xml.Name `xml:"DAV: propertyupdate"`
+		Items   []struct {
+			XMLName xml.Name
+			Prop    struct {
+				Props []webdav.Property `xml:",any"`
+			} `xml:"DAV: prop"`
+		} `xml:",any"`
+	}
+	if fs.proppatchMaxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, fs.proppatchMaxBody)
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&update); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "请求体过大", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	count := 0
+	for _, item := range update.Items {
+		count += len(item.Prop.Props)
+	}
+	if fs.proppatchMaxProps > 0 && count > fs.proppatchMaxProps {
+		writeTooManyProps(w)
+		return
+	}
+
+	// 先整体校验, 任何一个属性不能修改时整批都不生效
+	var props []JournalProp
+	var forbidden, conflicts []webdav.Property
+	for _, item := range update.Items {
+		if item.XMLName.Space != "DAV:" || (item.XMLName.Local != "set" && item.XMLName.Local != "remove") {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		remove := item.XMLName.Local == "remove"
+		for _, p := range item.Prop.Props {
+			if protectedProps[p.XMLName] {
+				forbidden = append(forbidden, webdav.Property{XMLName: p.XMLName})
+				continue
+			}
+			if !remove && p.XMLName == displayNameProp && strings.TrimSpace(propText(string(p.InnerXML))) == "" {
+				conflicts = append(conflicts, webdav.Property{XMLName: p.XMLName})
+				continue
+			}
+			if !remove && p.XMLName == creationDateProp {
+				if _, ok := parseWin32Time(propText(string(p.InnerXML))); !ok {
+					conflicts = append(conflicts, webdav.Property{XMLName: p.XMLName})
+					continue
+				}
+			}
+			props = append(props, JournalProp{
+				Space:  p.XMLName.Space,
+				Name:   p.XMLName.Local,
+				Value:  string(p.InnerXML),
+				Remove: remove,
+			})
+		}
+	}
+
+	type Prop struct {
+		Props []webdav.Property
+	}
+
+	type Propstat struct {
+		Prop   Prop   `xml:"D:prop"`
+		Status string `xml:"D:status"`
+		Error  *struct {
+			Protected struct{} `xml:"D:cannot-modify-protected-property"`
+		} `xml:"D:error,omitempty"`
+	}
+
+	var propstats []Propstat
+	names := make([]webdav.Property, 0, len(props))
+	for _, p := range props {
+		names = append(names, webdav.Property{XMLName: xml.Name{Space: p.Space, Local: p.Name}})
+	}
+
+	// RFC 4918 要求 PROPPATCH 全部成功或全部不生效
+	if len(forbidden) > 0 || len(conflicts) > 0 {
+		if len(forbidden) > 0 {
+			propstats = append(propstats, Propstat{
+				Prop:   Prop{Props: forbidden},
+				Status: statusLine(http.StatusForbidden),
+				Error: &struct {
+					Protected struct{} `xml:"D:cannot-modify-protected-property"`
+				}{},
+			})
+		}
+		if len(conflicts) > 0 {
+			propstats = append(propstats, Propstat{Prop: Prop{Props: conflicts}, Status: statusLine(http.StatusConflict)})
+		}
+		if len(names) > 0 {
+			propstats = append(propstats, Propstat{Prop: Prop{Props: names}, Status: statusLine(http.StatusFailedDependency)})
+		}
+	} else {
+		fs.mu.Lock()
+		err := fs.checkPropVersionLocked(path, r.Header.Get("X-Prop-Version"))
+		if err == nil {
+			err = fs.patchLocked(path, props)
+		}
+		if err == nil {
+			fs.recordMutation(JournalRecord{Op: JournalProppatch, Path: path, Props: props})
+		}
+		fs.mu.Unlock()
+
+		if os.IsNotExist(err) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if err == errVersionMismatch {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusForbidden
+		}
+		propstats = append(propstats, Propstat{Prop: Prop{Props: names}, Status: statusLine(status)})
+	}
+
+	multistatus := struct {
+		XMLName  xml.Name `xml:"D:multistatus"`
+		XmlnsD   string   `xml:"xmlns:D,attr"`
+		Response struct {
+			Href      string     `xml:"D:href"`
+			Propstats []Propstat `xml:"D:propstat"`
+		} `xml:"D:response"`
+	}{
+		XmlnsD: "DAV:",
+	}
+	multistatus.Response.Href = hrefFor(r, path)
+	multistatus.Response.Propstats = propstats
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	xml.NewEncoder(w).Encode(multistatus)
+}
+
+func (fs *TextWebDAVFileSystem) patchLocked(path string, props []JournalProp) error {
+	if path == "/" {
+		return os.ErrPermission
+	}
+	meta, ok := fs.Files[path]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	for _, p := range props {
+		name := xml.Name{Space: p.Space, Local: p.Name}
+		if name == displayNameProp {
+			if p.Remove {
+				meta.DisplayName = filepath.Base(path)
+			} else {
+				meta.DisplayName = propText(p.Value)
+			}
+			continue
+		}
+		if name == creationDateProp {
+			meta.Created = time.Time{}
+			if !p.Remove {
+				meta.Created, _ = parseWin32Time(propText(p.Value))
+			}
+			continue
+		}
+
+		if p.Remove {
+			delete(meta.Props, name)
+			continue
+		}
+		if meta.Props == nil {
+			meta.Props = make(map[xml.Name]webdav.Property)
+		}
+		meta.Props[name] = webdav.Property{XMLName: name, InnerXML: propInnerXML(p.Value)}
+		if name.Space == win32Namespace {
+			applyWin32Time(meta, name.Local, propText(p.Value))
+		}
+	}
+	if len(props) > 0 {
+		meta.PropVersion++
+	}
+	return nil
+}
+
+// checkPropVersionLocked 在 expected 非空时校验条目的当前属性版本.
+// 所有修改都持有 fs.mu 写锁, 校验和修改在同一把锁内完成
+func (fs *TextWebDAVFileSystem) checkPropVersionLocked(path, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	meta, ok := fs.Files[path]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if v, err := strconv.ParseInt(expected, 10, 64); err != nil || v != meta.PropVersion {
+		return errVersionMismatch
+	}
+	return nil
+}
+
+// propText 取出属性值中的纯文本, 解码其中的 XML 实体
+func propText(inner string) string {
+	var v struct {
+		Text string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal([]byte("<v>"+inner+"</v>"), &v); err != nil {
+		return inner
+	}
+	return v.Text
+}
+
+// propInnerXML 把属性值变成可以原样写进响应的 XML 内容. PROPPATCH 传来的值本身就是 XML,
+// 带结构的值 (如 <Z:tag>...</Z:tag>) 原样保留; /api/batch 和列表中的纯文本可能含有
+// & < > 等字符, 原样输出会让整个 multistatus 无法解析, 这时按文本转义
+func propInnerXML(v string) []byte {
+	d := xml.NewDecoder(strings.NewReader("<v>" + v + "</v>"))
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			return []byte(v)
+		} else if err != nil {
+			break
+		}
+	}
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(v))
+	return b.Bytes()
+}
+
+// writeTooManyProps 在单个 PROPPATCH 的属性数超过上限时返回 507, 不做任何修改
+func writeTooManyProps(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusInsufficientStorage)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<D:error xmlns:D="DAV:" xmlns:X="urn:xiaoya-webdav-proxy"><X:too-many-properties/></D:error>`)
+}
+
+func statusLine(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
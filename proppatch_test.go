@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// multistatus 是 PROPPATCH/PROPFIND 响应中测试关心的部分
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Prop struct {
+				Props []struct {
+					XMLName  xml.Name
+					InnerXML string `xml:",innerxml"`
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propStatuses 按状态码列出第一个 response 中的属性名, 同一状态码下按名称排序
+func propStatuses(t *testing.T, body string) map[int]string {
+	t.Helper()
+	var ms multistatus
+	if err := xml.Unmarshal([]byte(body), &ms); err != nil {
+		t.Fatalf("invalid multistatus: %v\n%s", err, body)
+	}
+	if len(ms.Responses) == 0 {
+		t.Fatalf("multistatus without responses:\n%s", body)
+	}
+	out := make(map[int]string)
+	for _, ps := range ms.Responses[0].Propstats {
+		fields := strings.Fields(ps.Status)
+		if len(fields) < 2 {
+			t.Fatalf("bad status line %q", ps.Status)
+		}
+		code, err := strconv.Atoi(fields[1])
+		if err != nil {
+			t.Fatalf("bad status line %q", ps.Status)
+		}
+		var names []string
+		for _, p := range ps.Prop.Props {
+			names = append(names, p.XMLName.Local)
+		}
+		sort.Strings(names)
+		out[code] = strings.Join(names, ",")
+	}
+	return out
+}
+
+func proppatch(fs *TextWebDAVFileSystem, name, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	fs.HandleProppatch(w, httptest.NewRequest("PROPPATCH", name, strings.NewReader(body)))
+	return w
+}
+
+func propertyUpdate(items ...string) string {
+	return `<?xml version="1.0" encoding="utf-8"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test">` +
+		strings.Join(items, "") + `</D:propertyupdate>`
+}
+
+func TestProppatchEchoesEveryProperty(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+
+	w := proppatch(fs, "/a.mkv", propertyUpdate(
+		`<D:set><D:prop><D:displayname>战狼2</D:displayname><Z:tag>x</Z:tag></D:prop></D:set>`,
+		`<D:remove><D:prop><Z:missing/></D:prop></D:remove>`,
+	))
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status %d", w.Code)
+	}
+	got := propStatuses(t, w.Body.String())
+	if len(got) != 1 || got[http.StatusOK] != "displayname,missing,tag" {
+		t.Errorf("propstats = %v, want every touched property under 200", got)
+	}
+	if fs.Files["/a.mkv"].DisplayName != "战狼2" {
+		t.Errorf("displayname = %q", fs.Files["/a.mkv"].DisplayName)
+	}
+}
+
+func TestProppatchGroupsFailuresByStatus(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+
+	w := proppatch(fs, "/a.mkv", propertyUpdate(
+		`<D:set><D:prop><D:getcontentlength>1</D:getcontentlength><Z:tag>x</Z:tag>`+
+			`<D:displayname> </D:displayname><D:creationdate>not a date</D:creationdate></D:prop></D:set>`,
+		`<D:remove><D:prop><Z:other/></D:prop></D:remove>`,
+	))
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status %d", w.Code)
+	}
+	got := propStatuses(t, w.Body.String())
+	want := map[int]string{
+		http.StatusForbidden:        "getcontentlength",
+		http.StatusConflict:         "creationdate,displayname",
+		http.StatusFailedDependency: "other,tag",
+	}
+	if len(got) != len(want) {
+		t.Errorf("propstats = %v, want %v", got, want)
+	}
+	for code, names := range want {
+		if got[code] != names {
+			t.Errorf("%d: %q, want %q", code, got[code], names)
+		}
+	}
+	if !strings.Contains(w.Body.String(), "cannot-modify-protected-property") {
+		t.Error("403 propstat without the cannot-modify-protected-property precondition")
+	}
+	meta := fs.Files["/a.mkv"]
+	if meta.DisplayName != "a.mkv" || len(meta.Props) != 0 || meta.PropVersion != 0 {
+		t.Errorf("a failed PROPPATCH changed the entry: %+v", meta)
+	}
+}
+
+func propfind(fs *TextWebDAVFileSystem, name, depth, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PROPFIND", name, strings.NewReader(body))
+	r.Header.Set("Depth", depth)
+	w := httptest.NewRecorder()
+	fs.HandlePropfind(w, r)
+	return w
+}
+
+func TestProppatchRemoveIsHonouredAndJournaled(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	file := filepath.Join(t.TempDir(), "journal")
+	journal, err := OpenJournal(file, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	fs.journal = journal
+
+	proppatch(fs, "/a.mkv", propertyUpdate(`<D:set><D:prop><Z:tag>x</Z:tag><D:displayname>战狼2</D:displayname></D:prop></D:set>`))
+	if len(fs.Files["/a.mkv"].Props) != 1 {
+		t.Fatalf("set did not store the property: %v", fs.Files["/a.mkv"].Props)
+	}
+	remove := proppatch(fs, "/a.mkv", propertyUpdate(`<D:remove><D:prop><Z:tag/><D:displayname/></D:prop></D:remove>`))
+	if got := propStatuses(t, remove.Body.String()); got[http.StatusOK] != "displayname,tag" {
+		t.Errorf("remove propstats = %v, want both properties under 200", got)
+	}
+	if meta := fs.Files["/a.mkv"]; meta.DisplayName != "a.mkv" || len(meta.Props) != 0 {
+		t.Errorf("after remove: displayname %q, props %v", meta.DisplayName, meta.Props)
+	}
+
+	w := propfind(fs, "/a.mkv", "0", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:test"><D:prop><Z:tag/></D:prop></D:propfind>`)
+	if got := propStatuses(t, w.Body.String()); got[http.StatusNotFound] != "tag" {
+		t.Errorf("PROPFIND after remove = %v, want tag under 404", got)
+	}
+
+	if err := journal.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadJournal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Op != JournalProppatch || len(records[1].Props) != 2 || !records[1].Props[0].Remove {
+		t.Fatalf("journal = %+v, want the removal recorded", records)
+	}
+
+	// 重放到重新加载的列表上, 删除同样生效
+	fresh := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	fresh.mu.Lock()
+	for _, rec := range records {
+		if err := fresh.applyRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fresh.mu.Unlock()
+	if meta := fresh.Files["/a.mkv"]; meta.DisplayName != "a.mkv" || len(meta.Props) != 0 {
+		t.Errorf("after replay: displayname %q, props %v", meta.DisplayName, meta.Props)
+	}
+}
+
+func TestProppatchLimits(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	fs.proppatchMaxProps = 3
+
+	var many strings.Builder
+	for i := 0; i < 4; i++ {
+		many.WriteString("<Z:p" + strconv.Itoa(i) + ">x</Z:p" + strconv.Itoa(i) + ">")
+	}
+	w := proppatch(fs, "/a.mkv", propertyUpdate(`<D:set><D:prop>`+many.String()+`</D:prop></D:set>`))
+	if w.Code != http.StatusInsufficientStorage || !strings.Contains(w.Body.String(), "too-many-properties") {
+		t.Errorf("too many properties: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	fs.proppatchMaxBody = 64
+	w = proppatch(fs, "/a.mkv", propertyUpdate(`<D:set><D:prop><Z:big>`+strings.Repeat("x", 100)+`</Z:big></D:prop></D:set>`))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d", w.Code)
+	}
+
+	if meta := fs.Files["/a.mkv"]; len(meta.Props) != 0 || meta.PropVersion != 0 {
+		t.Errorf("a rejected PROPPATCH changed the entry: %+v", meta)
+	}
+}
+
+func TestProppatchFailureInTheMiddleAppliesNothing(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+
+	w := proppatch(fs, "/a.mkv", propertyUpdate(
+		`<D:set><D:prop><Z:first>1</Z:first><D:displayname>新名</D:displayname></D:prop></D:set>`,
+		`<D:set><D:prop><D:getetag>"x"</D:getetag></D:prop></D:set>`,
+		`<D:set><D:prop><Z:last>2</Z:last></D:prop></D:set>`,
+	))
+	if got := propStatuses(t, w.Body.String()); got[http.StatusForbidden] != "getetag" || got[http.StatusFailedDependency] != "displayname,first,last" {
+		t.Errorf("propstats = %v", got)
+	}
+	if meta := fs.Files["/a.mkv"]; meta.DisplayName != "a.mkv" || len(meta.Props) != 0 || meta.PropVersion != 0 {
+		t.Errorf("partial application: %+v", meta)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNoBackend = errors.New("没有可用的上游地址")
+
+// upstreamURLs 按优先顺序返回条目的上游地址: 路径匹配 -backend-map 时按映射的上游
+// (可能有多个镜像) 拼出地址, 其次使用列表或抓取时记录的地址, 再其次按 -url-template
+// 生成, 最后在配置了 -backend 时按路径拼出地址. 都没有时返回 nil
+func (fs *TextWebDAVFileSystem) upstreamURLs(meta *FileMeta) []string {
+	if targets := fs.backends.Resolve(meta.Path); len(targets) > 0 {
+		return targets
+	}
+	if meta.URL != "" {
+		return []string{meta.URL}
+	}
+	if target := fs.urlTemplates.Resolve(meta.Path); target != "" {
+		return []string{target}
+	}
+	if fs.backend == nil {
+		return nil
+	}
+	return []string{fs.backend.ResolveReference(&url.URL{Path: path.Join(fs.backend.Path, meta.Path)}).String()}
+}
+
+// upstreamURL 返回首选的上游地址, 没有时返回空串
+func (fs *TextWebDAVFileSystem) upstreamURL(meta *FileMeta) string {
+	if targets := fs.upstreamURLs(meta); len(targets) > 0 {
+		return targets[0]
+	}
+	return ""
+}
+
+// openUpstream 向上游发起 GET, rangeHeader 非空时原样作为 Range 头.
+// 依次尝试条目的各个上游, 连接失败或 5xx 时换下一个, 都失败时退避后重新来一轮.
+// avoid 是刚刚传输中断的上游, 排到其它上游之后. 返回实际使用的上游地址
+func (fs *TextWebDAVFileSystem) openUpstream(ctx context.Context, meta *FileMeta, rangeHeader, avoid string) (*http.Response, string, error) {
+	targets := fs.upstreamURLs(meta)
+	if len(targets) == 0 {
+		return nil, "", errNoBackend
+	}
+	if fs.prober.offline(targets) {
+		return nil, "", errBackendOffline
+	}
+	targets = fs.health.order(fs.prober.online(targets), avoid)
+
+	ctx, span := startSpanKind(ctx, "upstream GET", spanKindClient)
+	span.SetPath("path", meta.Path)
+	defer span.End()
+
+	for retries := 0; ; {
+		var (
+			lastResp *http.Response
+			lastErr  error
+		)
+		for i, target := range targets {
+			if lastResp != nil {
+				lastResp.Body.Close()
+				lastResp = nil
+			}
+			start := time.Now()
+			resp, err := fs.fetchUpstream(ctx, meta, target, rangeHeader)
+			fs.backendMetrics.observe(target, resp, err, time.Since(start), retries > 0)
+			if err == nil && resp.StatusCode < 500 {
+				fs.health.succeed(target)
+				span.SetInt("http.status_code", int64(resp.StatusCode))
+				return resp, target, nil
+			}
+			if ctx.Err() != nil {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				span.SetError(ctx.Err())
+				return nil, "", ctx.Err()
+			}
+			fs.health.fail(target)
+			lastResp, lastErr = resp, err
+			if i < len(targets)-1 {
+				fs.backendMetrics.failover(target)
+				fmt.Printf("上游 %s 不可用, 改用下一个上游: %s\n", backendKey(target), meta.Path)
+			}
+		}
+
+		// 连接失败和 5xx 视为暂时性错误, 退避后重试; 客户端已断开时不再重试
+		if retries >= fs.upstreamRetries {
+			if lastErr != nil {
+				span.SetError(lastErr)
+				return nil, "", lastErr
+			}
+			span.SetInt("http.status_code", int64(lastResp.StatusCode))
+			return lastResp, targets[len(targets)-1], nil
+		}
+		retries++
+		if lastErr != nil {
+			fmt.Printf("请求上游失败, 第 %d 次重试: %s: %v\n", retries, meta.Path, lastErr)
+		} else {
+			lastResp.Body.Close()
+			fmt.Printf("上游返回 %d, 第 %d 次重试: %s\n", lastResp.StatusCode, retries, meta.Path)
+		}
+		if err := fs.waitRetry(ctx, retries); err != nil {
+			span.SetError(err)
+			return nil, "", err
+		}
+	}
+}
+
+// fetchUpstream 向一个上游地址发起 GET. 优先使用缓存的跳转后地址, 失效时回到原地址;
+// 签名被拒绝时重新签名再试一次
+func (fs *TextWebDAVFileSystem) fetchUpstream(ctx context.Context, meta *FileMeta, target, rangeHeader string) (*http.Response, error) {
+	resigned, useCache := false, true
+	for {
+		reqURL, cached := fs.resolved.Get(target)
+		if !useCache || !cached {
+			reqURL, cached = fs.signedURL(target), false
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		fs.authorizeUpstream(req)
+		fs.setUserAgent(ctx, req, meta.Path)
+		fs.upstreamHeaders.apply(req, target)
+		injectTraceContext(ctx, req.Header)
+
+		resp, err := fs.fetcher.Do(req)
+		if err != nil {
+			return nil, redactError(err)
+		}
+		// 缓存的最终地址返回 403/410 说明其中的签名已经过期, 丢掉缓存从原地址重新跳转
+		if cached && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusGone) {
+			resp.Body.Close()
+			fs.resolved.Invalidate(target)
+			useCache = false
+			continue
+		}
+		if !cached && resp.StatusCode < 300 {
+			if final := resp.Request.URL.String(); final != reqURL {
+				fs.resolved.Put(target, final)
+			}
+		}
+		// 签名过期时上游返回 401/403, 按当前时间重新签名后重试一次
+		if fs.signer != nil && !resigned && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			resp.Body.Close()
+			resigned = true
+			fmt.Printf("上游拒绝了签名 (%d), 重新签名后重试: %s\n", resp.StatusCode, meta.Path)
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// serveUpstream 把 GET 转发给上游并流式返回, 客户端的 Range 原样带给上游.
+// 上游明确 404/410 时标记为上游缺失并返回 404, 其它失败返回 502
+func (fs *TextWebDAVFileSystem) serveUpstream(w http.ResponseWriter, r *http.Request, meta *FileMeta) {
+	if notModified(w, r, meta) {
+		return
+	}
+	if !ifRangeMatches(r, meta) {
+		r.Header.Del("Range")
+	}
+	// 可以缓存的小文件总是向上游要完整内容, 客户端的 Range 从缓存中截取
+	rangeHeader := r.Header.Get("Range")
+	cacheable := fs.contentCache.accepts(meta)
+	if cacheable {
+		rangeHeader = ""
+	}
+	resp, source, err := fs.openUpstream(r.Context(), meta, rangeHeader, "")
+	if err == errNoBackend {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err == errBackendOffline {
+		fs.prober.serveOffline(w)
+		return
+	}
+	if err != nil {
+		fmt.Printf("请求上游失败: %s: %v\n", meta.Path, err)
+		http.Error(w, "请求上游失败", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if fs.checkUpstreamGone(meta.Path, resp.StatusCode) {
+		http.Error(w, "上游文件已不存在", http.StatusNotFound)
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		w.Header().Set("Content-Range", resp.Header.Get("Content-Range"))
+		http.Error(w, "Range 无效", http.StatusRequestedRangeNotSatisfiable)
+		return
+	default:
+		fmt.Printf("上游返回 %d: %s (%s)\n", resp.StatusCode, meta.Path, redactURL(source))
+		http.Error(w, fmt.Sprintf("上游返回 %d", resp.StatusCode), http.StatusBadGateway)
+		return
+	}
+	fs.verifyUpstreamSize(meta.Path, resp)
+	if size, ok := upstreamSize(resp); ok {
+		fs.recordSize(meta.Path, size)
+	}
+	if cacheable && resp.StatusCode == http.StatusOK && resp.ContentLength == meta.Size {
+		if err := fs.fillCache(w, r, meta, resp); err != nil {
+			fmt.Printf("读取上游失败: %s: %v\n", meta.Path, redactError(err))
+			http.Error(w, "请求上游失败", http.StatusBadGateway)
+			return
+		}
+		fs.backendMetrics.addBytes(source, meta.Size)
+		return
+	}
+
+	h := w.Header()
+	for _, k := range []string{"Content-Length", "Content-Range", "Accept-Ranges"} {
+		if v := resp.Header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+	status := resp.StatusCode
+	// 分块传输的上游没有 Content-Length, 按 Content-Range 或已确认的大小补上, 播放器没有长度时
+	// 不能拖动. 列表中的大小可能已经过时, 没有确认过时先向上游查询, 仍然不知道就不返回长度
+	if h.Get("Content-Length") == "" {
+		if start, end, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && status == http.StatusPartialContent {
+			h.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		} else if status == http.StatusOK {
+			if size, ok := fs.verifiedSize(r.Context(), meta); ok {
+				h.Set("Content-Length", strconv.FormatInt(size, 10))
+			}
+		}
+	}
+	rr := fs.newResumeReader(r.Context(), meta, resp, source)
+	ranged := status == http.StatusPartialContent || resp.Header.Get("Accept-Ranges") == "bytes"
+	src := fs.withReadAhead(r.Context(), rr, ranged)
+	defer src.Close()
+	body := io.Reader(src)
+	// 上游忽略了 Range 返回整个文件时, 丢掉前面的部分自己截出客户端要的范围
+	if rh := r.Header.Get("Range"); rh != "" && status == http.StatusOK {
+		total := resp.ContentLength
+		if total < 0 {
+			total = meta.Size
+		}
+		if start, length, ok := parseSingleRange(rh, total); ok {
+			if _, err := io.CopyN(io.Discard, src, start); err != nil {
+				http.Error(w, "请求上游失败", http.StatusBadGateway)
+				return
+			}
+			body = io.LimitReader(src, length)
+			status = http.StatusPartialContent
+			h.Set("Content-Length", strconv.FormatInt(length, 10))
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, total))
+		}
+	}
+	h.Set("Accept-Ranges", "bytes")
+	ctype := mime.TypeByExtension(path.Ext(meta.Path))
+	if ctype == "" {
+		ctype = resp.Header.Get("Content-Type")
+	}
+	if ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("ETag", meta.etag())
+	h.Set("Last-Modified", httpDate(meta.ModTime))
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, body); err != nil {
+		fmt.Printf("转发 %s 中断: %v\n", meta.Path, redactError(err))
+	}
+}
+
+// serveHead 只用元数据回答文件的 HEAD, 不打开上游也不读取内容,
+// 上游不可达时同样立即返回. 列表中大小未知的文件先向上游查询一次, 仍然未知时不返回 Content-Length
+func (fs *TextWebDAVFileSystem) serveHead(w http.ResponseWriter, r *http.Request, meta *FileMeta) {
+	fs.resolveSize(r.Context(), meta.Path)
+	if notModified(w, r, meta) {
+		return
+	}
+	// meta 是请求开始时的副本, 刚查到的大小在树中的条目上
+	size := meta.Size
+	fs.mu.RLock()
+	if cur, ok := fs.Files[meta.Path]; ok {
+		size = cur.Size
+	}
+	fs.mu.RUnlock()
+	if meta.Content != nil {
+		size = int64(len(meta.Content))
+	}
+	ctype := mime.TypeByExtension(path.Ext(meta.Path))
+	if ctype == "" && meta.Content != nil {
+		ctype = http.DetectContentType(meta.Content)
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	h := w.Header()
+	if size >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Type", ctype)
+	h.Set("ETag", meta.etag())
+	h.Set("Last-Modified", httpDate(meta.ModTime))
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseSingleRange 解析只有一段的 Range 头 (bytes=a-b、bytes=a-、bytes=-n),
+// 返回起点和长度. 多段或无法满足的范围返回 false, 此时按整个文件返回
+func parseSingleRange(h string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(h, "bytes=")
+	if !found || strings.Contains(spec, ",") || size <= 0 {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+// readUpstream 从上游读取 f.pos 处的内容. 连续读取复用同一个响应,
+// Seek 到别处后下一次读取按新位置重新发起 Range 请求, 中途断开时自动续传.
+// 开启预读时, 向前 Seek 到已预读的范围内直接跳过缓冲区中的内容
+func (f *VirtualFile) readUpstream(p []byte) (int, error) {
+	// 大小未知时读到上游结束为止
+	known := f.length >= 0
+	if known && f.pos >= f.length {
+		return 0, io.EOF
+	}
+	if f.body != nil && f.bodyPos != f.pos {
+		if ra, ok := f.body.(interface{ skipTo(int64) bool }); ok && ra.skipTo(f.pos) {
+			f.bodyPos = f.pos
+		} else {
+			f.body.Close()
+			f.body = nil
+		}
+	}
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if f.body == nil {
+		// 条目可能同时被其它请求修改, 上游请求用锁内取得的副本
+		f.fs.mu.RLock()
+		meta := *f.meta
+		f.fs.mu.RUnlock()
+		resp, source, err := f.fs.openUpstream(ctx, &meta, "bytes="+strconv.FormatInt(f.pos, 10)+"-", "")
+		if err != nil {
+			return 0, err
+		}
+		if f.fs.checkUpstreamGone(meta.Path, resp.StatusCode) {
+			resp.Body.Close()
+			return 0, fmt.Errorf("上游文件已不存在: %s", meta.Path)
+		}
+		// 大小未知时 Seek 到结尾之后, 上游对超出范围的 Range 返回 416
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			resp.Body.Close()
+			return 0, io.EOF
+		}
+		// 不支持 Range 的上游返回 200, 只能从头读起再丢掉前面的部分
+		if resp.StatusCode == http.StatusOK && f.pos > 0 {
+			if _, err := io.CopyN(io.Discard, resp.Body, f.pos); err != nil {
+				resp.Body.Close()
+				return 0, err
+			}
+		} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return 0, fmt.Errorf("上游返回 %d: %s", resp.StatusCode, meta.Path)
+		}
+		rr := f.fs.newResumeReader(ctx, &meta, resp, source)
+		rr.pos = f.pos
+		if known {
+			rr.end = f.length - 1
+		}
+		f.body = f.fs.withReadAhead(ctx, rr, resp.StatusCode == http.StatusPartialContent)
+		f.bodyPos = f.pos
+	}
+
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	f.bodyPos = f.pos
+	if err == io.EOF && known && f.pos < f.length {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// notModified 按 If-None-Match / If-Modified-Since 判断客户端缓存是否仍然有效,
+// 与 PROPFIND 的 getetag、getlastmodified 使用同样的值. 有效时直接返回 304
+func notModified(w http.ResponseWriter, r *http.Request, meta *FileMeta) bool {
+	etag := meta.etag()
+	match := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				match = true
+				break
+			}
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		match = !meta.ModTime.Truncate(time.Second).After(ims)
+	}
+	if !match {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", httpDate(meta.ModTime))
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ifRangeMatches 报告 If-Range 是否允许只返回 Range 指定的部分. 不匹配时应返回整个文件,
+// 客户端手里的旧片段不能和新内容拼在一起. ETag 按强比较, 弱 ETag 永远不匹配;
+// 日期必须与 Last-Modified 精确到秒相等
+func ifRangeMatches(r *http.Request, meta *FileMeta) bool {
+	ir := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		etag := meta.etag()
+		return !strings.HasPrefix(ir, "W/") && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && meta.ModTime.Truncate(time.Second).Equal(t)
+}
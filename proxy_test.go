@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// chunkedUpstream 返回分块传输、不带 Content-Length 的完整内容; ranges 为 true 时按 Range 返回 206
+func chunkedUpstream(t *testing.T, body []byte, ranges bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ranges && r.Header.Get("Range") != "" {
+			http.ServeContent(w, r, "", testModTime, bytes.NewReader(body))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func withBackend(t *testing.T, fs *TextWebDAVFileSystem, srv *httptest.Server) {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.backend = u
+}
+
+func getUpstream(fs *TextWebDAVFileSystem, name string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, name, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	fs.mu.RLock()
+	meta := *fs.Files[name]
+	fs.mu.RUnlock()
+	w := httptest.NewRecorder()
+	fs.serveUpstream(w, r, &meta)
+	return w
+}
+
+func TestChunkedUpstreamUsesProbedSize(t *testing.T) {
+	body := []byte("the list says eleven bytes")
+	fs := newTestFS(t, "/a.mkv#11#a.mkv\n")
+	withBackend(t, fs, chunkedUpstream(t, body, true))
+
+	w := getUpstream(fs, "/a.mkv", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if got, want := w.Header().Get("Content-Length"), "26"; got != want {
+		t.Errorf("Content-Length = %q, want the probed %q, not the stale list size", got, want)
+	}
+	if !bytes.Equal(w.Body.Bytes(), body) {
+		t.Errorf("body = %q", w.Body.Bytes())
+	}
+}
+
+func TestChunkedUpstreamUsesVerifiedSize(t *testing.T) {
+	body := []byte("0123456789")
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	fs.Files["/a.mkv"].SizeVerified = true
+	srv := chunkedUpstream(t, body, false)
+	withBackend(t, fs, srv)
+
+	w := getUpstream(fs, "/a.mkv", nil)
+	if got := w.Header().Get("Content-Length"); got != "10" {
+		t.Errorf("Content-Length = %q, want 10", got)
+	}
+}
+
+func TestChunkedUpstreamWithoutVerifiedSize(t *testing.T) {
+	body := []byte("no range support here")
+	fs := newTestFS(t, "/a.mkv#5#a.mkv\n")
+	withBackend(t, fs, chunkedUpstream(t, body, false))
+
+	w := getUpstream(fs, "/a.mkv", nil)
+	if got := w.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q for an unverified size, want none", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), body) {
+		t.Errorf("body = %q", w.Body.Bytes())
+	}
+}
+
+func TestChunkedUpstreamPartialContentLength(t *testing.T) {
+	body := []byte("0123456789")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 2-5/10")
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		w.Write(body[2:6])
+	}))
+	defer srv.Close()
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	withBackend(t, fs, srv)
+
+	w := getUpstream(fs, "/a.mkv", http.Header{"Range": {"bytes=2-5"}})
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("status %d, Content-Length %q", w.Code, w.Header().Get("Content-Length"))
+	}
+}
+
+// droppingUpstream 按 Range 返回 body, 第一次请求写出 dropAfter 字节后断开连接
+func droppingUpstream(t *testing.T, body []byte, dropAfter int) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := len(ranges) == 0
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		if !first {
+			http.ServeContent(w, r, "", testModTime, bytes.NewReader(body))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body[:dropAfter])
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &ranges
+}
+
+func TestServeUpstreamStreamsRange(t *testing.T) {
+	body := []byte("0123456789abcdef")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+	fs := newTestFS(t, "/a.mkv#16#a.mkv\n")
+	withBackend(t, fs, srv)
+
+	w := getUpstream(fs, "/a.mkv", http.Header{"Range": {"bytes=4-9"}})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status %d", w.Code)
+	}
+	if got := w.Body.String(); got != "456789" {
+		t.Errorf("body = %q, want the upstream bytes for the range", got)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 4-9/16" {
+		t.Errorf("Content-Range = %q", got)
+	}
+}
+
+func TestServeUpstreamResumesAfterDrop(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+	srv, ranges := droppingUpstream(t, body, 2500)
+	fs := newTestFS(t, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+	withBackend(t, fs, srv)
+
+	w := getUpstream(fs, "/a.mkv", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), body) {
+		t.Fatalf("got %d of %d bytes, or the bytes around the drop differ", w.Body.Len(), len(body))
+	}
+	if len(*ranges) != 2 || !strings.HasPrefix((*ranges)[1], "bytes=2500-") {
+		t.Errorf("upstream ranges = %q, want a resume from byte 2500", *ranges)
+	}
+}
+
+func TestServeUpstreamWithoutBackend(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#16#a.mkv\n")
+
+	w := getUpstream(fs, "/a.mkv", nil)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502 instead of fabricated content", w.Code)
+	}
+}
+
+// flakyUpstream 按 Range 返回 body, 前 drops 次请求在响应体中随机的位置断开连接
+func flakyUpstream(t *testing.T, body []byte, drops int, seed int64) (*httptest.Server, *int32) {
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewSource(seed))
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		mu.Lock()
+		cut := rnd.Float64()
+		mu.Unlock()
+
+		start, length := int64(0), int64(len(body))
+		status := http.StatusOK
+		if s, l, ok := parseSingleRange(r.Header.Get("Range"), int64(len(body))); ok {
+			start, length, status = s, l, http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", s, s+l-1, len(body)))
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		data := body[start : start+length]
+		if int(n) > drops || len(data) < 2 {
+			w.Write(data)
+			return
+		}
+		w.Write(data[:1+int(cut*float64(len(data)-1))])
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestServeUpstreamResumesAtRandomOffsets(t *testing.T) {
+	body := make([]byte, 64<<10)
+	for i := range body {
+		body[i] = byte(i * 31)
+	}
+	for seed := int64(1); seed <= 5; seed++ {
+		srv, requests := flakyUpstream(t, body, 6, seed)
+		fs := newTestFS(t, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+		withBackend(t, fs, srv)
+		fs.resumeAttempts = 10
+
+		w := getUpstream(fs, "/a.mkv", nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), body) {
+			t.Fatalf("seed %d: status %d, %d of %d bytes; bytes were lost or duplicated", seed, w.Code, w.Body.Len(), len(body))
+		}
+		if got := atomic.LoadInt32(requests); got != 7 {
+			t.Errorf("seed %d: %d upstream requests, want 7 (six drops, then the rest)", seed, got)
+		}
+	}
+}
+
+func TestServeUpstreamGivesUpAfterResumeAttempts(t *testing.T) {
+	body := make([]byte, 64<<10)
+	srv, requests := flakyUpstream(t, body, 100, 1)
+	fs := newTestFS(t, fmt.Sprintf("/a.mkv#%d#a.mkv\n", len(body)))
+	withBackend(t, fs, srv)
+	fs.resumeAttempts = 2
+
+	w := getUpstream(fs, "/a.mkv", nil)
+	if w.Body.Len() >= len(body) {
+		t.Errorf("%d bytes delivered from an upstream that always drops", w.Body.Len())
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("%d upstream requests, want the first and two resumes", got)
+	}
+}
+
+func TestGetAndHeadHeaderSet(t *testing.T) {
+	body := []byte("0123456789")
+	for _, tt := range []struct {
+		desc string
+		srv  *httptest.Server
+	}{
+		{"upstream with headers", rangedUpstream(t, body)},
+		{"chunked upstream", chunkedUpstream(t, body, true)},
+	} {
+		fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+		withBackend(t, fs, tt.srv)
+		fs.Files["/a.mkv"].ModTime = testModTime
+
+		for _, req := range []struct {
+			name   string
+			w      *httptest.ResponseRecorder
+			status int
+			length string
+		}{
+			{"HEAD", head(fs, "/a.mkv", nil), http.StatusOK, "10"},
+			{"GET", getUpstream(fs, "/a.mkv", nil), http.StatusOK, "10"},
+			{"ranged GET", getUpstream(fs, "/a.mkv", http.Header{"Range": {"bytes=2-4"}}), http.StatusPartialContent, "3"},
+		} {
+			h := req.w.Header()
+			if req.w.Code != req.status {
+				t.Errorf("%s, %s: status %d", tt.desc, req.name, req.w.Code)
+			}
+			if h.Get("Accept-Ranges") != "bytes" || h.Get("Content-Length") != req.length || h.Get("Last-Modified") != httpDate(testModTime) {
+				t.Errorf("%s, %s: Accept-Ranges %q, Content-Length %q, Last-Modified %q", tt.desc, req.name,
+					h.Get("Accept-Ranges"), h.Get("Content-Length"), h.Get("Last-Modified"))
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/xml"
+	"path"
+	"sync"
+)
+
+var (
+	quotaAvailableProp = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+	quotaUsedProp      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+)
+
+// unboundedQuota 是没有设置 -quota 时报告的可用空间 (1 PiB). 不输出该属性时 Finder
+// 等客户端会显示可用 0 字节, 数值过大时部分客户端又会溢出
+const unboundedQuota = 1 << 50
+
+// QuotaUsage 缓存每个目录下所有文件的大小之和 (RFC 4331 的 quota-used-bytes).
+// 第一次查询时遍历一遍目录树算出所有目录, 目录树有任何修改后整体作废
+type QuotaUsage struct {
+	limit int64
+
+	mu   sync.Mutex
+	used map[string]int64
+}
+
+// NewQuotaUsage 创建用量缓存, limit 为 0 表示不限制
+func NewQuotaUsage(limit int64) *QuotaUsage {
+	return &QuotaUsage{limit: limit}
+}
+
+// invalidate 在目录树修改后调用, 下一次查询时重新计算
+func (q *QuotaUsage) invalidate() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.used = nil
+	q.mu.Unlock()
+}
+
+// usage 返回 dir 的已用和可用字节数. 可用空间按整棵树计算, 与请求的目录无关.
+// 调用方持有 fs.mu 读锁
+func (fs *TextWebDAVFileSystem) usage(dir string) (used, available int64) {
+	q := fs.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used == nil {
+		q.used = make(map[string]int64)
+		for p, meta := range fs.Files {
+			// 大小未知的文件不计入
+			if meta.IsDir || meta.Size <= 0 {
+				continue
+			}
+			for d := path.Dir(p); ; d = path.Dir(d) {
+				q.used[d] += meta.Size
+				if d == "/" {
+					break
+				}
+			}
+		}
+	}
+
+	if q.limit <= 0 {
+		return q.used[dir], unboundedQuota
+	}
+	available = q.limit - q.used["/"]
+	if available < 0 {
+		available = 0
+	}
+	return q.used[dir], available
+}
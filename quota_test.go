@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+const quotaPropfind = `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop><D:quota-available-bytes/><D:quota-used-bytes/></D:prop></D:propfind>`
+
+func TestQuotaOnRootAndNestedCollections(t *testing.T) {
+	fs := newTestFS(t, "/a/x.mkv#100#x.mkv\n/a/b/y.mkv#50#y.mkv\n/c.mkv#25#c.mkv\n/a/unknown.mkv#-1#unknown.mkv\n")
+	fs.quota = NewQuotaUsage(1000)
+
+	for dir, used := range map[string]int64{"/": 175, "/a": 150, "/a/b": 50} {
+		w := propfind(fs, dir, "0", quotaPropfind)
+		if w.Code != http.StatusMultiStatus {
+			t.Fatalf("%s: status %d", dir, w.Code)
+		}
+		values := okValues(t, w.Body.String())
+		if got := values["quota-used-bytes"]; got != strconv.FormatInt(used, 10) {
+			t.Errorf("%s: quota-used-bytes = %q, want %d", dir, got, used)
+		}
+		if got := values["quota-available-bytes"]; got != "825" {
+			t.Errorf("%s: quota-available-bytes = %q, want 825", dir, got)
+		}
+	}
+
+	// 文件没有配额属性
+	if values := okValues(t, propfind(fs, "/c.mkv", "0", quotaPropfind).Body.String()); len(values) != 0 {
+		t.Errorf("a file reported %v", values)
+	}
+
+	// 修改目录树后重新计算
+	if err := fs.RemoveAll(context.Background(), "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := okValues(t, propfind(fs, "/", "0", quotaPropfind).Body.String())["quota-used-bytes"]; got != "125" {
+		t.Errorf("quota-used-bytes after a delete = %q, want 125", got)
+	}
+}
+
+func TestQuotaWithoutLimit(t *testing.T) {
+	fs := newTestFS(t, "/a/x.mkv#100#x.mkv\n")
+	values := okValues(t, propfind(fs, "/a", "0", quotaPropfind).Body.String())
+	if values["quota-used-bytes"] != "100" || values["quota-available-bytes"] != strconv.Itoa(unboundedQuota) {
+		t.Errorf("values = %v", values)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// 后台每次从上游读取的大小
+const readAheadChunk = 256 << 10
+
+// readAhead 在后台把上游内容预先读进最多 max 字节的缓冲区, 客户端的小块顺序读取
+// 直接从缓冲区返回, 不会每次都等一个跨公网的往返. 关闭或 ctx 取消时后台读取立即停止
+type readAhead struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	src  io.ReadCloser
+	max  int
+
+	buf []byte
+	// pos 是 buf[0] 在文件中的位置
+	pos int64
+	err error
+	// reading 表示后台正在读 src, 这时由后台读完后负责关闭 src
+	reading bool
+	closed  bool
+}
+
+// withReadAhead 在开启了 -read-ahead 时给 rr 套上预读缓冲. ranged 表示上游支持 Range,
+// 这时如果开启了 -parallel-fetch 改用多个连接并发预读
+func (fs *TextWebDAVFileSystem) withReadAhead(ctx context.Context, rr *resumeReader, ranged bool) io.ReadCloser {
+	if p := fs.newParallelReader(ctx, rr, ranged); p != nil {
+		return p
+	}
+	if fs.readAhead <= 0 {
+		return rr
+	}
+	return newReadAhead(ctx, rr, rr.pos, fs.readAhead)
+}
+
+func newReadAhead(ctx context.Context, src io.ReadCloser, pos int64, max int) *readAhead {
+	ra := &readAhead{src: src, max: max, pos: pos}
+	ra.cond = sync.NewCond(&ra.mu)
+	go ra.fill()
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			ra.Close()
+		}()
+	}
+	return ra
+}
+
+func (ra *readAhead) fill() {
+	chunk := make([]byte, readAheadChunk)
+	for {
+		ra.mu.Lock()
+		for len(ra.buf) >= ra.max && !ra.closed {
+			ra.cond.Wait()
+		}
+		if ra.closed {
+			ra.mu.Unlock()
+			return
+		}
+		n := ra.max - len(ra.buf)
+		ra.reading = true
+		ra.mu.Unlock()
+
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		n, err := ra.src.Read(chunk[:n])
+
+		ra.mu.Lock()
+		ra.reading = false
+		if ra.closed {
+			ra.mu.Unlock()
+			ra.src.Close()
+			return
+		}
+		ra.buf = append(ra.buf, chunk[:n]...)
+		if err != nil && ra.err == nil {
+			ra.err = err
+		}
+		ra.cond.Broadcast()
+		stop := ra.err != nil
+		ra.mu.Unlock()
+		if stop {
+			return
+		}
+	}
+}
+
+func (ra *readAhead) Read(p []byte) (int, error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	for len(ra.buf) == 0 && ra.err == nil && !ra.closed {
+		ra.cond.Wait()
+	}
+	if len(ra.buf) == 0 {
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		return 0, io.ErrClosedPipe
+	}
+	n := copy(p, ra.buf)
+	ra.consumeLocked(n)
+	return n, nil
+}
+
+func (ra *readAhead) consumeLocked(n int) {
+	ra.buf = ra.buf[n:]
+	ra.pos += int64(n)
+	if len(ra.buf) == 0 {
+		ra.buf = nil
+	}
+	ra.cond.Broadcast()
+}
+
+// skipTo 把读取位置向前移到 pos. pos 不在已缓冲的范围内时返回 false,
+// 调用方应关闭它并从新位置重新请求上游
+func (ra *readAhead) skipTo(pos int64) bool {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	if pos < ra.pos || pos > ra.pos+int64(len(ra.buf)) {
+		return false
+	}
+	ra.consumeLocked(int(pos - ra.pos))
+	return true
+}
+
+func (ra *readAhead) Close() error {
+	ra.mu.Lock()
+	if ra.closed {
+		ra.mu.Unlock()
+		return nil
+	}
+	ra.closed = true
+	ra.cond.Broadcast()
+	reading := ra.reading
+	ra.mu.Unlock()
+	if reading {
+		return nil
+	}
+	return ra.src.Close()
+}
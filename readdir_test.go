@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// hrefs 返回 PROPFIND 响应中按出现顺序排列、解码后的 href
+func hrefs(t *testing.T, body []byte) []string {
+	t.Helper()
+	var ms multistatus
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		t.Fatalf("invalid multistatus: %v\n%s", err, body)
+	}
+	var out []string
+	for _, resp := range ms.Responses {
+		href, err := url.PathUnescape(resp.Href)
+		if err != nil {
+			t.Fatalf("bad href %q", resp.Href)
+		}
+		out = append(out, href)
+	}
+	return out
+}
+
+func readdirNames(t *testing.T, fs *TextWebDAVFileSystem, name string) []string {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	infos, err := f.Readdir(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.(*VirtualFileInfo).path)
+	}
+	return names
+}
+
+func TestReaddirSiblingsSharingABaseName(t *testing.T) {
+	fs := newTestFS(t, "/a/season/1.mkv#1#1.mkv\n/b/season/2.mkv#2#2.mkv\n/a/season/sub/3.mkv#3#3.mkv\n/b/season/sub/4.mkv#4#4.mkv\n")
+
+	for dir, want := range map[string][]string{
+		"/a/season":     {"/a/season/1.mkv", "/a/season/sub"},
+		"/b/season":     {"/b/season/2.mkv", "/b/season/sub"},
+		"/a/season/sub": {"/a/season/sub/3.mkv"},
+		"/b/season/sub": {"/b/season/sub/4.mkv"},
+	} {
+		if got := readdirNames(t, fs, dir); !slices.Equal(got, want) {
+			t.Errorf("Readdir(%s) = %v, want %v", dir, got, want)
+		}
+		w := propfind(fs, dir+"/", "1", "")
+		if got := hrefs(t, w.Body.Bytes()); !slices.Equal(got, append([]string{dir}, want...)) {
+			t.Errorf("PROPFIND %s/ = %v, want %s and %v", dir, got, dir, want)
+		}
+	}
+}
+
+func TestReaddirPagesPerHandle(t *testing.T) {
+	fs := newTestFS(t, "/d/1.mkv#1#1.mkv\n/d/2.mkv#1#2.mkv\n/d/3.mkv#1#3.mkv\n/d/4.mkv#1#4.mkv\n/d/5.mkv#1#5.mkv\n")
+	ctx := context.Background()
+	open := func() webdav.File {
+		f, err := fs.OpenFile(ctx, "/d", os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	// count > 0: 每次最多 count 个, 读完后返回 io.EOF
+	a, b := open(), open()
+	var sizes []int
+	for {
+		infos, err := a.Readdir(2)
+		if err == io.EOF {
+			if len(infos) != 0 {
+				t.Errorf("io.EOF with %d entries", len(infos))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(infos))
+		// 另一个句柄有自己的位置
+		if other, err := b.Readdir(1); err != nil || len(other) != 1 {
+			t.Fatalf("second handle: %d entries, %v", len(other), err)
+		}
+	}
+	if !slices.Equal(sizes, []int{2, 2, 1}) {
+		t.Errorf("pages %v, want [2 2 1]", sizes)
+	}
+
+	// count <= 0: 返回剩下的全部, 没有剩余时返回空切片和 nil
+	c := open()
+	if infos, err := c.Readdir(1); err != nil || len(infos) != 1 {
+		t.Fatalf("Readdir(1) = %d entries, %v", len(infos), err)
+	}
+	if infos, err := c.Readdir(0); err != nil || len(infos) != 4 {
+		t.Errorf("Readdir(0) = %d entries, %v; want the remaining 4", len(infos), err)
+	}
+	if infos, err := c.Readdir(-1); err != nil || len(infos) != 0 {
+		t.Errorf("Readdir(-1) at the end = %d entries, %v; want none and nil", len(infos), err)
+	}
+	if infos, err := c.Readdir(1); err != io.EOF || len(infos) != 0 {
+		t.Errorf("Readdir(1) at the end = %d entries, %v; want io.EOF", len(infos), err)
+	}
+
+	if f, _ := fs.OpenFile(ctx, "/d/1.mkv", os.O_RDONLY, 0); f != nil {
+		defer f.Close()
+		if _, err := f.Readdir(0); err == nil {
+			t.Error("Readdir on a file succeeded")
+		}
+	}
+}
+
+func TestListingOrderIsStable(t *testing.T) {
+	var list strings.Builder
+	for _, i := range rand.New(rand.NewSource(1)).Perm(200) {
+		fmt.Fprintf(&list, "/d/%03d.mkv#1#同名.mkv\n", i)
+	}
+	list.WriteString("/d/sub/x.mkv#1#x.mkv\n")
+	fs := newTestFS(t, list.String())
+
+	first := readdirNames(t, fs, "/d")
+	if !sort.StringsAreSorted(first) || len(first) != 201 {
+		t.Fatalf("Readdir = %v", first)
+	}
+	for i := 0; i < 20; i++ {
+		if got := readdirNames(t, fs, "/d"); !slices.Equal(got, first) {
+			t.Fatalf("Readdir %d returned a different order", i)
+		}
+		if got := hrefs(t, propfind(fs, "/d", "1", "").Body.Bytes()); !slices.Equal(got[1:], first) {
+			t.Fatalf("PROPFIND %d: order differs from Readdir", i)
+		}
+	}
+}
+
+func TestListingSortOrders(t *testing.T) {
+	fs := newTestFS(t, "/d/b.mkv#1#A\n/d/a.mkv#1#C\n/d/z/#0#z\n/d/c.mkv#1#B\n")
+	fs.Files["/d/a.mkv"].ModTime = testModTime.Add(2 * time.Hour)
+	fs.Files["/d/b.mkv"].ModTime = testModTime
+	fs.Files["/d/c.mkv"].ModTime = testModTime.Add(time.Hour)
+	fs.Files["/d/z"].ModTime = testModTime.Add(3 * time.Hour)
+
+	for _, tt := range []struct {
+		order     string
+		dirsFirst bool
+		want      []string
+	}{
+		{SortName, false, []string{"/d/a.mkv", "/d/b.mkv", "/d/c.mkv", "/d/z"}},
+		{SortName, true, []string{"/d/z", "/d/a.mkv", "/d/b.mkv", "/d/c.mkv"}},
+		{SortDisplayName, false, []string{"/d/b.mkv", "/d/c.mkv", "/d/a.mkv", "/d/z"}},
+		{SortModTime, false, []string{"/d/z", "/d/a.mkv", "/d/c.mkv", "/d/b.mkv"}},
+		{SortModTime, true, []string{"/d/z", "/d/a.mkv", "/d/c.mkv", "/d/b.mkv"}},
+	} {
+		fs.sortOrder, fs.dirsFirst = tt.order, tt.dirsFirst
+		if got := readdirNames(t, fs, "/d"); !slices.Equal(got, tt.want) {
+			t.Errorf("-sort %s -dirs-first=%v: Readdir = %v, want %v", tt.order, tt.dirsFirst, got, tt.want)
+		}
+		if got := hrefs(t, propfind(fs, "/d", "1", "").Body.Bytes()); !slices.Equal(got[1:], tt.want) {
+			t.Errorf("-sort %s -dirs-first=%v: PROPFIND = %v", tt.order, tt.dirsFirst, got[1:])
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "net/http"
+
+// writeMethods 是会修改目录树或上游的 WebDAV 方法, -read-only 时全部拒绝.
+// LOCK 也拒绝, Finder 等客户端据此以只读方式挂载
+var writeMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodDelete: true,
+	"MKCOL":           true,
+	"MOVE":            true,
+	"COPY":            true,
+	"PROPPATCH":       true,
+	"LOCK":            true,
+	"UNLOCK":          true,
+}
+
+// rejectWrite 在只读模式下拒绝修改请求, 返回 true 表示已经回答
+func (fs *TextWebDAVFileSystem) rejectWrite(w http.ResponseWriter, r *http.Request) bool {
+	return writeMethods[r.Method] && fs.rejectReadOnly(w)
+}
+
+// rejectReadOnly 在只读模式下以 403 回答. WebDAV 之外的修改入口 (/api/batch、/admin/files、
+// 其它实例推送的修改) 在修改目录树之前调用, 返回 true 表示已经回答
+func (fs *TextWebDAVFileSystem) rejectReadOnly(w http.ResponseWriter) bool {
+	if !fs.readOnly {
+		return false
+	}
+	http.Error(w, "只读模式, 不允许修改", http.StatusForbidden)
+	return true
+}
+
+// protectedPath 报告 name 是否是根目录或远端源的挂载点. 删除它们会清空整棵树
+// 或让下一次重新抓取无处挂载, 因此不允许
+func (fs *TextWebDAVFileSystem) protectedPath(name string) bool {
+	if name == "/" {
+		return true
+	}
+	for _, source := range fs.sources {
+		if fs.normPath(source.Mount()) == name {
+			return true
+		}
+	}
+	return false
+}
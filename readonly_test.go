@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyRejectsEveryMutationEntryPoint(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	fs.readOnly = true
+
+	for method := range writeMethods {
+		w := httptest.NewRecorder()
+		if !fs.rejectWrite(w, httptest.NewRequest(method, "/a.mkv", nil)) || w.Code != http.StatusForbidden {
+			t.Errorf("%s not rejected: %d", method, w.Code)
+		}
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead, "PROPFIND", http.MethodOptions} {
+		if fs.rejectWrite(httptest.NewRecorder(), httptest.NewRequest(method, "/a.mkv", nil)) {
+			t.Errorf("%s rejected in read-only mode", method)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	fs.handleBatch(w, httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(`{"operations":[{"op":"delete","path":"/a.mkv"}]}`)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("batch: status %d", w.Code)
+	}
+	if w := adminFiles(fs, http.MethodPost, "/admin/files", `{"path":"/b.mkv","size":1}`); w.Code != http.StatusForbidden {
+		t.Errorf("admin POST: status %d", w.Code)
+	}
+	if w := adminFiles(fs, http.MethodDelete, "/admin/files?path=/a.mkv", ""); w.Code != http.StatusForbidden {
+		t.Errorf("admin DELETE: status %d", w.Code)
+	}
+
+	peer := NewPeerServer(fs, nil, "secret")
+	body, _ := json.Marshal(JournalRecord{Op: JournalDelete, Path: "/a.mkv"})
+	r := httptest.NewRequest(http.MethodPost, peerPathPrefix+"mutations", strings.NewReader(string(body)))
+	r.Header.Set("X-Peer-Token", "secret")
+	w = httptest.NewRecorder()
+	peer.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("peer mutation: status %d", w.Code)
+	}
+
+	if fs.Files["/a.mkv"] == nil || fs.Files["/b.mkv"] != nil {
+		t.Error("the tree was modified in read-only mode")
+	}
+}
+
+func TestBatchRefusesDeletingMountPoints(t *testing.T) {
+	fs := newTestFS(t, "/mnt/a.mkv#10#a.mkv\n/other/b.mkv#10#b.mkv\n")
+	fs.sources = []treeSource{mountSource("/mnt")}
+
+	w := httptest.NewRecorder()
+	fs.handleBatch(w, httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(`{"operations":[{"op":"delete","path":"/mnt"}]}`)))
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), batchErrorText(os.ErrPermission)) {
+		t.Errorf("status %d: %s", w.Code, w.Body)
+	}
+	if fs.Files["/mnt/a.mkv"] == nil {
+		t.Error("a batch deleted a mount point")
+	}
+}
+
+func TestRemoveDoesNotTouchSiblingsSharingAPrefix(t *testing.T) {
+	fs := newTestFS(t, "/a/x.mkv#1#x.mkv\n/ab/y.mkv#1#y.mkv\n/a.mkv#1#a.mkv\n")
+	if err := fs.removeAllLocked("/a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/ab", "/ab/y.mkv", "/a.mkv"} {
+		if fs.Files[p] == nil {
+			t.Errorf("%s was removed with /a", p)
+		}
+	}
+	if fs.Files["/a"] != nil || fs.Files["/a/x.mkv"] != nil {
+		t.Error("/a was not removed")
+	}
+	if err := fs.removeAllLocked("/"); !os.IsPermission(err) {
+		t.Errorf("removing the root: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// 上游地址中常见的携带凭据的查询参数, 按小写比较. 可以用 -redact-param 追加
+var sensitiveParams = map[string]bool{
+	"sign":                 true,
+	"signature":            true,
+	"sig":                  true,
+	"token":                true,
+	"access_token":         true,
+	"auth":                 true,
+	"auth_key":             true,
+	"key":                  true,
+	"secret":               true,
+	"password":             true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+}
+
+const redactedValue = "REDACTED"
+
+func addSensitiveParam(name string) {
+	sensitiveParams[strings.ToLower(strings.TrimSpace(name))] = true
+}
+
+// redactURL 返回可以安全写进日志、错误信息和导出数据的地址: 去掉密码,
+// 敏感查询参数的值替换为 REDACTED, 其余部分保留以便排查. 解析失败时整个查询串都去掉
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if i := strings.IndexByte(raw, '?'); i >= 0 {
+			return raw[:i] + "?" + redactedValue
+		}
+		return raw
+	}
+
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			if sensitiveParams[strings.ToLower(k)] {
+				q[k] = []string{redactedValue}
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactError 处理 net/http 返回的 *url.Error, 它的文本里带着完整的请求地址
+func redactError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return &url.Error{Op: ue.Op, URL: redactURL(ue.URL), Err: ue.Err}
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const secret = "s3cr3tv4lue"
+
+// captureStdout 返回 f 执行期间打印的日志
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	w.Close()
+	return string(<-done)
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"http://alist:5244/d/a.mkv?sign=" + secret, "http://alist:5244/d/a.mkv?sign=REDACTED"},
+		{"https://s3.example.com/b/a.mkv?X-Amz-Signature=" + secret + "&X-Amz-Expires=600", "https://s3.example.com/b/a.mkv?X-Amz-Expires=600&X-Amz-Signature=REDACTED"},
+		{"http://h/a?Token=" + secret + "&v=1", "http://h/a?Token=REDACTED&v=1"},
+		{"http://user:" + secret + "@h/a", "http://user:xxxxx@h/a"},
+		{"http://h/a.mkv", "http://h/a.mkv"},
+		{"http://h/%zz?sign=" + secret, "http://h/%zz?REDACTED"},
+	}
+	for _, tt := range tests {
+		if got := redactURL(tt.in); got != tt.want {
+			t.Errorf("redactURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactParamIsExtensible(t *testing.T) {
+	addSensitiveParam(" Ticket ")
+	defer delete(sensitiveParams, "ticket")
+	if got := redactURL("http://h/a?ticket=" + secret); strings.Contains(got, secret) {
+		t.Errorf("redactURL = %q", got)
+	}
+}
+
+func TestSignedURLNeverLeaksFromTheProxyPath(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	body := bytes.Repeat([]byte("x"), 4096)
+	dropping, _ := droppingUpstream(t, body, 100)
+
+	fs := newTestFS(t, "/5xx.mkv#10#a.mkv\n/down.mkv#10#b.mkv\n/drop.mkv#4096#c.mkv\n")
+	fs.Files["/5xx.mkv"].URL = failing.URL + "/a.mkv?sign=" + secret
+	fs.Files["/down.mkv"].URL = closed.URL + "/b.mkv?token=" + secret
+	fs.Files["/drop.mkv"].URL = dropping.URL + "/c.mkv?X-Amz-Signature=" + secret
+
+	var responses []string
+	logs := captureStdout(t, func() {
+		for _, name := range []string{"/5xx.mkv", "/down.mkv", "/drop.mkv"} {
+			w := getUpstream(fs, name, nil)
+			responses = append(responses, w.Body.String())
+			for _, v := range w.Header() {
+				responses = append(responses, v...)
+			}
+		}
+	})
+	if !strings.Contains(logs, "REDACTED") {
+		t.Errorf("expected the upstream address in the logs, got:\n%s", logs)
+	}
+	if strings.Contains(logs, secret) {
+		t.Errorf("secret in the logs:\n%s", logs)
+	}
+	for _, s := range responses {
+		if strings.Contains(s, secret) {
+			t.Errorf("secret in a response: %q", s)
+		}
+	}
+}
+
+func TestSignedURLNeverLeaksFromReportsAndExports(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv#####http://alist/d/a.mkv?sign="+secret+"\n")
+	var report *LoadReport
+	var err error
+	logs := captureStdout(t, func() {
+		report, err = fs.LoadFromText("/bad.mkv#notasize#bad.mkv#####http://alist/d/bad.mkv?sign=" + secret + "\n")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs, secret) {
+		t.Errorf("secret in the load log:\n%s", logs)
+	}
+	setLastLoadReport(report)
+	defer setLastLoadReport(nil)
+	w := httptest.NewRecorder()
+	fs.handleSkipped(w, httptest.NewRequest(http.MethodGet, "/api/skipped", nil))
+	if !strings.Contains(w.Body.String(), "bad.mkv") || strings.Contains(w.Body.String(), secret) {
+		t.Errorf("/api/skipped: %s", w.Body.String())
+	}
+
+	files := make(map[string]string)
+	if err := fs.buildMirror(func(name string, data []byte) error {
+		files[name] = string(data)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if m := files["manifest.json"]; !strings.Contains(m, "REDACTED") || strings.Contains(m, secret) {
+		t.Errorf("manifest.json: %s", m)
+	}
+	if files["index.html"] == "" || strings.Contains(files["index.html"], secret) {
+		t.Errorf("index.html: %s", files["index.html"])
+	}
+}
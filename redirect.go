@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RedirectRules 决定 GET/HEAD 是否用 302 把客户端直接指向上游地址, 而不是经本进程转发.
+// 默认值由 -redirect 决定, 路径前缀规则可以单独开启或关闭, 最长匹配优先
+type RedirectRules struct {
+	def      bool
+	prefixes map[string]bool
+}
+
+func NewRedirectRules(def bool) *RedirectRules {
+	return &RedirectRules{def: def, prefixes: make(map[string]bool)}
+}
+
+// Add 解析一条规则, 形如 "/电影=on" 或 "/直播=off"
+func (r *RedirectRules) Add(rule string) error {
+	prefix, mode, ok := strings.Cut(rule, "=")
+	prefix = strings.TrimSpace(prefix)
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("重定向规则格式错误, 需要 /prefix=on 或 /prefix=off: %q", rule)
+	}
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "on", "true", "1":
+		r.prefixes[strings.TrimSuffix(prefix, "/")] = true
+	case "off", "false", "0":
+		r.prefixes[strings.TrimSuffix(prefix, "/")] = false
+	default:
+		return fmt.Errorf("重定向规则的值必须是 on 或 off: %q", rule)
+	}
+	return nil
+}
+
+func (r *RedirectRules) For(name string) bool {
+	if r == nil {
+		return false
+	}
+	best, on := -1, r.def
+	for prefix, v := range r.prefixes {
+		if (name == prefix || strings.HasPrefix(name, prefix+"/") || prefix == "") && len(prefix) > best {
+			best, on = len(prefix), v
+		}
+	}
+	return on
+}
+
+// redirectUpstream 在重定向模式下对文件的 GET/HEAD 返回 302. 没有上游地址、
+// 有本地内容或上游需要本进程注入认证的条目不重定向, 返回 false 继续走原来的流程
+func (fs *TextWebDAVFileSystem) redirectUpstream(w http.ResponseWriter, r *http.Request, meta *FileMeta) bool {
+	if meta.IsDir || meta.Content != nil || !fs.redirects.For(meta.Path) {
+		return false
+	}
+	target := fs.upstreamURL(meta)
+	if target == "" {
+		return false
+	}
+	probe, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+	fs.authorizeUpstream(probe)
+	if probe.Header.Get("Authorization") != "" || len(fs.upstreamHeaders.resolve(target)) > 0 {
+		return false
+	}
+	// 上游要求固定的 User-Agent 时客户端直连会被拒绝
+	if ua := fs.userAgents.For(meta.Path); ua != "" && ua != userAgentForward {
+		return false
+	}
+
+	if notModified(w, r, meta) {
+		return true
+	}
+	w.Header().Set("Location", fs.signedURL(target))
+	w.WriteHeader(http.StatusFound)
+	return true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// treeSource 是在列表之外向虚拟树提供条目的远端源, 挂载在 Mount() 之下
+type treeSource interface {
+	Crawl(ctx context.Context, fs *TextWebDAVFileSystem) error
+	Mount() string
+}
+
+func (s *WebDAVSource) Mount() string { return s.mount }
+
+func (s *AlistSource) Mount() string { return s.mount }
+
+// ReloadResult 是一次重新加载的结果, 同时作为 /admin/reload 的响应
+type ReloadResult struct {
+	Files     int    `json:"files"`
+	Dirs      int    `json:"dirs"`
+	Warnings  int    `json:"warnings"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reloadState 记录最近一次重新加载的结果, 失败时旧树继续提供服务, 这里记录它已经旧了多久
+type reloadState struct {
+	mu       sync.Mutex
+	last     ReloadResult
+	lastAt   time.Time
+	lastGood time.Time
+	failures int
+}
+
+var reloadMu sync.Mutex
+
+// Reload 重新获取并解析列表、重新抓取远端源, 在独立的 map 中建好新树后一次性替换,
+// 进行中的请求只会看到旧树或新树. 日志中的客户端修改会重新应用到新树上.
+// 任何一步失败都不替换, 继续使用旧树
+func (fs *TextWebDAVFileSystem) Reload() (ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	// 缓存规则随列表一起重新加载, 规则文件有错时继续使用原来的规则
+	if err := fs.cacheRules.Reload(); err != nil {
+		fmt.Printf("重新读取缓存规则失败: %v\n", err)
+	}
+
+	start := time.Now()
+	result, err := fs.reload()
+	result.ElapsedMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	fs.reloadState.mu.Lock()
+	fs.reloadState.last = result
+	fs.reloadState.lastAt = time.Now()
+	if err != nil {
+		fs.reloadState.failures++
+	} else {
+		fs.reloadState.failures = 0
+		fs.reloadState.lastGood = time.Now()
+	}
+	fs.reloadState.mu.Unlock()
+
+	if err != nil {
+		fs.emitEvent("reload-failed", "", err.Error())
+	} else {
+		fmt.Printf("重新加载完成: %d 个文件, %d 个目录, 用时 %dms\n", result.Files, result.Dirs, result.ElapsedMs)
+	}
+	return result, err
+}
+
+func (fs *TextWebDAVFileSystem) reload() (ReloadResult, error) {
+	fresh := &TextWebDAVFileSystem{
+		Files:         make(map[string]*FileMeta),
+		upstreamCreds: fs.upstreamCreds,
+		filter:        fs.filter,
+		prefixMap:     fs.prefixMap,
+		strict:        fs.strict,
+		sources:       fs.sources,
+
+		renamePolicy:     fs.renamePolicy,
+		displayTemplates: fs.displayTemplates,
+		pathForm:         fs.pathForm,
+		caseIndex:        fs.caseIndex,
+	}
+
+	var report *LoadReport
+	var err error
+	if fs.loader != nil {
+		report, err = fresh.LoadEntries(context.Background(), fs.loader)
+	} else if fs.listSource != "" {
+		report, err = fresh.LoadFromSource(fs.listSource)
+	} else if len(fs.sources) == 0 {
+		report, err = fresh.LoadFromText(demoList)
+	}
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	// 先放入旧树中各个源子树的副本, 抓取时单个目录失败就保留旧内容.
+	// 有日志时清掉客户端修改的属性, 下面重放日志时会重新得到
+	fs.mu.RLock()
+	for _, source := range fs.sources {
+		mount := source.Mount()
+		for p, meta := range fs.Files {
+			if p == mount || strings.HasPrefix(p, mount+"/") || mount == "/" {
+				clone := *meta
+				if fs.journal != nil {
+					clone.Props, clone.PropVersion = nil, 0
+				}
+				fresh.Files[p] = &clone
+			}
+		}
+	}
+	fs.mu.RUnlock()
+
+	for _, source := range fs.sources {
+		if err := source.Crawl(context.Background(), fresh); err != nil {
+			return ReloadResult{}, err
+		}
+	}
+
+	// 日志在替换用的写锁内读取和重放: 修改都在持有写锁时写入日志, 这样抓取期间
+	// 和替换之前的修改不会丢失
+	fs.mu.Lock()
+	if fs.journal != nil {
+		fs.journal.Sync()
+		records, err := ReadJournal(fs.journal.path)
+		if err != nil {
+			fs.mu.Unlock()
+			return ReloadResult{}, err
+		}
+		for _, rec := range records {
+			fresh.applyRecord(rec)
+		}
+	}
+
+	var result ReloadResult
+	if report != nil {
+		result.Warnings = report.SkippedCount
+	}
+	for _, meta := range fresh.Files {
+		if meta.IsDir {
+			result.Dirs++
+		} else {
+			result.Files++
+		}
+	}
+
+	fs.Files = fresh.Files
+	fs.children.invalidate()
+	fs.contentCache.Purge()
+	fs.treeChanged()
+	// 仍在新目录树中的条目保留上游缺失标记, 等 ttl 到期后再重新确认
+	fs.missing.retain(func(p string) bool {
+		_, ok := fs.Files[p]
+		return ok
+	})
+	fs.mu.Unlock()
+	if report != nil {
+		setLastLoadReport(report)
+	}
+	return result, nil
+}
+
+// refreshLoop 按 interval 在后台重新加载, 间隔上下浮动 10%, 避免多个实例同时请求源
+func (fs *TextWebDAVFileSystem) refreshLoop(interval time.Duration) {
+	for {
+		jitter := time.Duration(rand.Int63n(int64(interval)/5+1)) - interval/10
+		time.Sleep(interval + jitter)
+
+		if _, err := fs.Reload(); err != nil {
+			fs.reloadState.mu.Lock()
+			failures, stale := fs.reloadState.failures, time.Since(fs.reloadState.lastGood)
+			fs.reloadState.mu.Unlock()
+			fmt.Printf("后台刷新失败 (连续 %d 次), 继续使用 %v 前的目录树: %v\n", failures, stale.Round(time.Second), err)
+		}
+	}
+}
+
+func (fs *TextWebDAVFileSystem) reloadStats() map[string]interface{} {
+	fs.reloadState.mu.Lock()
+	defer fs.reloadState.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"consecutive_failures": fs.reloadState.failures,
+		"stale_seconds":        int64(time.Since(fs.reloadState.lastGood).Seconds()),
+	}
+	if !fs.reloadState.lastAt.IsZero() {
+		stats["last"] = fs.reloadState.last
+		stats["last_at"] = fs.reloadState.lastAt
+	}
+	return stats
+}
+
+func (fs *TextWebDAVFileSystem) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := fs.Reload()
+	if err != nil {
+		fmt.Printf("重新加载失败: %v\n", err)
+		writeJSON(w, http.StatusInternalServerError, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
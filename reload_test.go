@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mutatingSource 在抓取期间通过 mutate 修改正在服务的目录树, 模拟重新加载过程中到达的客户端请求
+type mutatingSource struct {
+	mount  string
+	mutate func()
+}
+
+func (s *mutatingSource) Crawl(ctx context.Context, fs *TextWebDAVFileSystem) error {
+	s.mutate()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.upsertSourceEntryLocked(sourceEntry{path: s.mount, isDir: true, modTime: testModTime})
+	fs.upsertSourceEntryLocked(sourceEntry{path: s.mount + "/s.mkv", size: 1, modTime: testModTime})
+	return nil
+}
+
+func (s *mutatingSource) Mount() string { return s.mount }
+
+func TestReloadKeepsMutationsMadeDuringReload(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(list, []byte("/a.mkv#10#a.mkv\n/b.mkv#10#b.mkv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := newTestFS(t, "")
+	fs.listSource = list
+	if _, err := fs.LoadFromSource(list); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ReplayJournal(filepath.Join(dir, "journal"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.journal.Close()
+
+	mutate := func(rec JournalRecord) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		if err := fs.applyRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+		fs.recordMutation(rec)
+	}
+	mutate(JournalRecord{Op: JournalMkdir, Path: "/before"})
+	fs.sources = []treeSource{&mutatingSource{mount: "/src", mutate: func() {
+		mutate(JournalRecord{Op: JournalRename, Path: "/b.mkv", To: "/during.mkv"})
+	}}}
+
+	result, err := fs.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/a.mkv", "/before", "/during.mkv", "/src/s.mkv"} {
+		if fs.Files[p] == nil {
+			t.Errorf("%s missing after reload", p)
+		}
+	}
+	if fs.Files["/b.mkv"] != nil {
+		t.Error("/b.mkv came back from the list although it was renamed during the reload")
+	}
+	if result.Files != 3 || result.Dirs != 2 {
+		t.Errorf("result %+v, want 3 files and 2 directories", result)
+	}
+}
+
+func TestReloadFailureKeepsOldTree(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	fs.listSource = filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := fs.Reload(); err == nil {
+		t.Fatal("reload of a missing list succeeded")
+	}
+	if fs.Files["/a.mkv"] == nil {
+		t.Error("old tree replaced after a failed reload")
+	}
+}
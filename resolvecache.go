@@ -0,0 +1,97 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ResolveCache 记住上游地址跟随 302 之后的最终地址 (例如 Alist /d/ 链接跳转到的
+// 网盘签名地址), 拖动进度条时的 Range 请求直接发往最终地址, 不必每次都先问一遍 Alist.
+// 按最近使用淘汰, 条目数不超过 max
+type ResolveCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	max   int
+	order *list.List // 前面是最近使用的
+	items map[string]*list.Element
+}
+
+type resolvedURL struct {
+	key     string
+	url     string
+	expires time.Time
+}
+
+// NewResolveCache 在 ttl 或 max 不大于 0 时返回 nil, 即不缓存
+func NewResolveCache(ttl time.Duration, max int) *ResolveCache {
+	if ttl <= 0 || max <= 0 {
+		return nil
+	}
+	return &ResolveCache{ttl: ttl, max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *ResolveCache) Get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*resolvedURL)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return entry.url, true
+}
+
+func (c *ResolveCache) Put(key, url string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*resolvedURL)
+		entry.url, entry.expires = url, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&resolvedURL{key: key, url: url, expires: expires})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*resolvedURL).key)
+	}
+}
+
+func (c *ResolveCache) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *ResolveCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
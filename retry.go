@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 指数退避的上限, 避免重试次数较多时一次等待过久
+const maxRetryBackoff = 10 * time.Second
+
+// 续传后又正常传输了这么多字节, 就认为连接已经恢复, 续传次数重新计数.
+// 几个小时的播放中偶尔断开几次不会用完次数, 反复断开的上游仍然会很快放弃
+const resumeResetBytes = 1 << 20
+
+// waitRetry 在第 n 次重试前等待, 客户端断开时立即返回错误
+func (fs *TextWebDAVFileSystem) waitRetry(ctx context.Context, n int) error {
+	d := fs.retryBackoff
+	for i := 1; i < n && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resumeReader 包装上游响应体. 传输中断或提前结束时按已读到的位置重新发起
+// Range 请求接着读 (有备选上游时优先换一个), 调用方看到的是一段连续的内容,
+// 不会重复也不会缺失
+type resumeReader struct {
+	fs   *TextWebDAVFileSystem
+	ctx  context.Context
+	meta *FileMeta
+	body io.ReadCloser
+	// source 是当前响应来自的上游地址
+	source string
+	// pos 是下一个字节在文件中的位置, end 是要读到的最后一个字节, 未知时为 -1
+	pos int64
+	end int64
+	// retries 是连续续传的次数, progress 是上次续传之后读到的字节数
+	retries  int
+	progress int64
+}
+
+// newResumeReader 按响应的状态码和 Content-Range 确定 resp 对应的文件范围
+func (fs *TextWebDAVFileSystem) newResumeReader(ctx context.Context, meta *FileMeta, resp *http.Response, source string) *resumeReader {
+	r := &resumeReader{fs: fs, ctx: ctx, meta: meta, body: resp.Body, source: source, end: -1}
+	if resp.StatusCode == http.StatusPartialContent {
+		if start, end, ok := parseContentRange(resp.Header.Get("Content-Range")); ok {
+			r.pos, r.end = start, end
+		}
+		return r
+	}
+	if resp.ContentLength >= 0 {
+		r.end = resp.ContentLength - 1
+	} else if meta.Size > 0 {
+		r.end = meta.Size - 1
+	}
+	return r
+}
+
+func (r *resumeReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.pos += int64(n)
+		r.fs.backendMetrics.addBytes(r.source, int64(n))
+		if r.retries > 0 {
+			if r.progress += int64(n); r.progress >= resumeResetBytes {
+				r.retries, r.progress = 0, 0
+			}
+		}
+		if err == nil || (err == io.EOF && (r.end < 0 || r.pos > r.end)) {
+			return n, err
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		// 先交出已经读到的部分, 下一次读取时响应体会再次返回错误, 那时再续传
+		if n > 0 {
+			return n, nil
+		}
+		if rerr := r.resume(err); rerr != nil {
+			return 0, rerr
+		}
+	}
+}
+
+func (r *resumeReader) Close() error {
+	return r.body.Close()
+}
+
+// resume 在 cause 之后从 r.pos 处重新请求上游, 次数用完或客户端已断开时返回 cause
+func (r *resumeReader) resume(cause error) error {
+	limit := r.fs.resumeAttempts
+	if r.ctx.Err() != nil || r.retries >= limit {
+		if limit > 0 && r.ctx.Err() == nil {
+			fmt.Printf("续传 %d 次后仍然中断, 放弃: %s: %v\n", r.retries, r.meta.Path, redactError(cause))
+		}
+		return cause
+	}
+	r.retries++
+	r.progress = 0
+	r.fs.health.fail(r.source)
+	r.fs.backendMetrics.retry(r.source)
+	fmt.Printf("上游传输中断, 从 %d 字节处续传 (第 %d 次): %s: %v\n", r.pos, r.retries, r.meta.Path, redactError(cause))
+	// 有备选上游时直接换过去, 只有一个上游时才退避等待
+	if len(r.fs.upstreamURLs(r.meta)) < 2 {
+		if err := r.fs.waitRetry(r.ctx, r.retries); err != nil {
+			return cause
+		}
+	}
+	r.body.Close()
+
+	rangeHeader := "bytes=" + strconv.FormatInt(r.pos, 10) + "-"
+	if r.end >= 0 {
+		rangeHeader += strconv.FormatInt(r.end, 10)
+	}
+	resp, source, err := r.fs.openUpstream(r.ctx, r.meta, rangeHeader, r.source)
+	if err != nil {
+		r.body = io.NopCloser(errReader{err})
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != r.pos {
+			resp.Body.Close()
+			err = fmt.Errorf("续传时上游返回的范围不符: %s", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		// 上游不支持 Range, 只能从头读起再丢掉已经发送过的部分
+		if _, err = io.CopyN(io.Discard, resp.Body, r.pos); err != nil {
+			resp.Body.Close()
+		}
+	default:
+		resp.Body.Close()
+		err = fmt.Errorf("续传时上游返回 %d", resp.StatusCode)
+	}
+	if err != nil {
+		r.body = io.NopCloser(errReader{err})
+		return err
+	}
+	r.body = resp.Body
+	r.source = source
+	return nil
+}
+
+// errReader 在续传失败后代替响应体, 之后的读取都返回同一个错误
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// parseContentRange 解析 "bytes a-b/total" 形式的 Content-Range
+func parseContentRange(h string) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(h, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	spec, _, _ = strings.Cut(spec, "/")
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err1 := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	end, err2 := strconv.ParseInt(strings.TrimSpace(last), 10, 64)
+	if err1 != nil || err2 != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
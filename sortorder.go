@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 目录列表 (Readdir 和 PROPFIND Depth: 1) 中子项的顺序
+const (
+	SortName        = "name"        // 按路径中的文件名
+	SortDisplayName = "displayname" // 按显示名
+	SortModTime     = "mtime"       // 按修改时间, 新的在前
+)
+
+func parseSortOrder(s string) (string, error) {
+	switch s {
+	case SortName, SortDisplayName, SortModTime:
+		return s, nil
+	}
+	return "", fmt.Errorf("未知的目录排序方式 %q, 可选 name、displayname、mtime", s)
+}
+
+// sortEntries 按 -sort 排列同一目录下的子项, -dirs-first 时目录在前.
+// 排序键相同时按路径, 每次列出的顺序都一样, 播放器的"下一集"不会跳乱
+func (fs *TextWebDAVFileSystem) sortEntries(entries []*FileMeta) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if fs.dirsFirst && a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		switch fs.sortOrder {
+		case SortDisplayName:
+			if a.DisplayName != b.DisplayName {
+				return a.DisplayName < b.DisplayName
+			}
+		case SortModTime:
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.After(b.ModTime)
+			}
+		}
+		return a.Path < b.Path
+	})
+}
+
+// childrenLocked 返回 dir 的直接子项, 已按 sortEntries 排好. 调用方持有 fs.mu 读锁
+func (fs *TextWebDAVFileSystem) childrenLocked(dir string) []*FileMeta {
+	paths := fs.childPathsLocked(dir)
+	entries := make([]*FileMeta, 0, len(paths))
+	for _, p := range paths {
+		entries = append(entries, fs.Files[p])
+	}
+	fs.sortEntries(entries)
+	return entries
+}
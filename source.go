@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path"
+	"time"
+)
+
+// sourceEntry 是从远端源 (WebDAV、Alist) 抓取到的一个子项
+type sourceEntry struct {
+	path        string
+	displayName string
+	size        int64
+	modTime     time.Time
+	isDir       bool
+	url         string
+}
+
+// upsertSourceEntryLocked 把抓取到的子项合并进虚拟树. 类型变化 (文件变目录或相反) 时
+// 先删掉旧条目及其子树; 返回条目合并前是否已经存在
+func (fs *TextWebDAVFileSystem) upsertSourceEntryLocked(e sourceEntry) bool {
+	displayName := e.displayName
+	if displayName == "" {
+		displayName = path.Base(e.path)
+	}
+
+	meta, ok := fs.Files[e.path]
+	if !ok || meta.IsDir != e.isDir {
+		fs.removeAllLocked(e.path)
+		meta = &FileMeta{Path: e.path, IsDir: e.isDir}
+		fs.setEntryLocked(e.path, meta)
+		ok = false
+	}
+	if ok && meta.DisplayName != displayName {
+		meta.PropVersion++
+	}
+	meta.DisplayName = displayName
+	meta.ModTime = e.modTime
+	fs.treeChanged()
+	if !e.isDir {
+		if meta.Size != e.size {
+			meta.SizeVerified = false
+		}
+		meta.Size = e.size
+		meta.URL = e.url
+	}
+	return ok
+}
+
+// pruneSourceDirLocked 删除 dir 下这次抓取没有出现的直接子项
+func (fs *TextWebDAVFileSystem) pruneSourceDirLocked(dir string, seen map[string]bool) {
+	removed := false
+	for _, p := range fs.childPathsLocked(dir) {
+		if !seen[p] {
+			fs.removeAllLocked(p)
+			removed = true
+		}
+	}
+	if removed {
+		fs.treeChanged()
+	}
+}
+
+// ensureMountLocked 确保源的挂载点目录存在
+func (fs *TextWebDAVFileSystem) ensureMountLocked(mount string) {
+	if mount == "/" {
+		return
+	}
+	if _, ok := fs.Files[mount]; !ok {
+		fs.setEntryLocked(mount, &FileMeta{Path: mount, DisplayName: path.Base(mount), IsDir: true, ModTime: defaultModTime()})
+	}
+	fs.ensureParentsLocked(mount)
+}
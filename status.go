@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// version 在构建时通过 -ldflags "-X main.version=..." 设置
+var version = "dev"
+
+var startTime = time.Now()
+
+// mountCounts 按挂载点统计条目数, 不属于任何远端源的条目记在列表下
+func (fs *TextWebDAVFileSystem) mountCounts() map[string]int {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for p := range fs.Files {
+		owner, best := "列表", -1
+		for _, source := range fs.sources {
+			mount := source.Mount()
+			if (mount == "/" || p == mount || strings.HasPrefix(p, mount+"/")) && len(mount) > best {
+				owner, best = mount, len(mount)
+			}
+		}
+		counts[owner]++
+	}
+	return counts
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"since": func(t time.Time) string { return time.Since(t).Round(time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>XiaoyaWebDavProxy 状态</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f3f3f3; }
+.bad { color: #b00; }
+</style>
+</head>
+<body>
+<h1>XiaoyaWebDavProxy</h1>
+<p>版本 {{.Version}} · 已运行 {{since .Start}} · 共 {{.Entries}} 个条目</p>
+
+<h2>挂载点</h2>
+<table>
+<tr><th>挂载点</th><th>条目数</th></tr>
+{{range .Mounts}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>最近一次重新加载</h2>
+{{with .Reload}}{{if .At.IsZero}}<p>启动后尚未重新加载</p>{{else}}
+<p>{{since .At}} 前: {{if .Result.Error}}<span class="bad">失败: {{.Result.Error}}</span>{{else}}{{.Result.Files}} 个文件, {{.Result.Dirs}} 个目录, 用时 {{.Result.ElapsedMs}}ms{{end}}</p>
+{{end}}{{if .Failures}}<p class="bad">连续失败 {{.Failures}} 次, 当前目录树已 {{since .LastGood}} 未更新</p>{{end}}{{end}}
+
+<h2>当前传输</h2>
+{{if .Streams}}<table>
+<tr><th>路径</th><th>客户端</th><th>已传输</th><th>速率</th><th>时长</th></tr>
+{{range .Streams}}<tr><td>{{.Path}}</td><td>{{.Remote}}</td><td>{{bytes .Bytes}}</td><td>{{bytes .BytesPerS}}/s</td><td>{{printf "%.0f" .Seconds}}s</td></tr>
+{{end}}</table>{{else}}<p>无</p>{{end}}
+
+{{if .Probes}}<h2>上游状态</h2>
+<table>
+<tr><th>上游</th><th>状态</th><th>最近探测</th><th>最近错误</th></tr>
+{{range .Probes}}<tr><td>{{.Host}}</td><td>{{if .Up}}在线{{else}}<span class="bad">离线 {{since .Since}}</span>{{end}}</td><td>{{if .LastCheck.IsZero}}-{{else}}{{since .LastCheck}} 前{{end}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>{{end}}
+
+{{with .Transfer}}<h2>本月流量 ({{.month}})</h2>
+<p>已用 {{bytes .used_bytes}}{{if .hard_limit}} / 上限 {{bytes .hard_limit}}{{end}}{{if .exhausted}} <span class="bad">已用尽</span>{{else if .throttled}} <span class="bad">已限速</span>{{end}}</p>
+{{end}}
+
+<h2>最近事件</h2>
+{{if .Events}}<table>
+<tr><th>时间</th><th>类型</th><th>路径</th><th>详情</th></tr>
+{{range .Events}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Type}}</td><td>{{.Path}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>{{else}}<p>无</p>{{end}}
+</body>
+</html>
+`))
+
+type statusMount struct {
+	Name  string
+	Count int
+}
+
+// handleStatus 渲染给人看的状态页, 未启用的子系统不显示对应部分
+func (fs *TextWebDAVFileSystem) handleStatus(w http.ResponseWriter, r *http.Request) {
+	counts := fs.mountCounts()
+	mounts := make([]statusMount, 0, len(counts))
+	entries := 0
+	for name, n := range counts {
+		mounts = append(mounts, statusMount{Name: name, Count: n})
+		entries += n
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Name < mounts[j].Name })
+
+	fs.reloadState.mu.Lock()
+	reload := struct {
+		At       time.Time
+		Result   ReloadResult
+		Failures int
+		LastGood time.Time
+	}{fs.reloadState.lastAt, fs.reloadState.last, fs.reloadState.failures, fs.reloadState.lastGood}
+	fs.reloadState.mu.Unlock()
+
+	data := map[string]interface{}{
+		"Version": version,
+		"Start":   startTime,
+		"Entries": entries,
+		"Mounts":  mounts,
+		"Reload":  reload,
+		"Streams": fs.streams.Snapshot(),
+		"Events":  RecentEvents(),
+		"Probes":  fs.prober.Snapshot(),
+	}
+	if fs.transfer != nil {
+		data["Transfer"] = fs.transfer.Stats()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := statusTemplate.Execute(w, data); err != nil {
+		fmt.Printf("渲染状态页失败: %v\n", err)
+	}
+}
+
+// formatBytes 把字节数格式化成 1024 进制的可读形式
+func formatBytes(v interface{}) string {
+	var n float64
+	switch x := v.(type) {
+	case int64:
+		n = float64(x)
+	case int:
+		n = float64(x)
+	case float64:
+		n = x
+	}
+
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.2f %s", n, units[i])
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StreamTracker 记录正在进行的 GET 传输, 供状态页和 /api/stats 展示,
+// 同时把 5xx 响应记为事件
+type StreamTracker struct {
+	mu     sync.Mutex
+	nextID int64
+	active map[int64]*activeStream
+}
+
+type activeStream struct {
+	path   string
+	remote string
+	start  time.Time
+	bytes  atomic.Int64
+}
+
+// StreamInfo 是一个进行中传输的快照
+type StreamInfo struct {
+	Path      string  `json:"path"`
+	Remote    string  `json:"remote"`
+	Seconds   float64 `json:"seconds"`
+	Bytes     int64   `json:"bytes"`
+	BytesPerS float64 `json:"bytes_per_second"`
+}
+
+func NewStreamTracker() *StreamTracker {
+	return &StreamTracker{active: make(map[int64]*activeStream)}
+}
+
+func (t *StreamTracker) middleware(fs *TextWebDAVFileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(sw, r)
+		} else {
+			s := &activeStream{path: r.URL.Path, remote: r.RemoteAddr, start: time.Now()}
+			sw.counter = &s.bytes
+
+			t.mu.Lock()
+			t.nextID++
+			id := t.nextID
+			t.active[id] = s
+			t.mu.Unlock()
+
+			next.ServeHTTP(sw, r)
+
+			t.mu.Lock()
+			delete(t.active, id)
+			t.mu.Unlock()
+		}
+
+		if sw.status >= 500 {
+			fs.emitEvent("server-error", r.URL.Path, fmt.Sprintf("%s %d", r.Method, sw.status))
+		}
+	})
+}
+
+func (t *StreamTracker) Snapshot() []StreamInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	streams := make([]StreamInfo, 0, len(t.active))
+	for _, s := range t.active {
+		elapsed := time.Since(s.start).Seconds()
+		info := StreamInfo{Path: s.path, Remote: s.remote, Seconds: elapsed, Bytes: s.bytes.Load()}
+		if elapsed > 0 {
+			info.BytesPerS = float64(info.Bytes) / elapsed
+		}
+		streams = append(streams, info)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].Seconds > streams[j].Seconds })
+	return streams
+}
+
+// statusWriter 记录响应状态码, 需要时统计写出的字节数
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	counter *atomic.Int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if w.counter != nil {
+		w.counter.Add(int64(n))
+	}
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
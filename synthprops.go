@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/xml"
+
+	"golang.org/x/net/webdav"
+)
+
+// synthesizedProp 是客户端常探测、但条目上没有保存的活属性. Windows 和 GNOME 的客户端
+// 在这些属性返回 404 时会反复重试, 按 value 生成后与死属性一起输出
+type synthesizedProp struct {
+	name xml.Name
+	// value 返回属性值, 条目没有该属性时返回 false, 仍按 404 列出
+	value func(fs *TextWebDAVFileSystem, meta *FileMeta) (string, bool)
+}
+
+// synthesizedProps 只在按名请求或 propname 时输出, allprop 不包含. 新增属性在这里加一行
+var synthesizedProps = []synthesizedProp{
+	// RFC 4918 第 15.3 节, 目录没有内容语言; -content-language 为空时不返回
+	{xml.Name{Space: "DAV:", Local: "getcontentlanguage"}, func(fs *TextWebDAVFileSystem, meta *FileMeta) (string, bool) {
+		return fs.contentLanguage, !meta.IsDir && fs.contentLanguage != ""
+	}},
+	// mod_dav 的可执行标记, 虚拟文件都不可执行
+	{xml.Name{Space: "http://apache.org/dav/props/", Local: "executable"}, func(fs *TextWebDAVFileSystem, meta *FileMeta) (string, bool) {
+		return "F", !meta.IsDir
+	}},
+	// RFC 2518 的 source, 条目没有单独的源文件, 返回空值
+	{xml.Name{Space: "DAV:", Local: "source"}, func(fs *TextWebDAVFileSystem, meta *FileMeta) (string, bool) {
+		return "", true
+	}},
+}
+
+// withSynthesized 在 dead 后追加 pf 请求的合成属性. 客户端用 PROPPATCH 写过同名属性时以写入的值为准
+func (fs *TextWebDAVFileSystem) withSynthesized(pf propfindRequest, meta *FileMeta, dead []webdav.Property) []webdav.Property {
+	if !pf.propname && len(pf.names) == 0 {
+		return dead
+	}
+next:
+	for _, sp := range synthesizedProps {
+		if !pf.propname && !pf.names[sp.name] {
+			continue
+		}
+		for _, d := range dead {
+			if d.XMLName == sp.name {
+				continue next
+			}
+		}
+		if v, ok := sp.value(fs, meta); ok {
+			dead = append(dead, webdav.Property{XMLName: sp.name, InnerXML: propInnerXML(v)})
+		}
+	}
+	return dead
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 每次按令牌写出的最大字节数. 大块写入拆开发送, 速率更平稳
+const throttleChunk = 32 << 10
+
+// Throttle 用令牌桶限制内容 GET 的发送速率: 每个流一个桶, 另有一个所有流共享的总桶.
+// PROPFIND/HEAD 等请求不经过限速
+type Throttle struct {
+	perStream int64
+	total     int64
+	shared    *tokenBucket
+}
+
+// ThrottleSettings 是 /api/stats 中的限速设置, 0 表示不限制
+type ThrottleSettings struct {
+	PerStream int64 `json:"max_bps_per_stream"`
+	Total     int64 `json:"max_bps_total"`
+}
+
+// NewThrottle 在两个限制都为 0 时返回 nil, 即不限速
+func NewThrottle(perStream, total int64) *Throttle {
+	if perStream <= 0 && total <= 0 {
+		return nil
+	}
+	t := &Throttle{perStream: perStream, total: total}
+	if total > 0 {
+		t.shared = newTokenBucket(total)
+	}
+	return t
+}
+
+func (t *Throttle) Settings() ThrottleSettings {
+	if t == nil {
+		return ThrottleSettings{}
+	}
+	return ThrottleSettings{PerStream: t.perStream, Total: t.total}
+}
+
+func (t *Throttle) middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tw := &throttledWriter{ResponseWriter: w, ctx: r.Context(), shared: t.shared}
+		if t.perStream > 0 {
+			tw.own = newTokenBucket(t.perStream)
+		}
+		next.ServeHTTP(tw, r)
+	})
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	own    *tokenBucket
+	shared *tokenBucket
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		wait := w.own.reserve(len(chunk))
+		if d := w.shared.reserve(len(chunk)); d > wait {
+			wait = d
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return written, w.ctx.Err()
+			}
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// tokenBucket 每秒补充 rate 个令牌, 最多积攒 1/4 秒的量, 避免空闲后突发
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	burst := float64(rate) / 4
+	if burst < throttleChunk {
+		burst = throttleChunk
+	}
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve 取走 n 个令牌并返回需要等待的时间. 令牌可以透支, 等待时间按透支量计算,
+// 多个流共享同一个桶时按先来后到排队
+func (b *tokenBucket) reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
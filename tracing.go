@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 轻量的请求追踪: 按 W3C traceparent 传播上下文, 以 OTLP/HTTP JSON 格式批量上报.
+// 未配置 -otlp-endpoint 时 tracer 为 nil, 中间件直接跳过, startSpan 返回 nil span,
+// Span 的方法都允许 nil 接收者, 因此关闭时没有任何分配
+var tracer *Tracer
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+type Tracer struct {
+	endpoint  string
+	service   string
+	anonymize bool
+	client    *http.Client
+	spans     chan *Span
+}
+
+type Span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   []spanAttr
+	failed  bool
+}
+
+type spanAttr struct {
+	key   string
+	str   string
+	num   int64
+	isNum bool
+}
+
+type spanKey struct{}
+
+func NewTracer(endpoint, service string, anonymize bool) *Tracer {
+	t := &Tracer{
+		endpoint:  strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service:   service,
+		anonymize: anonymize,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		spans:     make(chan *Span, 4096),
+	}
+	go t.exportLoop()
+	return t
+}
+
+func startSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpanKind(ctx, name, spanKindInternal)
+}
+
+func startSpanKind(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.traceID = parent.traceID
+		span.parent = parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *Span) SetString(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, spanAttr{key: key, str: value})
+}
+
+func (s *Span) SetInt(key string, value int64) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, spanAttr{key: key, num: value, isNum: true})
+}
+
+// SetPath 记录路径属性, 开启匿名化时只记录路径的哈希
+func (s *Span) SetPath(key, path string) {
+	if s == nil {
+		return
+	}
+	if tracer.anonymize {
+		sum := sha1.Sum([]byte(path))
+		path = hex.EncodeToString(sum[:8])
+	}
+	s.SetString(key, path)
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.failed = true
+	s.SetString("error.message", err.Error())
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	select {
+	case tracer.spans <- s:
+	default:
+	}
+}
+
+// injectTraceContext 把当前 span 写入发往上游请求的 traceparent 头
+func injectTraceContext(ctx context.Context, header http.Header) {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok && span != nil {
+		header.Set("traceparent", fmt.Sprintf("00-%x-%x-01", span.traceID, span.spanID))
+	}
+}
+
+// extractTraceContext 解析客户端传入的 traceparent, 返回可作为父 span 的上下文
+func extractTraceContext(ctx context.Context, header http.Header) context.Context {
+	parts := strings.Split(header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+
+	parent := &Span{}
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, parent)
+}
+
+type tracingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *tracingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tracingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *tracingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func tracingMiddleware(next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := extractTraceContext(r.Context(), r.Header)
+		ctx, span := startSpanKind(ctx, "WebDAV "+r.Method, spanKindServer)
+		span.SetString("http.method", r.Method)
+		span.SetPath("http.target", r.URL.Path)
+		span.SetInt("http.request_content_length", r.ContentLength)
+
+		tw := &tracingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+
+		if tw.status == 0 {
+			tw.status = http.StatusOK
+		}
+		span.SetInt("http.status_code", int64(tw.status))
+		span.SetInt("http.response_content_length", tw.bytes)
+		span.failed = tw.status >= 500
+		span.End()
+	})
+}
+
+func (t *Tracer) exportLoop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) < 512 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.export(batch); err != nil {
+			fmt.Printf("上报追踪数据失败: %v\n", err)
+		}
+		batch = nil
+	}
+}
+
+func (t *Tracer) export(batch []*Span) error {
+	type attrValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	type attr struct {
+		Key   string    `json:"key"`
+		Value attrValue `json:"value"`
+	}
+	type status struct {
+		Code int `json:"code"`
+	}
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId,omitempty"`
+		Name         string `json:"name"`
+		Kind         int    `json:"kind"`
+		Start        string `json:"startTimeUnixNano"`
+		End          string `json:"endTimeUnixNano"`
+		Attributes   []attr `json:"attributes,omitempty"`
+		Status       status `json:"status"`
+	}
+
+	spans := make([]span, 0, len(batch))
+	for _, s := range batch {
+		out := span{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:  status{Code: 1},
+		}
+		if s.parent != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.failed {
+			out.Status.Code = 2
+		}
+		for _, a := range s.attrs {
+			v := a.str
+			if a.isNum {
+				v = strconv.FormatInt(a.num, 10)
+				out.Attributes = append(out.Attributes, attr{Key: a.key, Value: attrValue{IntValue: &v}})
+			} else {
+				out.Attributes = append(out.Attributes, attr{Key: a.key, Value: attrValue{StringValue: &v}})
+			}
+		}
+		spans = append(spans, out)
+	}
+
+	service := t.service
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []attr{{Key: "service.name", Value: attrValue{StringValue: &service}}},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": service},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("收集器返回 %s", resp.Status)
+	}
+	return nil
+}
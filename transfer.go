@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TransferMeter 按自然月统计 GET 响应的发送字节数并持久化. 超过软阈值后每个流限速,
+// 超过硬上限后内容 GET 返回 503, PROPFIND/HEAD 等元数据操作不受影响
+type TransferMeter struct {
+	mu        sync.Mutex
+	loc       *time.Location
+	statePath string
+	month     string
+	bytes     int64
+	dirty     bool
+
+	softLimit int64
+	hardLimit int64
+	softRate  int64
+}
+
+type transferState struct {
+	Month string `json:"month"`
+	Bytes int64  `json:"bytes"`
+}
+
+func NewTransferMeter(statePath string, loc *time.Location, softLimit, hardLimit, softRate int64) (*TransferMeter, error) {
+	m := &TransferMeter{
+		loc:       loc,
+		statePath: statePath,
+		softLimit: softLimit,
+		hardLimit: hardLimit,
+		softRate:  softRate,
+	}
+	m.month = m.monthOf(time.Now())
+
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("读取流量统计失败: %v", err)
+		}
+		if err == nil {
+			var state transferState
+			if err := json.Unmarshal(data, &state); err != nil {
+				fmt.Printf("流量统计文件 %s 损坏, 从零开始计数: %v\n", statePath, err)
+			} else if state.Month == m.month {
+				m.bytes = state.Bytes
+			}
+		}
+		go m.saveLoop()
+	}
+	return m, nil
+}
+
+func (m *TransferMeter) monthOf(t time.Time) string {
+	return t.In(m.loc).Format("2006-01")
+}
+
+// rollLocked 在跨月时清零计数
+func (m *TransferMeter) rollLocked(now time.Time) {
+	if month := m.monthOf(now); month != m.month {
+		fmt.Printf("流量统计进入新月份 %s, 上月用量 %d 字节\n", month, m.bytes)
+		m.month = month
+		m.bytes = 0
+		m.dirty = true
+	}
+}
+
+func (m *TransferMeter) Add(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollLocked(time.Now())
+	m.bytes += n
+	m.dirty = true
+}
+
+func (m *TransferMeter) Used() (string, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollLocked(time.Now())
+	return m.month, m.bytes
+}
+
+func (m *TransferMeter) overHard() bool {
+	_, used := m.Used()
+	return m.hardLimit > 0 && used >= m.hardLimit
+}
+
+func (m *TransferMeter) throttled() bool {
+	_, used := m.Used()
+	return m.softLimit > 0 && m.softRate > 0 && used >= m.softLimit
+}
+
+// untilNextMonth 返回到下个月开始的时长, 用作 Retry-After
+func (m *TransferMeter) untilNextMonth() time.Duration {
+	now := time.Now().In(m.loc)
+	next := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, m.loc)
+	return next.Sub(now)
+}
+
+func (m *TransferMeter) saveLoop() {
+	for range time.Tick(30 * time.Second) {
+		if err := m.Save(); err != nil {
+			fmt.Printf("保存流量统计失败: %v\n", err)
+		}
+	}
+}
+
+func (m *TransferMeter) Save() error {
+	if m == nil || m.statePath == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(transferState{Month: m.month, Bytes: m.bytes})
+	m.dirty = false
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := m.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.statePath)
+}
+
+func (m *TransferMeter) Stats() map[string]interface{} {
+	month, used := m.Used()
+	return map[string]interface{}{
+		"month":      month,
+		"used_bytes": used,
+		"soft_limit": m.softLimit,
+		"hard_limit": m.hardLimit,
+		"throttled":  m.throttled(),
+		"exhausted":  m.overHard(),
+	}
+}
+
+// middleware 统计 GET 响应字节数, 并在超过阈值时限速或拒绝内容请求
+func (m *TransferMeter) middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m.overHard() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(m.untilNextMonth().Seconds())))
+			http.Error(w, "本月流量已用尽, 下月恢复", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(&meteredWriter{ResponseWriter: w, meter: m}, r)
+	})
+}
+
+type meteredWriter struct {
+	http.ResponseWriter
+	meter *TransferMeter
+
+	throttleStart time.Time
+	throttleSent  int64
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	if w.meter.throttled() {
+		if w.throttleStart.IsZero() {
+			w.throttleStart = time.Now()
+		}
+		w.throttleSent += int64(len(p))
+		due := time.Duration(float64(w.throttleSent) / float64(w.meter.softRate) * float64(time.Second))
+		if wait := due - time.Since(w.throttleStart); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.meter.Add(int64(n))
+	return n, err
+}
+
+func (w *meteredWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
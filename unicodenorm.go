@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// parseUnicodeForm 解析 -unicode-norm. macOS Finder 发送 NFD 分解形式的路径,
+// 而列表一般是 NFC, 统一成同一种形式后两边才能在 map 中对上
+func parseUnicodeForm(s string) (*norm.Form, error) {
+	var f norm.Form
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "nfc":
+		f = norm.NFC
+	case "nfd":
+		f = norm.NFD
+	default:
+		return nil, fmt.Errorf("未知的 Unicode 规范化形式 %q, 可选 nfc、nfd", s)
+	}
+	return &f, nil
+}
+
+// normPath 把路径转换成配置的规范化形式, 未开启时原样返回.
+// 只用于路径 (map 的键), 显示名保持加载时的原样
+func (fs *TextWebDAVFileSystem) normPath(p string) string {
+	if fs.pathForm == nil || fs.pathForm.IsNormalString(p) {
+		return p
+	}
+	return fs.pathForm.String(p)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestUnicodeNormMatchesBothForms(t *testing.T) {
+	nfc := "/哪吒/café.mkv"
+	nfd := norm.NFD.String(nfc)
+	if nfc == nfd {
+		t.Fatal("the test name has no decomposed form")
+	}
+
+	for _, form := range []string{"nfc", "nfd"} {
+		for _, listed := range []string{nfc, nfd} {
+			fs := newTestFS(t, "")
+			f, err := parseUnicodeForm(form)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs.pathForm = f
+			if _, err := fs.LoadFromText(listed + "#10#" + listed[len("/哪吒/"):] + "\n"); err != nil {
+				t.Fatal(err)
+			}
+
+			key := f.String(nfc)
+			meta := fs.Files[key]
+			if meta == nil || len(fs.Files) != 2 {
+				t.Fatalf("%s, listed %+q: entries %v, want the key %+q", form, listed, fs.Files, key)
+			}
+			// 显示名保持列表中的原样
+			if want := listed[len("/哪吒/"):]; meta.DisplayName != want {
+				t.Errorf("%s: display name %+q, want %+q", form, meta.DisplayName, want)
+			}
+			for _, req := range []string{nfc, nfd} {
+				if _, err := fs.Stat(context.Background(), req); err != nil {
+					t.Errorf("%s, listed %+q: Stat(%+q): %v", form, listed, req, err)
+				}
+			}
+		}
+	}
+}
+
+func TestUnicodeNormOffKeepsPathsAsListed(t *testing.T) {
+	nfc := "/哪吒/café.mkv"
+	fs := newTestFS(t, nfc+"#10#café.mkv\n")
+	if _, err := fs.Stat(context.Background(), norm.NFD.String(nfc)); err == nil {
+		t.Error("the NFD path matched without -unicode-norm")
+	}
+	if _, err := parseUnicodeForm("nfkc"); err == nil {
+		t.Error("parseUnicodeForm accepted nfkc")
+	}
+}
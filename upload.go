@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// 上传目标的两种方式: 直接 PUT 到映射的地址, 或调用 Alist 的 /api/fs/put
+const (
+	uploadHTTP  = "http"
+	uploadAlist = "alist"
+)
+
+// UploadRules 把某些目录配置为可写: 客户端 PUT 到这些目录下的内容边收边转发给上游,
+// 上传成功后条目留在目录树中. 没有配置的目录只能创建空文件, 写入内容会失败
+type UploadRules []uploadRule
+
+type uploadRule struct {
+	prefix string
+	kind   string
+	base   *url.URL
+}
+
+// Add 解析 "/inbox=http://nas/dav/inbox" 或 "/inbox=alist:http://alist:5244/远端目录"
+func (u *UploadRules) Add(rule string) error {
+	prefix, raw, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("上传规则格式错误, 需要 /前缀=地址: %s", redactURL(rule))
+	}
+	prefix = strings.TrimSpace(prefix)
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("上传规则的路径必须以 / 开头: %q", prefix)
+	}
+	kind := uploadHTTP
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(raw), "alist:"); ok {
+		kind, raw = uploadAlist, rest
+	}
+	base, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(raw), "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return fmt.Errorf("上传规则的地址无效: %s", redactURL(raw))
+	}
+	*u = append(*u, uploadRule{prefix: path.Clean(prefix), kind: kind, base: base})
+	return nil
+}
+
+// For 返回 p 所在的可写目录规则和 p 相对于它的路径
+func (u UploadRules) For(p string) (uploadRule, string, bool) {
+	best := -1
+	for i, r := range u {
+		if (strings.HasPrefix(p, r.prefix+"/") || r.prefix == "/") && (best < 0 || len(r.prefix) > len(u[best].prefix)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return uploadRule{}, "", false
+	}
+	rest := p
+	if u[best].prefix != "/" {
+		rest = strings.TrimPrefix(p, u[best].prefix)
+	}
+	return u[best], rest, true
+}
+
+// uploadSizeKey 在请求上下文中保存 PUT 的 Content-Length, 转发时原样告诉上游
+type uploadSizeKey struct{}
+
+func withUploadSize(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), uploadSizeKey{}, r.ContentLength))
+}
+
+// upload 是一个进行中的上传, 写入的内容经管道直接流给上游的请求体, 不在内存中缓存整个文件
+type upload struct {
+	pw      *io.PipeWriter
+	done    chan error
+	written int64
+	// url 是上传完成后条目的内容地址
+	url string
+}
+
+// writable 报告 name 能否写入内容, 只有可写目录下的文件可以
+func (fs *TextWebDAVFileSystem) writable(name string) bool {
+	_, _, ok := fs.uploads.For(name)
+	return ok
+}
+
+// startUpload 开始把 name 的内容上传到所在可写目录的上游. 不在可写目录下时返回 os.ErrPermission
+func (fs *TextWebDAVFileSystem) startUpload(ctx context.Context, name string) (*upload, error) {
+	rule, rest, ok := fs.uploads.For(name)
+	if !ok {
+		return nil, os.ErrPermission
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	pr, pw := io.Pipe()
+	u := &upload{pw: pw, done: make(chan error, 1)}
+	var req *http.Request
+	var err error
+	switch rule.kind {
+	case uploadAlist:
+		remote := path.Join("/", rule.base.Path, rest)
+		api := &url.URL{Scheme: rule.base.Scheme, Host: rule.base.Host, Path: "/api/fs/put"}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, api.String(), pr)
+		if err == nil {
+			req.Header.Set("File-Path", url.PathEscape(remote))
+			req.Header.Set("As-Task", "false")
+		}
+	default:
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, rule.contentURL(rest), pr)
+	}
+	u.url = rule.contentURL(rest)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if size, ok := ctx.Value(uploadSizeKey{}).(int64); ok && size >= 0 {
+		req.ContentLength = size
+	}
+	fs.authorizeUpstream(req)
+	fs.setUserAgent(ctx, req, name)
+	fs.upstreamHeaders.apply(req, req.URL.String())
+
+	go func() {
+		err := fs.doUpload(req, rule.kind)
+		// 上游提前失败时让还在进行的 Write 立即返回错误
+		pr.CloseWithError(err)
+		u.done <- err
+	}()
+	return u, nil
+}
+
+// contentURL 返回上传到可写目录中 rest 的内容之后的读取地址
+func (r uploadRule) contentURL(rest string) string {
+	if r.kind == uploadAlist {
+		remote := path.Join("/", r.base.Path, rest)
+		return (&url.URL{Scheme: r.base.Scheme, Host: r.base.Host, Path: path.Join("/d", remote)}).String()
+	}
+	return r.base.ResolveReference(&url.URL{Path: path.Join(r.base.Path, rest)}).String()
+}
+
+func (fs *TextWebDAVFileSystem) doUpload(req *http.Request, kind string) error {
+	resp, err := fs.fetcher.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("上游返回 %d", resp.StatusCode)
+	}
+	if kind == uploadAlist {
+		var result struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("Alist 返回的内容无法解析: %v", err)
+		}
+		if result.Code != http.StatusOK {
+			return fmt.Errorf("Alist 上传失败: %d %s", result.Code, result.Message)
+		}
+	}
+	return nil
+}
+
+func (u *upload) Write(p []byte) (int, error) {
+	n, err := u.pw.Write(p)
+	u.written += int64(n)
+	return n, err
+}
+
+// finish 结束请求体并等待上游的结果. cause 非空表示写入过程中已经出错, 上传随之中止
+func (u *upload) finish(cause error) error {
+	if cause != nil {
+		u.pw.CloseWithError(cause)
+	} else {
+		u.pw.Close()
+	}
+	err := <-u.done
+	if cause != nil {
+		return cause
+	}
+	return err
+}
+
+// finishWrite 在文件关闭时结束写入. 上传成功后更新条目的大小、修改时间和内容地址;
+// 失败时删除本次 PUT 新建的条目, 不留下看似成功的空文件
+func (f *VirtualFile) finishWrite() error {
+	err := f.writeErr
+	if f.upload != nil {
+		err = f.upload.finish(err)
+	}
+
+	fs := f.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// 条目可能在上传期间被移动, 在锁内取它当前的路径和显示名
+	name, displayName := f.meta.Path, f.meta.DisplayName
+	if err != nil {
+		if cur, ok := fs.Files[name]; ok && cur == f.meta && f.created {
+			fs.removeAllLocked(name)
+			fs.recordMutation(JournalRecord{Op: JournalDelete, Path: name})
+		}
+		fmt.Printf("写入 %s 失败: %v\n", name, err)
+		return err
+	}
+
+	if _, err := fs.putLocked(name, f.upload.written, displayName, f.upload.url); err != nil {
+		return err
+	}
+	if f.hasher != nil {
+		meta := fs.Files[name]
+		meta.MD5, meta.SHA1 = f.hasher.Sums()
+	}
+	fs.recordMutation(JournalRecord{Op: JournalPut, Path: name, Size: f.upload.written, Name: displayName, URL: f.upload.url})
+	fs.emitEvent("uploaded", name, fmt.Sprintf("size=%d", f.upload.written))
+	return nil
+}
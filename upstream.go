@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// upstreamCredential 是按地址前缀匹配的上游认证信息. 列表和抓取结果里只保存
+// 不带认证的地址, 认证在发往上游的请求上注入
+type upstreamCredential struct {
+	prefix string
+	user   string
+	pass   string
+}
+
+func (fs *TextWebDAVFileSystem) addUpstreamCredential(prefix, user, pass string) {
+	fs.upstreamCreds = append(fs.upstreamCreds, upstreamCredential{prefix: prefix, user: user, pass: pass})
+}
+
+// authorizeUpstream 为发往上游的请求设置最长前缀匹配的认证信息
+func (fs *TextWebDAVFileSystem) authorizeUpstream(req *http.Request) {
+	target := req.URL.String()
+	var best *upstreamCredential
+	for i := range fs.upstreamCreds {
+		c := &fs.upstreamCreds[i]
+		if strings.HasPrefix(target, c.prefix) && (best == nil || len(c.prefix) > len(best.prefix)) {
+			best = c
+		}
+	}
+	if best != nil && best.user != "" {
+		req.SetBasicAuth(best.user, best.pass)
+	}
+}
+
+// UpstreamHeaders 是附加到所有上游内容请求和抓取请求上的固定请求头 (例如 Alist 令牌或 Cookie).
+// 规则可以限定地址前缀, 同名的头以最长前缀为准. 值只用于发往上游的请求, 不写日志
+type UpstreamHeaders []upstreamHeader
+
+type upstreamHeader struct {
+	prefix string
+	name   string
+	value  string
+}
+
+// 跳转到其它主机后不再携带这些头, 与 net/http 跟随跳转时的做法一致
+var sensitiveUpstreamHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// Add 解析 "名称: 值" 或 "http://上游前缀=名称: 值"
+func (h *UpstreamHeaders) Add(rule string) error {
+	var prefix string
+	if strings.HasPrefix(rule, "http://") || strings.HasPrefix(rule, "https://") {
+		var ok bool
+		if prefix, rule, ok = strings.Cut(rule, "="); !ok {
+			return fmt.Errorf("上游请求头规则需要 前缀=名称: 值")
+		}
+	}
+	name, value, ok := strings.Cut(rule, ":")
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		// 值可能是令牌, 错误信息中只给出名称
+		return fmt.Errorf("上游请求头规则需要 名称: 值, 名称 %q", name)
+	}
+	*h = append(*h, upstreamHeader{prefix: prefix, name: name, value: strings.TrimSpace(value)})
+	return nil
+}
+
+// resolve 返回适用于 target 的请求头, 每个名称取最长前缀的规则
+func (h UpstreamHeaders) resolve(target string) map[string]upstreamHeader {
+	out := make(map[string]upstreamHeader)
+	for _, hv := range h {
+		if !strings.HasPrefix(target, hv.prefix) {
+			continue
+		}
+		if cur, ok := out[hv.name]; !ok || len(hv.prefix) >= len(cur.prefix) {
+			out[hv.name] = hv
+		}
+	}
+	return out
+}
+
+// apply 按逻辑上的上游地址 target 选出请求头并设置到 req 上.
+// req 实际发往别的主机时 (例如缓存的跳转地址) 不带认证类的头
+func (h UpstreamHeaders) apply(req *http.Request, target string) {
+	if len(h) == 0 {
+		return
+	}
+	sameHost := true
+	if u, err := url.Parse(target); err == nil {
+		sameHost = u.Host == req.URL.Host
+	}
+	for name, hv := range h.resolve(target) {
+		if !sameHost && sensitiveUpstreamHeaders[name] {
+			continue
+		}
+		req.Header.Set(name, hv.value)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// signPlaceholder 原样留在模板生成的地址中, 每次请求上游时由 signedURL 换成当前的签名,
+// 这样同一文件的地址保持不变, 跳转缓存和按地址配置的请求头仍然有效
+const signPlaceholder = "{sign}"
+
+// URLTemplates 按虚拟路径生成上游地址, 列表中的条目不必各自保存完整地址.
+// 按路径前缀配置, 最长匹配优先, 前缀为空的是没有匹配时使用的默认模板. 可用的变量:
+// {path} 完整虚拟路径, {rel} 去掉前缀后的路径, {name} 文件名, 路径按段转义;
+// {sign} 为 -sign-scheme 计算的签名
+type URLTemplates []urlTemplate
+
+type urlTemplate struct {
+	prefix string
+	tmpl   string
+}
+
+// Add 解析 "/电影=http://alist:5244/d{path}?sign={sign}", 不带前缀时作为默认模板
+func (t *URLTemplates) Add(rule string) error {
+	prefix, tmpl := "", strings.TrimSpace(rule)
+	if strings.HasPrefix(rule, "/") {
+		var ok bool
+		prefix, tmpl, ok = strings.Cut(rule, "=")
+		if !ok {
+			return fmt.Errorf("地址模板格式错误, 需要 /前缀=模板 或 模板: %q", rule)
+		}
+		prefix, tmpl = path.Clean(strings.TrimSpace(prefix)), strings.TrimSpace(tmpl)
+	}
+	if !strings.Contains(tmpl, "{path}") && !strings.Contains(tmpl, "{rel}") && !strings.Contains(tmpl, "{name}") {
+		return fmt.Errorf("地址模板中至少要有 {path}、{rel} 或 {name} 之一: %q", rule)
+	}
+	u, err := url.Parse(strings.NewReplacer("{path}", "", "{rel}", "", "{name}", "", signPlaceholder, "").Replace(tmpl))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("地址模板不是有效的地址: %s", redactURL(tmpl))
+	}
+	*t = append(*t, urlTemplate{prefix: prefix, tmpl: tmpl})
+	return nil
+}
+
+// signed 报告是否有模板使用了 {sign}
+func (t URLTemplates) signed() bool {
+	for _, ut := range t {
+		if strings.Contains(ut.tmpl, signPlaceholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve 返回 p 对应的上游地址, 没有匹配的模板时返回空串. {sign} 保留为占位符
+func (t URLTemplates) Resolve(p string) string {
+	best := -1
+	for i, ut := range t {
+		match := ut.prefix == "" || ut.prefix == "/" || p == ut.prefix || strings.HasPrefix(p, ut.prefix+"/")
+		if match && (best < 0 || len(ut.prefix) > len(t[best].prefix)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	rel := p
+	if prefix := t[best].prefix; prefix != "" && prefix != "/" {
+		rel = strings.TrimPrefix(p, prefix)
+	}
+	return strings.NewReplacer(
+		"{path}", escapePath(p),
+		"{rel}", escapePath(rel),
+		"{name}", url.PathEscape(path.Base(p)),
+	).Replace(t[best].tmpl)
+}
+
+// printUpstreamURLs 实现 -print-url: 打印 p 按当前配置请求的上游地址, 有签名时按当前时间计算.
+// 返回进程退出码
+func (fs *TextWebDAVFileSystem) printUpstreamURLs(p string) int {
+	name := fs.normPath(path.Clean("/" + p))
+	fs.mu.RLock()
+	meta, ok := fs.Files[name]
+	fs.mu.RUnlock()
+	if !ok {
+		fmt.Printf("%s 不在目录树中\n", name)
+		return 1
+	}
+	if meta.IsDir {
+		fmt.Printf("%s 是目录, 没有上游地址\n", name)
+		return 1
+	}
+	if meta.Content != nil {
+		fmt.Printf("%s 的内容在本地, 不请求上游\n", name)
+		return 0
+	}
+	targets := fs.upstreamURLs(meta)
+	if len(targets) == 0 {
+		fmt.Printf("%s: %v\n", name, errNoBackend)
+		return 1
+	}
+	for _, target := range targets {
+		fmt.Println(fs.signedURL(target))
+	}
+	return 0
+}
+
+// escapePath 逐段转义路径, 保留分隔的 /. 中文和空格转成 %XX, 段中的 ? # 等也会转义
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
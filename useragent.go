@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 规则的值为 forward 时把 WebDAV 客户端自己的 User-Agent 转给上游
+const userAgentForward = "forward"
+
+// UserAgentRules 决定请求上游时使用的 User-Agent. 部分 Alist 驱动 (115、夸克等) 会拒绝
+// Go 默认的 User-Agent. 按虚拟路径前缀配置, 最长匹配优先, 键为空串的是全局设置;
+// 没有匹配时保持 Go 默认值. -upstream-header 中为上游地址配置的 User-Agent 优先于这里
+type UserAgentRules map[string]string
+
+// Add 解析 "/115=forward" 或 "/quark=Mozilla/5.0 ...", 不带前缀时作为全局设置
+func (u UserAgentRules) Add(rule string) error {
+	prefix, value := "", strings.TrimSpace(rule)
+	if strings.HasPrefix(rule, "/") {
+		var ok bool
+		prefix, value, ok = strings.Cut(rule, "=")
+		if !ok {
+			return fmt.Errorf("User-Agent 规则格式错误, 需要 /前缀=forward 或 /前缀=字符串: %q", rule)
+		}
+		prefix, value = strings.TrimSuffix(strings.TrimSpace(prefix), "/"), strings.TrimSpace(value)
+	}
+	if value == "" {
+		return fmt.Errorf("User-Agent 不能为空: %q", rule)
+	}
+	u[prefix] = value
+	return nil
+}
+
+func (u UserAgentRules) For(name string) string {
+	best, value := -1, ""
+	for prefix, v := range u {
+		if (prefix == "" || name == prefix || strings.HasPrefix(name, prefix+"/")) && len(prefix) > best {
+			best, value = len(prefix), v
+		}
+	}
+	return value
+}
+
+type clientUserAgentKey struct{}
+
+// withClientUserAgent 把客户端的 User-Agent 放进请求上下文, 请求上游时按规则转发
+func withClientUserAgent(r *http.Request) *http.Request {
+	ua := r.Header.Get("User-Agent")
+	if ua == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), clientUserAgentKey{}, ua))
+}
+
+// userAgent 返回请求 name 的上游时应使用的 User-Agent, 空串表示保持默认.
+// forward 规则下 ctx 中没有客户端的 User-Agent (例如健康探测) 时也保持默认
+func (fs *TextWebDAVFileSystem) userAgent(ctx context.Context, name string) string {
+	ua := fs.userAgents.For(name)
+	if ua != userAgentForward {
+		return ua
+	}
+	if ctx != nil {
+		if client, ok := ctx.Value(clientUserAgentKey{}).(string); ok {
+			return client
+		}
+	}
+	return ""
+}
+
+func (fs *TextWebDAVFileSystem) setUserAgent(ctx context.Context, req *http.Request, name string) {
+	if ua := fs.userAgent(ctx, name); ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const driverUA = "Mozilla/5.0 115Browser/27.0"
+
+func TestUserAgentOverrideFixesRejectingBackend(t *testing.T) {
+	body := []byte("0123456789")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != driverUA {
+			http.Error(w, "forbidden user agent", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	get := func(rule, clientUA string) *httptest.ResponseRecorder {
+		fs := newTestFS(t, "/115/a.mkv#10#a.mkv\n")
+		withBackend(t, fs, srv)
+		if rule != "" {
+			if err := fs.userAgents.Add(rule); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := httptest.NewRequest(http.MethodGet, "/115/a.mkv", nil)
+		r.Header.Set("User-Agent", clientUA)
+		r = withClientUserAgent(r)
+		meta := *fs.Files["/115/a.mkv"]
+		w := httptest.NewRecorder()
+		fs.serveUpstream(w, r, &meta)
+		return w
+	}
+
+	if w := get("", driverUA); w.Code == http.StatusOK {
+		t.Errorf("without a rule the Go default User-Agent was accepted")
+	}
+	if w := get("/other=forward", driverUA); w.Code == http.StatusOK {
+		t.Errorf("a rule for another prefix forwarded the client User-Agent")
+	}
+	for _, tt := range []struct{ rule, client string }{
+		{"/115=" + driverUA, "Microsoft-WebDAV-MiniRedir/10.0"},
+		{"/115=forward", driverUA},
+		{driverUA, ""},
+	} {
+		if w := get(tt.rule, tt.client); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), body) {
+			t.Errorf("rule %q, client %q: status %d", tt.rule, tt.client, w.Code)
+		}
+	}
+}
+
+func TestUserAgentRulesLongestPrefix(t *testing.T) {
+	u := make(UserAgentRules)
+	for _, rule := range []string{"global", "/115=forward", "/115/vip=vip"} {
+		if err := u.Add(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{
+		"/a.mkv":         "global",
+		"/115/a.mkv":     "forward",
+		"/115/vip/a.mkv": "vip",
+		"/1150/a.mkv":    "global",
+	} {
+		if got := u.For(name); got != want {
+			t.Errorf("For(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if err := u.Add("/115="); err == nil {
+		t.Error("an empty User-Agent was accepted")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SizeMismatch 记录列表声明的大小与上游实际大小不一致的条目
+type SizeMismatch struct {
+	Path      string    `json:"path"`
+	Declared  int64     `json:"declared"`
+	Upstream  int64     `json:"upstream"`
+	Corrected bool      `json:"corrected"`
+	Time      time.Time `json:"time"`
+}
+
+type MismatchReport struct {
+	mu      sync.Mutex
+	entries map[string]SizeMismatch
+}
+
+func NewMismatchReport() *MismatchReport {
+	return &MismatchReport{entries: make(map[string]SizeMismatch)}
+}
+
+func (r *MismatchReport) Add(m SizeMismatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[m.Path] = m
+}
+
+func (r *MismatchReport) List() []SizeMismatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]SizeMismatch, 0, len(r.entries))
+	for _, m := range r.entries {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// upstreamSize 从上游响应中取出文件总大小, 206 取 Content-Range 的总长度, 200 取 Content-Length
+func upstreamSize(resp *http.Response) (int64, bool) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if resp.ContentLength >= 0 {
+			return resp.ContentLength, true
+		}
+	case http.StatusPartialContent:
+		cr := resp.Header.Get("Content-Range")
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if total, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				return total, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// verifyUpstreamSize 在校验模式下比较上游响应与列表声明的大小,
+// 不一致时记录到报告, 并在开启自动修正时更新内存中的大小
+func (fs *TextWebDAVFileSystem) verifyUpstreamSize(path string, resp *http.Response) {
+	if fs.mismatches == nil {
+		return
+	}
+	actual, ok := upstreamSize(resp)
+	if !ok {
+		return
+	}
+
+	fs.mu.Lock()
+	meta, exists := fs.Files[path]
+	if !exists || meta.Size == actual {
+		fs.mu.Unlock()
+		return
+	}
+	declared := meta.Size
+	if fs.fixSize {
+		meta.Size = actual
+		meta.SizeVerified = true
+		// 列表声明的 ETag 对应旧内容, 清掉后按新大小重新生成
+		meta.ETag = ""
+		fs.treeChanged()
+	}
+	fs.mu.Unlock()
+
+	fmt.Printf("大小不一致: %s 列表 %d 上游 %d\n", path, declared, actual)
+	fs.mismatches.Add(SizeMismatch{
+		Path:      path,
+		Declared:  declared,
+		Upstream:  actual,
+		Corrected: fs.fixSize,
+		Time:      time.Now(),
+	})
+	fs.emitEvent("size-mismatch", path, fmt.Sprintf("declared=%d upstream=%d corrected=%v", declared, actual, fs.fixSize))
+}
+
+func (fs *TextWebDAVFileSystem) handleMismatches(w http.ResponseWriter, r *http.Request) {
+	if fs.mismatches == nil {
+		http.Error(w, "未开启大小校验", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, fs.mismatches.List())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func rangedUpstream(t *testing.T, body []byte) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", testModTime, bytes.NewReader(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetVerifiesAndCorrectsSize(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 20)
+	fs := newTestFS(t, "/d/a.mkv#10#a.mkv##abc\n/d/b.mkv#5#b.mkv\n")
+	withBackend(t, fs, rangedUpstream(t, body))
+	fs.mismatches = NewMismatchReport()
+	fs.fixSize = true
+
+	fs.mu.RLock()
+	used, _ := fs.usage("/d")
+	fs.mu.RUnlock()
+	if used != 15 {
+		t.Fatalf("used before = %d", used)
+	}
+
+	w := getUpstream(fs, "/d/a.mkv", http.Header{"Range": {"bytes=0-3"}})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status %d", w.Code)
+	}
+
+	meta := fs.Files["/d/a.mkv"]
+	if meta.Size != 20 || !meta.SizeVerified {
+		t.Errorf("size %d, verified %v; want the upstream size", meta.Size, meta.SizeVerified)
+	}
+	if meta.ETag != "" || meta.etag() == `"abc"` {
+		t.Errorf("the list ETag survived the correction: %q", meta.etag())
+	}
+	fs.mu.RLock()
+	used, _ = fs.usage("/d")
+	fs.mu.RUnlock()
+	if used != 25 {
+		t.Errorf("used after = %d, want 25", used)
+	}
+
+	rec := httptest.NewRecorder()
+	fs.handleMismatches(rec, httptest.NewRequest(http.MethodGet, "/api/mismatches", nil))
+	var list []SizeMismatch
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Path != "/d/a.mkv" || list[0].Declared != 10 || list[0].Upstream != 20 || !list[0].Corrected {
+		t.Errorf("/api/mismatches = %+v", list)
+	}
+}
+
+func TestGetReportsSizeWithoutCorrecting(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv##abc\n")
+	withBackend(t, fs, rangedUpstream(t, bytes.Repeat([]byte("x"), 20)))
+	fs.mismatches = NewMismatchReport()
+
+	if w := getUpstream(fs, "/a.mkv", nil); w.Code != http.StatusOK || w.Body.Len() != 20 {
+		t.Fatalf("status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if meta := fs.Files["/a.mkv"]; meta.Size != 10 || meta.ETag != `"abc"` || meta.SizeVerified {
+		t.Errorf("entry changed without -fix-size: %+v", meta)
+	}
+	if list := fs.mismatches.List(); len(list) != 1 || list[0].Corrected {
+		t.Errorf("mismatches = %+v", list)
+	}
+}
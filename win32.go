@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Windows 资源管理器 (WebClient) 上传后用 PROPPATCH 写入的属性都在这个命名空间下,
+// 原始值作为死属性保存以便原样返回, 其中的时间同时反映到条目的修改/创建时间
+const win32Namespace = "urn:schemas-microsoft-com:"
+
+// applyWin32Time 解析 Win32LastModifiedTime / Win32CreationTime 并更新条目.
+// 无法解析的值只保留原始属性, 不让整个 PROPPATCH 失败
+func applyWin32Time(meta *FileMeta, local, value string) {
+	if local != "Win32LastModifiedTime" && local != "Win32CreationTime" {
+		return
+	}
+	t, ok := parseWin32Time(value)
+	if !ok {
+		return
+	}
+	if local == "Win32LastModifiedTime" {
+		meta.ModTime = t
+	} else {
+		meta.Created = t
+	}
+}
+
+// parseWin32Time 接受 WebClient 发送的 RFC 1123 格式 (Thu, 15 Oct 2026 03:59:34 GMT),
+// 以及部分客户端使用的 RFC 3339 格式
+func parseWin32Time(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{http.TimeFormat, time.RFC1123, time.RFC1123Z, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// creationDate 返回 PROPFIND 中的 creationdate, 按 RFC 4918 使用 RFC 3339 格式.
+// 未设置创建时间时取修改时间, Windows 资源管理器缺少这个属性时会显示成 1970 年
+func (m *FileMeta) creationDate() *string {
+	t := m.Created
+	if t.IsZero() {
+		t = m.ModTime
+	}
+	return strPtr(t.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// webClientProppatch 是 Windows 资源管理器上传文件后发送的 PROPPATCH
+const webClientProppatch = `<?xml version="1.0" encoding="utf-8" ?>` +
+	`<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:"><D:set><D:prop>` +
+	`<Z:Win32CreationTime>Mon, 12 Oct 2026 08:30:00 GMT</Z:Win32CreationTime>` +
+	`<Z:Win32LastAccessTime>Thu, 15 Oct 2026 03:59:34 GMT</Z:Win32LastAccessTime>` +
+	`<Z:Win32LastModifiedTime>Tue, 13 Oct 2026 21:15:07 GMT</Z:Win32LastModifiedTime>` +
+	`<Z:Win32FileAttributes>00000020</Z:Win32FileAttributes>` +
+	`</D:prop></D:set></D:propertyupdate>`
+
+// okValues 返回 PROPFIND 第一个 response 中 200 的属性值
+func okValues(t *testing.T, body string) map[string]string {
+	t.Helper()
+	var ms multistatus
+	if err := xml.Unmarshal([]byte(body), &ms); err != nil || len(ms.Responses) == 0 {
+		t.Fatalf("invalid multistatus: %v\n%s", err, body)
+	}
+	values := make(map[string]string)
+	for _, ps := range ms.Responses[0].Propstats {
+		if ps.Status != statusLine(http.StatusOK) {
+			continue
+		}
+		for _, p := range ps.Prop.Props {
+			values[p.XMLName.Local] = p.InnerXML
+		}
+	}
+	return values
+}
+
+func head(fs *TextWebDAVFileSystem, name string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodHead, name, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	fs.mu.RLock()
+	meta := *fs.Files[name]
+	fs.mu.RUnlock()
+	w := httptest.NewRecorder()
+	fs.serveHead(w, r, &meta)
+	return w
+}
+
+func TestWin32PropertiesRoundTrip(t *testing.T) {
+	fs := newTestFS(t, "/up/a.mkv#10#a.mkv\n")
+	before := fs.Files["/up/a.mkv"].etag()
+
+	w := proppatch(fs, "/up/a.mkv", webClientProppatch)
+	if got := propStatuses(t, w.Body.String()); got[http.StatusOK] != "Win32CreationTime,Win32FileAttributes,Win32LastAccessTime,Win32LastModifiedTime" {
+		t.Fatalf("propstats = %v", got)
+	}
+
+	modified := time.Date(2026, 10, 13, 21, 15, 7, 0, time.UTC)
+	created := time.Date(2026, 10, 12, 8, 30, 0, 0, time.UTC)
+	meta := fs.Files["/up/a.mkv"]
+	if !meta.ModTime.Equal(modified) || !meta.Created.Equal(created) {
+		t.Errorf("modtime %v, created %v", meta.ModTime, meta.Created)
+	}
+	if meta.etag() == before {
+		t.Error("ETag did not change with the modification time")
+	}
+
+	w = propfind(fs, "/up/a.mkv", "0", `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:"><D:prop>`+
+		`<D:getlastmodified/><D:creationdate/><D:getetag/><Z:Win32FileAttributes/><Z:Win32LastModifiedTime/></D:prop></D:propfind>`)
+	values := okValues(t, w.Body.String())
+	want := map[string]string{
+		"getlastmodified":       "Tue, 13 Oct 2026 21:15:07 GMT",
+		"creationdate":          "2026-10-12T08:30:00Z",
+		"getetag":               meta.etag(),
+		"Win32FileAttributes":   "00000020",
+		"Win32LastModifiedTime": "Tue, 13 Oct 2026 21:15:07 GMT",
+	}
+	for name, v := range want {
+		if got := propText(values[name]); got != v {
+			t.Errorf("PROPFIND %s = %q, want %q", name, got, v)
+		}
+	}
+
+	if w := head(fs, "/up/a.mkv", http.Header{"If-Modified-Since": {"Tue, 13 Oct 2026 21:15:07 GMT"}}); w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since the new time: status %d, want 304", w.Code)
+	}
+	if w := head(fs, "/up/a.mkv", http.Header{"If-Modified-Since": {"Mon, 12 Oct 2026 00:00:00 GMT"}}); w.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since an older time: status %d, want 200", w.Code)
+	} else if got := w.Header().Get("Last-Modified"); got != "Tue, 13 Oct 2026 21:15:07 GMT" {
+		t.Errorf("Last-Modified = %q", got)
+	}
+	if w := head(fs, "/up/a.mkv", http.Header{"If-None-Match": {meta.etag()}}); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match the new ETag: status %d, want 304", w.Code)
+	}
+}
+
+func TestWin32UnparsableTimeKeepsRawProperty(t *testing.T) {
+	fs := newTestFS(t, "/a.mkv#10#a.mkv\n")
+	modTime := fs.Files["/a.mkv"].ModTime
+
+	w := proppatch(fs, "/a.mkv", `<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:">`+
+		`<D:set><D:prop><Z:Win32LastModifiedTime>yesterday</Z:Win32LastModifiedTime></D:prop></D:set></D:propertyupdate>`)
+	if got := propStatuses(t, w.Body.String()); got[http.StatusOK] != "Win32LastModifiedTime" {
+		t.Errorf("propstats = %v", got)
+	}
+	meta := fs.Files["/a.mkv"]
+	if !meta.ModTime.Equal(modTime) {
+		t.Errorf("modtime changed to %v", meta.ModTime)
+	}
+	if v := string(meta.Props[xml.Name{Space: win32Namespace, Local: "Win32LastModifiedTime"}].InnerXML); v != "yesterday" {
+		t.Errorf("raw property = %q", v)
+	}
+}